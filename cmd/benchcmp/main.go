@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore/benchmarks"
+)
+
+var (
+	baselinePath = flag.String("baseline", "datastore/benchmarks/testdata/baseline.txt", "go test -bench output to compare against")
+	tolerance    = flag.Float64("tolerance", 0.2, "allowed relative ns/op increase before a benchmark counts as a regression")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <bench output>\n\nFlags:\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	baseline, err := parseFile(*baselinePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	current, err := parseFile(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	names := make([]string, 0, len(current))
+	for name := range current {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cur := current[name]
+		if base, ok := baseline[name]; ok {
+			fmt.Printf("%-32s %12.0f -> %12.0f ns/op (%+.1f%%)\n", name, base.NsPerOp, cur.NsPerOp, (cur.NsPerOp/base.NsPerOp-1)*100)
+		} else {
+			fmt.Printf("%-32s %12s -> %12.0f ns/op (new)\n", name, "", cur.NsPerOp)
+		}
+	}
+
+	regressions := benchmarks.Compare(baseline, current, *tolerance)
+	if len(regressions) > 0 {
+		fmt.Printf("\n%d regression(s) over %.0f%%:\n", len(regressions), *tolerance*100)
+		for _, r := range regressions {
+			fmt.Println("  " + r.String())
+		}
+		os.Exit(1)
+	}
+}
+
+func parseFile(path string) (map[string]benchmarks.Result, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return benchmarks.Parse(f)
+}
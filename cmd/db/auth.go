@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/subtle"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/roman-mazur/architecture-practice-4-template/httptools"
+)
+
+var (
+	writeTokens  = flag.String("write-tokens", os.Getenv("DB_WRITE_TOKENS"), "Comma-separated bearer tokens allowed to write and read (defaults to $DB_WRITE_TOKENS; empty leaves writes open)")
+	readTokens   = flag.String("read-tokens", os.Getenv("DB_READ_TOKENS"), "Comma-separated bearer tokens allowed to read (defaults to $DB_READ_TOKENS; empty leaves reads open)")
+	primaryToken = flag.String("primary-token", os.Getenv("DB_PRIMARY_TOKEN"), "Bearer token a replica sends to the primary (defaults to $DB_PRIMARY_TOKEN)")
+)
+
+type access int
+
+const (
+	accessNone access = iota
+	accessRead
+	accessWrite
+)
+
+// authenticator checks static bearer tokens. A write token also allows
+// reads. Without write tokens everyone may write, and without read tokens
+// everyone may read.
+type authenticator struct {
+	write, read []string
+}
+
+// newAuthenticator returns nil if no tokens are configured.
+func newAuthenticator(write, read []string) *authenticator {
+	if len(write) == 0 && len(read) == 0 {
+		return nil
+	}
+	return &authenticator{write: write, read: read}
+}
+
+func newAuthenticatorFromFlags() *authenticator {
+	return newAuthenticator(httptools.SplitList(*writeTokens), httptools.SplitList(*readTokens))
+}
+
+// granted returns the access the request's bearer token gives.
+func (a *authenticator) granted(r *http.Request) access {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return accessNone
+	}
+	if containsToken(a.write, token) {
+		return accessWrite
+	}
+	if containsToken(a.read, token) {
+		return accessRead
+	}
+	return accessNone
+}
+
+// check returns 0 if the request may proceed, or the status to reject it
+// with: 401 without a valid token, 403 for a read token on a write.
+func (a *authenticator) check(r *http.Request, write bool) int {
+	required := accessNone
+	switch {
+	case write && len(a.write) > 0:
+		required = accessWrite
+	case len(a.read) > 0:
+		required = accessRead
+	}
+	if required == accessNone {
+		return 0
+	}
+	granted := a.granted(r)
+	switch {
+	case granted >= required:
+		return 0
+	case granted == accessNone:
+		return http.StatusUnauthorized
+	default:
+		return http.StatusForbidden
+	}
+}
+
+// authorize rejects requests without the token they need. Health checks and
+// metrics stay open for probes and scrapers.
+func (h *Handler) authorize(w http.ResponseWriter, r *http.Request) bool {
+	if h.auth == nil {
+		return true
+	}
+	switch r.URL.Path {
+	case "/health", "/ready", "/metrics":
+		return true
+	}
+	status := h.auth.check(r, isWrite(r))
+	if status == 0 {
+		return true
+	}
+	log.Printf("auth: rejected %s %s from %s with %d", r.Method, r.URL.Path, r.RemoteAddr, status)
+	if status == http.StatusUnauthorized {
+		h.rejections.inc(reasonUnauthenticated)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="db"`)
+		http.Error(w, "missing or invalid token", status)
+	} else {
+		h.rejections.inc(reasonForbidden)
+		http.Error(w, "token does not allow writes", status)
+	}
+	return false
+}
+
+// containsToken compares against every token in constant time, so response
+// times do not leak how much of a token matched.
+func containsToken(tokens []string, token string) bool {
+	found := false
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			found = true
+		}
+	}
+	return found
+}
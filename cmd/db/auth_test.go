@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler_Auth(t *testing.T) {
+	h := newTestHandler(t)
+	h.auth = newAuthenticator([]string{"writer"}, []string{"reader"})
+
+	do := func(method, target, body, token string) int {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	tests := []struct {
+		name, method, target, body, token string
+		status                            int
+	}{
+		{"write without token", "POST", "/db/key", `{"value": "v"}`, "", http.StatusUnauthorized},
+		{"write with unknown token", "POST", "/db/key", `{"value": "v"}`, "guess", http.StatusUnauthorized},
+		{"write with read token", "POST", "/db/key", `{"value": "v"}`, "reader", http.StatusForbidden},
+		{"write with write token", "POST", "/db/key", `{"value": "v"}`, "writer", http.StatusOK},
+		{"read without token", "GET", "/db/key", "", "", http.StatusUnauthorized},
+		{"read with read token", "GET", "/db/key", "", "reader", http.StatusOK},
+		{"read with write token", "GET", "/db/key", "", "writer", http.StatusOK},
+		{"delete with read token", "DELETE", "/db/key", "", "reader", http.StatusForbidden},
+		{"replication stream without token", "GET", "/replicate", "", "", http.StatusUnauthorized},
+		{"health stays open", "GET", "/health", "", "", http.StatusOK},
+		{"metrics stay open", "GET", "/metrics", "", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := do(tt.method, tt.target, tt.body, tt.token); got != tt.status {
+				t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.target, tt.status, got)
+			}
+		})
+	}
+
+	h.auth = newAuthenticator([]string{"writer"}, nil)
+	if got := do("GET", "/db/key", "", ""); got != http.StatusOK {
+		t.Errorf("reads must stay open without read tokens, got %d", got)
+	}
+	if got := do("POST", "/db/key", `{"value": "v"}`, ""); got != http.StatusUnauthorized {
+		t.Errorf("writes must still need the write token, got %d", got)
+	}
+
+	if newAuthenticator(nil, nil) != nil {
+		t.Error("expected no authenticator without tokens")
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+// setVersionHeaders exposes the version of a value as its ETag and its write
+// time as Last-Modified. Values written before versioning have neither.
+func setVersionHeaders(w http.ResponseWriter, meta datastore.Meta) {
+	if meta.Version == 0 {
+		return
+	}
+	w.Header().Set("ETag", strconv.Quote(strconv.FormatUint(meta.Version, 10)))
+	w.Header().Set("Last-Modified", meta.Modified.UTC().Format(http.TimeFormat))
+}
+
+var errBadIfMatch = errors.New("If-Match must be * or a single ETag")
+
+// ifMatchVersion parses the If-Match header of a write. It returns ok=false
+// without the header; "*" matches any existing value and yields the current
+// version of key.
+func (h *Handler) ifMatchVersion(r *http.Request, key string) (version uint64, ok bool, err error) {
+	value := strings.TrimSpace(r.Header.Get("If-Match"))
+	if value == "" {
+		return 0, false, nil
+	}
+	if value == "*" {
+		meta, err := h.currentMeta(key)
+		if errors.Is(err, datastore.ErrNotFound) {
+			return 0, true, fmt.Errorf("%w: %w", datastore.ErrVersionMismatch, err)
+		}
+		return meta.Version, true, err
+	}
+	unquoted, err := strconv.Unquote(value)
+	if err != nil {
+		return 0, true, errBadIfMatch
+	}
+	version, err = strconv.ParseUint(unquoted, 10, 64)
+	if err != nil {
+		return 0, true, errBadIfMatch
+	}
+	return version, true, nil
+}
+
+func (h *Handler) currentMeta(key string) (datastore.Meta, error) {
+	_, meta, err := h.db.GetWithMeta(key)
+	if errors.Is(err, datastore.ErrTypeMismatch) {
+		_, meta, err = h.db.GetInt64WithMeta(key)
+	}
+	return meta, err
+}
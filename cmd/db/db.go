@@ -1,31 +1,203 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
-	"fmt"
 	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+	"github.com/roman-mazur/architecture-practice-4-template/httptools"
+	"github.com/roman-mazur/architecture-practice-4-template/internal/telemetry"
+	"github.com/roman-mazur/architecture-practice-4-template/signal"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var (
-	dbDir  = flag.String("path", "/var/lib/db/data", "Path to database directory")
-	dbSize = flag.Int64("size", 10*datastore.Mi, "Size of database to use")
+	dbDir       = flag.String("path", "/var/lib/db/data", "Path to database directory")
+	addr        = flag.String("addr", ":8080", "Address of the HTTP API")
+	dbSize      = flag.Int64("size", 10*datastore.Mi, "Size of database to use")
+	replicaOf   = flag.String("replica-of", "", "Base URL of a primary db instance to replicate from (serves read-only)")
+	readOnly    = flag.Bool("read-only", false, "Serve the existing segments without modifying them: writes fail with 405 and no compaction runs")
+	quarantine  = flag.Bool("quarantine-orphans", false, "Move files in the data directory that are neither segments nor hints into its quarantine subdirectory on startup")
+	maxInFlight = flag.Int64("max-inflight", 0, "Shed reads with 503 above this many in-flight requests (0 disables shedding)")
+
+	readTimeout    = flag.Duration("read-timeout", 10*time.Second, "Maximum duration for reading an entire request")
+	writeTimeout   = flag.Duration("write-timeout", 10*time.Second, "Maximum duration before timing out writes of a response")
+	idleTimeout    = flag.Duration("idle-timeout", 60*time.Second, "Maximum time to wait for the next request on a keep-alive connection")
+	maxBodyBytes   = flag.Int64("max-body-bytes", 1<<20, "Maximum size of a single-key write request body")
+	maxKeyBytes    = flag.Int("max-key-bytes", 0, "Maximum key size; longer keys are rejected with 400 (0 leaves only the segment format limit)")
+	maxValueBytes  = flag.Int64("max-value-bytes", 0, "Maximum value size; larger values are rejected with 413 (0 leaves only the segment format limit)")
+	maxStreamBytes = flag.Int64("max-stream-bytes", defaultMaxStreamBytes, "Maximum size of a raw value written with PUT as application/octet-stream (0 leaves only the segment format limit)")
+	minFreeBytes   = flag.Uint64("min-free-bytes", 64*uint64(datastore.Mi), "Free disk space below which /ready reports not ready")
+	grpcAddr       = flag.String("grpc-addr", ":9090", "Address of the gRPC API (empty disables it)")
+	bucketNames    = flag.String("buckets", "", "Comma-separated names of the buckets served at /db/{bucket}/{key}; other paths under /db/ are keys outside buckets, slashes included")
+	quotaBytes     = flag.Int64("quota-bytes", 0, "Maximum total size of all segments; writes beyond it fail with 507 (0 disables the quota)")
+	bucketQuotas   = flag.String("bucket-quotas", "", "Comma-separated bucket=bytes limits on the live records of buckets; writes beyond one fail with 507")
+	syncInterval   = flag.Duration("sync-interval", 0, "How often to fsync the active segment in the background (0 leaves flushing to the OS)")
+	recoveryJobs   = flag.Int("recovery-workers", 0, "Segments indexed concurrently on startup (0 uses GOMAXPROCS)")
+
+	diskCheckInterval = flag.Duration("disk-check-interval", 10*time.Second, "How often to check the free disk space (0 disables the watchdog)")
+	compactBelowFree  = flag.Uint64("compact-below-free-bytes", 256*uint64(datastore.Mi), "Free disk space below which segments are merged")
+	criticalFree      = flag.Uint64("critical-free-bytes", 16*uint64(datastore.Mi), "Free disk space below which writes fail with 507")
+
+	corsOrigins = flag.String("cors-origins", "", `Comma-separated origins allowed to call the API from a browser, or "*" (empty disables CORS)`)
+	corsMethods = flag.String("cors-methods", "GET,POST,PUT,DELETE", "Comma-separated methods allowed for cross-origin requests")
+	corsHeaders = flag.String("cors-headers", "Content-Type,Authorization,If-Match,X-Deadline-Ms", "Comma-separated request headers allowed for cross-origin requests")
+	corsMaxAge  = flag.Duration("cors-max-age", 10*time.Minute, "How long browsers may cache a preflight response")
 )
 
 func main() {
 	flag.Parse()
+	if *readOnly && *replicaOf != "" {
+		log.Fatal("-read-only and -replica-of are mutually exclusive: replicas write the primary's stream")
+	}
 
-	db, err := datastore.Open(*dbDir, *dbSize)
+	opts := []datastore.Option{
+		datastore.WithSegmentSize(*dbSize),
+		datastore.WithDiskWatchdog(*diskCheckInterval, *compactBelowFree, *criticalFree),
+		datastore.WithSyncInterval(*syncInterval),
+		datastore.WithRecoveryWorkers(*recoveryJobs),
+		datastore.WithMaxKeySize(*maxKeyBytes),
+		datastore.WithMaxValueSize(*maxValueBytes),
+	}
+	if *readOnly {
+		opts = append(opts, datastore.WithReadOnly())
+	}
+	if *quarantine {
+		opts = append(opts, datastore.WithQuarantine())
+	}
+	db, err := datastore.OpenWithOptions(*dbDir, opts...)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer db.Close()
+	if report := db.StartupReport(); len(report.Orphans) > 0 {
+		log.Printf("Found %d unexpected files in %s (quarantined: %t)", len(report.Orphans), *dbDir, report.Quarantined)
+	}
+	db.SetQuota(*quotaBytes)
+	for _, item := range httptools.SplitList(*bucketQuotas) {
+		name, value, _ := strings.Cut(item, "=")
+		bytes, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			log.Fatalf("-bucket-quotas: invalid quota %q", item)
+		}
+		if err := db.Bucket(name).SetQuota(bytes); err != nil {
+			log.Fatalf("-bucket-quotas: %v", err)
+		}
+	}
+	if *seedFile != "" {
+		if *readOnly || *replicaOf != "" {
+			log.Fatal("-seed-file needs a writable primary")
+		}
+		n, err := seed(db, *seedFile)
+		if err != nil {
+			log.Fatalf("Seeding from %s failed after %d keys: %v", *seedFile, n, err)
+		}
+		log.Printf("Seeded %d keys from %s", n, *seedFile)
+	}
+
+	tracer, err := telemetry.FromEnv("db")
+	if err != nil {
+		log.Printf("OpenTelemetry tracing disabled: %v", err)
+	}
+	defer tracer.Close()
 
 	handler := NewHandler(db)
+	handler.tracer = tracer
+	handler.buckets = make(map[string]bool)
+	for _, name := range httptools.SplitList(*bucketNames) {
+		if _, err := datastore.BucketKey(name, ""); err != nil {
+			log.Fatalf("-buckets: %v", err)
+		}
+		handler.buckets[name] = true
+	}
+	handler.maxBodyBytes = *maxBodyBytes
+	handler.maxStreamBytes = *maxStreamBytes
+	handler.minFreeBytes = *minFreeBytes
+	handler.maxWait = *maxWait
+	handler.auth = newAuthenticatorFromFlags()
+	if handler.auth == nil {
+		log.Println("No tokens configured: the API is open to every client")
+	}
+	if *readOnly {
+		handler.readOnly = true
+		log.Printf("Serving %s read-only", *dbDir)
+	}
+	if *mirrorTo != "" {
+		if *readOnly || *replicaOf != "" {
+			log.Fatal("-mirror-to needs a writable primary")
+		}
+		handler.mirror = newMirror(db, *mirrorTo, *mirrorToken, *mirrorQueueBytes)
+		db.Go("mirror", handler.mirror.run)
+		log.Printf("Mirroring writes to %s", *mirrorTo)
+	}
+	if *replicaOf != "" {
+		handler.readOnly = true
+		db.SetReadOnly(true)
+		db.Go("replicator", func(ctx context.Context) { replicate(ctx, db, *replicaOf) })
+		log.Printf("Running as read-only replica of %s", *replicaOf)
+	}
 
-	fmt.Println("Listening on :8080")
-	if err := http.ListenAndServe(":8080", handler); err != nil {
-		log.Fatal(err)
+	server := &http.Server{
+		Addr:              *addr,
+		Handler:           tracer.Handler(httptools.CORS(corsConfig(), newLoadShedder(handler, *maxInFlight))),
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       *readTimeout,
+		WriteTimeout:      *writeTimeout,
+		IdleTimeout:       *idleTimeout,
+		MaxHeaderBytes:    1 << 20,
+	}
+	go func() {
+		log.Printf("Listening on %s", *addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	var grpcServer *http.Server
+	if *grpcAddr != "" {
+		grpcHandler := NewGRPCHandler(db)
+		grpcHandler.maxMessageBytes = int(*maxBodyBytes)
+		grpcHandler.auth = handler.auth
+		var protocols http.Protocols
+		protocols.SetUnencryptedHTTP2(true)
+		grpcServer = &http.Server{
+			Addr:              *grpcAddr,
+			Handler:           grpcHandler,
+			Protocols:         &protocols,
+			ReadHeaderTimeout: 5 * time.Second,
+			IdleTimeout:       *idleTimeout,
+		}
+		go func() {
+			log.Printf("gRPC API listening on %s", *grpcAddr)
+			if err := grpcServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	signal.WaitForTerminationSignal()
+	ctx, cancel := context.WithTimeout(context.Background(), *writeTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Server shutdown: %v", err)
+	}
+	if grpcServer != nil {
+		if err := grpcServer.Shutdown(ctx); err != nil {
+			log.Printf("gRPC server shutdown: %v", err)
+		}
+	}
+}
+
+func corsConfig() httptools.CORSConfig {
+	return httptools.CORSConfig{
+		AllowedOrigins: httptools.SplitList(*corsOrigins),
+		AllowedMethods: httptools.SplitList(*corsMethods),
+		AllowedHeaders: httptools.SplitList(*corsHeaders),
+		ExposedHeaders: []string{"ETag", "Last-Modified"},
+		MaxAge:         *corsMaxAge,
 	}
 }
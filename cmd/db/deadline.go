@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// deadlineHeader carries the caller's remaining time budget in milliseconds.
+// The handler derives a context deadline from it, so the datastore stops
+// working on requests whose caller has already given up.
+const deadlineHeader = "X-Deadline-Ms"
+
+func withDeadline(r *http.Request) (*http.Request, context.CancelFunc, error) {
+	value := r.Header.Get(deadlineHeader)
+	if value == "" {
+		return r, func() {}, nil
+	}
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || ms <= 0 {
+		return r, nil, fmt.Errorf("invalid %s header %q", deadlineHeader, value)
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(ms)*time.Millisecond)
+	return r.WithContext(ctx), cancel, nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+	"github.com/roman-mazur/architecture-practice-4-template/dbpb"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// gRPC status codes used by the service.
+const (
+	codeOK                 = 0
+	codeInvalidArgument    = 3
+	codeNotFound           = 5
+	codePermissionDenied   = 7
+	codeResourceExhausted  = 8
+	codeFailedPrecondition = 9
+	codeUnimplemented      = 12
+	codeInternal           = 13
+	codeDataLoss           = 15
+	codeUnauthenticated    = 16
+)
+
+// grpcCodes maps datastore errors to gRPC status codes; anything else is
+// INTERNAL.
+var grpcCodes = []struct {
+	err  error
+	code int
+}{
+	{datastore.ErrNotFound, codeNotFound},
+	{datastore.ErrTypeMismatch, codeFailedPrecondition},
+	{datastore.ErrReadOnly, codeFailedPrecondition},
+	{datastore.ErrTooLarge, codeInvalidArgument},
+	{datastore.ErrQuotaExceeded, codeResourceExhausted},
+	{datastore.ErrCorrupted, codeDataLoss},
+}
+
+type grpcError struct {
+	code int
+	msg  string
+}
+
+func (e *grpcError) Error() string {
+	return e.msg
+}
+
+func toGRPCError(err error) *grpcError {
+	var gerr *grpcError
+	if errors.As(err, &gerr) {
+		return gerr
+	}
+	for _, c := range grpcCodes {
+		if errors.Is(err, c.err) {
+			return &grpcError{c.code, c.err.Error()}
+		}
+	}
+	log.Printf("grpc: internal error: %v", err)
+	return &grpcError{codeInternal, "internal error"}
+}
+
+// GRPCHandler serves the Db service from db.proto. It speaks the gRPC wire
+// protocol directly over HTTP/2, so it must be served with unencrypted
+// HTTP/2 enabled.
+type GRPCHandler struct {
+	db *datastore.Db
+	// maxMessageBytes limits the size of a single request message.
+	maxMessageBytes int
+	// auth checks bearer tokens sent as authorization metadata; nil leaves
+	// the API open.
+	auth *authenticator
+}
+
+func NewGRPCHandler(db *datastore.Db) *GRPCHandler {
+	return &GRPCHandler{db: db, maxMessageBytes: defaultMaxBodyBytes}
+}
+
+func (h *GRPCHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var call func([]byte) ([]byte, error)
+	switch r.URL.Path {
+	case "/db.v1.Db/Get":
+		call = h.get
+	case "/db.v1.Db/Put":
+		call = h.put
+	case "/db.v1.Db/Delete":
+		call = h.delete
+	case "/db.v1.Db/Batch":
+		call = h.batch
+	default:
+		writeGRPCStatus(w, &grpcError{codeUnimplemented, "unknown method " + r.URL.Path})
+		return
+	}
+	if h.auth != nil {
+		switch h.auth.check(r, r.URL.Path != "/db.v1.Db/Get") {
+		case http.StatusUnauthorized:
+			log.Printf("grpc: rejected %s from %s: missing or invalid token", r.URL.Path, r.RemoteAddr)
+			writeGRPCStatus(w, &grpcError{codeUnauthenticated, "missing or invalid token"})
+			return
+		case http.StatusForbidden:
+			log.Printf("grpc: rejected %s from %s: token does not allow writes", r.URL.Path, r.RemoteAddr)
+			writeGRPCStatus(w, &grpcError{codePermissionDenied, "token does not allow writes"})
+			return
+		}
+	}
+
+	req, err := dbpb.ReadFrame(r.Body, h.maxMessageBytes)
+	if err != nil {
+		writeGRPCStatus(w, &grpcError{codeInvalidArgument, "cannot read request: " + err.Error()})
+		return
+	}
+	resp, err := call(req)
+	if err != nil {
+		writeGRPCStatus(w, toGRPCError(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+	if err := dbpb.WriteFrame(w, resp); err != nil {
+		log.Printf("grpc: failed to write response: %v", err)
+		return
+	}
+	w.Header().Set("Grpc-Status", strconv.Itoa(codeOK))
+}
+
+// writeGRPCStatus sends a trailers-only response carrying the error.
+func writeGRPCStatus(w http.ResponseWriter, err *grpcError) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Status", strconv.Itoa(err.code))
+	w.Header().Set("Grpc-Message", url.PathEscape(err.msg))
+	w.WriteHeader(http.StatusOK)
+}
+
+func invalidArgument(msg string) error {
+	return &grpcError{codeInvalidArgument, msg}
+}
+
+func (h *GRPCHandler) get(b []byte) ([]byte, error) {
+	var req dbpb.GetRequest
+	if err := req.Unmarshal(b); err != nil {
+		return nil, invalidArgument(err.Error())
+	}
+	values, err := h.db.GetMany([]string{req.Key})
+	if err != nil {
+		return nil, err
+	}
+	resp := dbpb.GetResponse{Value: &dbpb.Value{}}
+	switch v := values[req.Key].(type) {
+	case string:
+		resp.Value.StringValue = &v
+	case int64:
+		resp.Value.Int64Value = &v
+	default:
+		return nil, datastore.ErrNotFound
+	}
+	return resp.Marshal(), nil
+}
+
+func (h *GRPCHandler) put(b []byte) ([]byte, error) {
+	var req dbpb.PutRequest
+	if err := req.Unmarshal(b); err != nil {
+		return nil, invalidArgument(err.Error())
+	}
+	switch {
+	case req.Value == nil:
+		return nil, invalidArgument("value is required")
+	case req.Value.StringValue != nil:
+		return nil, h.db.Put(req.Key, *req.Value.StringValue)
+	case req.Value.Int64Value != nil:
+		return nil, h.db.PutInt64(req.Key, *req.Value.Int64Value)
+	default:
+		return nil, invalidArgument("value is empty")
+	}
+}
+
+func (h *GRPCHandler) delete(b []byte) ([]byte, error) {
+	var req dbpb.DeleteRequest
+	if err := req.Unmarshal(b); err != nil {
+		return nil, invalidArgument(err.Error())
+	}
+	return nil, h.db.Delete(req.Key)
+}
+
+func (h *GRPCHandler) batch(b []byte) ([]byte, error) {
+	var req dbpb.BatchRequest
+	if err := req.Unmarshal(b); err != nil {
+		return nil, invalidArgument(err.Error())
+	}
+	tx := h.db.Begin()
+	for _, m := range req.Mutations {
+		var err error
+		switch {
+		case m.Delete:
+			err = tx.Delete(m.Key)
+		case m.Put != nil && m.Put.StringValue != nil:
+			err = tx.Put(m.Key, *m.Put.StringValue)
+		case m.Put != nil && m.Put.Int64Value != nil:
+			err = tx.PutInt64(m.Key, *m.Put.Int64Value)
+		default:
+			tx.Rollback()
+			return nil, invalidArgument("mutation for key " + strconv.Quote(m.Key) + " has no operation")
+		}
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	return nil, tx.Commit()
+}
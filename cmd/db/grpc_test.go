@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/roman-mazur/architecture-practice-4-template/dbpb"
+)
+
+type grpcMessage interface {
+	Marshal() []byte
+}
+
+// grpcCall sends req to method over unencrypted HTTP/2 and returns the status
+// code and the response message.
+func grpcCall(t *testing.T, client *http.Client, baseURL, method string, req grpcMessage) (int, []byte) {
+	t.Helper()
+	var body bytes.Buffer
+	if err := dbpb.WriteFrame(&body, req.Marshal()); err != nil {
+		t.Fatal(err)
+	}
+	httpReq, _ := http.NewRequest(http.MethodPost, baseURL+"/db.v1.Db/"+method, &body)
+	httpReq.Header.Set("Content-Type", "application/grpc")
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("expected HTTP/2 response, got %s", resp.Proto)
+	}
+
+	var msg []byte
+	if status := resp.Header.Get("Grpc-Status"); status == "" {
+		if msg, err = dbpb.ReadFrame(resp.Body, 0); err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+	}
+	status := resp.Header.Get("Grpc-Status")
+	if status == "" {
+		status = resp.Trailer.Get("Grpc-Status")
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		t.Fatalf("invalid grpc-status %q", status)
+	}
+	return code, msg
+}
+
+func TestGRPCHandler(t *testing.T) {
+	h := newTestHandler(t)
+	srv := httptest.NewUnstartedServer(NewGRPCHandler(h.db))
+	srv.Config.Protocols = new(http.Protocols)
+	srv.Config.Protocols.SetUnencryptedHTTP2(true)
+	srv.Start()
+	defer srv.Close()
+
+	transport := &http.Transport{Protocols: new(http.Protocols)}
+	transport.Protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: transport}
+
+	str, num := "hello", int64(42)
+	if code, _ := grpcCall(t, client, srv.URL, "Put", &dbpb.PutRequest{Key: "s", Value: &dbpb.Value{StringValue: &str}}); code != codeOK {
+		t.Fatalf("Put: status %d", code)
+	}
+	batch := &dbpb.BatchRequest{Mutations: []*dbpb.Mutation{
+		{Key: "n", Put: &dbpb.Value{Int64Value: &num}},
+		{Key: "s", Delete: true},
+	}}
+	if code, _ := grpcCall(t, client, srv.URL, "Batch", batch); code != codeOK {
+		t.Fatalf("Batch: status %d", code)
+	}
+
+	code, msg := grpcCall(t, client, srv.URL, "Get", &dbpb.GetRequest{Key: "n"})
+	var resp dbpb.GetResponse
+	if err := resp.Unmarshal(msg); err != nil || code != codeOK {
+		t.Fatalf("Get: status %d, %v", code, err)
+	}
+	if resp.Value == nil || resp.Value.Int64Value == nil || *resp.Value.Int64Value != num {
+		t.Errorf("Get returned %+v, want int64 %d", resp.Value, num)
+	}
+
+	if code, _ := grpcCall(t, client, srv.URL, "Get", &dbpb.GetRequest{Key: "s"}); code != codeNotFound {
+		t.Errorf("Get of deleted key: expected NOT_FOUND, got %d", code)
+	}
+	if code, _ := grpcCall(t, client, srv.URL, "Put", &dbpb.PutRequest{Key: "empty"}); code != codeInvalidArgument {
+		t.Errorf("Put without value: expected INVALID_ARGUMENT, got %d", code)
+	}
+	if code, _ := grpcCall(t, client, srv.URL, "Scan", &dbpb.GetRequest{}); code != codeUnimplemented {
+		t.Errorf("unknown method: expected UNIMPLEMENTED, got %d", code)
+	}
+
+	h.db.SetReadOnly(true)
+	if code, _ := grpcCall(t, client, srv.URL, "Delete", &dbpb.DeleteRequest{Key: "n"}); code != codeFailedPrecondition {
+		t.Errorf("Delete on read-only db: expected FAILED_PRECONDITION, got %d", code)
+	}
+}
@@ -1,26 +1,130 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+	"github.com/roman-mazur/architecture-practice-4-template/internal/telemetry"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
+)
+
+const (
+	importBatchSize     = 500
+	importMaxLineSize   = 1 << 20
+	defaultMaxBodyBytes = 1 << 20
+	// defaultPurgeThreshold is the garbage ratio above which /admin/purge
+	// rewrites a segment.
+	defaultPurgeThreshold = 0.5
 )
 
 type Handler struct {
 	db *datastore.Db
+	// readOnly rejects every write, e.g. on replicas that only apply the
+	// primary's stream.
+	readOnly bool
+	// maxBodyBytes limits bodies of single-key writes; bulk imports are
+	// streamed and not limited.
+	maxBodyBytes int64
+	// maxStreamBytes limits raw values written with PUT; 0 leaves only the
+	// limit of the segment format.
+	maxStreamBytes int64
+	// minFreeBytes is the free disk space below which /ready fails.
+	minFreeBytes uint64
+	// rejections counts client errors by reason for /metrics.
+	rejections rejectionCounters
+	// auth checks bearer tokens; nil leaves the API open.
+	auth *authenticator
+	// mirror, if set, copies writes to a secondary db.
+	mirror *mirror
+	// maxWait caps the wait of long-polling GETs.
+	maxWait time.Duration
+	// tracer traces the datastore operations of requests; nil disables it.
+	tracer *telemetry.Tracer
+	// buckets are the bucket names served at /db/{bucket}/{key}.
+	buckets map[string]bool
 }
 
 func NewHandler(db *datastore.Db) *Handler {
-	return &Handler{db: db}
+	return &Handler{db: db, maxBodyBytes: defaultMaxBodyBytes, maxStreamBytes: defaultMaxStreamBytes, rejections: newRejectionCounters(), maxWait: time.Minute}
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if id := r.Header.Get("X-Request-ID"); id != "" {
+		log.Printf("[%s] %s %s", id, r.Method, r.URL.Path)
+	}
+	r, cancel, err := withDeadline(r)
+	if err != nil {
+		h.reject(w, reasonInvalidDeadline, err.Error())
+		return
+	}
+	defer cancel()
+	if !h.authorize(w, r) {
+		return
+	}
+	if h.readOnly && isWrite(r) {
+		log.Printf("rejected %s %s on read-only instance", r.Method, r.URL.Path)
+		http.Error(w, "read-only instance", http.StatusMethodNotAllowed)
+		return
+	}
+
 	switch {
+	case r.URL.Path == "/health":
+		w.Header().Set("content-type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("OK"))
+	case r.URL.Path == "/ready":
+		h.handleReady(w)
+	case r.URL.Path == "/metrics":
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h.handleMetrics(w)
+	case r.URL.Path == "/db":
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		log.Println("new multi-get request")
+		h.handleGetMany(w, r)
 	case strings.HasPrefix(r.URL.Path, "/db/"):
-		key := strings.TrimPrefix(r.URL.Path, "/db/")
+		path := strings.TrimPrefix(r.URL.EscapedPath(), "/db/")
+		if h.maxBodyBytes > 0 && r.Method != http.MethodPut {
+			r.Body = http.MaxBytesReader(w, r.Body, h.maxBodyBytes)
+		}
+		var op string
+		if i := strings.LastIndex(path, "/"); i >= 0 && (path[i+1:] == "cas" || path[i+1:] == "incr") {
+			path, op = path[:i], path[i+1:]
+		}
+		key, err := h.storedKey(path)
+		if err != nil {
+			h.reject(w, reasonInvalidKey, err.Error())
+			return
+		}
+		if op != "" {
+			if r.Method != http.MethodPost {
+				http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			log.Printf("new %s request", op)
+			if op == "cas" {
+				h.handleCAS(w, r, key)
+			} else {
+				h.handleIncr(w, r, key)
+			}
+			return
+		}
 		switch r.Method {
 		case http.MethodGet:
 			log.Println("new GET request")
@@ -28,51 +132,278 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		case http.MethodPost:
 			log.Println("new POST request")
 			h.handlePost(w, r, key)
+		case http.MethodPut:
+			log.Println("new PUT request")
+			h.handlePut(w, r, key)
+		case http.MethodDelete:
+			log.Println("new DELETE request")
+			h.handleDelete(w, r, key)
 		default:
 			log.Printf("unknown method: %s", r.Method)
 			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 		}
+	case r.URL.Path == "/admin/import":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		log.Println("new import request")
+		h.handleImport(w, r)
+	case r.URL.Path == "/admin/apply":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h.handleApply(w, r)
+	case r.URL.Path == "/replicate":
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		log.Printf("replica connected from %s", r.RemoteAddr)
+		h.handleReplicate(w, r)
+	case r.URL.Path == "/admin/purge":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		log.Println("new purge request")
+		h.handlePurge(w, r)
+	case r.URL.Path == "/admin/stats":
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h.handleStats(w)
+	case r.URL.Path == "/admin/verify":
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		log.Println("new verify request")
+		h.handleVerify(w)
 	default:
 		http.NotFound(w, r)
 	}
 }
 
+// storedKey returns the datastore key of the escaped path after /db/. The
+// first segment names a bucket only if it is one of h.buckets; any other
+// path is a key outside buckets, slashes included, as before buckets
+// existed. An escaped slash (%2F) never separates a bucket from its key.
+func (h *Handler) storedKey(path string) (string, error) {
+	if segment, rest, ok := strings.Cut(path, "/"); ok {
+		if bucket, err := url.PathUnescape(segment); err == nil && h.buckets[bucket] {
+			key, err := url.PathUnescape(rest)
+			if err != nil {
+				return "", err
+			}
+			return datastore.BucketKey(bucket, key)
+		}
+	}
+	key, err := url.PathUnescape(path)
+	if err != nil {
+		return "", err
+	}
+	if strings.Contains(key, "\x00") {
+		return "", errors.New("key must not contain NUL bytes")
+	}
+	return key, nil
+}
+
+// userKey is the key of a response: the stored key without its bucket.
+func userKey(stored string) string {
+	_, key := datastore.SplitBucketKey(stored)
+	return key
+}
+
+// readBodyError answers a failed body read, telling oversized bodies apart.
+func readBodyError(w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		http.Error(w, fmt.Sprintf("body exceeds %d bytes", maxBytesErr.Limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, "cannot read body", http.StatusBadRequest)
+}
+
+// cancelled reports whether the client went away or the request timed out,
+// in which case the handler should not start any datastore work. A request
+// past its X-Deadline-Ms is answered with 504.
+func cancelled(w http.ResponseWriter, r *http.Request) bool {
+	err := r.Context().Err()
+	if err == nil {
+		return false
+	}
+	log.Printf("abandoning %s %s: %v", r.Method, r.URL.Path, err)
+	if errors.Is(err, context.DeadlineExceeded) {
+		http.Error(w, "deadline exceeded", http.StatusGatewayTimeout)
+	}
+	return true
+}
+
+func isWrite(r *http.Request) bool {
+	return r.Method != http.MethodGet && r.Method != http.MethodHead && r.URL.Path != "/admin/verify"
+}
+
 func (h *Handler) handleGet(w http.ResponseWriter, r *http.Request, key string) {
-	valueType := r.URL.Query().Get("type")
+	if cancelled(w, r) {
+		return
+	}
+	if wantsRaw(r) {
+		h.handleGetRaw(w, r, key)
+		return
+	}
+	query := r.URL.Query()
+	valueType := query.Get("type")
 	if valueType == "" {
 		valueType = "string"
 	}
+	fields, err := selectFields(query)
+	if err != nil {
+		h.reject(w, reasonInvalidFields, err.Error())
+		return
+	}
+	// A default is parsed as the requested type up front, so a bad one is
+	// rejected whether the key exists or not.
+	var def any
+	if query.Has("default") {
+		if def, err = parseDefault(valueType, query.Get("default")); err != nil {
+			h.reject(w, reasonInvalidDefault, err.Error())
+			return
+		}
+	}
+	if query.Has("wait") && !h.awaitChange(w, r, key) {
+		return
+	}
 
+	var val any
+	var meta datastore.Meta
+	span := h.traceOp(r, "get")
 	switch valueType {
 	case "int64":
-		val, err := h.db.GetInt64(key)
-		if err != nil {
-			http.NotFound(w, r)
-			return
-		}
-		h.respondJSON(w, map[string]any{
-			"key":   key,
-			"value": val,
-		})
+		val, meta, err = h.db.GetInt64WithMeta(key)
 	case "string":
-		val, err := h.db.Get(key)
-		if err != nil {
-			http.NotFound(w, r)
-			return
+		val, meta, err = h.db.GetWithMeta(key)
+	default:
+		span.End()
+		h.reject(w, reasonUnsupportedType, "invalid type")
+		return
+	}
+	endOp(span, err)
+	if errors.Is(err, datastore.ErrNotFound) && def != nil {
+		w.Header().Set("x-db-default", "true")
+		val, err = def, nil
+	}
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	setVersionHeaders(w, meta)
+	if fields == nil {
+		h.respondJSON(w, val)
+		return
+	}
+	envelope := map[string]any{"key": userKey(key), "value": val}
+	selected := make(map[string]any, len(fields))
+	for _, f := range fields {
+		selected[f] = envelope[f]
+	}
+	h.respondJSON(w, selected)
+}
+
+// envelopeFields are the fields of the JSON envelope of a GET.
+var envelopeFields = []string{"key", "value"}
+
+// selectFields parses the fields and envelope query parameters of a GET:
+// ?fields=value keeps only the listed envelope fields and ?envelope=false
+// drops the envelope for the bare value, returned as a nil slice.
+func selectFields(query url.Values) ([]string, error) {
+	fields := envelopeFields
+	if query.Has("fields") {
+		fields = nil
+		for _, f := range strings.Split(query.Get("fields"), ",") {
+			if f = strings.TrimSpace(f); !slices.Contains(envelopeFields, f) {
+				return nil, fmt.Errorf("unknown field %q, expected one of %s", f, strings.Join(envelopeFields, ","))
+			}
+			if !slices.Contains(fields, f) {
+				fields = append(fields, f)
+			}
+		}
+	}
+	switch query.Get("envelope") {
+	case "", "true":
+		return fields, nil
+	case "false":
+		if query.Has("fields") && !slices.Equal(fields, []string{"value"}) {
+			return nil, errors.New("envelope=false only returns the value")
 		}
-		h.respondJSON(w, map[string]any{
-			"key":   key,
-			"value": val,
+		return nil, nil
+	}
+	return nil, errors.New("envelope must be true or false")
+}
+
+// parseDefault converts the default query parameter of a GET to valueType.
+func parseDefault(valueType, value string) (any, error) {
+	if valueType != "int64" {
+		return value, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, errors.New("default must be an int64")
+	}
+	return n, nil
+}
+
+func (h *Handler) handleGetMany(w http.ResponseWriter, r *http.Request) {
+	var keys []string
+	for _, key := range strings.Split(r.URL.Query().Get("keys"), ",") {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		http.Error(w, `"keys" parameter missing`, http.StatusBadRequest)
+		return
+	}
+
+	span := h.traceOp(r, "get_many")
+	span.SetAttribute("db.keys", len(keys))
+	values, err := h.db.GetManyContext(r.Context(), keys)
+	endOp(span, err)
+	if errors.Is(err, context.DeadlineExceeded) {
+		// Tell the caller how far the lookup got before the deadline.
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusGatewayTimeout)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"error":     "deadline exceeded",
+			"requested": len(keys),
+			"found":     len(values),
+			"values":    values,
 		})
-	default:
-		http.Error(w, "invalid type", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.writeError(w, err)
+		return
 	}
+	h.respondJSON(w, values)
 }
 
 func (h *Handler) handlePost(w http.ResponseWriter, r *http.Request, key string) {
+	var ifAbsent bool
+	switch cond := r.URL.Query().Get("if"); cond {
+	case "":
+	case "absent":
+		ifAbsent = true
+	default:
+		http.Error(w, fmt.Sprintf("unsupported condition %q", cond), http.StatusBadRequest)
+		return
+	}
+
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "cannot read body", http.StatusBadRequest)
+		readBodyError(w, err)
 		return
 	}
 	defer func() {
@@ -82,33 +413,363 @@ func (h *Handler) handlePost(w http.ResponseWriter, r *http.Request, key string)
 
 	var input map[string]any
 	if err := json.Unmarshal(body, &input); err != nil {
-		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		h.reject(w, reasonInvalidJSON, "invalid JSON")
 		return
 	}
 
 	val, ok := input["value"]
 	if !ok {
-		http.Error(w, `"value" field missing`, http.StatusBadRequest)
+		h.reject(w, reasonMissingValue, `"value" field missing`)
+		return
+	}
+	if cancelled(w, r) {
+		return
+	}
+	version, ifMatch, err := h.ifMatchVersion(r, key)
+	if errors.Is(err, errBadIfMatch) || (ifMatch && ifAbsent) {
+		http.Error(w, "If-Match cannot be combined with ?if=absent and must be * or a single ETag", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.writeError(w, err)
 		return
 	}
 
+	written := true
+	span := h.traceOp(r, "put")
 	switch v := val.(type) {
 	case string:
-		if err := h.db.Put(key, v); err != nil {
-			http.Error(w, "db error", http.StatusInternalServerError)
+		if ifMatch {
+			err = h.db.PutIfVersion(key, v, version)
+		} else if ifAbsent {
+			written, err = h.db.PutIfAbsent(key, v)
+		} else {
+			err = h.db.PutContext(r.Context(), key, v)
 		}
 	case float64:
 		intVal := int64(v)
 		if float64(intVal) != v {
-			http.Error(w, "value must be int64 or string", http.StatusBadRequest)
+			span.End()
+			h.reject(w, reasonUnsupportedType, "value must be int64 or string")
+			return
+		}
+		if ifMatch {
+			err = h.db.PutInt64IfVersion(key, intVal, version)
+		} else if ifAbsent {
+			written, err = h.db.PutInt64IfAbsent(key, intVal)
+		} else {
+			err = h.db.PutInt64Context(r.Context(), key, intVal)
+		}
+	default:
+		span.End()
+		h.reject(w, reasonUnsupportedType, "unsupported value type")
+		return
+	}
+	endOp(span, err)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	if !written {
+		http.Error(w, "key already exists", http.StatusConflict)
+	}
+}
+
+func (h *Handler) handleDelete(w http.ResponseWriter, r *http.Request, key string) {
+	if cancelled(w, r) {
+		return
+	}
+	version, ifMatch, err := h.ifMatchVersion(r, key)
+	switch {
+	case errors.Is(err, errBadIfMatch):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		h.writeError(w, err)
+		return
+	}
+	span := h.traceOp(r, "delete")
+	if ifMatch {
+		err = h.db.DeleteIfVersion(key, version)
+	} else {
+		err = h.db.DeleteContext(r.Context(), key)
+	}
+	endOp(span, err)
+	if err != nil {
+		h.writeError(w, err)
+	}
+}
+
+func (h *Handler) handleCAS(w http.ResponseWriter, r *http.Request, key string) {
+	defer r.Body.Close()
+	var input struct {
+		Old *string `json:"old"`
+		New *string `json:"new"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		if errors.As(err, new(*http.MaxBytesError)) {
+			readBodyError(w, err)
+			return
+		}
+		h.reject(w, reasonInvalidJSON, "invalid JSON")
+		return
+	}
+	if input.Old == nil || input.New == nil {
+		h.reject(w, reasonMissingValue, `"old" and "new" fields are required`)
+		return
+	}
+	if cancelled(w, r) {
+		return
+	}
+
+	span := h.traceOp(r, "cas")
+	swapped, err := h.db.CompareAndSwap(key, *input.Old, *input.New)
+	endOp(span, err)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	if !swapped {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+	}
+	h.respondJSON(w, map[string]any{
+		"key":     userKey(key),
+		"swapped": swapped,
+	})
+}
+
+func (h *Handler) handleIncr(w http.ResponseWriter, r *http.Request, key string) {
+	defer r.Body.Close()
+	input := struct {
+		Delta int64 `json:"delta"`
+	}{Delta: 1}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil && !errors.Is(err, io.EOF) {
+		if errors.As(err, new(*http.MaxBytesError)) {
+			readBodyError(w, err)
+			return
+		}
+		h.reject(w, reasonInvalidJSON, "invalid JSON")
+		return
+	}
+	if cancelled(w, r) {
+		return
+	}
+
+	span := h.traceOp(r, "incr")
+	val, err := h.db.Increment(key, input.Delta)
+	endOp(span, err)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	h.respondJSON(w, map[string]any{
+		"key":   userKey(key),
+		"value": val,
+	})
+}
+
+// handleImport loads a JSON-lines body of {"key": ..., "value": ...} records.
+// Records are written in batches and a progress line is streamed back after
+// each batch, so the response is always 200 and the last line tells whether
+// the import finished or where it stopped.
+func (h *Handler) handleImport(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	// Imports may legitimately take longer than the server-wide timeouts.
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+
+	imported, line := 0, 0
+	progress := func(extra map[string]any) {
+		msg := map[string]any{"imported": imported}
+		for k, v := range extra {
+			msg[k] = v
+		}
+		_ = enc.Encode(msg)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	var batch datastore.Batch
+	flush := func() bool {
+		if err := r.Context().Err(); err != nil {
+			log.Printf("import: abandoned: %v", err)
+			progress(map[string]any{"error": err.Error(), "line": line})
+			return false
+		}
+		span := h.traceOp(r, "write_batch")
+		span.SetAttribute("db.records", batch.Len())
+		err := h.db.WriteBatchContext(r.Context(), &batch)
+		endOp(span, err)
+		if err != nil {
+			log.Printf("import: batch write failed: %v", err)
+			progress(map[string]any{"error": errorMessage(err), "line": line})
+			return false
+		}
+		imported += batch.Len()
+		batch.Reset()
+		progress(nil)
+		return true
+	}
+
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 64*1024), importMaxLineSize)
+	for scanner.Scan() {
+		line++
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+		var record struct {
+			Key   string `json:"key"`
+			Value any    `json:"value"`
+		}
+		if err := json.Unmarshal(raw, &record); err != nil || record.Key == "" {
+			h.rejections.inc(reasonInvalidJSON)
+			progress(map[string]any{"error": "invalid record", "line": line})
+			return
+		}
+		if err := addToBatch(&batch, record.Key, record.Value); err != nil {
+			h.rejections.inc(reasonUnsupportedType)
+			progress(map[string]any{"error": err.Error(), "line": line})
 			return
 		}
-		if err := h.db.PutInt64(key, intVal); err != nil {
-			http.Error(w, "db error", http.StatusInternalServerError)
+		if batch.Len() >= importBatchSize && !flush() {
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		progress(map[string]any{"error": "cannot read body", "line": line})
+		return
+	}
+	if batch.Len() > 0 && !flush() {
+		return
+	}
+	progress(map[string]any{"done": true})
+}
+
+func addToBatch(b *datastore.Batch, key string, val any) error {
+	switch v := val.(type) {
+	case string:
+		b.Put(key, v)
+	case float64:
+		intVal := int64(v)
+		if float64(intVal) != v {
+			return errors.New("value must be int64 or string")
 		}
+		b.PutInt64(key, intVal)
 	default:
-		http.Error(w, "unsupported value type", http.StatusBadRequest)
+		return errors.New("unsupported value type")
+	}
+	return nil
+}
+
+func (h *Handler) handleReady(w http.ResponseWriter) {
+	var problems []string
+	if err := h.db.Ready(); err != nil && !(h.readOnly && errors.Is(err, datastore.ErrReadOnly)) {
+		problems = append(problems, err.Error())
+	}
+	free, err := h.db.FreeSpace()
+	switch {
+	case err != nil:
+		problems = append(problems, fmt.Sprintf("cannot check free space: %v", err))
+	case free < h.minFreeBytes:
+		problems = append(problems, fmt.Sprintf("free space %d bytes is below %d", free, h.minFreeBytes))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(problems) > 0 {
+		log.Printf("not ready: %s", strings.Join(problems, "; "))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"ready":      len(problems) == 0,
+		"problems":   problems,
+		"free_bytes": free,
+	})
+}
+
+// handlePurge rewrites the segments whose garbage ratio is above the
+// threshold query parameter, 0.5 by default.
+func (h *Handler) handlePurge(w http.ResponseWriter, r *http.Request) {
+	threshold := defaultPurgeThreshold
+	if value := r.URL.Query().Get("threshold"); value != "" {
+		var err error
+		if threshold, err = strconv.ParseFloat(value, 64); err != nil || threshold < 0 || threshold > 1 {
+			http.Error(w, "threshold must be a number between 0 and 1", http.StatusBadRequest)
+			return
+		}
+	}
+	purged, err := h.db.PurgeTombstones(threshold)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	h.respondJSON(w, map[string]any{"purged_segments": purged})
+}
+
+func (h *Handler) handleVerify(w http.ResponseWriter) {
+	report, err := h.db.Verify()
+	if err != nil {
+		http.Error(w, "verify failed", http.StatusInternalServerError)
+		return
+	}
+	h.respondJSON(w, map[string]any{
+		"ok":     report.OK(),
+		"report": report,
+	})
+}
+
+// errorStatuses maps datastore errors to HTTP statuses; anything else is a 500.
+var errorStatuses = []struct {
+	err    error
+	status int
+}{
+	{datastore.ErrVersionMismatch, http.StatusPreconditionFailed},
+	{datastore.ErrNotFound, http.StatusNotFound},
+	{datastore.ErrTypeMismatch, http.StatusConflict},
+	{datastore.ErrReadOnly, http.StatusMethodNotAllowed},
+	{datastore.ErrKeyTooLarge, http.StatusBadRequest},
+	{datastore.ErrValueTooLarge, http.StatusRequestEntityTooLarge},
+	{datastore.ErrTooLarge, http.StatusRequestEntityTooLarge},
+	{datastore.ErrQuotaExceeded, http.StatusInsufficientStorage},
+	{datastore.ErrDiskFull, http.StatusInsufficientStorage},
+	{datastore.ErrCorrupted, http.StatusInternalServerError},
+	{context.DeadlineExceeded, http.StatusGatewayTimeout},
+}
+
+func errorStatus(err error) (int, error) {
+	for _, e := range errorStatuses {
+		if errors.Is(err, e.err) {
+			return e.status, e.err
+		}
+	}
+	return http.StatusInternalServerError, nil
+}
+
+// errorMessage hides internal details such as segment paths from clients.
+func errorMessage(err error) string {
+	if _, known := errorStatus(err); known != nil {
+		return known.Error()
+	}
+	return "db error"
+}
+
+func (h *Handler) writeError(w http.ResponseWriter, err error) {
+	status, known := errorStatus(err)
+	if known == datastore.ErrTypeMismatch {
+		h.rejections.inc(reasonTypeMismatch)
+	}
+	if status >= http.StatusInternalServerError {
+		log.Printf("db error: %v", err)
 	}
+	http.Error(w, errorMessage(err), status)
 }
 
 func (h *Handler) respondJSON(w http.ResponseWriter, data any) {
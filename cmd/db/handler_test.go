@@ -0,0 +1,556 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+func newTestHandler(t *testing.T) *Handler {
+	db, err := datastore.Open(t.TempDir(), datastore.Mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	return NewHandler(db)
+}
+
+func doRequest(h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return rr
+}
+
+func TestHandler_ErrorStatuses(t *testing.T) {
+	h := newTestHandler(t)
+
+	if rr := doRequest(h, "POST", "/db/str", `{"value": "text"}`); rr.Code != http.StatusOK {
+		t.Fatalf("POST failed with %d", rr.Code)
+	}
+
+	tests := []struct {
+		name, method, target, body string
+		status                     int
+	}{
+		{"missing key", "GET", "/db/missing", "", http.StatusNotFound},
+		{"type mismatch", "GET", "/db/str?type=int64", "", http.StatusConflict},
+		{"incr on string", "POST", "/db/str/incr", `{"delta": 1}`, http.StatusConflict},
+		{"cas mismatch", "POST", "/db/str/cas", `{"old": "x", "new": "y"}`, http.StatusConflict},
+		{"cas on missing", "POST", "/db/missing/cas", `{"old": "x", "new": "y"}`, http.StatusNotFound},
+		{"invalid type", "GET", "/db/str?type=float", "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := doRequest(h, tt.method, tt.target, tt.body); rr.Code != tt.status {
+				t.Errorf("%s %s: expected status %d, got %d (%s)", tt.method, tt.target, tt.status, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	h.db.SetReadOnly(true)
+	if rr := doRequest(h, "POST", "/db/str", `{"value": "other"}`); rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST on read-only db: expected status 405, got %d", rr.Code)
+	}
+
+	h.db.SetReadOnly(false)
+	h.db.SetQuota(1)
+	if rr := doRequest(h, "POST", "/db/str", `{"value": "other"}`); rr.Code != http.StatusInsufficientStorage {
+		t.Errorf("POST over quota: expected status 507, got %d", rr.Code)
+	}
+}
+
+func TestHandler_Delete(t *testing.T) {
+	h := newTestHandler(t)
+
+	if rr := doRequest(h, "POST", "/db/k", `{"value": "v"}`); rr.Code != http.StatusOK {
+		t.Fatalf("POST failed with %d", rr.Code)
+	}
+	if rr := doRequest(h, "DELETE", "/db/k", ""); rr.Code != http.StatusOK {
+		t.Errorf("DELETE: expected status 200, got %d", rr.Code)
+	}
+	if rr := doRequest(h, "GET", "/db/k", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("GET after DELETE: expected status 404, got %d", rr.Code)
+	}
+}
+
+func TestHandler_PostIfAbsent(t *testing.T) {
+	h := newTestHandler(t)
+
+	if rr := doRequest(h, "POST", "/db/k?if=absent", `{"value": "first"}`); rr.Code != http.StatusOK {
+		t.Errorf("first conditional POST: expected status 200, got %d", rr.Code)
+	}
+	if rr := doRequest(h, "POST", "/db/k?if=absent", `{"value": "second"}`); rr.Code != http.StatusConflict {
+		t.Errorf("second conditional POST: expected status 409, got %d", rr.Code)
+	}
+	if rr := doRequest(h, "POST", "/db/k?if=present", `{"value": "x"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("unknown condition: expected status 400, got %d", rr.Code)
+	}
+	if rr := doRequest(h, "GET", "/db/k", ""); !strings.Contains(rr.Body.String(), "first") {
+		t.Errorf("conditional POST overwrote the value: %s", rr.Body.String())
+	}
+}
+
+func TestHandler_GetDefault(t *testing.T) {
+	h := newTestHandler(t)
+
+	if rr := doRequest(h, "POST", "/db/str", `{"value": "text"}`); rr.Code != http.StatusOK {
+		t.Fatalf("POST failed with %d", rr.Code)
+	}
+
+	tests := []struct {
+		target, body string
+		status       int
+		isDefault    bool
+	}{
+		{"/db/str?default=fallback", `"value":"text"`, http.StatusOK, false},
+		{"/db/missing?default=fallback", `"value":"fallback"`, http.StatusOK, true},
+		{"/db/missing?default=", `"value":""`, http.StatusOK, true},
+		{"/db/missing?type=int64&default=42", `"value":42`, http.StatusOK, true},
+		{"/db/missing?type=int64&default=abc", "default must be an int64", http.StatusBadRequest, false},
+		{"/db/str?type=int64&default=abc", "default must be an int64", http.StatusBadRequest, false},
+		{"/db/str?type=int64&default=1", "", http.StatusConflict, false},
+	}
+	for _, tt := range tests {
+		rr := doRequest(h, "GET", tt.target, "")
+		if rr.Code != tt.status || !strings.Contains(rr.Body.String(), tt.body) {
+			t.Errorf("GET %s: status %d, body %s", tt.target, rr.Code, rr.Body.String())
+		}
+		if got := rr.Header().Get("x-db-default") == "true"; got != tt.isDefault {
+			t.Errorf("GET %s: x-db-default header %t, want %t", tt.target, got, tt.isDefault)
+		}
+	}
+}
+
+func TestHandler_BodyLimit(t *testing.T) {
+	h := newTestHandler(t)
+	h.maxBodyBytes = 32
+
+	if rr := doRequest(h, "POST", "/db/k", `{"value": "short"}`); rr.Code != http.StatusOK {
+		t.Errorf("small body: expected status 200, got %d", rr.Code)
+	}
+	large := `{"value": "` + strings.Repeat("x", 64) + `"}`
+	if rr := doRequest(h, "POST", "/db/k", large); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("large body: expected status 413, got %d", rr.Code)
+	}
+	if rr := doRequest(h, "POST", "/db/k/cas", `{"old": "short", "new": "`+strings.Repeat("y", 64)+`"}`); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("large cas body: expected status 413, got %d", rr.Code)
+	}
+}
+
+func TestHandler_HealthReady(t *testing.T) {
+	h := newTestHandler(t)
+
+	if rr := doRequest(h, "GET", "/health", ""); rr.Code != http.StatusOK {
+		t.Errorf("/health: expected status 200, got %d", rr.Code)
+	}
+	if rr := doRequest(h, "GET", "/ready", ""); rr.Code != http.StatusOK {
+		t.Errorf("/ready: expected status 200, got %d (%s)", rr.Code, rr.Body.String())
+	}
+
+	h.minFreeBytes = 1 << 62
+	if rr := doRequest(h, "GET", "/ready", ""); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("/ready with low disk space: expected status 503, got %d", rr.Code)
+	}
+	h.minFreeBytes = 0
+
+	h.db.SetReadOnly(true)
+	if rr := doRequest(h, "GET", "/ready", ""); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("/ready on read-only primary: expected status 503, got %d", rr.Code)
+	}
+	h.readOnly = true
+	if rr := doRequest(h, "GET", "/ready", ""); rr.Code != http.StatusOK {
+		t.Errorf("/ready on replica: expected status 200, got %d", rr.Code)
+	}
+
+	_ = h.db.Close()
+	if rr := doRequest(h, "GET", "/ready", ""); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("/ready on closed db: expected status 503, got %d", rr.Code)
+	}
+}
+
+func TestHandler_DiskFull(t *testing.T) {
+	db, err := datastore.OpenWithOptions(t.TempDir(), datastore.WithDiskWatchdog(time.Hour, 0, 1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	h := NewHandler(db)
+
+	if rr := doRequest(h, "POST", "/db/key", `{"value": "text"}`); rr.Code != http.StatusInsufficientStorage {
+		t.Errorf("POST on a full disk: expected status 507, got %d (%s)", rr.Code, rr.Body.String())
+	}
+	if rr := doRequest(h, "GET", "/ready", ""); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("/ready on a full disk: expected status 503, got %d", rr.Code)
+	}
+}
+
+func TestHandler_Metrics(t *testing.T) {
+	h := newTestHandler(t)
+
+	doRequest(h, "POST", "/db/k", `{"value": "v"}`)
+	doRequest(h, "GET", "/db/k", "")
+	doRequest(h, "GET", "/db/missing", "")
+	doRequest(h, "POST", "/db/k", `{"value": `)
+	doRequest(h, "POST", "/db/k", `{}`)
+	doRequest(h, "POST", "/db/k", `{"value": true}`)
+	doRequest(h, "GET", "/db/k?type=int64", "")
+
+	rr := doRequest(h, "GET", "/metrics", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GET /metrics: expected status 200, got %d", rr.Code)
+	}
+	for _, line := range []string{
+		"# TYPE datastore_puts_total counter",
+		"datastore_puts_total 1\n",
+		"datastore_gets_total 3\n",
+		"datastore_misses_total 1\n",
+		"datastore_segments 1\n",
+		`db_requests_rejected_total{reason="invalid_json"} 1`,
+		`db_requests_rejected_total{reason="missing_value"} 1`,
+		`db_requests_rejected_total{reason="unsupported_type"} 1`,
+		`db_requests_rejected_total{reason="type_mismatch"} 1`,
+		`datastore_task_up{task="io-worker"} 1`,
+		`datastore_segment_superseded{segment="0"} 0`,
+	} {
+		if !strings.Contains(rr.Body.String(), line) {
+			t.Errorf("metrics output misses %q:\n%s", line, rr.Body.String())
+		}
+	}
+}
+
+func TestHandler_ReadOnlyStore(t *testing.T) {
+	dir := t.TempDir()
+	db, err := datastore.Open(dir, datastore.Mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	_ = db.Close()
+
+	db, err = datastore.OpenWithOptions(dir, datastore.WithReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	h := NewHandler(db)
+	h.readOnly = true
+
+	if rr := doRequest(h, "GET", "/db/key", ""); rr.Code != http.StatusOK {
+		t.Errorf("GET: expected status 200, got %d", rr.Code)
+	}
+	if rr := doRequest(h, "POST", "/db/key", `{"value": "new"}`); rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: expected status 405, got %d", rr.Code)
+	}
+	if rr := doRequest(h, "DELETE", "/db/key", ""); rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE: expected status 405, got %d", rr.Code)
+	}
+	if rr := doRequest(h, "GET", "/ready", ""); rr.Code != http.StatusOK {
+		t.Errorf("/ready: expected status 200, got %d (%s)", rr.Code, rr.Body.String())
+	}
+}
+
+func TestHandler_Deadline(t *testing.T) {
+	h := newTestHandler(t)
+	if rr := doRequest(h, "POST", "/db/key", `{"value": "v"}`); rr.Code != http.StatusOK {
+		t.Fatalf("POST failed with %d", rr.Code)
+	}
+
+	withHeader := func(method, target, deadline string, ctx context.Context) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(`{"value": "new"}`)).WithContext(ctx)
+		req.Header.Set(deadlineHeader, deadline)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := withHeader("GET", "/db/key", "soon", context.Background()); rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid deadline: expected status 400, got %d", rr.Code)
+	}
+	if rr := withHeader("GET", "/db/key", "1000", context.Background()); rr.Code != http.StatusOK {
+		t.Errorf("GET within deadline: expected status 200, got %d", rr.Code)
+	}
+
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	for _, method := range []string{"GET", "POST", "DELETE"} {
+		if rr := withHeader(method, "/db/key", "1000", expired); rr.Code != http.StatusGatewayTimeout {
+			t.Errorf("%s past deadline: expected status 504, got %d", method, rr.Code)
+		}
+	}
+	rr := withHeader("GET", "/db?keys=key,other", "1000", expired)
+	if rr.Code != http.StatusGatewayTimeout || !strings.Contains(rr.Body.String(), `"requested":2`) {
+		t.Errorf("multi-get past deadline: expected 504 with progress, got %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := doRequest(h, "GET", "/db/key", ""); !strings.Contains(rr.Body.String(), `"v"`) {
+		t.Errorf("writes past their deadline must not be applied, got %s", rr.Body.String())
+	}
+}
+
+func TestHandler_Versions(t *testing.T) {
+	h := newTestHandler(t)
+	if rr := doRequest(h, "POST", "/db/key", `{"value": "v1"}`); rr.Code != http.StatusOK {
+		t.Fatalf("POST failed with %d", rr.Code)
+	}
+	rr := doRequest(h, "GET", "/db/key", "")
+	etag := rr.Header().Get("ETag")
+	if etag == "" || rr.Header().Get("Last-Modified") == "" {
+		t.Fatalf("expected ETag and Last-Modified, got %v", rr.Header())
+	}
+
+	conditional := func(method, ifMatch, body string) int {
+		req := httptest.NewRequest(method, "/db/key", strings.NewReader(body))
+		req.Header.Set("If-Match", ifMatch)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	if code := conditional("POST", `"1"`, `{"value": "v2"}`); code != http.StatusPreconditionFailed {
+		t.Errorf("POST with a stale ETag: expected 412, got %d", code)
+	}
+	if code := conditional("POST", "1", `{"value": "v2"}`); code != http.StatusBadRequest {
+		t.Errorf("POST with a malformed If-Match: expected 400, got %d", code)
+	}
+	if code := conditional("POST", etag, `{"value": "v2"}`); code != http.StatusOK {
+		t.Errorf("POST with the current ETag: expected 200, got %d", code)
+	}
+	if code := conditional("POST", etag, `{"value": "v3"}`); code != http.StatusPreconditionFailed {
+		t.Errorf("POST reusing a consumed ETag: expected 412, got %d", code)
+	}
+	if code := conditional("POST", "*", `{"value": 3}`); code != http.StatusOK {
+		t.Errorf("POST with If-Match * on an existing key: expected 200, got %d", code)
+	}
+
+	rr = doRequest(h, "GET", "/db/key?type=int64", "")
+	if rr.Header().Get("ETag") == etag {
+		t.Error("expected the ETag to change after a write")
+	}
+	if code := conditional("DELETE", rr.Header().Get("ETag"), ""); code != http.StatusOK {
+		t.Errorf("DELETE with the current ETag: expected 200, got %d", code)
+	}
+	if code := conditional("POST", "*", `{"value": "v4"}`); code != http.StatusPreconditionFailed {
+		t.Errorf("POST with If-Match * on a missing key: expected 412, got %d", code)
+	}
+}
+
+func TestHandler_Purge(t *testing.T) {
+	db, err := datastore.Open(t.TempDir(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	h := NewHandler(db)
+
+	doRequest(h, "POST", "/db/k", `{"value": "v1"}`)
+	doRequest(h, "POST", "/db/k", `{"value": "v2"}`)
+	if rr := doRequest(h, "POST", "/admin/purge?threshold=2", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid threshold: expected status 400, got %d", rr.Code)
+	}
+	rr := doRequest(h, "POST", "/admin/purge", "")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"purged_segments":1`) {
+		t.Errorf("purge: status %d, body %s", rr.Code, rr.Body.String())
+	}
+	if rr := doRequest(h, "GET", "/db/k", ""); !strings.Contains(rr.Body.String(), `"value":"v2"`) {
+		t.Errorf("GET after purge: status %d, body %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestHandler_Stats(t *testing.T) {
+	db, err := datastore.Open(t.TempDir(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	h := NewHandler(db)
+
+	doRequest(h, "POST", "/db/k", `{"value": "v1"}`)
+	doRequest(h, "POST", "/db/k", `{"value": "v2"}`)
+	doRequest(h, "POST", "/db/other", `{"value": "v"}`)
+	doRequest(h, "GET", "/db/k", "")
+	doRequest(h, "POST", "/admin/purge", "")
+
+	rr := doRequest(h, "GET", "/admin/stats", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("stats: status %d, body %s", rr.Code, rr.Body.String())
+	}
+	var report keyspaceStats
+	if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Keys != 2 || report.Puts != 3 || report.Gets != 1 {
+		t.Errorf("unexpected counts %+v", report)
+	}
+	if report.SegmentCount != len(report.Segments) || report.Bytes == 0 {
+		t.Errorf("unexpected segments %+v", report)
+	}
+	if len(report.Compactions) != 1 || report.Compactions[0].Kind != "purge" || report.Compactions[0].ReclaimedBytes <= 0 {
+		t.Errorf("unexpected compaction history %+v", report.Compactions)
+	}
+	if rr := doRequest(h, "POST", "/admin/stats", ""); rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: expected status 405, got %d", rr.Code)
+	}
+}
+
+func TestHandler_Buckets(t *testing.T) {
+	h := newTestHandler(t)
+	h.buckets = map[string]bool{"users": true, "orders": true, "counters": true}
+
+	if rr := doRequest(h, "POST", "/db/users/42", `{"value": "alice"}`); rr.Code != http.StatusOK {
+		t.Fatalf("POST to a bucket failed with %d", rr.Code)
+	}
+	doRequest(h, "POST", "/db/42", `{"value": "plain"}`)
+	if rr := doRequest(h, "GET", "/db/users/42?fields=key,value", ""); !strings.Contains(rr.Body.String(), `{"key":"42","value":"alice"}`) {
+		t.Errorf("GET from a bucket: status %d, body %s", rr.Code, rr.Body.String())
+	}
+	if rr := doRequest(h, "GET", "/db/42", ""); !strings.Contains(rr.Body.String(), `"plain"`) {
+		t.Errorf("GET outside buckets: status %d, body %s", rr.Code, rr.Body.String())
+	}
+	if rr := doRequest(h, "GET", "/db/orders/42", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("GET from another bucket: expected status 404, got %d", rr.Code)
+	}
+	if rr := doRequest(h, "POST", "/db/counters/hits/incr", `{"delta": 2}`); !strings.Contains(rr.Body.String(), `{"key":"hits","value":2}`) {
+		t.Errorf("incr in a bucket: status %d, body %s", rr.Code, rr.Body.String())
+	}
+	if v, err := h.db.Bucket("counters").GetInt64("hits"); err != nil || v != 2 {
+		t.Errorf("counters/hits = %d, %v", v, err)
+	}
+	if rr := doRequest(h, "GET", "/db/users%2F42", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("an escaped slash must not separate a bucket: expected status 404, got %d", rr.Code)
+	}
+
+	if err := h.db.Bucket("orders").SetQuota(1); err != nil {
+		t.Fatal(err)
+	}
+	if rr := doRequest(h, "POST", "/db/orders/42", `{"value": "big"}`); rr.Code != http.StatusInsufficientStorage {
+		t.Errorf("POST over the bucket quota: expected status 507, got %d", rr.Code)
+	}
+	if rr := doRequest(h, "POST", "/db/users/43", `{"value": "bob"}`); rr.Code != http.StatusOK {
+		t.Errorf("POST to a bucket under its quota failed with %d", rr.Code)
+	}
+}
+
+func TestHandler_KeysWithSlashes(t *testing.T) {
+	h := newTestHandler(t)
+
+	// Without -buckets, paths keep meaning the keys they meant before
+	// buckets existed.
+	if rr := doRequest(h, "POST", "/db/users/42", `{"value": "alice"}`); rr.Code != http.StatusOK {
+		t.Fatalf("POST failed with %d", rr.Code)
+	}
+	if v, err := h.db.Get("users/42"); err != nil || v != "alice" {
+		t.Errorf("users/42 = %q, %v", v, err)
+	}
+	if rr := doRequest(h, "POST", "/db/a/b/incr", `{"delta": 1}`); !strings.Contains(rr.Body.String(), `{"key":"a/b","value":1}`) {
+		t.Errorf("incr of a key with a slash: status %d, body %s", rr.Code, rr.Body.String())
+	}
+
+	// Declaring the bucket routes the path to it; the old key stays
+	// reachable with the slash escaped.
+	h.buckets = map[string]bool{"users": true}
+	if rr := doRequest(h, "GET", "/db/users/42", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("GET from a declared bucket: expected status 404, got %d", rr.Code)
+	}
+	if rr := doRequest(h, "GET", "/db/users%2F42", ""); !strings.Contains(rr.Body.String(), `"alice"`) {
+		t.Errorf("GET with an escaped slash: status %d, body %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestHandler_SizeLimits(t *testing.T) {
+	db, err := datastore.OpenWithOptions(t.TempDir(), datastore.WithMaxKeySize(8), datastore.WithMaxValueSize(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	h := NewHandler(db)
+
+	if rr := doRequest(h, "POST", "/db/"+strings.Repeat("k", 9), `{"value": "v"}`); rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "key too large") {
+		t.Errorf("long key: expected status 400, got %d (%s)", rr.Code, rr.Body.String())
+	}
+	if rr := doRequest(h, "POST", "/db/k", `{"value": "`+strings.Repeat("v", 17)+`"}`); rr.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rr.Body.String(), "value too large") {
+		t.Errorf("long value: expected status 413, got %d (%s)", rr.Code, rr.Body.String())
+	}
+}
+
+func TestHandler_GetFields(t *testing.T) {
+	h := newTestHandler(t)
+	doRequest(h, "POST", "/db/str", `{"value": "text"}`)
+	doRequest(h, "POST", "/db/num", `{"value": 42}`)
+
+	tests := []struct {
+		target, body string
+		status       int
+	}{
+		{"/db/str", `{"key":"str","value":"text"}`, http.StatusOK},
+		{"/db/str?fields=value", `{"value":"text"}`, http.StatusOK},
+		{"/db/str?fields=key", `{"key":"str"}`, http.StatusOK},
+		{"/db/str?fields=value,key", `{"key":"str","value":"text"}`, http.StatusOK},
+		{"/db/str?envelope=false", `"text"`, http.StatusOK},
+		{"/db/num?type=int64&fields=value&envelope=false", `42`, http.StatusOK},
+		{"/db/missing?fields=value&default=x", `{"value":"x"}`, http.StatusOK},
+		{"/db/str?fields=version", `unknown field "version"`, http.StatusBadRequest},
+		{"/db/str?fields=", `unknown field ""`, http.StatusBadRequest},
+		{"/db/str?fields=key&envelope=false", "only returns the value", http.StatusBadRequest},
+		{"/db/str?envelope=no", "envelope must be true or false", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rr := doRequest(h, "GET", tt.target, "")
+		if rr.Code != tt.status || !strings.Contains(rr.Body.String(), tt.body) {
+			t.Errorf("GET %s: status %d, body %s", tt.target, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestHandler_Wait(t *testing.T) {
+	h := newTestHandler(t)
+	if rr := doRequest(h, "POST", "/db/key", `{"value": "v1"}`); rr.Code != http.StatusOK {
+		t.Fatalf("POST failed with %d", rr.Code)
+	}
+	etag := doRequest(h, "GET", "/db/key", "").Header().Get("ETag")
+	version := strings.Trim(etag, `"`)
+
+	rr := doRequest(h, "GET", "/db/key?wait=50ms&since_version="+version, "")
+	if rr.Code != http.StatusNotModified || rr.Header().Get("ETag") != etag {
+		t.Errorf("expected 304 with ETag %s after an unchanged wait, got %d %q", etag, rr.Code, rr.Header().Get("ETag"))
+	}
+	if rr := doRequest(h, "GET", "/db/key?wait=1s&since_version=0", ""); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "v1") {
+		t.Errorf("expected a stale version to return at once, got %d %s", rr.Code, rr.Body)
+	}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- doRequest(h, "GET", "/db/key?wait=10s&since_version="+version, "") }()
+	time.Sleep(20 * time.Millisecond)
+	if rr := doRequest(h, "POST", "/db/key", `{"value": "v2"}`); rr.Code != http.StatusOK {
+		t.Fatalf("POST failed with %d", rr.Code)
+	}
+	select {
+	case rr := <-done:
+		if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "v2") || rr.Header().Get("ETag") == etag {
+			t.Errorf("expected the new value, got %d %s", rr.Code, rr.Body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the long poll did not return after the write")
+	}
+
+	for _, target := range []string{"/db/key?wait=soon&since_version=1", "/db/key?wait=1s"} {
+		if rr := doRequest(h, "GET", target, ""); rr.Code != http.StatusBadRequest {
+			t.Errorf("GET %s: expected 400, got %d", target, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+// Reasons for rejecting a request, exported as the reason label of
+// db_requests_rejected_total.
+const (
+	reasonInvalidJSON     = "invalid_json"
+	reasonMissingValue    = "missing_value"
+	reasonUnsupportedType = "unsupported_type"
+	reasonTypeMismatch    = "type_mismatch"
+	reasonInvalidDeadline = "invalid_deadline"
+	reasonUnauthenticated = "unauthenticated"
+	reasonForbidden       = "forbidden"
+	reasonInvalidDefault  = "invalid_default"
+	reasonInvalidFields   = "invalid_fields"
+	reasonInvalidWait     = "invalid_wait"
+	reasonInvalidKey      = "invalid_key"
+)
+
+var rejectionReasons = []string{reasonInvalidJSON, reasonMissingValue, reasonUnsupportedType, reasonTypeMismatch, reasonInvalidDeadline, reasonUnauthenticated, reasonForbidden, reasonInvalidDefault, reasonInvalidFields, reasonInvalidWait, reasonInvalidKey}
+
+type rejectionCounters map[string]*atomic.Int64
+
+func newRejectionCounters() rejectionCounters {
+	c := make(rejectionCounters, len(rejectionReasons))
+	for _, reason := range rejectionReasons {
+		c[reason] = new(atomic.Int64)
+	}
+	return c
+}
+
+func (c rejectionCounters) inc(reason string) {
+	if counter, ok := c[reason]; ok {
+		counter.Add(1)
+	}
+}
+
+// reject answers a malformed request with 400 and counts it.
+func (h *Handler) reject(w http.ResponseWriter, reason, msg string) {
+	h.rejections.inc(reason)
+	http.Error(w, msg, http.StatusBadRequest)
+}
+
+type metric struct {
+	name, help, kind string
+	value            float64
+}
+
+// handleMetrics serves the datastore stats in the Prometheus text exposition
+// format.
+func (h *Handler) handleMetrics(w http.ResponseWriter) {
+	stats := h.db.Stats()
+	metrics := []metric{
+		{"datastore_puts_total", "Entries written to the store.", "counter", float64(stats.Puts)},
+		{"datastore_deletes_total", "Tombstones written to the store.", "counter", float64(stats.Deletes)},
+		{"datastore_gets_total", "Key lookups.", "counter", float64(stats.Gets)},
+		{"datastore_misses_total", "Key lookups that found no value.", "counter", float64(stats.Misses)},
+		{"datastore_merges_total", "Segment merge runs.", "counter", float64(stats.Merges)},
+		{"datastore_merge_seconds_total", "Time spent merging segments.", "counter", stats.MergeDuration.Seconds()},
+		{"datastore_group_commits_total", "Writes that committed one or more queued puts together.", "counter", float64(stats.GroupCommits)},
+		{"datastore_segments", "Number of segment files.", "gauge", float64(stats.Segments)},
+		{"datastore_bytes", "Total size of all segments in bytes.", "gauge", float64(stats.Bytes)},
+	}
+	if h.mirror != nil {
+		metrics = append(metrics, h.mirror.metrics()...)
+	}
+
+	var b strings.Builder
+	for _, m := range metrics {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", m.name, m.help, m.name, m.kind, m.name, m.value)
+	}
+	b.WriteString("# HELP db_requests_rejected_total Requests rejected because of malformed input or value type mismatches.\n")
+	b.WriteString("# TYPE db_requests_rejected_total counter\n")
+	for _, reason := range rejectionReasons {
+		fmt.Fprintf(&b, "db_requests_rejected_total{reason=%q} %d\n", reason, h.rejections[reason].Load())
+	}
+	b.WriteString("# HELP datastore_task_up Whether a background task of the store is running.\n")
+	b.WriteString("# TYPE datastore_task_up gauge\n")
+	for _, task := range stats.Tasks {
+		up := 0
+		if task.State == datastore.TaskRunning {
+			up = 1
+		}
+		fmt.Fprintf(&b, "datastore_task_up{task=%q} %d\n", task.Name, up)
+	}
+	b.WriteString("# HELP datastore_task_restarts_total Restarts of a background task after a panic.\n")
+	b.WriteString("# TYPE datastore_task_restarts_total counter\n")
+	for _, task := range stats.Tasks {
+		fmt.Fprintf(&b, "datastore_task_restarts_total{task=%q} %d\n", task.Name, task.Restarts)
+	}
+	if garbage, err := h.db.Garbage(); err != nil {
+		log.Printf("metrics: cannot count segment garbage: %v", err)
+	} else {
+		b.WriteString("# HELP datastore_segment_tombstones Tombstones in a segment.\n")
+		b.WriteString("# TYPE datastore_segment_tombstones gauge\n")
+		for _, g := range garbage {
+			fmt.Fprintf(&b, "datastore_segment_tombstones{segment=\"%d\"} %d\n", g.ID, g.Tombstones)
+		}
+		b.WriteString("# HELP datastore_segment_superseded Values in a segment overwritten or deleted by a later entry.\n")
+		b.WriteString("# TYPE datastore_segment_superseded gauge\n")
+		for _, g := range garbage {
+			fmt.Fprintf(&b, "datastore_segment_superseded{segment=\"%d\"} %d\n", g.ID, g.Superseded)
+		}
+		b.WriteString("# HELP datastore_segment_garbage_ratio Share of a segment's entries that compaction would drop.\n")
+		b.WriteString("# TYPE datastore_segment_garbage_ratio gauge\n")
+		for _, g := range garbage {
+			fmt.Fprintf(&b, "datastore_segment_garbage_ratio{segment=\"%d\"} %g\n", g.ID, g.Ratio())
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = w.Write([]byte(b.String()))
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+var (
+	mirrorTo         = flag.String("mirror-to", "", "Base URL of a secondary db every write is asynchronously mirrored to, e.g. to migrate to a new deployment (empty disables mirroring)")
+	mirrorToken      = flag.String("mirror-token", os.Getenv("DB_MIRROR_TOKEN"), "Bearer token with write access sent to the mirror (defaults to $DB_MIRROR_TOKEN)")
+	mirrorQueueBytes = flag.Int64("mirror-queue-bytes", 64*datastore.Mi, "Writes kept for replay while the mirror is unreachable; beyond this the mirror is resynchronized from a snapshot")
+)
+
+const (
+	mirrorRetryDelay = time.Second
+	// mirrorMaxRequest bounds the queued writes sent in one apply request.
+	mirrorMaxRequest = 1 << 20
+)
+
+type mirrorItem struct {
+	data []byte
+	at   time.Time
+}
+
+// mirror copies every entry appended to db to a secondary db service through
+// its /admin/apply endpoint. It starts with a snapshot, so the secondary ends
+// up with a full copy, then replays writes from a queue that keeps them while
+// the secondary is unreachable. If the queue overflows, or the store drops
+// the subscription, the mirror starts over with a fresh snapshot.
+type mirror struct {
+	db       *datastore.Db
+	url      string
+	token    string
+	client   *http.Client
+	maxQueue int64
+	// retryDelay is the pause between failed requests to the secondary.
+	retryDelay time.Duration
+
+	mu     sync.Mutex
+	queue  []mirrorItem
+	queued int64
+	resync bool
+	wake   chan struct{}
+
+	sent     atomic.Int64
+	failures atomic.Int64
+	resyncs  atomic.Int64
+}
+
+func newMirror(db *datastore.Db, secondary, token string, maxQueue int64) *mirror {
+	return &mirror{
+		db:         db,
+		url:        secondary + "/admin/apply",
+		token:      token,
+		client:     http.DefaultClient,
+		maxQueue:   maxQueue,
+		retryDelay: mirrorRetryDelay,
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// run mirrors writes until ctx is done.
+func (m *mirror) run(ctx context.Context) {
+	for ctx.Err() == nil {
+		m.sync(ctx)
+		if ctx.Err() == nil {
+			m.resyncs.Add(1)
+			log.Printf("mirror: resynchronizing %s from a snapshot", m.url)
+		}
+	}
+}
+
+// sync sends a snapshot and then the queued writes until a resync is needed
+// or ctx is done. The subscription is taken before the snapshot, so writes
+// made during it are replayed afterwards.
+func (m *mirror) sync(ctx context.Context) {
+	updates, cancel := m.db.Subscribe()
+	m.mu.Lock()
+	m.queue, m.queued, m.resync = nil, 0, false
+	m.mu.Unlock()
+
+	collected := make(chan struct{})
+	go func() {
+		defer close(collected)
+		for data := range updates {
+			m.enqueue(data)
+		}
+		m.mu.Lock()
+		m.resync = true
+		m.mu.Unlock()
+		m.notify()
+	}()
+	defer func() {
+		cancel()
+		<-collected
+	}()
+
+	if !m.retry(ctx, m.sendSnapshot) {
+		return
+	}
+	log.Printf("mirror: snapshot sent to %s, replaying writes", m.url)
+	for {
+		data, n, ok := m.next(ctx)
+		if !ok {
+			return
+		}
+		if !m.retry(ctx, func(ctx context.Context) error { return m.post(ctx, bytes.NewReader(data)) }) {
+			return
+		}
+		m.ack(n)
+	}
+}
+
+func (m *mirror) notify() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (m *mirror) enqueue(data []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.resync {
+		return
+	}
+	m.queue = append(m.queue, mirrorItem{data: data, at: time.Now()})
+	m.queued += int64(len(data))
+	if m.queued > m.maxQueue {
+		log.Printf("mirror: replay queue exceeded %d bytes", m.maxQueue)
+		m.queue, m.queued, m.resync = nil, 0, true
+	}
+	m.notify()
+}
+
+// next waits for queued writes and returns up to mirrorMaxRequest bytes of
+// them along with their number. It returns false once a resync is needed or
+// ctx is done.
+func (m *mirror) next(ctx context.Context) ([]byte, int, bool) {
+	for {
+		m.mu.Lock()
+		if m.resync {
+			m.mu.Unlock()
+			return nil, 0, false
+		}
+		if len(m.queue) > 0 {
+			var data []byte
+			n := 0
+			for n < len(m.queue) && (n == 0 || len(data)+len(m.queue[n].data) <= mirrorMaxRequest) {
+				data = append(data, m.queue[n].data...)
+				n++
+			}
+			m.mu.Unlock()
+			return data, n, true
+		}
+		m.mu.Unlock()
+
+		select {
+		case <-m.wake:
+		case <-ctx.Done():
+			return nil, 0, false
+		}
+	}
+}
+
+// ack drops the first n queued writes once the secondary has applied them.
+func (m *mirror) ack(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.resync {
+		return
+	}
+	for _, item := range m.queue[:n] {
+		m.queued -= int64(len(item.data))
+	}
+	m.queue = m.queue[n:]
+	m.sent.Add(int64(n))
+}
+
+// retry calls send until it succeeds. It gives up, returning false, when ctx
+// is done or a resync is needed.
+func (m *mirror) retry(ctx context.Context, send func(context.Context) error) bool {
+	for {
+		err := send(ctx)
+		if err == nil {
+			return true
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+		m.failures.Add(1)
+		log.Printf("mirror: %v", err)
+		m.mu.Lock()
+		resync := m.resync
+		m.mu.Unlock()
+		if resync {
+			return false
+		}
+		select {
+		case <-time.After(m.retryDelay):
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+func (m *mirror) sendSnapshot(ctx context.Context) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(m.db.Snapshot(pw))
+	}()
+	// The transport closes the body even on errors, which stops the
+	// snapshot writer. A full snapshot makes the secondary delete the keys
+	// it lacks, which covers the deletes missed while resynchronizing.
+	return m.postTo(ctx, m.url+"?snapshot=full", pr)
+}
+
+func (m *mirror) post(ctx context.Context, body io.Reader) error {
+	return m.postTo(ctx, m.url, body)
+}
+
+func (m *mirror) postTo(ctx context.Context, url string, body io.Reader) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if m.token != "" {
+		req.Header.Set("Authorization", "Bearer "+m.token)
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("apply on %s: unexpected response status: %s", m.url, resp.Status)
+	}
+	return nil
+}
+
+// lag is how long the oldest write not yet applied by the secondary has been
+// waiting.
+func (m *mirror) lag() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.queue) == 0 {
+		return 0
+	}
+	return time.Since(m.queue[0].at)
+}
+
+func (m *mirror) metrics() []metric {
+	m.mu.Lock()
+	entries, queued := len(m.queue), m.queued
+	m.mu.Unlock()
+	return []metric{
+		{"db_mirror_lag_seconds", "Age of the oldest write not yet applied by the mirror.", "gauge", m.lag().Seconds()},
+		{"db_mirror_queue_writes", "Writes waiting to be replayed on the mirror.", "gauge", float64(entries)},
+		{"db_mirror_queue_bytes", "Size of the writes waiting to be replayed on the mirror.", "gauge", float64(queued)},
+		{"db_mirror_writes_total", "Writes applied by the mirror.", "counter", float64(m.sent.Load())},
+		{"db_mirror_failures_total", "Failed requests to the mirror.", "counter", float64(m.failures.Load())},
+		{"db_mirror_resyncs_total", "Snapshots resent to the mirror after overflows.", "counter", float64(m.resyncs.Load())},
+	}
+}
+
+func (h *Handler) handleApply(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	// A snapshot may legitimately take longer than the server-wide timeouts.
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{})
+	apply := h.db.ApplyStream
+	if r.URL.Query().Get("snapshot") == "full" {
+		apply = h.db.ApplySnapshot
+	}
+	if err := apply(r.Body); err != nil {
+		h.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
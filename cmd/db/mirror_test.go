@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMirror(t *testing.T) {
+	primary := newTestHandler(t)
+	secondary := newTestHandler(t)
+	var down atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		secondary.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	if err := primary.db.Put("existing", "old"); err != nil {
+		t.Fatal(err)
+	}
+	m := newMirror(primary.db, srv.URL, "", datastore.Mi)
+	m.retryDelay = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	has := func(key, want string) func() bool {
+		return func() bool {
+			value, err := secondary.db.Get(key)
+			return err == nil && value == want
+		}
+	}
+	waitFor(t, "the snapshot", has("existing", "old"))
+
+	if err := primary.db.Put("new", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := primary.db.Delete("existing"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the write", has("new", "value"))
+	waitFor(t, "the delete", func() bool {
+		_, err := secondary.db.Get("existing")
+		return err != nil
+	})
+
+	down.Store(true)
+	if err := primary.db.Put("queued", "value"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "a failed attempt", func() bool { return m.failures.Load() > 0 })
+	if m.lag() <= 0 {
+		t.Error("expected a lag while the mirror is down")
+	}
+	down.Store(false)
+	waitFor(t, "the replay", has("queued", "value"))
+	waitFor(t, "an empty queue", func() bool { return m.lag() == 0 })
+
+	primary.mirror = m
+	rr := doRequest(primary, "GET", "/metrics", "")
+	if !strings.Contains(rr.Body.String(), "db_mirror_lag_seconds 0") {
+		t.Errorf("metrics do not report the mirror lag:\n%s", rr.Body.String())
+	}
+}
+
+func TestMirror_Resync(t *testing.T) {
+	primary := newTestHandler(t)
+	secondary := newTestHandler(t)
+	var down atomic.Bool
+	down.Store(true)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		secondary.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	// The key was mirrored before and deleted on the primary while the
+	// secondary was down.
+	if err := secondary.db.Put("deleted", "stale"); err != nil {
+		t.Fatal(err)
+	}
+	m := newMirror(primary.db, srv.URL, "", 64)
+	m.retryDelay = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// Writes made before the mirror subscribes go out with the snapshot
+	// instead of the queue.
+	waitFor(t, "a failed snapshot", func() bool { return m.failures.Load() > 0 })
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if err := primary.db.Put(key, strings.Repeat("x", 30)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "a resync", func() bool { return m.resyncs.Load() > 0 })
+	down.Store(false)
+	waitFor(t, "the snapshot after the overflow", func() bool {
+		value, err := secondary.db.Get("d")
+		return err == nil && value == strings.Repeat("x", 30)
+	})
+	if _, err := secondary.db.Get("deleted"); !errors.Is(err, datastore.ErrNotFound) {
+		t.Errorf("a key the snapshot lacks must be deleted from the secondary, Get returned %v", err)
+	}
+}
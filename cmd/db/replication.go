@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+const replicaRetryDelay = 2 * time.Second
+
+// handleReplicate streams a snapshot of the store followed by every entry
+// appended afterwards. The subscription is taken before the snapshot, so
+// entries written concurrently are replayed after it and the replica ends up
+// with the latest values.
+func (h *Handler) handleReplicate(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	// The stream stays open for as long as the replica is connected.
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	updates, cancel := h.db.Subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	if err := h.db.Snapshot(w); err != nil {
+		log.Printf("replicate: snapshot failed: %v", err)
+		return
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case data, ok := <-updates:
+			if !ok {
+				log.Printf("replicate: replica %s fell behind, closing stream", r.RemoteAddr)
+				return
+			}
+			if _, err := w.Write(data); err != nil {
+				log.Printf("replicate: write to %s failed: %v", r.RemoteAddr, err)
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			log.Printf("replica %s disconnected", r.RemoteAddr)
+			return
+		}
+	}
+}
+
+// replicate keeps a stream from the primary open and applies it to db,
+// reconnecting (and so resynchronizing from a fresh snapshot) on failures.
+func replicate(ctx context.Context, db *datastore.Db, primary string) {
+	url := fmt.Sprintf("%s/replicate", primary)
+	for {
+		err := replicateOnce(ctx, db, url)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("replication from %s interrupted: %v", primary, err)
+		select {
+		case <-time.After(replicaRetryDelay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func replicateOnce(ctx context.Context, db *datastore.Db, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if *primaryToken != "" {
+		req.Header.Set("Authorization", "Bearer "+*primaryToken)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+	log.Printf("replicating from %s", url)
+	if err := db.ApplyStream(resp.Body); err != nil {
+		return err
+	}
+	return fmt.Errorf("stream closed by primary")
+}
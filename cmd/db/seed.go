@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+var seedFile = flag.String("seed-file", "", `JSON-lines file of {"key": ..., "value": ...} records written on startup unless the key already exists; values are strings or int64`)
+
+// seed writes the records of a JSON-lines file whose keys the store does
+// not hold yet and returns how many it wrote. Blank lines are skipped.
+// Records before a malformed line stay written.
+func seed(db *datastore.Db, path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16<<20)
+	written := 0
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		ok, err := seedRecord(db, scanner.Bytes())
+		if err != nil {
+			return written, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if ok {
+			written++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return written, fmt.Errorf("%s: %w", path, err)
+	}
+	return written, nil
+}
+
+func seedRecord(db *datastore.Db, data []byte) (bool, error) {
+	var record struct {
+		Key   string `json:"key"`
+		Value any    `json:"value"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&record); err != nil {
+		return false, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if record.Key == "" {
+		return false, fmt.Errorf(`"key" field missing`)
+	}
+	switch v := record.Value.(type) {
+	case string:
+		return db.PutIfAbsent(record.Key, v)
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil {
+			return false, fmt.Errorf("value of %q must be int64 or string", record.Key)
+		}
+		return db.PutInt64IfAbsent(record.Key, n)
+	case nil:
+		return false, fmt.Errorf(`"value" field missing for %q`, record.Key)
+	default:
+		return false, fmt.Errorf("value of %q must be int64 or string", record.Key)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+func TestSeed(t *testing.T) {
+	db, err := datastore.Open(t.TempDir(), datastore.Mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	if err := db.Put("existing", "kept"); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "seed.jsonl")
+	content := `{"key": "team", "value": "2024-01-01"}
+
+{"key": "count", "value": 42}
+{"key": "existing", "value": "overwritten"}
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := seed(db, path); err != nil || n != 2 {
+		t.Fatalf("seed wrote %d keys, %v; want 2", n, err)
+	}
+	if v, err := db.Get("team"); err != nil || v != "2024-01-01" {
+		t.Errorf("Get(team) = %q, %v", v, err)
+	}
+	if v, err := db.GetInt64("count"); err != nil || v != 42 {
+		t.Errorf("GetInt64(count) = %d, %v", v, err)
+	}
+	if v, err := db.Get("existing"); err != nil || v != "kept" {
+		t.Errorf("seed overwrote an existing key: %q, %v", v, err)
+	}
+	if n, err := seed(db, path); err != nil || n != 0 {
+		t.Errorf("seeding again wrote %d keys, %v; want 0", n, err)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"key": "a", "value": "1"}`+"\n"+`{"key": "b", "value": 1.5}`+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	n, err := seed(db, path)
+	if err == nil || !strings.Contains(err.Error(), "seed.jsonl:2:") || n != 1 {
+		t.Errorf("expected an error on line 2 after 1 key, got %d keys, %v", n, err)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync/atomic"
+)
+
+// loadShedder counts in-flight requests and, once more than maxInFlight are
+// running, answers new reads with 503 so an overloaded instance degrades
+// instead of queueing without bound. Writes are never shed, and probes,
+// metric scrapes and replication streams bypass the accounting entirely.
+type loadShedder struct {
+	next        http.Handler
+	maxInFlight int64
+	inFlight    atomic.Int64
+}
+
+func newLoadShedder(next http.Handler, maxInFlight int64) *loadShedder {
+	return &loadShedder{next: next, maxInFlight: maxInFlight}
+}
+
+func (s *loadShedder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/health", "/ready", "/metrics", "/replicate":
+		s.next.ServeHTTP(w, r)
+		return
+	}
+
+	n := s.inFlight.Add(1)
+	defer s.inFlight.Add(-1)
+
+	if s.maxInFlight > 0 && n > s.maxInFlight && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		log.Printf("shedding %s %s: %d requests in flight", r.Method, r.URL.Path, n-1)
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+		return
+	}
+	s.next.ServeHTTP(w, r)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestLoadShedder(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 2)
+	blocking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/db/slow" {
+			started <- struct{}{}
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	s := newLoadShedder(blocking, 2)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			doRequest(s, "GET", "/db/slow", "")
+		}()
+	}
+	<-started
+	<-started
+
+	rr := doRequest(s, "GET", "/db/key", "")
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected read to be shed with 503, got %d", rr.Code)
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("expected Retry-After header on shed response")
+	}
+	if rr := doRequest(s, "POST", "/db/key", ""); rr.Code != http.StatusOK {
+		t.Errorf("expected writes not to be shed, got %d", rr.Code)
+	}
+	for _, path := range []string{"/health", "/ready"} {
+		if rr := doRequest(s, "GET", path, ""); rr.Code != http.StatusOK {
+			t.Errorf("expected %s to bypass shedding, got %d", path, rr.Code)
+		}
+	}
+
+	close(release)
+	wg.Wait()
+	if rr := doRequest(s, "GET", "/db/key", ""); rr.Code != http.StatusOK {
+		t.Errorf("expected read to pass once load dropped, got %d", rr.Code)
+	}
+}
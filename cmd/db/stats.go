@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// segmentStats is a segment in the /admin/stats report.
+type segmentStats struct {
+	ID           int64   `json:"id"`
+	Bytes        int64   `json:"bytes"`
+	Records      int64   `json:"records"`
+	Tombstones   int64   `json:"tombstones"`
+	Superseded   int64   `json:"superseded"`
+	GarbageRatio float64 `json:"garbage_ratio"`
+}
+
+// compactionStats is a past compaction in the /admin/stats report.
+type compactionStats struct {
+	Kind           string    `json:"kind"`
+	Start          time.Time `json:"start"`
+	DurationMs     float64   `json:"duration_ms"`
+	ReclaimedBytes int64     `json:"reclaimed_bytes"`
+	Error          string    `json:"error,omitempty"`
+}
+
+// keyspaceStats is the /admin/stats report.
+type keyspaceStats struct {
+	Keys         int64          `json:"keys"`
+	SegmentCount int            `json:"segment_count"`
+	Bytes        int64          `json:"bytes"`
+	Segments     []segmentStats `json:"segments"`
+	// StaleRatio is the share of all entries that compaction would drop;
+	// it counts entries, not bytes, so it is only an estimate of the
+	// space compaction reclaims.
+	StaleRatio  float64           `json:"stale_ratio"`
+	Compactions []compactionStats `json:"compactions"`
+	Gets        int64             `json:"gets"`
+	Misses      int64             `json:"misses"`
+	Puts        int64             `json:"puts"`
+	Deletes     int64             `json:"deletes"`
+}
+
+// handleStats reports the size and garbage of the keyspace, the latest
+// compactions and the read and write counters, for deciding when to compact.
+func (h *Handler) handleStats(w http.ResponseWriter) {
+	garbage, err := h.db.Garbage()
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	stats := h.db.Stats()
+
+	report := keyspaceStats{
+		SegmentCount: stats.Segments,
+		Bytes:        stats.Bytes,
+		Segments:     make([]segmentStats, 0, len(garbage)),
+		Compactions:  make([]compactionStats, 0, len(stats.Compactions)),
+		Gets:         stats.Gets,
+		Misses:       stats.Misses,
+		Puts:         stats.Puts,
+		Deletes:      stats.Deletes,
+	}
+	var records, stale int64
+	for _, g := range garbage {
+		report.Keys += g.Live()
+		records += g.Records
+		stale += g.Tombstones + g.Superseded
+		report.Segments = append(report.Segments, segmentStats{
+			ID:           g.ID,
+			Bytes:        g.Bytes,
+			Records:      g.Records,
+			Tombstones:   g.Tombstones,
+			Superseded:   g.Superseded,
+			GarbageRatio: g.Ratio(),
+		})
+	}
+	if records > 0 {
+		report.StaleRatio = float64(stale) / float64(records)
+	}
+	for _, c := range stats.Compactions {
+		report.Compactions = append(report.Compactions, compactionStats{
+			Kind:           c.Kind,
+			Start:          c.Start,
+			DurationMs:     float64(c.Duration) / float64(time.Millisecond),
+			ReclaimedBytes: c.Reclaimed,
+			Error:          c.Err,
+		})
+	}
+	h.respondJSON(w, report)
+}
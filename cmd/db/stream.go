@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	octetStream           = "application/octet-stream"
+	defaultMaxStreamBytes = 1 << 30
+)
+
+// wantsRaw reports whether a GET asks for the bare value instead of JSON.
+func wantsRaw(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mediaType, _, err := mime.ParseMediaType(accept); err == nil && mediaType == octetStream {
+			return true
+		}
+	}
+	return false
+}
+
+// handlePut stores an application/octet-stream body as the string value of
+// key. The body is streamed into the datastore rather than read into memory,
+// so it is limited by maxStreamBytes instead of maxBodyBytes.
+func (h *Handler) handlePut(w http.ResponseWriter, r *http.Request, key string) {
+	defer r.Body.Close()
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != octetStream {
+		h.rejections.inc(reasonUnsupportedType)
+		http.Error(w, "PUT expects an "+octetStream+" body; use POST for JSON", http.StatusUnsupportedMediaType)
+		return
+	}
+	if cancelled(w, r) {
+		return
+	}
+	if h.maxStreamBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, h.maxStreamBytes)
+	}
+	// Large values may legitimately take longer than the server-wide
+	// timeouts.
+	_ = http.NewResponseController(w).SetReadDeadline(time.Time{})
+
+	span := h.traceOp(r, "put_stream")
+	err := h.db.PutStream(r.Context(), key, r.Body)
+	endOp(span, err)
+	if errors.As(err, new(*http.MaxBytesError)) {
+		readBodyError(w, err)
+		return
+	}
+	if err != nil {
+		h.writeError(w, err)
+	}
+}
+
+// handleGetRaw answers a GET accepting application/octet-stream with the
+// bare string value, streamed from its segment.
+func (h *Handler) handleGetRaw(w http.ResponseWriter, r *http.Request, key string) {
+	span := h.traceOp(r, "open_value")
+	v, err := h.db.OpenValue(key)
+	endOp(span, err)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	defer v.Close()
+
+	setVersionHeaders(w, v.Meta)
+	w.Header().Set("Content-Type", octetStream)
+	if v.Size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(v.Size, 10))
+	}
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	if _, err := io.Copy(w, v); err != nil {
+		// The status is already sent, so the only way to tell the client
+		// the value is incomplete is to cut the connection.
+		log.Printf("streaming value of %s failed: %v", key, err)
+		panic(http.ErrAbortHandler)
+	}
+}
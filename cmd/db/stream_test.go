@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHandler_RawValues(t *testing.T) {
+	h := newTestHandler(t)
+	h.maxBodyBytes = 32
+	h.maxStreamBytes = 4096
+	value := strings.Repeat("raw bytes\x00", 200)
+
+	put := func(body, contentType string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/db/blob", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+	if rr := put(value, "application/octet-stream"); rr.Code != http.StatusOK {
+		t.Fatalf("PUT: expected status 200, got %d (%s)", rr.Code, rr.Body.String())
+	}
+	if rr := put(`{"value": "x"}`, "application/json"); rr.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("PUT with JSON: expected status 415, got %d", rr.Code)
+	}
+	if rr := put(strings.Repeat("x", 5000), "application/octet-stream"); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("PUT above the stream limit: expected status 413, got %d", rr.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/db/blob", nil)
+	req.Header.Set("Accept", "text/plain, application/octet-stream;q=0.9")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Body.String() != value {
+		t.Fatalf("raw GET: status %d, %d bytes", rr.Code, rr.Body.Len())
+	}
+	if rr.Header().Get("Content-Type") != "application/octet-stream" || rr.Header().Get("Content-Length") != strconv.Itoa(len(value)) {
+		t.Errorf("raw GET: unexpected headers %v", rr.Header())
+	}
+	if rr.Header().Get("ETag") == "" {
+		t.Error("raw GET: missing ETag")
+	}
+
+	// The value is an ordinary string for JSON clients.
+	if rr := doRequest(h, "GET", "/db/blob", ""); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `raw bytes\u0000`) {
+		t.Errorf("JSON GET: status %d, body %.64s", rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/db/missing", nil)
+	req.Header.Set("Accept", "application/octet-stream")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("raw GET of a missing key: expected status 404, got %d", rr.Code)
+	}
+}
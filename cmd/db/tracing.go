@@ -0,0 +1,26 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+	"github.com/roman-mazur/architecture-practice-4-template/internal/telemetry"
+)
+
+// traceOp starts the span of a datastore operation of r, a child of the
+// request's server span. The caller ends it with endOp.
+func (h *Handler) traceOp(r *http.Request, op string) *telemetry.Span {
+	_, span := h.tracer.Start(r.Context(), "datastore."+op, telemetry.KindInternal)
+	span.SetAttribute("db.operation.name", op)
+	return span
+}
+
+// endOp ends the span of a datastore operation that returned err. A missing
+// key is an answer, not a failure.
+func endOp(span *telemetry.Span, err error) {
+	if !errors.Is(err, datastore.ErrNotFound) {
+		span.SetError(err)
+	}
+	span.End()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/roman-mazur/architecture-practice-4-template/internal/telemetry"
+)
+
+func TestHandler_OpenTelemetry(t *testing.T) {
+	type span struct {
+		Name         string `json:"name"`
+		SpanID       string `json:"spanId"`
+		ParentSpanID string `json:"parentSpanId"`
+		Status       struct {
+			Code int `json:"code"`
+		} `json:"status"`
+	}
+	var mu sync.Mutex
+	spans := map[string]span{}
+	collector := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []span `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		defer mu.Unlock()
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, s := range ss.Spans {
+					spans[s.Name] = s
+				}
+			}
+		}
+	}))
+	defer collector.Close()
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", collector.URL)
+	tracer, err := telemetry.FromEnv("db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newTestHandler(t)
+	h.tracer = tracer
+
+	handler := tracer.Handler(h)
+	if rr := doRequest(handler, http.MethodPost, "/db/k", `{"value": "v"}`); rr.Code != http.StatusOK {
+		t.Fatalf("POST: %d", rr.Code)
+	}
+	if rr := doRequest(handler, http.MethodGet, "/db/missing", ""); rr.Code != http.StatusNotFound {
+		t.Fatalf("GET: %d", rr.Code)
+	}
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if spans["datastore.put"].ParentSpanID != spans["POST"].SpanID || spans["POST"].SpanID == "" {
+		t.Errorf("the datastore operation must be a child of the request span: %+v", spans)
+	}
+	if get := spans["datastore.get"]; get.ParentSpanID != spans["GET"].SpanID || get.Status.Code != 0 {
+		t.Errorf("a missing key must not fail the operation span: %+v", get)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var maxWait = flag.Duration("max-wait", time.Minute, "Longest wait a GET with ?wait= may ask for; longer waits are capped")
+
+// waitSlack is how long a long-polling GET may take to write its response
+// after the wait.
+const waitSlack = 10 * time.Second
+
+// awaitChange handles ?wait=<duration>&since_version=<N> on a GET: it blocks
+// until the version of key differs from N, the ETag of the value the client
+// last saw, or 0 for a missing key, and reports whether the GET should go on
+// to return the new value. When the wait passes without a change it answers
+// 304 Not Modified itself.
+func (h *Handler) awaitChange(w http.ResponseWriter, r *http.Request, key string) bool {
+	query := r.URL.Query()
+	wait, err := time.ParseDuration(query.Get("wait"))
+	if err != nil || wait <= 0 {
+		h.reject(w, reasonInvalidWait, fmt.Sprintf("invalid wait %q", query.Get("wait")))
+		return false
+	}
+	since, err := strconv.ParseUint(query.Get("since_version"), 10, 64)
+	if err != nil {
+		h.reject(w, reasonInvalidWait, "wait needs since_version, the version last seen (0 for a missing key)")
+		return false
+	}
+	wait = min(wait, h.maxWait)
+
+	// The server's write timeout would cut the response of a long wait.
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(wait + waitSlack))
+	ctx, cancel := context.WithTimeout(r.Context(), wait)
+	defer cancel()
+	_, err = h.db.Watch(ctx, key, since)
+	switch {
+	case err == nil:
+		return true
+	case cancelled(w, r):
+		return false
+	case errors.Is(err, context.DeadlineExceeded):
+		if since != 0 {
+			w.Header().Set("ETag", strconv.Quote(strconv.FormatUint(since, 10)))
+		}
+		w.WriteHeader(http.StatusNotModified)
+		return false
+	default:
+		h.writeError(w, err)
+		return false
+	}
+}
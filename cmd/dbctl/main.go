@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+var dbDir = flag.String("path", "/var/lib/db/data", "Path to database directory")
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <command>\n\nCommands:\n", os.Args[0])
+	fmt.Fprintln(flag.CommandLine.Output(), "  verify\tcheck framing and checksums of all segment entries")
+	fmt.Fprintln(flag.CommandLine.Output(), "  compact <segment-id>\trewrite one sealed segment without its dead entries; stop the db service first")
+	fmt.Fprintln(flag.CommandLine.Output(), "\nFlags:")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	switch flag.Arg(0) {
+	case "verify":
+		verify()
+	case "compact":
+		compact(flag.Arg(1))
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func verify() {
+	report, err := datastore.VerifyDir(*dbDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	out, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(out))
+	if !report.OK() {
+		os.Exit(1)
+	}
+}
+
+func compact(arg string) {
+	id, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		log.Fatalf("invalid segment id %q", arg)
+	}
+	db, err := datastore.OpenWithOptions(*dbDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	reclaimed, err := db.CompactSegment(id)
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("segment %d compacted, %d bytes reclaimed\n", id, reclaimed)
+}
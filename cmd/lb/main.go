@@ -0,0 +1,193 @@
+package main
+
+import (
+	"expvar"
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/httptools"
+	"github.com/roman-mazur/architecture-practice-4-template/internal/lb"
+	"github.com/roman-mazur/architecture-practice-4-template/internal/telemetry"
+	"github.com/roman-mazur/architecture-practice-4-template/signal"
+)
+
+var defaults = lb.DefaultConfig()
+
+var (
+	port         = flag.Int("port", defaults.Port, "load balancer port")
+	timeoutSec   = flag.Int("timeout-sec", int(defaults.Timeout/time.Second), "request timeout time in seconds")
+	https        = flag.Bool("https", false, "whether backends configured without an http:// or https:// prefix support HTTPs")
+	traceEnabled = flag.Bool("trace", false, "whether to include tracing information into responses")
+	strategy     = flag.String("strategy", defaults.Strategy, "backend selection strategy: least-traffic, least-connections, round-robin, latency-aware, least-latency or hash; adjustable at runtime through /admin/strategy")
+	hashKey      = flag.String("hash-key", defaults.HashKey, `request key for the hash strategy: "path", "query:<name>" or "header:<name>"`)
+	healthEvery  = flag.Duration("health-interval", defaults.HealthInterval, "interval between backend health checks")
+	healthJitter = flag.Float64("health-jitter", defaults.HealthJitter, "fraction by which every health check interval is randomly stretched or shrunk, so backends are not checked in lockstep")
+	configPath   = flag.String("config", "", "path to a JSON config file; supports ${VAR} and ${VAR:-default} interpolation")
+	adminPort    = flag.Int("admin-port", 0, "port serving runtime counters at /debug/vars (0 disables it)")
+
+	discovery         = flag.String("discovery", "", `source of the default backend pool, polled every -discovery-interval: "static:<host:port>,...", "dns:<name>:<port>", "srv:<name>" or an http(s) registry URL returning a JSON array`)
+	discoveryInterval = flag.Duration("discovery-interval", defaults.DiscoveryInterval, "interval between polls of -discovery")
+
+	canaryWeight  = flag.Float64("canary-weight", 0, "percentage of requests sent to backends tagged with -canary-version; adjustable at runtime through /admin/canary")
+	canaryVersion = flag.String("canary-version", defaults.CanaryVersion, "version label of canary backends")
+
+	echoEnabled      = flag.Bool("echo", false, "answer /lb/echo in the balancer itself, without touching backends, to measure balancer overhead")
+	echoPayloadBytes = flag.Int("echo-payload-bytes", defaults.Echo.PayloadBytes, "size of the /lb/echo response body; a size query parameter overrides it")
+	echoLatency      = flag.Duration("echo-latency", 0, "delay before answering /lb/echo; a latency_ms query parameter overrides it")
+
+	trustForwarded       = flag.Bool("trust-forwarded", false, "keep Forwarded/X-Forwarded-* headers sent by clients instead of stripping them")
+	stripResponseHeaders = flag.String("strip-response-headers", "", "comma-separated backend response headers to drop, e.g. Server,X-Debug")
+	allowResponseHeaders = flag.String("allow-response-headers", "", "comma-separated backend response headers to keep; when set, every other header is dropped")
+	preserveHost         = flag.Bool("preserve-host", false, "forward the client Host header instead of rewriting it to the backend address")
+
+	compress         = flag.Bool("compress", false, "gzip or deflate responses for clients that accept it, unless the backend already encoded them")
+	compressTypes    = flag.String("compress-types", defaults.Compress.Types, `comma-separated content types -compress applies to; an entry ending in "/" matches every subtype`)
+	compressMinBytes = flag.Int64("compress-min-bytes", defaults.Compress.MinBytes, "responses declaring a smaller Content-Length are not compressed")
+
+	trafficWindow   = flag.Duration("traffic-window", defaults.TrafficWindow, "time constant of the decayed traffic rate used by the least-traffic strategy")
+	degradedLatency = flag.Duration("degraded-latency", defaults.DegradedLatency, "health checks slower than this mark a backend degraded (0 disables it)")
+	slowStart       = flag.Duration("slow-start", defaults.SlowStart, "how long the share of requests sent to a backend that recovered ramps up from nothing to full (0 disables it)")
+
+	maxIdleConnsPerHost = flag.Int("max-idle-conns-per-host", defaults.Transport.MaxIdleConnsPerHost, "idle keep-alive connections kept per backend")
+	idleConnTimeout     = flag.Duration("idle-conn-timeout", defaults.Transport.IdleConnTimeout, "how long an idle backend connection is kept open")
+	dialTimeout         = flag.Duration("dial-timeout", defaults.Transport.DialTimeout, "timeout for establishing a backend connection")
+	tlsSkipVerify       = flag.Bool("tls-skip-verify", false, "skip verification of backend TLS certificates")
+
+	tlsSkipVerifyBackends = flag.String("tls-skip-verify-backends", "", "comma-separated backends, as configured, whose TLS certificates are not verified, e.g. lab servers with self-signed certificates")
+	tlsCAFile             = flag.String("tls-ca-file", "", "PEM file of the CAs trusted to sign backend certificates instead of the system roots")
+	tlsCertFile           = flag.String("tls-cert-file", "", "PEM client certificate presented to backends that ask for one; needs -tls-key-file")
+	tlsKeyFile            = flag.String("tls-key-file", "", "PEM private key of -tls-cert-file")
+
+	maxInFlight    = flag.Int("max-in-flight", 0, "requests in flight a backend may have before no strategy picks it (0 leaves backends unlimited)")
+	queueWait      = flag.Duration("queue-wait", 0, "how long a request waits for a backend below -max-in-flight when none is available, instead of getting 503 right away (0 disables queuing)")
+	queueMaxLength = flag.Int("queue-max-length", defaults.Queue.MaxLength, "requests that may wait at once; the ones beyond it get 503 right away")
+
+	maxRetries    = flag.Int("max-retries", 0, "other backends tried when a backend gives no response to a bodiless GET, HEAD, OPTIONS or DELETE request")
+	retryBudget   = flag.Duration("retry-budget", 0, "total time limit for all attempts of a retried request (0 only limits each attempt by -timeout-sec)")
+	maxTimeout    = flag.Duration("max-timeout", defaults.MaxTimeout, "upper bound of the timeout a client may ask for with the X-LB-Timeout header (0 leaves it unbounded)")
+	slowThreshold = flag.Duration("slow-threshold", 0, "requests taking longer are logged with their backend and counted in lb_slow_requests_total (0 disables it)")
+	drainTimeout  = flag.Duration("drain-timeout", defaults.DrainTimeout, "how long a backend removed by a reload or DELETE /admin/backends may finish its requests in flight before they are cut")
+
+	shadowTo      = flag.String("mirror-to", "", "shadow backend (host:port) that gets an asynchronous copy of requests; its responses are discarded")
+	shadowPercent = flag.Float64("mirror-percent", defaults.ShadowPercent, "percentage of requests copied to the -mirror-to backend")
+
+	journalPath       = flag.String("journal", "", "file journaling requests that ended in 5xx, for post-mortems through /admin/journal (empty disables it)")
+	journalMaxEntries = flag.Int("journal-max-entries", defaults.JournalMaxEntries, "entries kept in the -journal file; the oldest are overwritten")
+
+	scaleWebhook     = flag.String("scale-webhook", "", "URL POSTed a JSON scaling event when the load stays past a -scale-* threshold")
+	scaleCommand     = flag.String("scale-command", "", "shell command run with a JSON scaling event on stdin and its name in LB_SCALE_EVENT")
+	backendCapacity  = flag.Int("backend-capacity", 0, "requests in flight a backend handles at full load, for -scale-up-percent and -scale-down-percent")
+	scaleUpPercent   = flag.Float64("scale-up-percent", defaults.Scale.UpPercent, "emit scale_up when no alive backend is below this percentage of -backend-capacity")
+	scaleDownPercent = flag.Float64("scale-down-percent", 0, "emit scale_down when every alive backend is below this percentage of -backend-capacity (0 disables it)")
+	scaleQueueDepth  = flag.Int("scale-queue-depth", 0, "emit scale_up when this many requests are in flight at the balancer (0 disables it)")
+	scaleSustain     = flag.Duration("scale-sustain", defaults.Scale.Sustain, "how long the load must stay past a threshold before a scaling event")
+	scaleCooldown    = flag.Duration("scale-cooldown", defaults.Scale.Cooldown, "minimum time between two scaling events")
+)
+
+func main() {
+	flag.Parse()
+
+	cfg := defaults
+	cfg.Port = *port
+	cfg.Timeout = time.Duration(*timeoutSec) * time.Second
+	cfg.HTTPS = *https
+	cfg.Trace = *traceEnabled
+	cfg.Strategy = *strategy
+	cfg.HashKey = *hashKey
+	cfg.HealthInterval = *healthEvery
+	cfg.HealthJitter = *healthJitter
+	cfg.Discovery = *discovery
+	cfg.DiscoveryInterval = *discoveryInterval
+	cfg.CanaryWeight = *canaryWeight
+	cfg.CanaryVersion = *canaryVersion
+	cfg.Echo = lb.EchoOptions{Enabled: *echoEnabled, PayloadBytes: *echoPayloadBytes, Latency: *echoLatency}
+	cfg.TrustForwarded = *trustForwarded
+	cfg.StripResponseHeaders = *stripResponseHeaders
+	cfg.AllowResponseHeaders = *allowResponseHeaders
+	cfg.PreserveHost = *preserveHost
+	cfg.Compress = lb.CompressOptions{Enabled: *compress, Types: *compressTypes, MinBytes: *compressMinBytes}
+	cfg.TrafficWindow = *trafficWindow
+	cfg.DegradedLatency = *degradedLatency
+	cfg.SlowStart = *slowStart
+	cfg.Transport = lb.TransportOptions{
+		MaxIdleConnsPerHost: *maxIdleConnsPerHost,
+		IdleConnTimeout:     *idleConnTimeout,
+		DialTimeout:         *dialTimeout,
+		TLSSkipVerify:       *tlsSkipVerify,
+
+		TLSSkipVerifyBackends: *tlsSkipVerifyBackends,
+		TLSCAFile:             *tlsCAFile,
+		TLSCertFile:           *tlsCertFile,
+		TLSKeyFile:            *tlsKeyFile,
+	}
+	cfg.ShadowTo = *shadowTo
+	cfg.ShadowPercent = *shadowPercent
+	cfg.JournalPath = *journalPath
+	cfg.JournalMaxEntries = *journalMaxEntries
+	cfg.Queue = lb.QueueOptions{MaxInFlight: *maxInFlight, Wait: *queueWait, MaxLength: *queueMaxLength}
+	cfg.MaxRetries = *maxRetries
+	cfg.RetryBudget = *retryBudget
+	cfg.DrainTimeout = *drainTimeout
+	cfg.MaxTimeout = *maxTimeout
+	cfg.SlowThreshold = *slowThreshold
+	cfg.Scale = lb.ScaleOptions{
+		Webhook:     *scaleWebhook,
+		Command:     *scaleCommand,
+		Capacity:    *backendCapacity,
+		UpPercent:   *scaleUpPercent,
+		DownPercent: *scaleDownPercent,
+		QueueDepth:  *scaleQueueDepth,
+		Sustain:     *scaleSustain,
+		Cooldown:    *scaleCooldown,
+	}
+	cfg.ConfigFile = *configPath
+	// Flags set on the command line win over the config file.
+	cfg.Pinned = make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { cfg.Pinned[f.Name] = true })
+
+	balancer, err := lb.NewBalancer(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer balancer.Close()
+	if cfg.Discovery != "" {
+		log.Printf("Discovering backends from %s", cfg.Discovery)
+	}
+	if *configPath != "" {
+		log.Printf("Loaded config from %s", *configPath)
+	}
+	cfg = balancer.Config()
+	if cfg.JournalPath != "" {
+		log.Printf("Journaling 5xx responses to %s", cfg.JournalPath)
+	}
+	balancer.PublishMetrics()
+	tracer, err := telemetry.FromEnv("lb")
+	if err != nil {
+		log.Printf("OpenTelemetry tracing disabled: %v", err)
+	}
+	defer tracer.Close()
+	balancer.SetTracer(tracer)
+
+	frontend := httptools.CreateServer(cfg.Port, tracer.Handler(balancer))
+
+	log.Println("Starting load balancer on port", cfg.Port)
+	log.Printf("Tracing support enabled: %t", cfg.Trace)
+	log.Printf("Selection strategy: %s", cfg.Strategy)
+	if cfg.ShadowTo != "" {
+		log.Printf("Mirroring %g%% of requests to %s", cfg.ShadowPercent, cfg.ShadowTo)
+	}
+	frontend.Start()
+	if *adminPort != 0 {
+		log.Println("Serving runtime counters on admin port", *adminPort)
+		admin := http.NewServeMux()
+		admin.Handle("/debug/vars", expvar.Handler())
+		admin.Handle("/admin/", balancer.AdminHandler())
+		httptools.CreateServer(*adminPort, admin).Start()
+	}
+	if *configPath != "" {
+		signal.OnReload(func() { _, _ = balancer.Reload() })
+	}
+	signal.WaitForTerminationSignal()
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	reportPersistInterval = flag.Duration("report-persist-interval", 0, "how often a JSON snapshot of /report is written to the db (0 disables it)")
+	reportPersistKey      = flag.String("report-persist-key", "", `db key of the /report snapshots (defaults to "report-<instance id>")`)
+)
+
+// otherName collects the clients and paths seen after the report is full.
+const otherName = "(other)"
+
+// requestStats aggregates the requests of one client or path.
+type requestStats struct {
+	count      int64
+	latency    time.Duration
+	maxLatency time.Duration
+	statuses   map[int]int64
+	lastSeen   time.Time
+}
+
+// requestSummary is requestStats as served at /report.
+type requestSummary struct {
+	Count        int64            `json:"count"`
+	AvgLatencyMs float64          `json:"avg_latency_ms"`
+	MaxLatencyMs float64          `json:"max_latency_ms"`
+	Statuses     map[string]int64 `json:"statuses"`
+}
+
+func (s *requestStats) summary() requestSummary {
+	sum := requestSummary{
+		Count:        s.count,
+		MaxLatencyMs: milliseconds(s.maxLatency),
+		Statuses:     make(map[string]int64, len(s.statuses)),
+	}
+	if s.count > 0 {
+		sum.AvgLatencyMs = milliseconds(s.latency / time.Duration(s.count))
+	}
+	for status, n := range s.statuses {
+		sum.Statuses[strconv.Itoa(status)] = n
+	}
+	return sum
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// clientOf names the client of a request: its lb-author, else the first
+// X-Forwarded-For address, else the remote address.
+func clientOf(req *http.Request) string {
+	if author := req.Header.Get("lb-author"); author != "" {
+		return author
+	}
+	if fwd := req.Header.Get("X-Forwarded-For"); fwd != "" {
+		first, _, _ := strings.Cut(fwd, ",")
+		return strings.TrimSpace(first)
+	}
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return host
+	}
+	return req.RemoteAddr
+}
+
+// Record accounts a served request to its client and path. Like authors,
+// at most maxAuthors clients and paths are kept; later ones are counted
+// under "(other)".
+func (r *Report) Record(req *http.Request, status int, latency time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	r.recordLocked(r.clients, clientOf(req), status, latency, now)
+	r.recordLocked(r.paths, req.URL.Path, status, latency, now)
+}
+
+func (r *Report) recordLocked(stats map[string]*requestStats, name string, status int, latency time.Duration, now time.Time) {
+	s, ok := stats[name]
+	if !ok && r.maxAuthors > 0 && len(stats) >= r.maxAuthors {
+		name = otherName
+		s, ok = stats[name]
+	}
+	if !ok {
+		s = &requestStats{statuses: make(map[int]int64)}
+		stats[name] = s
+	}
+	s.count++
+	s.latency += latency
+	s.maxLatency = max(s.maxLatency, latency)
+	s.statuses[status]++
+	s.lastSeen = now
+}
+
+// ageStatsLocked drops the clients or paths not seen since cutoff.
+func ageStatsLocked(stats map[string]*requestStats, cutoff time.Time) {
+	for name, s := range stats {
+		if !s.lastSeen.After(cutoff) {
+			delete(stats, name)
+		}
+	}
+}
+
+func summaries(stats map[string]*requestStats) map[string]requestSummary {
+	if len(stats) == 0 {
+		return nil
+	}
+	res := make(map[string]requestSummary, len(stats))
+	for name, s := range stats {
+		res[name] = s.summary()
+	}
+	return res
+}
+
+// Track wraps next so that the report records every request it serves.
+func (r *Report) Track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: rw, status: http.StatusOK}
+		next.ServeHTTP(rec, req)
+		r.Record(req, rec.status, time.Since(start))
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status, rec.wroteHeader = status, true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(p []byte) (int, error) {
+	rec.wroteHeader = true
+	return rec.ResponseWriter.Write(p)
+}
+
+// writeCSV writes the client and path stats as CSV, one row per client or
+// path, with the status counts as "code=count" pairs.
+func writeCSV(rw http.ResponseWriter, snapshot reportResponse) {
+	rw.Header().Set("content-type", "text/csv")
+	w := csv.NewWriter(rw)
+	_ = w.Write([]string{"kind", "name", "count", "avg_latency_ms", "max_latency_ms", "statuses"})
+	for _, group := range []struct {
+		kind  string
+		stats map[string]requestSummary
+	}{{"client", snapshot.Clients}, {"path", snapshot.Paths}} {
+		names := make([]string, 0, len(group.stats))
+		for name := range group.stats {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			s := group.stats[name]
+			codes := make([]string, 0, len(s.Statuses))
+			for code := range s.Statuses {
+				codes = append(codes, code)
+			}
+			slices.Sort(codes)
+			for i, code := range codes {
+				codes[i] = fmt.Sprintf("%s=%d", code, s.Statuses[code])
+			}
+			_ = w.Write([]string{
+				group.kind, name, strconv.FormatInt(s.Count, 10),
+				strconv.FormatFloat(s.AvgLatencyMs, 'f', 3, 64),
+				strconv.FormatFloat(s.MaxLatencyMs, 'f', 3, 64),
+				strings.Join(codes, " "),
+			})
+		}
+	}
+	w.Flush()
+}
+
+// StartPersisting passes a JSON snapshot of the report to save every
+// interval until stop is closed.
+func (r *Report) StartPersisting(interval time.Duration, save func([]byte) error, stop <-chan struct{}) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				data, err := json.Marshal(r.snapshot())
+				if err == nil {
+					err = save(data)
+				}
+				if err != nil {
+					log.Printf("report: failed to persist snapshot: %v", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReport_Track(t *testing.T) {
+	r := NewReport(2, time.Minute)
+	now := time.Now()
+	r.now = func() time.Time { return now }
+	h := r.Track(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/missing" {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = rw.Write([]byte("ok"))
+	}))
+	serve := func(path, author, remote string) {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = remote
+		if author != "" {
+			req.Header.Set("lb-author", author)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	serve("/api", "alice", "10.0.0.1:1000")
+	serve("/api", "", "10.0.0.2:1000")
+	serve("/missing", "alice", "10.0.0.1:1000")
+	serve("/third", "bob", "10.0.0.3:1000")
+
+	snapshot := r.snapshot()
+	if got := snapshot.Clients["alice"]; got.Count != 2 || got.Statuses["200"] != 1 || got.Statuses["404"] != 1 {
+		t.Errorf("unexpected stats for alice: %+v", got)
+	}
+	if got := snapshot.Clients["10.0.0.2"]; got.Count != 1 {
+		t.Errorf("expected the remote address to name a client without lb-author, got %+v", snapshot.Clients)
+	}
+	if got := snapshot.Paths[otherName]; got.Count != 1 || len(snapshot.Paths) != 3 {
+		t.Errorf("expected the path past the limit under %s, got %+v", otherName, snapshot.Paths)
+	}
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("GET", "/report?format=csv", nil))
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	if rr.Header().Get("content-type") != "text/csv" || len(lines) != 7 {
+		t.Fatalf("expected a header and 6 rows of CSV, got %q", rr.Body)
+	}
+	if !strings.HasPrefix(lines[2], "client,10.0.0.2,1,") || !strings.HasSuffix(lines[3], ",200=1 404=1") {
+		t.Errorf("unexpected CSV rows %q", lines)
+	}
+
+	now = now.Add(2 * time.Minute)
+	r.Age()
+	if snapshot := r.snapshot(); snapshot.Clients != nil || snapshot.Paths != nil {
+		t.Errorf("expected aged out clients and paths, got %+v", snapshot)
+	}
+}
+
+func TestReport_StartPersisting(t *testing.T) {
+	r := NewReport(0, 0)
+	r.Record(httptest.NewRequest("GET", "/api", nil), http.StatusOK, time.Millisecond)
+
+	saved := make(chan []byte, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	r.StartPersisting(time.Millisecond, func(data []byte) error {
+		select {
+		case saved <- data:
+		default:
+		}
+		return nil
+	}, stop)
+
+	var got reportResponse
+	if err := json.Unmarshal(<-saved, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Paths["/api"].Count != 1 || got.Paths["/api"].MaxLatencyMs != 1 {
+		t.Errorf("unexpected persisted snapshot %+v", got)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"flag"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var cacheTTL = flag.Duration("cache-ttl", 0, "how long values fetched from the db are cached; ?nocache=1 bypasses the cache (0 only caches -warm-keys)")
+
+type cacheKey struct {
+	key, typ string
+}
+
+// responseCache keeps db values the server can answer without asking the db.
+// Warmed values stay until warming drops them; looked up values expire.
+type responseCache struct {
+	mu      sync.RWMutex
+	entries map[cacheKey]cacheEntry
+	now     func() time.Time
+
+	hits, misses, bypassed atomic.Int64
+}
+
+type cacheEntry struct {
+	body []byte
+	// stale is set when the last refresh of the entry failed.
+	stale bool
+	// expires is zero for warmed values.
+	expires time.Time
+}
+
+func (e cacheEntry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && !now.Before(e.expires)
+}
+
+func newResponseCache() *responseCache {
+	return &responseCache{entries: make(map[cacheKey]cacheEntry), now: time.Now}
+}
+
+// Get returns the cached body of key and whether it is stale.
+func (c *responseCache) Get(key, typ string) (body []byte, stale, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	e, ok := c.entries[cacheKey{key, typ}]
+	if ok && e.expired(c.now()) {
+		return nil, false, false
+	}
+	return e.body, e.stale, ok
+}
+
+// Lookup is Get for client requests: it counts hits and misses.
+func (c *responseCache) Lookup(key, typ string) (body []byte, stale, ok bool) {
+	body, stale, ok = c.Get(key, typ)
+	if ok {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+	return body, stale, ok
+}
+
+// Set caches a warmed value, which does not expire.
+func (c *responseCache) Set(key, typ string, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[cacheKey{key, typ}] = cacheEntry{body: body}
+}
+
+// Store caches a looked up value for ttl. A warmed value of the key is
+// updated and stays warmed; without one, a ttl <= 0 caches nothing.
+func (c *responseCache) Store(key, typ string, body []byte, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	k := cacheKey{key, typ}
+	if e, ok := c.entries[k]; ok && e.expires.IsZero() {
+		c.entries[k] = cacheEntry{body: body}
+		return
+	}
+	if ttl <= 0 {
+		return
+	}
+	c.entries[k] = cacheEntry{body: body, expires: c.now().Add(ttl)}
+}
+
+// Expire removes the expired values.
+func (c *responseCache) Expire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	for k, e := range c.entries {
+		if e.expired(now) {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// StartExpiring runs Expire periodically until stop is closed.
+func (c *responseCache) StartExpiring(interval time.Duration, stop <-chan struct{}) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.Expire()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// MarkStale flags the cached value of key, if any, as no longer refreshed.
+func (c *responseCache) MarkStale(key, typ string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[cacheKey{key, typ}]; ok {
+		e.stale = true
+		c.entries[cacheKey{key, typ}] = e
+	}
+}
+
+func (c *responseCache) Delete(key, typ string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, cacheKey{key, typ})
+}
+
+func (c *responseCache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.entries)
+}
+
+// Stale returns the number of cached values whose last refresh failed.
+func (c *responseCache) Stale() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	n := 0
+	for _, e := range c.entries {
+		if e.stale {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResponseCache_TTL(t *testing.T) {
+	c := newResponseCache()
+	now := time.Unix(0, 0)
+	c.now = func() time.Time { return now }
+
+	c.Store("a", "string", []byte(`"1"`), 0)
+	if _, _, ok := c.Lookup("a", "string"); ok {
+		t.Error("a zero ttl must not cache the value")
+	}
+	c.Store("a", "string", []byte(`"1"`), time.Second)
+	if body, _, ok := c.Lookup("a", "string"); !ok || string(body) != `"1"` {
+		t.Errorf("expected the stored value, got %q, %t", body, ok)
+	}
+
+	c.Set("warm", "string", []byte(`"w1"`))
+	c.Store("warm", "string", []byte(`"w2"`), 0)
+
+	now = now.Add(time.Second)
+	if _, _, ok := c.Lookup("a", "string"); ok {
+		t.Error("expected the value to expire after its ttl")
+	}
+	if body, _, ok := c.Lookup("warm", "string"); !ok || string(body) != `"w2"` {
+		t.Errorf("expected the warmed value to be updated and kept, got %q, %t", body, ok)
+	}
+	if c.hits.Load() != 2 || c.misses.Load() != 2 {
+		t.Errorf("expected 2 hits and 2 misses, got %d and %d", c.hits.Load(), c.misses.Load())
+	}
+
+	c.Expire()
+	if c.Len() != 1 {
+		t.Errorf("expected only the warmed value after Expire, got %d entries", c.Len())
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"os"
+)
+
+var dbToken = flag.String("db-token", os.Getenv("DB_TOKEN"), "bearer token sent to the db service; it needs write access for the startup load (defaults to $DB_TOKEN)")
+
+// withDbToken authenticates a request to the db with -db-token, if set.
+func withDbToken(req *http.Request) *http.Request {
+	if *dbToken != "" {
+		req.Header.Set("Authorization", "Bearer "+*dbToken)
+	}
+	return req
+}
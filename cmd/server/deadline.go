@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// deadlineHeader carries the remaining time budget of a request in
+// milliseconds. The server takes its deadline from it and passes what is
+// left on to the db, whose handler stops work the caller no longer waits
+// for.
+const deadlineHeader = "X-Deadline-Ms"
+
+// withDeadline sets the deadline of requests with a deadline header.
+// Invalid values are answered with 400, as the db does.
+func withDeadline(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		value := r.Header.Get(deadlineHeader)
+		if value == "" {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		ms, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || ms <= 0 {
+			http.Error(rw, "invalid "+deadlineHeader+" header", http.StatusBadRequest)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(ms)*time.Millisecond)
+		defer cancel()
+		next.ServeHTTP(rw, r.WithContext(ctx))
+	})
+}
+
+// setDeadlineHeader tells the db how much of the budget of req is left. It
+// is called before every attempt, so retries send what remains after the
+// earlier ones.
+func setDeadlineHeader(req *http.Request) {
+	deadline, ok := req.Context().Deadline()
+	if !ok {
+		req.Header.Del(deadlineHeader)
+		return
+	}
+	// The db rejects budgets below a millisecond; a request that late fails
+	// on its context anyway.
+	ms := max(time.Until(deadline).Milliseconds(), 1)
+	req.Header.Set(deadlineHeader, strconv.FormatInt(ms, 10))
+}
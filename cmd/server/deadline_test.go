@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWithDeadline(t *testing.T) {
+	var remaining time.Duration
+	var hasDeadline bool
+	handler := withDeadline(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var deadline time.Time
+		deadline, hasDeadline = r.Context().Deadline()
+		remaining = time.Until(deadline)
+	}))
+
+	serve := func(value string) int {
+		r := httptest.NewRequest("GET", "/api/v1/some-data?key=a", nil)
+		if value != "" {
+			r.Header.Set(deadlineHeader, value)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	if code := serve(""); code != http.StatusOK || hasDeadline {
+		t.Errorf("without the header: status %d, deadline set %t", code, hasDeadline)
+	}
+	if code := serve("1500"); code != http.StatusOK || !hasDeadline || remaining <= time.Second || remaining > 1500*time.Millisecond {
+		t.Errorf("with a budget of 1500 ms: status %d, %s left", code, remaining)
+	}
+	for _, value := range []string{"0", "-5", "soon"} {
+		if code := serve(value); code != http.StatusBadRequest {
+			t.Errorf("%s=%s: expected status 400, got %d", deadlineHeader, value, code)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+const (
+	dbModeHTTP     = "http"
+	dbModeEmbedded = "embedded"
+)
+
+var (
+	dbMode = flag.String("db-mode", envOr("DB_MODE", dbModeHTTP), `how the server reaches the db: "http" calls the db service at -db-url, "embedded" opens the datastore at -db-path in-process, for single-node deployments (defaults to $DB_MODE)`)
+	dbPath = flag.String("db-path", envOr("DB_PATH", "./server-data"), "directory of the datastore opened by -db-mode=embedded (defaults to $DB_PATH)")
+)
+
+// embeddedDb is the store opened by -db-mode=embedded. When it is set, the
+// db helpers use it instead of the db service and ignore their db URL.
+var embeddedDb datastore.Store
+
+// openEmbeddedDb opens the datastore of -db-mode=embedded. It returns nil
+// in http mode.
+func openEmbeddedDb(mode, path string, sharded bool) (datastore.Store, error) {
+	switch mode {
+	case dbModeHTTP:
+		return nil, nil
+	case dbModeEmbedded:
+		if sharded {
+			return nil, fmt.Errorf("-db-shards cannot be used with -db-mode=%s", dbModeEmbedded)
+		}
+		db, err := datastore.OpenWithOptions(path)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	}
+	return nil, fmt.Errorf("unknown db mode %q", mode)
+}
+
+// int64Store is the part of datastore.Db beyond Store that typed reads
+// need.
+type int64Store interface {
+	GetInt64(key string) (int64, error)
+}
+
+// fetchEmbedded is fetchFromDb for the embedded store: it answers with the
+// status and the {"value": ...} body the db service would.
+func fetchEmbedded(key, typ string) (int, []byte, error) {
+	var val any
+	var err error
+	switch typ {
+	case "string":
+		val, err = embeddedDb.Get(key)
+	case "int64":
+		ints, ok := embeddedDb.(int64Store)
+		if !ok {
+			return http.StatusBadRequest, nil, nil
+		}
+		val, err = ints.GetInt64(key)
+	default:
+		return http.StatusBadRequest, nil, nil
+	}
+	switch {
+	case errors.Is(err, datastore.ErrNotFound):
+		return http.StatusNotFound, nil, nil
+	case errors.Is(err, datastore.ErrTypeMismatch):
+		return http.StatusConflict, nil, nil
+	case err != nil:
+		return 0, nil, err
+	}
+	body, err := json.Marshal(map[string]any{"value": val})
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, body, nil
+}
+
+// checkEmbeddedRoundtrip is checkDbRoundtrip for the embedded store.
+func checkEmbeddedRoundtrip(key, want string) error {
+	if err := embeddedDb.Put(key, want); err != nil {
+		return fmt.Errorf("write probe key: %w", err)
+	}
+	got, err := embeddedDb.Get(key)
+	if err != nil {
+		return fmt.Errorf("read probe key: %w", err)
+	}
+	if got != want {
+		return fmt.Errorf("read back %q, wrote %q", got, want)
+	}
+	_ = embeddedDb.Delete(key)
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestEmbeddedDb(t *testing.T) {
+	db, err := openEmbeddedDb(dbModeEmbedded, t.TempDir(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	embeddedDb = db
+	t.Cleanup(func() {
+		embeddedDb = nil
+		_ = db.Close()
+	})
+
+	// The db URL is ignored: nothing listens there.
+	const dbURL = "http://127.0.0.1:1"
+	if err := loadOnce(dbURL, "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	status, body, err := fetchFromDb(context.Background(), dbURL, "k", "string", nil)
+	if err != nil || status != http.StatusOK || string(body) != `{"value":"v"}` {
+		t.Errorf("got %d %s %v", status, body, err)
+	}
+	if status, _, _ := fetchFromDb(context.Background(), dbURL, "k", "int64", nil); status != http.StatusConflict {
+		t.Errorf("reading a string as int64: expected 409, got %d", status)
+	}
+	if err := deleteFromDb(dbURL, "k"); err != nil {
+		t.Fatal(err)
+	}
+	if status, _, _ := fetchFromDb(context.Background(), dbURL, "k", "string", nil); status != http.StatusNotFound {
+		t.Errorf("expected 404 after delete, got %d", status)
+	}
+	if err := checkDbRoundtrip(dbURL); err != nil {
+		t.Errorf("self-test roundtrip: %v", err)
+	}
+}
+
+func TestOpenEmbeddedDb_Modes(t *testing.T) {
+	if db, err := openEmbeddedDb(dbModeHTTP, "", false); db != nil || err != nil {
+		t.Errorf("http mode must not open a store, got %v, %v", db, err)
+	}
+	if _, err := openEmbeddedDb(dbModeEmbedded, t.TempDir(), true); err == nil {
+		t.Error("an embedded store cannot be sharded")
+	}
+	if _, err := openEmbeddedDb("grpc", "", false); err == nil {
+		t.Error("unknown modes must be rejected")
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const maxInjectedDelay = 300 * time.Second
+
+// healthFailure makes /health fail on demand, see handleChaosHealth.
+var healthFailure atomic.Bool
+
+// injectFault applies the per-request delay_ms and fail_rate query
+// parameters. It reports whether the request was failed and already answered.
+func injectFault(rw http.ResponseWriter, r *http.Request) bool {
+	q := r.URL.Query()
+	if delayMs, err := strconv.Atoi(q.Get("delay_ms")); err == nil && delayMs > 0 {
+		delay := time.Duration(delayMs) * time.Millisecond
+		if delay > maxInjectedDelay {
+			delay = maxInjectedDelay
+		}
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return true
+		}
+	}
+	if rate, err := strconv.ParseFloat(q.Get("fail_rate"), 64); err == nil && rate > 0 && rand.Float64() < rate {
+		http.Error(rw, "injected failure", http.StatusInternalServerError)
+		return true
+	}
+	return false
+}
+
+// handleChaosHealth toggles health check failures at runtime:
+// POST /chaos/health?fail=true|false. GET returns the current state.
+func handleChaosHealth(rw http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		fail, err := strconv.ParseBool(r.URL.Query().Get("fail"))
+		if err != nil {
+			http.Error(rw, `"fail" must be true or false`, http.StatusBadRequest)
+			return
+		}
+		healthFailure.Store(fail)
+		log.Printf("chaos: health failure set to %t", fail)
+	default:
+		http.Error(rw, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rw.Header().Set("content-type", "application/json")
+	_ = json.NewEncoder(rw).Encode(map[string]bool{"fail": healthFailure.Load()})
+}
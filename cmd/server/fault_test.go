@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestInjectFault(t *testing.T) {
+	rr := httptest.NewRecorder()
+	if injectFault(rr, httptest.NewRequest("GET", "/?fail_rate=1", nil)) != true {
+		t.Error("expected fail_rate=1 to fail the request")
+	}
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	if injectFault(rr, httptest.NewRequest("GET", "/?fail_rate=0", nil)) {
+		t.Error("expected fail_rate=0 to pass the request through")
+	}
+
+	start := time.Now()
+	rr = httptest.NewRecorder()
+	if injectFault(rr, httptest.NewRequest("GET", "/?delay_ms=50", nil)) {
+		t.Error("expected delayed request to pass through")
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("expected at least 50ms delay, got %s", elapsed)
+	}
+}
+
+func TestHandleChaosHealth(t *testing.T) {
+	defer healthFailure.Store(false)
+
+	rr := httptest.NewRecorder()
+	handleChaosHealth(rr, httptest.NewRequest("POST", "/chaos/health?fail=true", nil))
+	if rr.Code != http.StatusOK || !healthFailure.Load() {
+		t.Fatalf("expected health failure to be enabled, status %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handleChaosHealth(rr, httptest.NewRequest("POST", "/chaos/health?fail=maybe", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for invalid value, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handleChaosHealth(rr, httptest.NewRequest("POST", "/chaos/health?fail=false", nil))
+	if healthFailure.Load() {
+		t.Error("expected health failure to be disabled")
+	}
+}
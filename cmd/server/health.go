@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	healthLiveness  = "liveness"
+	healthReadiness = "readiness"
+)
+
+var (
+	healthMode      = flag.String("health-mode", healthLiveness, `what /health checks by default: "liveness" only checks the process, "readiness" also requires a reachable db (override per request with ?mode=)`)
+	healthDbTTL     = flag.Duration("health-db-ttl", 5*time.Second, "how long the result of a db probe is reused by /health")
+	healthDbTimeout = flag.Duration("health-db-timeout", time.Second, "timeout of a single db probe made by /health")
+)
+
+func checkHealthMode(mode string) error {
+	if mode != healthLiveness && mode != healthReadiness {
+		return fmt.Errorf("health mode must be %s or %s, got %q", healthLiveness, healthReadiness, mode)
+	}
+	return nil
+}
+
+// dbProbe checks that the db service answers its /health endpoint and caches
+// the result for ttl, so frequent balancer checks do not hammer the db.
+type dbProbe struct {
+	dbURL  string
+	ttl    time.Duration
+	client *http.Client
+	now    func() time.Time
+
+	mu      sync.Mutex
+	checked time.Time
+	err     error
+}
+
+func newDbProbe(dbURL string, ttl, timeout time.Duration) *dbProbe {
+	return &dbProbe{
+		dbURL:  dbURL,
+		ttl:    ttl,
+		client: &http.Client{Timeout: timeout},
+		now:    time.Now,
+	}
+}
+
+// Check returns the result of the last probe if it is younger than ttl and
+// probes the db otherwise. Concurrent callers wait for a single probe.
+func (p *dbProbe) Check() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.checked.IsZero() && p.now().Sub(p.checked) < p.ttl {
+		return p.err
+	}
+	p.err = pingDb(p.client, p.dbURL)
+	p.checked = p.now()
+	return p.err
+}
+
+// healthHandler serves /health. Liveness fails only when a failure is forced
+// through CONF_HEALTH_FAILURE or the chaos API; readiness additionally fails
+// while the db is unreachable.
+func healthHandler(probe *dbProbe, defaultMode string) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		mode := r.URL.Query().Get("mode")
+		if mode == "" {
+			mode = defaultMode
+		}
+		if err := checkHealthMode(mode); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rw.Header().Set("content-type", "text/plain")
+		if failConfig := os.Getenv(confHealthFailure); failConfig == "true" || healthFailure.Load() {
+			rw.WriteHeader(http.StatusInternalServerError)
+			_, _ = rw.Write([]byte("FAILURE"))
+			return
+		}
+		if mode == healthReadiness {
+			if err := probe.Check(); err != nil {
+				rw.WriteHeader(http.StatusServiceUnavailable)
+				_, _ = fmt.Fprintf(rw, "FAILURE: db: %v", err)
+				return
+			}
+		}
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write([]byte("OK"))
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthHandler(t *testing.T) {
+	var probes atomic.Int32
+	var down atomic.Bool
+	db := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		probes.Add(1)
+		if down.Load() {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(db.Close)
+
+	now := time.Unix(0, 0)
+	probe := newDbProbe(db.URL, 5*time.Second, time.Second)
+	probe.now = func() time.Time { return now }
+
+	check := func(h http.HandlerFunc, target string, want int) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		h(rr, httptest.NewRequest("GET", target, nil))
+		if rr.Code != want {
+			t.Errorf("GET %s: expected status %d, got %d (%s)", target, want, rr.Code, rr.Body)
+		}
+	}
+
+	liveness := healthHandler(probe, healthLiveness)
+	readiness := healthHandler(probe, healthReadiness)
+
+	check(liveness, "/health", http.StatusOK)
+	if probes.Load() != 0 {
+		t.Errorf("liveness probed the db %d times", probes.Load())
+	}
+	check(readiness, "/health", http.StatusOK)
+	check(liveness, "/health?mode=readiness", http.StatusOK)
+	if probes.Load() != 1 {
+		t.Errorf("expected the probe result to be cached, got %d probes", probes.Load())
+	}
+
+	down.Store(true)
+	check(readiness, "/health", http.StatusOK)
+	now = now.Add(5 * time.Second)
+	check(readiness, "/health", http.StatusServiceUnavailable)
+	check(readiness, "/health?mode=liveness", http.StatusOK)
+	check(liveness, "/health?mode=bogus", http.StatusBadRequest)
+
+	db.Close()
+	now = now.Add(5 * time.Second)
+	down.Store(false)
+	check(readiness, "/health", http.StatusServiceUnavailable)
+
+	healthFailure.Store(true)
+	defer healthFailure.Store(false)
+	check(liveness, "/health", http.StatusInternalServerError)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	reportHistoryInterval = flag.Duration("report-history-interval", 0, "how often a timestamped /report snapshot is pushed to the db for /report/history (0 disables it)")
+	reportHistorySize     = flag.Int("report-history-size", 60, "number of timestamped /report snapshots kept in the db")
+)
+
+// reportSnapshotVersion is the version of reportSnapshotSchema the pushed
+// snapshots follow.
+const reportSnapshotVersion = 1
+
+// reportSnapshot is a report pushed to the db under a timestamped key.
+type reportSnapshot struct {
+	Version  int             `json:"version"`
+	Instance string          `json:"instance"`
+	TakenAt  time.Time       `json:"taken_at"`
+	Report   json.RawMessage `json:"report"`
+}
+
+// reportSnapshotSchema is the JSON Schema of reportSnapshot, served at
+// /report/schema.
+const reportSnapshotSchema = `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "$id": "report-snapshot/v1",
+  "title": "Report snapshot",
+  "type": "object",
+  "required": ["version", "instance", "taken_at", "report"],
+  "properties": {
+    "version": {"const": 1},
+    "instance": {"type": "string"},
+    "taken_at": {"type": "string", "format": "date-time"},
+    "report": {
+      "type": "object",
+      "required": ["served_by", "authors"],
+      "properties": {
+        "served_by": {"type": "string"},
+        "authors": {
+          "type": "object",
+          "additionalProperties": {"type": "array", "items": {"type": "string"}}
+        },
+        "clients": {"$ref": "#/$defs/summaries"},
+        "paths": {"$ref": "#/$defs/summaries"}
+      }
+    }
+  },
+  "$defs": {
+    "summaries": {
+      "type": "object",
+      "additionalProperties": {
+        "type": "object",
+        "required": ["count", "avg_latency_ms", "max_latency_ms", "statuses"],
+        "properties": {
+          "count": {"type": "integer", "minimum": 0},
+          "avg_latency_ms": {"type": "number", "minimum": 0},
+          "max_latency_ms": {"type": "number", "minimum": 0},
+          "statuses": {"type": "object", "additionalProperties": {"type": "integer", "minimum": 0}}
+        }
+      }
+    }
+  }
+}
+`
+
+// reportHistory pushes report snapshots to the db under "<key>-<unix ms>"
+// and keeps the newest size of them listed, oldest first, under the index
+// key "<key>". Older snapshots are deleted.
+type reportHistory struct {
+	dbURL    string
+	key      string
+	instance string
+	size     int
+	now      func() time.Time
+
+	mu   sync.Mutex
+	keys []string
+}
+
+func newReportHistory(dbURL, key, instance string, size int) *reportHistory {
+	return &reportHistory{dbURL: dbURL, key: key, instance: instance, size: size, now: time.Now}
+}
+
+// LoadIndex reads the index back from the db, so a restarted server keeps
+// serving the snapshots pushed before. A missing index is not an error.
+func (h *reportHistory) LoadIndex(ctx context.Context) error {
+	status, body, err := fetchFromDb(ctx, h.dbURL, h.key, "string", nil)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusNotFound {
+		return nil
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("unexpected db status %d", status)
+	}
+	var keys []string
+	if err := decodeDbValue(body, &keys); err != nil {
+		return fmt.Errorf("invalid history index: %w", err)
+	}
+	h.mu.Lock()
+	h.keys = keys
+	h.mu.Unlock()
+	return nil
+}
+
+// Push wraps a JSON report in a snapshot, writes it under a new timestamped
+// key and updates the index.
+func (h *reportHistory) Push(report []byte) error {
+	snapshot := reportSnapshot{
+		Version:  reportSnapshotVersion,
+		Instance: h.instance,
+		TakenAt:  h.now().UTC(),
+		Report:   report,
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%s-%d", h.key, snapshot.TakenAt.UnixMilli())
+	if err := loadOnce(h.dbURL, key, string(data)); err != nil {
+		return fmt.Errorf("push snapshot %s: %w", key, err)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.keys = append(h.keys, key)
+	var dropped []string
+	if len(h.keys) > h.size {
+		dropped = slices.Clone(h.keys[:len(h.keys)-h.size])
+		h.keys = slices.Delete(h.keys, 0, len(dropped))
+	}
+	index, err := json.Marshal(h.keys)
+	if err != nil {
+		return err
+	}
+	if err := loadOnce(h.dbURL, h.key, string(index)); err != nil {
+		return fmt.Errorf("update history index: %w", err)
+	}
+	for _, old := range dropped {
+		if err := deleteFromDb(h.dbURL, old); err != nil {
+			log.Printf("report: failed to delete old snapshot %s: %v", old, err)
+		}
+	}
+	return nil
+}
+
+// ServeHTTP serves the newest snapshots, newest first; ?limit= caps how
+// many (10 by default). Snapshots missing from the db are skipped.
+func (h *reportHistory) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	limit := 10
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(rw, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	h.mu.Lock()
+	keys := slices.Clone(h.keys)
+	h.mu.Unlock()
+	slices.Reverse(keys)
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+
+	snapshots := make([]json.RawMessage, 0, len(keys))
+	for _, key := range keys {
+		status, body, err := fetchFromDb(r.Context(), h.dbURL, key, "string", r.Header)
+		if err != nil {
+			log.Printf("%sfailed to read snapshot %s: %v", logPrefix(r), key, err)
+			http.Error(rw, "cannot read report history", http.StatusBadGateway)
+			return
+		}
+		if status == http.StatusNotFound {
+			continue
+		}
+		var snapshot json.RawMessage
+		if status != http.StatusOK || decodeDbValue(body, &snapshot) != nil {
+			log.Printf("%sskipping snapshot %s: db status %d", logPrefix(r), key, status)
+			continue
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	rw.Header().Set("content-type", "application/json")
+	_ = json.NewEncoder(rw).Encode(snapshots)
+}
+
+// serveReportSchema serves reportSnapshotSchema.
+func serveReportSchema(rw http.ResponseWriter, _ *http.Request) {
+	rw.Header().Set("content-type", "application/schema+json")
+	_, _ = rw.Write([]byte(reportSnapshotSchema))
+}
+
+// decodeDbValue decodes the JSON held in the string value of a db response.
+func decodeDbValue(body []byte, v any) error {
+	var resp struct {
+		Value string `json:"value"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(resp.Value), v)
+}
+
+func deleteFromDb(dbURL, key string) error {
+	if embeddedDb != nil {
+		return embeddedDb.Delete(key)
+	}
+	rawURL, err := dbKeyURL(dbURL, key)
+	if err != nil {
+		return fmt.Errorf("invalid db url: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodDelete, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(withDbToken(req))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReportHistory(t *testing.T) {
+	db := fakeDb(t, false)
+	h := newReportHistory(db.URL, "report-a-history", "a", 2)
+	now := time.UnixMilli(1000)
+	h.now = func() time.Time { return now }
+
+	for _, served := range []string{"first", "second", "third"} {
+		if err := h.Push([]byte(`{"served_by":"` + served + `","authors":{}}`)); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(time.Second)
+	}
+	if status, _, _ := fetchFromDb(context.Background(), db.URL, "report-a-history-1000", "string", nil); status != http.StatusNotFound {
+		t.Errorf("expected the oldest snapshot deleted, got status %d", status)
+	}
+
+	// A restarted server reads the index back.
+	restarted := newReportHistory(db.URL, "report-a-history", "a", 2)
+	if err := restarted.LoadIndex(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	restarted.ServeHTTP(rr, httptest.NewRequest("GET", "/report/history", nil))
+	var snapshots []reportSnapshot
+	if err := json.Unmarshal(rr.Body.Bytes(), &snapshots); err != nil {
+		t.Fatalf("invalid history %q: %v", rr.Body, err)
+	}
+	if len(snapshots) != 2 {
+		t.Fatalf("expected the 2 newest snapshots, got %d", len(snapshots))
+	}
+	var newest reportResponse
+	_ = json.Unmarshal(snapshots[0].Report, &newest)
+	if snapshots[0].Version != reportSnapshotVersion || snapshots[0].Instance != "a" ||
+		!snapshots[0].TakenAt.Equal(time.UnixMilli(3000)) || newest.ServedBy != "third" {
+		t.Errorf("unexpected newest snapshot %+v", snapshots[0])
+	}
+
+	rr = httptest.NewRecorder()
+	restarted.ServeHTTP(rr, httptest.NewRequest("GET", "/report/history?limit=1", nil))
+	if err := json.Unmarshal(rr.Body.Bytes(), &snapshots); err != nil || len(snapshots) != 1 {
+		t.Errorf("expected 1 snapshot with ?limit=1, got %q", rr.Body)
+	}
+	rr = httptest.NewRecorder()
+	restarted.ServeHTTP(rr, httptest.NewRequest("GET", "/report/history?limit=0", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for ?limit=0, got %d", rr.Code)
+	}
+}
+
+func TestReportSnapshotSchema(t *testing.T) {
+	var schema struct {
+		Required   []string                   `json:"required"`
+		Properties map[string]json.RawMessage `json:"properties"`
+	}
+	if err := json.Unmarshal([]byte(reportSnapshotSchema), &schema); err != nil {
+		t.Fatalf("invalid schema: %v", err)
+	}
+	data, _ := json.Marshal(reportSnapshot{Report: json.RawMessage(`{}`)})
+	var fields map[string]any
+	_ = json.Unmarshal(data, &fields)
+	for name := range fields {
+		if _, ok := schema.Properties[name]; !ok {
+			t.Errorf("the schema does not describe %q", name)
+		}
+	}
+	if len(schema.Properties) != len(fields) {
+		t.Errorf("the schema describes %d fields, the snapshot has %d", len(schema.Properties), len(fields))
+	}
+}
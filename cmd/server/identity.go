@@ -0,0 +1,31 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"os"
+)
+
+var instanceID = flag.String("instance-id", "", "identity reported in the x-served-by header and /report (defaults to the hostname)")
+
+const servedByHeader = "x-served-by"
+
+// resolveInstanceID returns -instance-id, falling back to the hostname.
+func resolveInstanceID() string {
+	if *instanceID != "" {
+		return *instanceID
+	}
+	if host, err := os.Hostname(); err == nil && host != "" {
+		return host
+	}
+	return "unknown"
+}
+
+// withServedBy tags every response with the instance that produced it, so
+// responses can be attributed to a replica even without balancer tracing.
+func withServedBy(id string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set(servedByHeader, id)
+		next.ServeHTTP(rw, r)
+	})
+}
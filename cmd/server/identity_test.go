@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithServedBy(t *testing.T) {
+	h := withServedBy("server2", http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusNotFound)
+	}))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/some-data", nil))
+	if got := rr.Header().Get(servedByHeader); got != "server2" {
+		t.Errorf("%s = %q, want server2", servedByHeader, got)
+	}
+
+	original := *instanceID
+	defer func() { *instanceID = original }()
+	*instanceID = "custom"
+	if got := resolveInstanceID(); got != "custom" {
+		t.Errorf("resolveInstanceID() = %q, want the flag value", got)
+	}
+	*instanceID = ""
+	if got := resolveInstanceID(); got == "" {
+		t.Error("expected a fallback identity")
+	}
+}
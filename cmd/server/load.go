@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+var (
+	loadKey           = flag.String("load-key", envOr("LOAD_KEY", TEAM_NAME), "key written to the db at startup (defaults to $LOAD_KEY)")
+	loadValue         = flag.String("load-value", envOr("LOAD_VALUE", ""), "value written under -load-key at startup; empty writes the current date (defaults to $LOAD_VALUE)")
+	loadTimeout       = flag.Duration("load-timeout", 30*time.Second, "how long to keep retrying the startup load while the db is not up yet (0 tries once)")
+	loadRetryInterval = flag.Duration("load-retry-interval", time.Second, "delay between startup load attempts")
+)
+
+// errLoadRejected marks a startup load the db answered with a client error;
+// retrying it would not help.
+var errLoadRejected = errors.New("db rejected the startup value")
+
+// loadedValue returns the value the server writes at startup.
+func loadedValue(value string) string {
+	if value == "" {
+		return time.Now().Format(time.DateOnly)
+	}
+	return value
+}
+
+// load writes value under key, retrying every interval until timeout passes
+// while the db is unreachable or answers with a server error.
+func load(dbURL, key, value string, timeout, interval time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for attempt := 1; ; attempt++ {
+		err := loadOnce(dbURL, key, value)
+		if err == nil {
+			log.Printf("successfully loaded %s = %s", key, value)
+			return nil
+		}
+		if errors.Is(err, errLoadRejected) || time.Now().Add(interval).After(deadline) {
+			return err
+		}
+		log.Printf("startup load attempt %d failed, retrying in %s: %v", attempt, interval, err)
+		time.Sleep(interval)
+	}
+}
+
+func loadOnce(dbURL, key, value string) error {
+	if embeddedDb != nil {
+		return embeddedDb.Put(key, value)
+	}
+	rawURL, err := dbKeyURL(dbURL, key)
+	if err != nil {
+		return fmt.Errorf("invalid db url: %w", err)
+	}
+	jsonBytes, err := json.Marshal(map[string]string{"value": value})
+	if err != nil {
+		return fmt.Errorf("failed to encode JSON: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, rawURL, bytes.NewBuffer(jsonBytes))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(withDbToken(req))
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests:
+		return fmt.Errorf("%w: unexpected response status: %s", errLoadRejected, resp.Status)
+	default:
+		return fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	var attempts atomic.Int32
+	var stored string
+	db := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) < 3 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path != "/db/team" {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var body struct{ Value string }
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		stored = body.Value
+	}))
+	t.Cleanup(db.Close)
+
+	if err := load(db.URL, "team", "v1", time.Second, time.Millisecond); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if attempts.Load() != 3 || stored != "v1" {
+		t.Errorf("expected v1 stored on the 3rd attempt, got %q after %d", stored, attempts.Load())
+	}
+
+	attempts.Store(10)
+	err := load(db.URL, "other", "v1", time.Second, time.Millisecond)
+	if !errors.Is(err, errLoadRejected) || attempts.Load() != 11 {
+		t.Errorf("expected a single rejected attempt, got %v after %d", err, attempts.Load()-10)
+	}
+
+	db.Close()
+	start := time.Now()
+	if err := load(db.URL, "team", "v1", 50*time.Millisecond, 10*time.Millisecond); err == nil {
+		t.Error("expected load against a stopped db to fail")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("load kept retrying for %s", elapsed)
+	}
+
+	if got := loadedValue(""); got != time.Now().Format(time.DateOnly) {
+		t.Errorf("expected today's date by default, got %q", got)
+	}
+}
@@ -1,32 +1,186 @@
 package main
 
 import (
+	"container/list"
 	"encoding/json"
 	"log"
 	"net/http"
+	"slices"
+	"sync"
+	"time"
 )
 
 const reportMaxLen = 100
 
-type Report map[string][]string
+type reportEntry struct {
+	author   string
+	counters []string
+	lastSeen time.Time
+}
+
+// Report keeps the most recent request counters per author, and request
+// analytics per client and per path (see Record). It holds at most
+// maxAuthors authors, evicting the least recently seen one when full, and
+// forgets authors that have not been seen for longer than retention.
+type Report struct {
+	// servedBy identifies the instance in the served report.
+	servedBy   string
+	mu         sync.Mutex
+	maxAuthors int
+	retention  time.Duration
+	entries    map[string]*list.Element
+	lru        *list.List // front is the most recently seen author
+	clients    map[string]*requestStats
+	paths      map[string]*requestStats
+	now        func() time.Time
+}
+
+func NewReport(maxAuthors int, retention time.Duration) *Report {
+	return &Report{
+		maxAuthors: maxAuthors,
+		retention:  retention,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		clients:    make(map[string]*requestStats),
+		paths:      make(map[string]*requestStats),
+		now:        time.Now,
+	}
+}
 
-func (r Report) Process(req *http.Request) {
+func (r *Report) Process(req *http.Request) {
 	author := req.Header.Get("lb-author")
 	counter := req.Header.Get("lb-req-cnt")
-	log.Printf("GET some-data from [%s] request [%s]", author, counter)
+	log.Printf("%sGET some-data from [%s] request [%s]", logPrefix(req), author, counter)
+
+	if len(author) == 0 {
+		return
+	}
 
-	if len(author) > 0 {
-		list := r[author]
-		list = append(list, counter)
-		if len(list) > reportMaxLen {
-			list = list[len(list)-reportMaxLen:]
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var e *reportEntry
+	if elem, ok := r.entries[author]; ok {
+		e = elem.Value.(*reportEntry)
+		r.lru.MoveToFront(elem)
+	} else {
+		if r.maxAuthors > 0 && r.lru.Len() >= r.maxAuthors {
+			r.removeLocked(r.lru.Back())
 		}
-		r[author] = list
+		e = &reportEntry{author: author}
+		r.entries[author] = r.lru.PushFront(e)
+	}
+
+	e.lastSeen = r.now()
+	e.counters = append(e.counters, counter)
+	if len(e.counters) > reportMaxLen {
+		e.counters = e.counters[len(e.counters)-reportMaxLen:]
 	}
 }
 
-func (r Report) ServeHTTP(rw http.ResponseWriter, _ *http.Request) {
+func (r *Report) Get(author string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if elem, ok := r.entries[author]; ok {
+		return append([]string(nil), elem.Value.(*reportEntry).counters...)
+	}
+	return nil
+}
+
+func (r *Report) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lru.Len()
+}
+
+// Age drops authors, clients and paths not seen within the retention period.
+func (r *Report) Age() {
+	if r.retention <= 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cutoff := r.now().Add(-r.retention)
+	for elem := r.lru.Back(); elem != nil; elem = r.lru.Back() {
+		if elem.Value.(*reportEntry).lastSeen.After(cutoff) {
+			break
+		}
+		r.removeLocked(elem)
+	}
+	ageStatsLocked(r.clients, cutoff)
+	ageStatsLocked(r.paths, cutoff)
+}
+
+// StartAging runs Age periodically until stop is closed.
+func (r *Report) StartAging(interval time.Duration, stop <-chan struct{}) {
+	if r.retention <= 0 || interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.Age()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func (r *Report) removeLocked(elem *list.Element) {
+	delete(r.entries, elem.Value.(*reportEntry).author)
+	r.lru.Remove(elem)
+}
+
+// reportResponse is the whole report, served at /report?view=full and
+// persisted by StartPersisting.
+type reportResponse struct {
+	ServedBy string                    `json:"served_by"`
+	Authors  map[string][]string       `json:"authors"`
+	Clients  map[string]requestSummary `json:"clients,omitempty"`
+	Paths    map[string]requestSummary `json:"paths,omitempty"`
+}
+
+func (r *Report) snapshot() reportResponse {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	snapshot := reportResponse{
+		ServedBy: r.servedBy,
+		Authors:  make(map[string][]string, len(r.entries)),
+		Clients:  summaries(r.clients),
+		Paths:    summaries(r.paths),
+	}
+	for author, elem := range r.entries {
+		snapshot.Authors[author] = slices.Clone(elem.Value.(*reportEntry).counters)
+	}
+	return snapshot
+}
+
+// ServeHTTP serves the counters of every author as a JSON object keyed by
+// author; the x-served-by header tells which instance answered. With
+// ?view=full it serves the whole report, client and path stats included,
+// and with ?format=csv those stats as CSV.
+func (r *Report) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	snapshot := r.snapshot()
+	query := req.URL.Query()
+	if query.Get("format") == "csv" {
+		writeCSV(rw, snapshot)
+		return
+	}
+	var body any = snapshot.Authors
+	if query.Get("view") == "full" {
+		body = snapshot
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		http.Error(rw, "cannot encode report", http.StatusInternalServerError)
+		return
+	}
+
 	rw.Header().Set("content-type", "application/json")
 	rw.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(rw).Encode(r)
+	_, _ = rw.Write(data)
 }
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http/httptest"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestReport_Process(t *testing.T) {
@@ -11,17 +14,17 @@ func TestReport_Process(t *testing.T) {
 	req.Header.Set("lb-author", "test-author")
 	req.Header.Set("lb-req-cnt", "1")
 
-	r := make(Report)
+	r := NewReport(0, 0)
 
 	r.Process(req)
-	if !reflect.DeepEqual(r["test-author"], []string{"1"}) {
-		t.Errorf("Unexpected report state %s", r)
+	if !reflect.DeepEqual(r.Get("test-author"), []string{"1"}) {
+		t.Errorf("Unexpected report state %v", r.Get("test-author"))
 	}
 
 	req.Header.Set("lb-req-cnt", "2")
 	r.Process(req)
-	if !reflect.DeepEqual(r["test-author"], []string{"1", "2"}) {
-		t.Errorf("Unexpected report state %s", r)
+	if !reflect.DeepEqual(r.Get("test-author"), []string{"1", "2"}) {
+		t.Errorf("Unexpected report state %v", r.Get("test-author"))
 	}
 
 	req.Header.Set("lb-author", "test-len")
@@ -29,7 +32,82 @@ func TestReport_Process(t *testing.T) {
 		req.Header.Set("lb-req-cnt", "test-len")
 		r.Process(req)
 	}
-	if len(r["test-len"]) != reportMaxLen {
-		t.Errorf("Unexpectd error length: %d", len(r["test-len"]))
+	if len(r.Get("test-len")) != reportMaxLen {
+		t.Errorf("Unexpectd error length: %d", len(r.Get("test-len")))
+	}
+}
+
+func TestReport_Eviction(t *testing.T) {
+	r := NewReport(2, 0)
+	process := func(author string) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("lb-author", author)
+		req.Header.Set("lb-req-cnt", "1")
+		r.Process(req)
+	}
+
+	process("a")
+	process("b")
+	process("a") // "b" is now the least recently seen author
+	process("c")
+
+	if r.Len() != 2 {
+		t.Errorf("expected 2 authors, got %d", r.Len())
+	}
+	if r.Get("b") != nil {
+		t.Error("expected least recently seen author to be evicted")
+	}
+	if r.Get("a") == nil || r.Get("c") == nil {
+		t.Error("expected recently seen authors to be kept")
+	}
+}
+
+func TestReport_Age(t *testing.T) {
+	now := time.Now()
+	r := NewReport(0, time.Minute)
+	r.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("lb-author", fmt.Sprintf("author-%d", i))
+		r.Process(req)
+		now = now.Add(40 * time.Second)
+	}
+
+	r.Age()
+	if r.Len() != 1 || r.Get("author-2") == nil {
+		t.Errorf("expected only the author seen within retention to remain, got %d authors", r.Len())
+	}
+}
+
+func TestReport_ServeHTTP(t *testing.T) {
+	r := NewReport(0, 0)
+	r.servedBy = "server1"
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("lb-author", "author")
+	req.Header.Set("lb-req-cnt", "7")
+	r.Process(req)
+
+	rr := httptest.NewRecorder()
+	withServedBy("server1", r).ServeHTTP(rr, httptest.NewRequest("GET", "/report", nil))
+	var got map[string][]string
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string][]string{"author": {"7"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("report = %+v, want %+v", got, want)
+	}
+	if rr.Header().Get(servedByHeader) != "server1" {
+		t.Errorf("the report must name the instance in %s, got %q", servedByHeader, rr.Header().Get(servedByHeader))
+	}
+
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("GET", "/report?view=full", nil))
+	var full reportResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &full); err != nil {
+		t.Fatal(err)
+	}
+	if want := (reportResponse{ServedBy: "server1", Authors: got}); !reflect.DeepEqual(full, want) {
+		t.Errorf("full report = %+v, want %+v", full, want)
 	}
 }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	dbRetryBudget   = flag.Duration("db-retry-budget", 2*time.Second, "total time to wait on Retry-After from an overloaded db before failing the request (0 disables retries)")
+	dbRetryAttempts = flag.Int("db-retry-attempts", 3, "maximum number of db requests per client request when the db answers 503 or 429")
+)
+
+// defaultRetryAfter is used when an overloaded db does not say how long to
+// wait.
+const defaultRetryAfter = 100 * time.Millisecond
+
+// doWithRetry sends a body-less request and repeats it while the db answers
+// 503 or 429, waiting as told by Retry-After. Retries stop once the next wait
+// would exceed the budget, so a long overload fails fast instead of piling up
+// requests; the last response is returned as is. Every attempt carries the
+// budget left of the request context in the deadline header.
+func doWithRetry(client *http.Client, req *http.Request, budget time.Duration, attempts int) (*http.Response, error) {
+	deadline := time.Now().Add(budget)
+	for attempt := 1; ; attempt++ {
+		setDeadlineHeader(req)
+		resp, err := client.Do(req)
+		if err != nil || attempt >= attempts || !retryableStatus(resp.StatusCode) {
+			return resp, err
+		}
+		wait := retryAfter(resp.Header.Get("Retry-After"), time.Now())
+		if time.Now().Add(wait).After(deadline) {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+func retryableStatus(code int) bool {
+	return code == http.StatusServiceUnavailable || code == http.StatusTooManyRequests
+}
+
+// retryAfter parses a Retry-After value given either in seconds or as an
+// HTTP date.
+func retryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return defaultRetryAfter
+	}
+	if sec, err := strconv.Atoi(value); err == nil && sec >= 0 {
+		return time.Duration(sec) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(t.Sub(now), 0)
+	}
+	return defaultRetryAfter
+}
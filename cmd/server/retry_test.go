@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", defaultRetryAfter},
+		{"0", 0},
+		{"2", 2 * time.Second},
+		{now.Add(3 * time.Second).Format(http.TimeFormat), 3 * time.Second},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+		{"soon", defaultRetryAfter},
+	}
+	for _, tt := range tests {
+		if got := retryAfter(tt.value, now); got != tt.want {
+			t.Errorf("retryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestDoWithRetry(t *testing.T) {
+	var calls atomic.Int32
+	failures := int32(0)
+	retryAfterValue := "0"
+	db := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= failures {
+			rw.Header().Set("Retry-After", retryAfterValue)
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer db.Close()
+
+	get := func(budget time.Duration) int {
+		calls.Store(0)
+		req, _ := http.NewRequest(http.MethodGet, db.URL, nil)
+		resp, err := doWithRetry(db.Client(), req, budget, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	failures = 2
+	if code := get(time.Second); code != http.StatusOK || calls.Load() != 3 {
+		t.Errorf("brief overload: status %d after %d calls, want 200 after 3", code, calls.Load())
+	}
+
+	failures = 5
+	if code := get(time.Second); code != http.StatusServiceUnavailable || calls.Load() != 3 {
+		t.Errorf("long overload: status %d after %d calls, want 503 after 3", code, calls.Load())
+	}
+
+	failures, retryAfterValue = 1, "30"
+	if code := get(time.Second); code != http.StatusServiceUnavailable || calls.Load() != 1 {
+		t.Errorf("Retry-After over budget: status %d after %d calls, want 503 after 1", code, calls.Load())
+	}
+}
+
+func TestDoWithRetry_DeadlineHeader(t *testing.T) {
+	var budgets []int64
+	db := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		ms, _ := strconv.ParseInt(r.Header.Get(deadlineHeader), 10, 64)
+		budgets = append(budgets, ms)
+		time.Sleep(20 * time.Millisecond)
+		rw.Header().Set("Retry-After", "0")
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, db.URL, nil)
+	resp, err := doWithRetry(db.Client(), req, time.Second, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if len(budgets) != 3 {
+		t.Fatalf("expected 3 attempts, got budgets %v", budgets)
+	}
+	for i, ms := range budgets {
+		if ms <= 0 || ms > 5000 || (i > 0 && ms >= budgets[i-1]) {
+			t.Errorf("the db must see the budget shrink with every attempt, got %v", budgets)
+			break
+		}
+	}
+
+	budgets = nil
+	req, _ = http.NewRequest(http.MethodGet, db.URL, nil)
+	resp, err = doWithRetry(db.Client(), req, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if budgets[0] != 0 {
+		t.Errorf("a request without a deadline must not send a budget, got %d", budgets[0])
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+var selfTestMode = flag.Bool("selftest", false, "check config and the db dependency, then exit with status 0 on success or 1 on failure instead of serving")
+
+// selfTest runs every startup check against the db at dbURL and returns the
+// failures joined together, or nil if all checks passed.
+func selfTest(dbURL string) error {
+	checks := []struct {
+		name string
+		run  func() error
+	}{
+		{"config", checkConfig},
+		{"db reachable", func() error { return checkDbHealth(dbURL) }},
+		{"db roundtrip", func() error { return checkDbRoundtrip(dbURL) }},
+	}
+
+	var errs []error
+	for _, check := range checks {
+		if err := check.run(); err != nil {
+			log.Printf("self-test: %s: FAIL: %v", check.name, err)
+			errs = append(errs, fmt.Errorf("%s: %w", check.name, err))
+			continue
+		}
+		log.Printf("self-test: %s: OK", check.name)
+	}
+	return errors.Join(errs...)
+}
+
+func checkConfig() error {
+	if v := os.Getenv(confResponseDelaySec); v != "" {
+		if delay, err := strconv.Atoi(v); err != nil || delay < 0 {
+			return fmt.Errorf("%s must be a non-negative integer, got %q", confResponseDelaySec, v)
+		}
+	}
+	if v := os.Getenv(confHealthFailure); v != "" && v != "true" && v != "false" {
+		return fmt.Errorf("%s must be true or false, got %q", confHealthFailure, v)
+	}
+	if *reportMaxAuthors < 0 || *reportRetention < 0 {
+		return errors.New("report limits must not be negative")
+	}
+	if *reportHistoryInterval > 0 && *reportHistorySize <= 0 {
+		return errors.New("report-history-size must be positive")
+	}
+	if err := checkHealthMode(*healthMode); err != nil {
+		return err
+	}
+	return nil
+}
+
+var selfTestClient = &http.Client{Timeout: 5 * time.Second}
+
+func checkDbHealth(dbURL string) error {
+	return pingDb(selfTestClient, dbURL)
+}
+
+// pingDb checks that the db service at dbURL answers its /health endpoint.
+// The embedded store runs in-process, so it is always reachable.
+func pingDb(client *http.Client, dbURL string) error {
+	if embeddedDb != nil {
+		return nil
+	}
+	resp, err := client.Get(dbURL + "/health")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("health check returned %s", resp.Status)
+	}
+	return nil
+}
+
+// checkDbRoundtrip writes a unique value under a probe key, reads it back and
+// removes the key again.
+func checkDbRoundtrip(dbURL string) error {
+	probeKey := fmt.Sprintf("selftest-probe-%d", os.Getpid())
+	want := strconv.FormatInt(time.Now().UnixNano(), 10)
+	if embeddedDb != nil {
+		return checkEmbeddedRoundtrip(probeKey, want)
+	}
+	probeURL := dbURL + "/db/" + probeKey
+
+	body, _ := json.Marshal(map[string]string{"value": want})
+	req, _ := http.NewRequest(http.MethodPost, probeURL, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := selfTestClient.Do(withDbToken(req))
+	if err != nil {
+		return fmt.Errorf("write probe key: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("write probe key: unexpected status %s", resp.Status)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, probeURL, nil)
+	resp, err = selfTestClient.Do(withDbToken(req))
+	if err != nil {
+		return fmt.Errorf("read probe key: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("read probe key: unexpected status %s", resp.Status)
+	}
+	var got struct {
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		return fmt.Errorf("read probe key: %w", err)
+	}
+	if got.Value != want {
+		return fmt.Errorf("read back %q, wrote %q", got.Value, want)
+	}
+
+	req, _ = http.NewRequest(http.MethodDelete, probeURL, nil)
+	if resp, err := selfTestClient.Do(withDbToken(req)); err == nil {
+		resp.Body.Close()
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDb mimics the /health and /db/{key} endpoints of the db service.
+func fakeDb(t *testing.T, corrupt bool) *httptest.Server {
+	var mu sync.Mutex
+	data := make(map[string]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			return
+		}
+		key := strings.TrimPrefix(r.URL.Path, "/db/")
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPost:
+			var body struct{ Value string }
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			if corrupt {
+				body.Value += "x"
+			}
+			data[key] = body.Value
+		case http.MethodGet:
+			value, ok := data[key]
+			if !ok {
+				rw.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_ = json.NewEncoder(rw).Encode(map[string]string{"key": key, "value": value})
+		case http.MethodDelete:
+			delete(data, key)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestSelfTest(t *testing.T) {
+	if err := selfTest(fakeDb(t, false).URL); err != nil {
+		t.Errorf("self-test against a healthy db failed: %v", err)
+	}
+	if err := selfTest(fakeDb(t, true).URL); err == nil || !strings.Contains(err.Error(), "roundtrip") {
+		t.Errorf("expected roundtrip failure, got %v", err)
+	}
+
+	t.Setenv(confHealthFailure, "maybe")
+	if err := selfTest(fakeDb(t, false).URL); err == nil || !strings.Contains(err.Error(), "config") {
+		t.Errorf("expected config failure, got %v", err)
+	}
+
+	srv := fakeDb(t, false)
+	srv.Close()
+	if err := selfTest(srv.URL); err == nil || !strings.Contains(err.Error(), "db reachable") {
+		t.Errorf("expected unreachable db failure, got %v", err)
+	}
+}
@@ -1,8 +1,7 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"flag"
 	"fmt"
 	"io"
@@ -14,43 +13,113 @@ import (
 	"time"
 
 	"github.com/roman-mazur/architecture-practice-4-template/httptools"
+	"github.com/roman-mazur/architecture-practice-4-template/internal/telemetry"
 	"github.com/roman-mazur/architecture-practice-4-template/signal"
 )
 
-var port = flag.Int("port", 8080, "server port")
+var (
+	port     = flag.Int("port", 8080, "server port")
+	dbURL    = flag.String("db-url", envOr("DB_URL", "http://db:8080"), "base URL of the db service (defaults to $DB_URL)")
+	testMode = flag.Bool("test-mode", false, "enable fault injection: delay_ms/fail_rate query parameters and the /chaos API")
+
+	reportMaxAuthors = flag.Int("report-max-authors", 1000, "maximum number of authors kept in /report (0 means unlimited)")
+	reportRetention  = flag.Duration("report-retention", 30*time.Minute, "drop /report authors not seen for this long (0 keeps them forever)")
+
+	corsOrigins = flag.String("cors-origins", "", `comma-separated origins allowed to call the API from a browser, or "*" (empty disables CORS)`)
+	corsMethods = flag.String("cors-methods", "GET", "comma-separated methods allowed for cross-origin requests")
+	corsHeaders = flag.String("cors-headers", "Content-Type", "comma-separated request headers allowed for cross-origin requests")
+	corsMaxAge  = flag.Duration("cors-max-age", 10*time.Minute, "how long browsers may cache a preflight response")
+)
 
 const (
 	confResponseDelaySec = "CONF_RESPONSE_DELAY_SEC"
 	confHealthFailure    = "CONF_HEALTH_FAILURE"
-	DB_URL               = "http://db:8080"
 	TEAM_NAME            = "kpi3-test"
 )
 
+// envOr returns the value of the environment variable name, or def if it is
+// unset or empty.
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
 func main() {
-	err := load()
+	flag.Parse()
+	var err error
+	if tracer, err = telemetry.FromEnv("server"); err != nil {
+		log.Printf("OpenTelemetry tracing disabled: %v", err)
+	}
+	defer tracer.Close()
+	if shards := httptools.SplitList(*dbShardList); len(shards) > 0 {
+		dbShards = newShardRing(shards)
+		log.Printf("Routing db keys over %d shards", len(shards))
+	}
+	db, err := openEmbeddedDb(*dbMode, *dbPath, dbShards != nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if db != nil {
+		embeddedDb = db
+		defer db.Close()
+		log.Printf("Using the embedded datastore at %s", *dbPath)
+	}
+	if *selfTestMode {
+		if err := selfTest(*dbURL); err != nil {
+			log.Printf("self-test failed: %v", err)
+			os.Exit(1)
+		}
+		log.Println("self-test passed")
+		os.Exit(0)
+	}
+	err = load(*dbURL, *loadKey, loadedValue(*loadValue), *loadTimeout, *loadRetryInterval)
 	if err != nil {
 		log.Fatal(err)
 	}
 	h := new(http.ServeMux)
 
-	h.HandleFunc("/health", func(rw http.ResponseWriter, r *http.Request) {
-		rw.Header().Set("content-type", "text/plain")
-		if failConfig := os.Getenv(confHealthFailure); failConfig == "true" {
-			rw.WriteHeader(http.StatusInternalServerError)
-			_, _ = rw.Write([]byte("FAILURE"))
-		} else {
-			rw.WriteHeader(http.StatusOK)
-			_, _ = rw.Write([]byte("OK"))
+	if err := checkHealthMode(*healthMode); err != nil {
+		log.Fatal(err)
+	}
+	probe := newDbProbe(*dbURL, *healthDbTTL, *healthDbTimeout)
+	h.HandleFunc("/health", healthHandler(probe, *healthMode))
+
+	servedBy := resolveInstanceID()
+	report := NewReport(*reportMaxAuthors, *reportRetention)
+	report.servedBy = servedBy
+	report.StartAging(*reportRetention/10, nil)
+	persistKey := *reportPersistKey
+	if persistKey == "" {
+		persistKey = "report-" + servedBy
+	}
+	report.StartPersisting(*reportPersistInterval, func(data []byte) error {
+		return loadOnce(*dbURL, persistKey, string(data))
+	}, nil)
+	history := newReportHistory(*dbURL, persistKey+"-history", servedBy, *reportHistorySize)
+	if *reportHistoryInterval > 0 {
+		if *reportHistorySize <= 0 {
+			log.Fatal("report-history-size must be positive")
 		}
-	})
+		if err := history.LoadIndex(context.Background()); err != nil {
+			log.Printf("report: failed to load the history index: %v", err)
+		}
+		report.StartPersisting(*reportHistoryInterval, history.Push, nil)
+	}
 
-	report := make(Report)
+	cache := newResponseCache()
+	cache.StartExpiring(*cacheTTL, nil)
+	startWarming(cache, *dbURL, parseWarmKeys(*warmKeys), *warmRefresh, nil)
 
 	h.HandleFunc("/api/v1/some-data", func(rw http.ResponseWriter, r *http.Request) {
 		respDelayString := os.Getenv(confResponseDelaySec)
 		if delaySec, parseErr := strconv.Atoi(respDelayString); parseErr == nil && delaySec > 0 && delaySec < 300 {
 			time.Sleep(time.Duration(delaySec) * time.Second)
 		}
+		if *testMode && injectFault(rw, r) {
+			return
+		}
 		report.Process(r)
 		key := r.URL.Query().Get("key")
 		if key == "" {
@@ -61,64 +130,108 @@ func main() {
 		if t == "" {
 			t = "string"
 		}
-		rawUrl, err := url.JoinPath(DB_URL, "db", key)
-		if err != nil {
-			rw.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-		url, err := url.Parse(rawUrl)
-		if err != nil {
-			rw.WriteHeader(http.StatusInternalServerError)
+		if r.URL.Query().Get("nocache") == "1" {
+			cache.bypassed.Add(1)
+		} else if body, stale, ok := cache.Lookup(key, t); ok {
+			state := cacheHit
+			if stale {
+				state = cacheStale
+			}
+			setCacheHeaders(rw.Header(), r, state, 0)
+			rw.Header().Set("content-type", "application/json")
+			rw.WriteHeader(http.StatusOK)
+			_, _ = rw.Write(body)
 			return
 		}
-
-		q := url.Query()
-		q.Set("type", t)
-		url.RawQuery = q.Encode()
-		respFromDb, err := http.Get(url.String())
+		start := time.Now()
+		status, body, err := fetchFromDb(r.Context(), *dbURL, key, t, r.Header)
+		setCacheHeaders(rw.Header(), r, cacheMiss, time.Since(start))
 		if err != nil {
+			log.Printf("%sfailed to query db: %v", logPrefix(r), err)
 			rw.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		defer respFromDb.Body.Close()
-		if respFromDb.StatusCode == http.StatusNotFound {
-			rw.WriteHeader(http.StatusNotFound)
+		if status != http.StatusOK {
+			rw.WriteHeader(status)
 			return
 		}
+		cache.Store(key, t, body, *cacheTTL)
 		rw.Header().Set("content-type", "application/json")
 		rw.WriteHeader(http.StatusOK)
-		_, err = io.Copy(rw, respFromDb.Body)
-		if err != nil {
-			log.Printf("failed to copy response body: %v", err)
+		if _, err := rw.Write(body); err != nil {
+			log.Printf("%sfailed to write response body: %v", logPrefix(r), err)
 		}
 	})
 
 	h.Handle("/report", report)
+	h.Handle("/report/history", history)
+	h.HandleFunc("/report/schema", serveReportSchema)
+	h.Handle("/status", &serverStatus{
+		servedBy: servedBy,
+		started:  time.Now(),
+		probe:    probe,
+		cache:    cache,
+		flags:    flag.CommandLine,
+	})
+	if *testMode {
+		h.HandleFunc("/chaos/health", handleChaosHealth)
+	}
 
-	server := httptools.CreateServer(*port, h)
+	server := httptools.CreateServer(*port, tracer.Handler(withRequestLog(withDeadline(withServedBy(servedBy, report.Track(httptools.CORS(httptools.CORSConfig{
+		AllowedOrigins: httptools.SplitList(*corsOrigins),
+		AllowedMethods: httptools.SplitList(*corsMethods),
+		AllowedHeaders: httptools.SplitList(*corsHeaders),
+		MaxAge:         *corsMaxAge,
+	}, h)))))))
 	server.Start()
 	signal.WaitForTerminationSignal()
 }
 
-func load() error {
-	url := fmt.Sprintf("%s/db/%s", DB_URL, TEAM_NAME)
-	today := time.Now().Format(time.DateOnly)
-	payload := map[string]string{
-		"value": today,
+// fetchFromDb reads a value from the db service, or the embedded store,
+// forwarding the trace headers of the incoming request, if any. The body is only returned for a 200.
+func fetchFromDb(ctx context.Context, dbURL, key, typ string, header http.Header) (int, []byte, error) {
+	if embeddedDb != nil {
+		_, span := tracer.Start(ctx, "datastore.get", telemetry.KindInternal)
+		defer span.End()
+		status, body, err := fetchEmbedded(key, typ)
+		span.SetError(err)
+		return status, body, err
 	}
-	jsonBytes, err := json.Marshal(payload)
+	ctx, span := tracer.Start(ctx, "db.get", telemetry.KindClient)
+	defer span.End()
+	rawUrl, err := dbKeyURL(dbURL, key)
 	if err != nil {
-		return fmt.Errorf("failed to encode JSON: %w", err)
+		return 0, nil, err
 	}
-
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(jsonBytes))
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return 0, nil, err
+	}
+	q := u.Query()
+	q.Set("type", typ)
+	// Clients only read the value, so the db leaves the key out.
+	q.Set("fields", "value")
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	copyTraceHeaders(req.Header, header)
+	telemetry.Inject(ctx, req.Header)
+	span.SetAttribute("server.address", u.Host)
+	resp, err := doWithRetry(http.DefaultClient, withDbToken(req), *dbRetryBudget, *dbRetryAttempts)
 	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
+		span.SetError(err)
+		return 0, nil, err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode >= 300 {
-		return fmt.Errorf("unexpected response status: %s", resp.Status)
+	span.SetHTTPStatus(resp.StatusCode)
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, nil, nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read db response: %w", err)
 	}
-	log.Printf("successfully loaded date %s", today)
-	return nil
+	return http.StatusOK, body, nil
 }
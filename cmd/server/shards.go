@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"net/url"
+	"sort"
+)
+
+var dbShardList = flag.String("db-shards", envOr("DB_SHARDS", ""), "comma-separated base URLs of db shards; keys are spread over them by consistent hashing instead of all going to -db-url, which only serves health checks and the self-test (defaults to $DB_SHARDS)")
+
+// shardVirtualNodes is how many points every shard gets on the ring, so keys
+// spread evenly over a handful of shards.
+const shardVirtualNodes = 100
+
+// shardRing maps keys onto db shards so that a key keeps its shard as long
+// as that shard stays in the list, and only ~1/n of keys move when a shard
+// is added or removed.
+type shardRing struct {
+	points []uint32
+	owners map[uint32]string
+}
+
+// dbShards routes the keys of the db helpers; nil sends every key to the
+// db URL they are given.
+var dbShards *shardRing
+
+func newShardRing(shards []string) *shardRing {
+	ring := &shardRing{owners: make(map[uint32]string, len(shards)*shardVirtualNodes)}
+	for _, shard := range shards {
+		for i := 0; i < shardVirtualNodes; i++ {
+			point := hashKey(fmt.Sprintf("%s#%d", shard, i))
+			if _, taken := ring.owners[point]; taken {
+				continue
+			}
+			ring.owners[point] = shard
+			ring.points = append(ring.points, point)
+		}
+	}
+	sort.Slice(ring.points, func(i, j int) bool { return ring.points[i] < ring.points[j] })
+	return ring
+}
+
+// shardFor returns the base URL of the shard that holds key.
+func (ring *shardRing) shardFor(key string) string {
+	h := hashKey(key)
+	i := sort.Search(len(ring.points), func(i int) bool { return ring.points[i] >= h })
+	if i == len(ring.points) {
+		i = 0
+	}
+	return ring.owners[ring.points[i]]
+}
+
+// hashKey is FNV-1a followed by the murmur3 finalizer, the hash the
+// balancer's ring uses: plain FNV spreads short, similar strings poorly.
+func hashKey(s string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(s))
+	x := h.Sum32()
+	x ^= x >> 16
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	x *= 0xc2b2ae35
+	x ^= x >> 16
+	return x
+}
+
+// dbKeyURL returns the URL of key in the db: on its shard if the db is
+// sharded, at dbURL otherwise.
+func dbKeyURL(dbURL, key string) (string, error) {
+	if dbShards != nil {
+		dbURL = dbShards.shardFor(key)
+	}
+	return url.JoinPath(dbURL, "db", key)
+}
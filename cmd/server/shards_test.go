@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestShardRing(t *testing.T) {
+	shards := []string{"http://db1:8080", "http://db2:8080", "http://db3:8080"}
+	ring := newShardRing(shards)
+
+	counts := make(map[string]int)
+	owners := make(map[string]string)
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		owners[key] = ring.shardFor(key)
+		counts[owners[key]]++
+	}
+	for _, shard := range shards {
+		if counts[shard] < 600 {
+			t.Errorf("shard %s got only %d of 3000 keys", shard, counts[shard])
+		}
+	}
+
+	// Adding a shard only moves keys onto it.
+	grown := newShardRing(append(shards, "http://db4:8080"))
+	for key, owner := range owners {
+		if got := grown.shardFor(key); got != owner && got != "http://db4:8080" {
+			t.Fatalf("key %s moved from %s to %s", key, owner, got)
+		}
+	}
+}
+
+func TestDbKeyURL(t *testing.T) {
+	defer func() { dbShards = nil }()
+	if got, _ := dbKeyURL("http://db:8080", "k"); got != "http://db:8080/db/k" {
+		t.Errorf("unsharded url = %s", got)
+	}
+	dbShards = newShardRing([]string{"http://shard:8080"})
+	if got, _ := dbKeyURL("http://db:8080", "k"); got != "http://shard:8080/db/k" {
+		t.Errorf("sharded url = %s", got)
+	}
+}
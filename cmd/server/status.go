@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// serverStatus collects what GET /status reports. Unlike /health it always
+// answers 200 and is meant for people, not orchestrators.
+type serverStatus struct {
+	servedBy string
+	started  time.Time
+	probe    *dbProbe
+	cache    *responseCache
+	flags    *flag.FlagSet
+}
+
+type statusReport struct {
+	ServedBy string            `json:"served_by"`
+	Uptime   string            `json:"uptime"`
+	Db       dbStatus          `json:"db"`
+	Cache    cacheStatus       `json:"cache"`
+	Health   healthStatus      `json:"health"`
+	Config   map[string]string `json:"config"`
+}
+
+type dbStatus struct {
+	URL       string `json:"url"`
+	Reachable bool   `json:"reachable"`
+	Error     string `json:"error,omitempty"`
+}
+
+type cacheStatus struct {
+	Entries  int   `json:"entries"`
+	Stale    int   `json:"stale"`
+	Hits     int64 `json:"hits"`
+	Misses   int64 `json:"misses"`
+	Bypassed int64 `json:"bypassed"`
+}
+
+type healthStatus struct {
+	Mode          string `json:"mode"`
+	ForcedFailure bool   `json:"forced_failure"`
+}
+
+func (s *serverStatus) report() statusReport {
+	r := statusReport{
+		ServedBy: s.servedBy,
+		Uptime:   time.Since(s.started).Round(time.Second).String(),
+		Db:       dbStatus{URL: s.probe.dbURL, Reachable: true},
+		Cache: cacheStatus{
+			Entries:  s.cache.Len(),
+			Stale:    s.cache.Stale(),
+			Hits:     s.cache.hits.Load(),
+			Misses:   s.cache.misses.Load(),
+			Bypassed: s.cache.bypassed.Load(),
+		},
+		Health: healthStatus{
+			Mode:          *healthMode,
+			ForcedFailure: os.Getenv(confHealthFailure) == "true" || healthFailure.Load(),
+		},
+		Config: make(map[string]string),
+	}
+	if err := s.probe.Check(); err != nil {
+		r.Db.Reachable = false
+		r.Db.Error = err.Error()
+	}
+	s.flags.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if strings.Contains(f.Name, "token") && value != "" {
+			value = "redacted"
+		}
+		r.Config[f.Name] = value
+	})
+	return r
+}
+
+func (s *serverStatus) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(rw, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rw.Header().Set("content-type", "application/json")
+	_ = json.NewEncoder(rw).Encode(s.report())
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServerStatus(t *testing.T) {
+	db := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	t.Cleanup(db.Close)
+
+	flags := flag.NewFlagSet("server", flag.ContinueOnError)
+	flags.String("db-url", db.URL, "")
+	flags.String("db-token", "secret", "")
+	cache := newResponseCache()
+	cache.Set("a", "string", []byte(`"1"`))
+	cache.Set("b", "string", []byte(`"2"`))
+	cache.MarkStale("b", "string")
+	s := &serverStatus{
+		servedBy: "server1",
+		started:  time.Now(),
+		probe:    newDbProbe(db.URL, 0, time.Second),
+		cache:    cache,
+		flags:    flags,
+	}
+
+	get := func() statusReport {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		s.ServeHTTP(rr, httptest.NewRequest("GET", "/status", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", rr.Code)
+		}
+		var r statusReport
+		if err := json.NewDecoder(rr.Body).Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	r := get()
+	if r.ServedBy != "server1" || !r.Db.Reachable || r.Db.URL != db.URL {
+		t.Errorf("unexpected status %+v", r)
+	}
+	if r.Cache != (cacheStatus{Entries: 2, Stale: 1}) {
+		t.Errorf("unexpected cache status %+v", r.Cache)
+	}
+	if r.Config["db-url"] != db.URL || r.Config["db-token"] != "redacted" {
+		t.Errorf("unexpected config %v", r.Config)
+	}
+
+	db.Close()
+	if r := get(); r.Db.Reachable || r.Db.Error == "" {
+		t.Errorf("expected the stopped db to be reported unreachable, got %+v", r.Db)
+	}
+}
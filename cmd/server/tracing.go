@@ -0,0 +1,72 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/internal/telemetry"
+)
+
+// tracer exports the spans of the server to OpenTelemetry; nil, unless the
+// OTEL_* environment configures an exporter.
+var tracer *telemetry.Tracer
+
+// traceHeaders identify a request across the balancer, the server and the db
+// service.
+var traceHeaders = []string{"X-Request-ID", "Traceparent", "Tracestate"}
+
+func copyTraceHeaders(dst, src http.Header) {
+	for _, name := range traceHeaders {
+		if value := src.Get(name); value != "" {
+			dst.Set(name, value)
+		}
+	}
+}
+
+// logPrefix tags log lines with the request ID, if there is one.
+func logPrefix(r *http.Request) string {
+	if id := r.Header.Get("X-Request-ID"); id != "" {
+		return "[" + id + "] "
+	}
+	return ""
+}
+
+// withRequestLog logs every request that carries a request ID, so the server
+// has a line for each hop of a traced request, as the balancer and the db
+// service do.
+func withRequestLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if id := r.Header.Get("X-Request-ID"); id != "" {
+			log.Printf("[%s] %s %s", id, r.Method, r.URL.Path)
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// Values of the x-cache header.
+const (
+	cacheHit   = "hit"
+	cacheMiss  = "miss"
+	cacheStale = "stale"
+)
+
+// traced reports whether the balancer runs in trace mode, which tags every
+// forwarded request with an X-Request-ID.
+func traced(r *http.Request) bool {
+	return r.Header.Get("X-Request-ID") != ""
+}
+
+// setCacheHeaders attributes the latency of a traced response: x-cache tells
+// whether it came from the response cache and, on a miss, x-db-latency-ms
+// how long the db took.
+func setCacheHeaders(h http.Header, r *http.Request, cache string, dbLatency time.Duration) {
+	if !traced(r) {
+		return
+	}
+	h.Set("x-cache", cache)
+	if cache == cacheMiss {
+		h.Set("x-db-latency-ms", strconv.FormatFloat(float64(dbLatency.Microseconds())/1000, 'f', 3, 64))
+	}
+}
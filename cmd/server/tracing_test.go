@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCopyTraceHeaders(t *testing.T) {
+	src := http.Header{}
+	src.Set("X-Request-ID", "req-1")
+	src.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	src.Set("Authorization", "secret")
+
+	dst := http.Header{}
+	copyTraceHeaders(dst, src)
+	if dst.Get("X-Request-ID") != "req-1" || dst.Get("Traceparent") != src.Get("Traceparent") {
+		t.Errorf("trace headers not copied: %v", dst)
+	}
+	if dst.Get("Authorization") != "" {
+		t.Error("unrelated headers must not be copied")
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	if p := logPrefix(r); p != "" {
+		t.Errorf("logPrefix without request ID = %q", p)
+	}
+	r.Header.Set("X-Request-ID", "req-1")
+	if p := logPrefix(r); p != "[req-1] " {
+		t.Errorf("logPrefix = %q", p)
+	}
+}
+
+func TestWithRequestLog(t *testing.T) {
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+	handler := withRequestLog(http.NotFoundHandler())
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/some-data", nil))
+	if out.Len() != 0 {
+		t.Errorf("requests without an ID must not be logged: %q", out.String())
+	}
+	r := httptest.NewRequest("GET", "/api/v1/some-data?key=a", nil)
+	r.Header.Set("X-Request-ID", "req-1")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if !strings.HasSuffix(out.String(), "[req-1] GET /api/v1/some-data\n") {
+		t.Errorf("unexpected log %q", out.String())
+	}
+}
+
+func TestSetCacheHeaders(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/v1/some-data?key=a", nil)
+	h := http.Header{}
+	setCacheHeaders(h, r, cacheMiss, time.Millisecond)
+	if len(h) != 0 {
+		t.Errorf("untraced requests must not get cache headers, got %v", h)
+	}
+
+	r.Header.Set("X-Request-ID", "req-1")
+	setCacheHeaders(h, r, cacheMiss, 1500*time.Microsecond)
+	if h.Get("x-cache") != "miss" || h.Get("x-db-latency-ms") != "1.500" {
+		t.Errorf("unexpected miss headers: %v", h)
+	}
+
+	h = http.Header{}
+	setCacheHeaders(h, r, cacheStale, 0)
+	if h.Get("x-cache") != "stale" || h.Get("x-db-latency-ms") != "" {
+		t.Errorf("a cached response must not report db latency: %v", h)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	warmKeys    = flag.String("warm-keys", "", "comma-separated keys to prefetch from the db into the response cache at startup, as key or key:type")
+	warmRefresh = flag.Duration("warm-refresh", 30*time.Second, "how often to refetch the warmed keys (0 fetches them only once)")
+)
+
+// parseWarmKeys parses the -warm-keys list; the type defaults to "string".
+func parseWarmKeys(list string) []cacheKey {
+	var keys []cacheKey
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		key, typ, _ := strings.Cut(item, ":")
+		if typ == "" {
+			typ = "string"
+		}
+		keys = append(keys, cacheKey{key, typ})
+	}
+	return keys
+}
+
+// warmCache fetches every key from the db and stores the values in the cache.
+// Keys the db no longer has are dropped; other failures leave the cached
+// value in place, marked stale, so a flaky db does not empty the cache.
+func warmCache(ctx context.Context, cache *responseCache, dbURL string, keys []cacheKey) {
+	for _, k := range keys {
+		status, body, err := fetchFromDb(ctx, dbURL, k.key, k.typ, nil)
+		switch {
+		case err != nil:
+			log.Printf("cache warming: %s: %v", k.key, err)
+			cache.MarkStale(k.key, k.typ)
+		case status == http.StatusOK:
+			cache.Set(k.key, k.typ, body)
+		case status == http.StatusNotFound:
+			cache.Delete(k.key, k.typ)
+		default:
+			log.Printf("cache warming: %s: db answered %d", k.key, status)
+			cache.MarkStale(k.key, k.typ)
+		}
+	}
+}
+
+// startWarming warms the cache in the background and refreshes it every
+// interval until stop is closed.
+func startWarming(cache *responseCache, dbURL string, keys []cacheKey, interval time.Duration, stop <-chan struct{}) {
+	if len(keys) == 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+	go func() {
+		warmCache(ctx, cache, dbURL, keys)
+		log.Printf("cache warming: %d of %d keys cached", cache.Len(), len(keys))
+		if interval <= 0 {
+			return
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				warmCache(ctx, cache, dbURL, keys)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestParseWarmKeys(t *testing.T) {
+	got := parseWarmKeys(" a, b:int64,,c: ")
+	want := []cacheKey{{"a", "string"}, {"b", "int64"}, {"c", "string"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseWarmKeys() = %v, want %v", got, want)
+	}
+	if keys := parseWarmKeys(""); len(keys) != 0 {
+		t.Errorf("expected no keys for an empty list, got %v", keys)
+	}
+}
+
+func TestWarmCache(t *testing.T) {
+	var mu sync.Mutex
+	values := map[string]string{"/db/hot": `{"key":"hot","value":"1"}`}
+	failing := false
+	db := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if failing {
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		value, ok := values[r.URL.Path]
+		if !ok {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = rw.Write([]byte(value))
+	}))
+	defer db.Close()
+
+	cache := newResponseCache()
+	keys := []cacheKey{{"hot", "string"}, {"cold", "string"}}
+	warmCache(context.Background(), cache, db.URL, keys)
+	if body, stale, ok := cache.Get("hot", "string"); !ok || stale || string(body) != values["/db/hot"] {
+		t.Errorf("expected the hot key to be cached, got %q, stale %t, %t", body, stale, ok)
+	}
+	if _, _, ok := cache.Get("cold", "string"); ok {
+		t.Error("a missing key must not be cached")
+	}
+
+	mu.Lock()
+	failing = true
+	mu.Unlock()
+	warmCache(context.Background(), cache, db.URL, keys)
+	if _, stale, ok := cache.Get("hot", "string"); !ok || !stale {
+		t.Errorf("a failed refresh must keep the cached value as stale, got stale %t, %t", stale, ok)
+	}
+
+	mu.Lock()
+	failing = false
+	delete(values, "/db/hot")
+	mu.Unlock()
+	warmCache(context.Background(), cache, db.URL, keys)
+	if _, _, ok := cache.Get("hot", "string"); ok {
+		t.Error("a key deleted from the db must be dropped on refresh")
+	}
+}
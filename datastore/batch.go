@@ -0,0 +1,49 @@
+package datastore
+
+import "context"
+
+// Batch collects writes that are appended to the store in one go.
+type Batch struct {
+	entries []*entry
+}
+
+func (b *Batch) Put(key, value string) {
+	b.entries = append(b.entries, &entry{key: key, value: value, valueType: StrValType})
+}
+
+func (b *Batch) PutInt64(key string, value int64) {
+	b.entries = append(b.entries, &entry{key: key, value: encodeInt64(value), valueType: Int64ValType})
+}
+
+func (b *Batch) Len() int {
+	return len(b.entries)
+}
+
+func (b *Batch) Reset() {
+	b.entries = b.entries[:0]
+}
+
+// WriteBatch appends all writes of the batch with a single write to the active
+// segment. Either every entry of the batch gets indexed or none does.
+func (db *Db) WriteBatch(b *Batch) error {
+	return db.WriteBatchContext(context.Background(), b)
+}
+
+// WriteBatchContext is WriteBatch that stops waiting once ctx is done. A
+// batch the io worker already accepted may still be applied.
+func (db *Db) WriteBatchContext(ctx context.Context, b *Batch) error {
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+	entries := make([]*entry, len(b.entries))
+	copy(entries, b.entries)
+
+	respChan := make(chan error, 1)
+	return send(ctx, db, db.batchRequests, batchRequest{
+		entries:  entries,
+		respChan: respChan,
+	}, respChan)
+}
@@ -0,0 +1,51 @@
+package datastore
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestWriteBatch(t *testing.T) {
+	tmp := t.TempDir()
+	db, err := Open(tmp, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b Batch
+	for i := 0; i < 20; i++ {
+		b.Put(fmt.Sprintf("k%d", i), fmt.Sprintf("v%d", i))
+	}
+	b.PutInt64("n", 42)
+	if err := db.WriteBatch(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func() {
+		for i := 0; i < 20; i++ {
+			got, err := db.Get(fmt.Sprintf("k%d", i))
+			if err != nil {
+				t.Fatalf("Get(k%d): %v", i, err)
+			}
+			if want := fmt.Sprintf("v%d", i); got != want {
+				t.Errorf("Get(k%d) = %q, want %q", i, got, want)
+			}
+		}
+		if n, err := db.GetInt64("n"); err != nil || n != 42 {
+			t.Errorf("GetInt64(n) = %d, %v; want 42", n, err)
+		}
+	}
+	check()
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(tmp, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	check()
+}
@@ -0,0 +1,169 @@
+package benchmarks
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+var valueSizes = []int{16, 1024, 64 * 1024}
+
+func openDb(b *testing.B, opts ...datastore.Option) *datastore.Db {
+	b.Helper()
+	db, err := datastore.OpenWithOptions(b.TempDir(), opts...)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+// fill writes keys key-0..key-(keys-1) with values of the given size,
+// rotating segments as configured by the store's segment size.
+func fill(b *testing.B, db *datastore.Db, keys, valueSize int) {
+	b.Helper()
+	value := strings.Repeat("v", valueSize)
+	for i := 0; i < keys; i++ {
+		if err := db.Put(fmt.Sprintf("key-%d", i), value); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPut(b *testing.B) {
+	for _, size := range valueSizes {
+		b.Run(fmt.Sprintf("value=%d", size), func(b *testing.B) {
+			db := openDb(b, datastore.WithSegmentSize(64*datastore.Mi))
+			value := strings.Repeat("v", size)
+			b.SetBytes(int64(size))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := db.Put(fmt.Sprintf("key-%d", i%1000), value); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkPutSync(b *testing.B) {
+	db := openDb(b, datastore.WithSegmentSize(64*datastore.Mi), datastore.WithSync(datastore.SyncAlways))
+	value := strings.Repeat("v", 1024)
+	b.SetBytes(1024)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := db.Put(fmt.Sprintf("key-%d", i%1000), value); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkPutParallel measures writes from many goroutines, as when several
+// HTTP handlers write at once. All of them queue for the single io worker, so
+// throughput should hold steady as the number of writers grows.
+func BenchmarkPutParallel(b *testing.B) {
+	for _, writers := range []int{1, 8, 64} {
+		b.Run(fmt.Sprintf("writers=%d", writers), func(b *testing.B) {
+			db := openDb(b, datastore.WithSegmentSize(64*datastore.Mi))
+			value := strings.Repeat("v", 1024)
+			var n atomic.Int64
+			b.SetBytes(1024)
+			b.SetParallelism(writers)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if err := db.Put(fmt.Sprintf("key-%d", n.Add(1)%1000), value); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkPutSyncParallel measures writes from many goroutines with
+// SyncAlways. Puts queued while one is fsynced are committed together, so
+// throughput should grow with the number of writers instead of staying at
+// one put per fsync.
+func BenchmarkPutSyncParallel(b *testing.B) {
+	for _, writers := range []int{1, 8, 64} {
+		b.Run(fmt.Sprintf("writers=%d", writers), func(b *testing.B) {
+			db := openDb(b, datastore.WithSegmentSize(64*datastore.Mi), datastore.WithSync(datastore.SyncAlways))
+			value := strings.Repeat("v", 1024)
+			var n atomic.Int64
+			b.SetBytes(1024)
+			b.SetParallelism(writers)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if err := db.Put(fmt.Sprintf("key-%d", n.Add(1)%1000), value); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+			b.StopTimer()
+			if commits := db.Stats().GroupCommits; commits > 0 {
+				b.ReportMetric(float64(b.N)/float64(commits), "puts/commit")
+			}
+		})
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	const keys = 1000
+	for _, segments := range []int{1, 10, 100} {
+		b.Run(fmt.Sprintf("segments=%d", segments), func(b *testing.B) {
+			// Every key is written once; the segment size spreads them over
+			// the requested number of segments.
+			const valueSize = 100
+			segmentSize := int64(keys/segments) * (valueSize + 30)
+			db := openDb(b, datastore.WithSegmentSize(segmentSize))
+			fill(b, db, keys, valueSize)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := db.Get(fmt.Sprintf("key-%d", i%keys)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkGetCached(b *testing.B) {
+	const keys = 1000
+	db := openDb(b, datastore.WithCacheSize(keys))
+	fill(b, db, keys, 100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := db.Get(fmt.Sprintf("key-%d", i%keys)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMerge(b *testing.B) {
+	for _, segments := range []int{2, 10} {
+		b.Run(fmt.Sprintf("segments=%d", segments), func(b *testing.B) {
+			const keys, valueSize = 500, 100
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				db, err := datastore.OpenWithOptions(b.TempDir(), datastore.WithSegmentSize(int64(keys/segments)*(valueSize+30)))
+				if err != nil {
+					b.Fatal(err)
+				}
+				fill(b, db, keys, valueSize)
+				b.StartTimer()
+				if err := db.MergeSegments(); err != nil {
+					b.Fatal(err)
+				}
+				b.StopTimer()
+				_ = db.Close()
+			}
+		})
+	}
+}
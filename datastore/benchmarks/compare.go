@@ -0,0 +1,91 @@
+package benchmarks
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Result is the mean of every run of one benchmark.
+type Result struct {
+	NsPerOp     float64
+	BytesPerOp  float64
+	AllocsPerOp float64
+	Runs        int
+}
+
+// Regression is a benchmark that got slower than the tolerance allows.
+type Regression struct {
+	Name           string
+	Baseline, Curr float64
+	// Delta is the relative change of ns/op, e.g. 0.25 for 25% slower.
+	Delta float64
+}
+
+func (r Regression) String() string {
+	return fmt.Sprintf("%s: %.0f ns/op -> %.0f ns/op (%+.1f%%)", r.Name, r.Baseline, r.Curr, r.Delta*100)
+}
+
+var procsSuffix = regexp.MustCompile(`-\d+$`)
+
+// Parse reads `go test -bench` output and averages repeated runs of each
+// benchmark. The GOMAXPROCS suffix is dropped from the names.
+func Parse(r io.Reader) (map[string]Result, error) {
+	results := make(map[string]Result)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || !strings.HasPrefix(fields[0], "Benchmark") {
+			continue
+		}
+		name := procsSuffix.ReplaceAllString(fields[0], "")
+		res := results[name]
+		for i := 2; i+1 < len(fields); i += 2 {
+			value, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("benchmark %s: bad value %q: %w", name, fields[i], err)
+			}
+			switch fields[i+1] {
+			case "ns/op":
+				res.NsPerOp += value
+			case "B/op":
+				res.BytesPerOp += value
+			case "allocs/op":
+				res.AllocsPerOp += value
+			}
+		}
+		res.Runs++
+		results[name] = res
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for name, res := range results {
+		n := float64(res.Runs)
+		res.NsPerOp, res.BytesPerOp, res.AllocsPerOp = res.NsPerOp/n, res.BytesPerOp/n, res.AllocsPerOp/n
+		results[name] = res
+	}
+	return results, nil
+}
+
+// Compare returns the benchmarks present in both runs whose ns/op grew by
+// more than tolerance (0.2 means 20%), sorted by name.
+func Compare(baseline, current map[string]Result, tolerance float64) []Regression {
+	var regressions []Regression
+	for name, cur := range current {
+		base, ok := baseline[name]
+		if !ok || base.NsPerOp == 0 {
+			continue
+		}
+		delta := cur.NsPerOp/base.NsPerOp - 1
+		if delta > tolerance {
+			regressions = append(regressions, Regression{Name: name, Baseline: base.NsPerOp, Curr: cur.NsPerOp, Delta: delta})
+		}
+	}
+	sort.Slice(regressions, func(i, j int) bool { return regressions[i].Name < regressions[j].Name })
+	return regressions
+}
@@ -0,0 +1,61 @@
+package benchmarks
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	out := `goos: linux
+BenchmarkPut/value=16-8   	  595453	      1800 ns/op	   8.59 MB/s	     294 B/op	       6 allocs/op
+BenchmarkPut/value=16-8   	  633908	      2000 ns/op	   9.24 MB/s	     294 B/op	       6 allocs/op
+BenchmarkGetCached        	 8675434	       132.5 ns/op
+PASS
+`
+	results, err := Parse(strings.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	put := results["BenchmarkPut/value=16"]
+	if put.Runs != 2 || put.NsPerOp != 1900 || put.BytesPerOp != 294 || put.AllocsPerOp != 6 {
+		t.Errorf("unexpected result for Put: %+v", put)
+	}
+	if got := results["BenchmarkGetCached"].NsPerOp; got != 132.5 {
+		t.Errorf("unexpected ns/op for GetCached: %v", got)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	baseline := map[string]Result{
+		"BenchmarkA": {NsPerOp: 100},
+		"BenchmarkB": {NsPerOp: 100},
+		"BenchmarkC": {NsPerOp: 100},
+	}
+	current := map[string]Result{
+		"BenchmarkA": {NsPerOp: 150},
+		"BenchmarkB": {NsPerOp: 110},
+		"BenchmarkD": {NsPerOp: 1000},
+	}
+	regressions := Compare(baseline, current, 0.2)
+	if len(regressions) != 1 || regressions[0].Name != "BenchmarkA" || regressions[0].Delta != 0.5 {
+		t.Errorf("unexpected regressions: %v", regressions)
+	}
+}
+
+func TestBaselineParses(t *testing.T) {
+	f, err := os.Open("testdata/baseline.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	results, err := Parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"BenchmarkPut/value=1024", "BenchmarkGet/segments=100", "BenchmarkMerge/segments=10"} {
+		if results[name].Runs == 0 {
+			t.Errorf("baseline has no %s", name)
+		}
+	}
+}
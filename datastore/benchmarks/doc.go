@@ -0,0 +1,18 @@
+// Package benchmarks measures the datastore write, read and compaction paths
+// and compares benchmark runs against a recorded baseline.
+//
+// Run the suite with
+//
+//	go test -run '^$' -bench . -benchmem -count 3 ./datastore/benchmarks/ > new.txt
+//
+// and check it for regressions with
+//
+//	go run ./cmd/benchcmp -baseline datastore/benchmarks/testdata/baseline.txt new.txt
+//
+// testdata/baseline.txt was recorded with go1.27.1 on linux/amd64, on a
+// machine with one CPU (nproc 1) reported as "Intel(R) Xeon(R) Processor",
+// with the temporary directory on ext4. Numbers from other machines are
+// only comparable to a baseline recorded there. Refresh it with the first
+// command whenever a change is meant to move the numbers, and record the
+// environment here.
+package benchmarks
@@ -0,0 +1,76 @@
+package datastore
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// bloomBitsPerKey is the default Options.BloomBitsPerKey, about a 1% false
+// positive rate.
+const bloomBitsPerKey = 10
+
+// bloomFilter answers "definitely not present" for keys of a segment, so Get
+// can skip the segment index for misses.
+type bloomFilter struct {
+	bits []uint64
+	m    uint64
+	k    uint64
+}
+
+// newBloomFilter sizes a filter for expectedKeys at bitsPerKey and probes
+// the number of bits that minimises false positives for that size.
+func newBloomFilter(expectedKeys, bitsPerKey int) *bloomFilter {
+	m := uint64(expectedKeys * bitsPerKey)
+	if m < 64 {
+		m = 64
+	}
+	k := uint64(math.Round(float64(bitsPerKey) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &bloomFilter{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+// buildBloomFilter returns nil if bitsPerKey disables the filters.
+func buildBloomFilter(index hashIndex, bitsPerKey int) *bloomFilter {
+	if bitsPerKey <= 0 {
+		return nil
+	}
+	f := newBloomFilter(len(index), bitsPerKey)
+	for key := range index {
+		f.Add(key)
+	}
+	return f
+}
+
+// hashes uses double hashing of a single 64-bit FNV hash to derive all probe
+// positions (Kirsch–Mitzenmacher).
+func (f *bloomFilter) hashes(key string) (uint64, uint64) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	sum := h.Sum64()
+	return sum & 0xffffffff, sum>>32 | 1
+}
+
+func (f *bloomFilter) Add(key string) {
+	h1, h2 := f.hashes(key)
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+func (f *bloomFilter) MayContain(key string) bool {
+	h1, h2 := f.hashes(key)
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,85 @@
+package datastore
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestBloomFilter(t *testing.T) {
+	const n = 1000
+	index := make(hashIndex)
+	for i := 0; i < n; i++ {
+		index[fmt.Sprintf("key-%d", i)] = int64(i)
+	}
+	f := buildBloomFilter(index, bloomBitsPerKey)
+
+	for key := range index {
+		if !f.MayContain(key) {
+			t.Fatalf("false negative for %q", key)
+		}
+	}
+
+	falsePositives := 0
+	for i := 0; i < n; i++ {
+		if f.MayContain(fmt.Sprintf("missing-%d", i)) {
+			falsePositives++
+		}
+	}
+	if falsePositives > n/20 {
+		t.Errorf("too many false positives: %d of %d", falsePositives, n)
+	}
+}
+
+func TestDb_SealedSegmentFilters(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		options []Option
+		filters bool
+	}{
+		{"default", nil, true},
+		{"disabled", []Option{WithBloomFilter(0)}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, err := OpenWithOptions(t.TempDir(), append([]Option{WithSegmentSize(60)}, tc.options...)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() {
+				_ = db.Close()
+			})
+
+			for i := 0; i < 10; i++ {
+				if err := db.Put(fmt.Sprintf("k%d", i), "value"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			db.segmentsMutex.RLock()
+			segments := db.segments
+			db.segmentsMutex.RUnlock()
+			for _, segment := range segments[:len(segments)-1] {
+				if (segment.filter != nil) != tc.filters {
+					t.Errorf("sealed segment %s: has bloom filter %t, want %t", segment.filePath, segment.filter != nil, tc.filters)
+				}
+			}
+			if err := db.MergeSegments(); err != nil {
+				t.Fatal(err)
+			}
+			db.segmentsMutex.RLock()
+			merged := db.segments[0]
+			db.segmentsMutex.RUnlock()
+			if (merged.filter != nil) != tc.filters {
+				t.Errorf("merged segment: has bloom filter %t, want %t", merged.filter != nil, tc.filters)
+			}
+
+			for i := 0; i < 10; i++ {
+				if _, err := db.Get(fmt.Sprintf("k%d", i)); err != nil {
+					t.Errorf("Get(k%d): %v", i, err)
+				}
+			}
+			if _, err := db.Get("absent"); err != ErrNotFound {
+				t.Errorf("expected ErrNotFound for absent key, got %v", err)
+			}
+		})
+	}
+}
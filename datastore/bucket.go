@@ -0,0 +1,147 @@
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// bucketMarker starts the stored keys of buckets and separates the bucket
+// name from the key. Keys outside buckets must not start with it.
+const bucketMarker = "\x00"
+
+// ErrInvalidBucket is returned by the operations of a Bucket whose name is
+// empty or holds a NUL byte or a slash.
+var ErrInvalidBucket = errors.New("invalid bucket name")
+
+// Bucket is a named keyspace within a Db, so that one store can serve
+// several logical datasets without their keys clashing. The records of a
+// bucket are stored under keys prefixed with the bucket name (see
+// BucketKey), so they share the segments, merges and replication stream of
+// the Db while lookups and scans of one bucket only see its own keys.
+//
+// Buckets have no index of their own: their keys are in the per-segment
+// indexes of the Db like any other, so a lookup costs the same in and out
+// of buckets, while listing a bucket walks the index entries of every key
+// in the Db.
+type Bucket struct {
+	db   *Db
+	name string
+	// prefix is the stored key prefix of the bucket.
+	prefix string
+	err    error
+}
+
+// Bucket returns the bucket with the given name. Buckets need not be
+// created: a bucket exists while it holds keys.
+func (db *Db) Bucket(name string) *Bucket {
+	b := &Bucket{db: db, name: name, prefix: bucketPrefix(name)}
+	if err := validateBucket(name); err != nil {
+		b.err = err
+	}
+	return b
+}
+
+func validateBucket(name string) error {
+	if name == "" || strings.ContainsAny(name, bucketMarker+"/") {
+		return fmt.Errorf("%w: %q", ErrInvalidBucket, name)
+	}
+	return nil
+}
+
+func bucketPrefix(name string) string {
+	return bucketMarker + name + bucketMarker
+}
+
+// BucketKey returns the key the Db stores key of bucket under, for callers
+// that need the Db methods Bucket does not wrap, such as versioned writes.
+func BucketKey(bucket, key string) (string, error) {
+	if err := validateBucket(bucket); err != nil {
+		return "", err
+	}
+	return bucketPrefix(bucket) + key, nil
+}
+
+// SplitBucketKey is the inverse of BucketKey. Keys outside buckets have the
+// bucket "".
+func SplitBucketKey(stored string) (bucket, key string) {
+	rest, ok := strings.CutPrefix(stored, bucketMarker)
+	if !ok {
+		return "", stored
+	}
+	bucket, key, _ = strings.Cut(rest, bucketMarker)
+	return bucket, key
+}
+
+// Name returns the name of the bucket.
+func (b *Bucket) Name() string {
+	return b.name
+}
+
+// Get is Db.Get within the bucket.
+func (b *Bucket) Get(key string) (string, error) {
+	if b.err != nil {
+		return "", b.err
+	}
+	return b.db.Get(b.prefix + key)
+}
+
+// Put is Db.Put within the bucket.
+func (b *Bucket) Put(key, value string) error {
+	if b.err != nil {
+		return b.err
+	}
+	return b.db.Put(b.prefix+key, value)
+}
+
+// Delete is Db.Delete within the bucket.
+func (b *Bucket) Delete(key string) error {
+	if b.err != nil {
+		return b.err
+	}
+	return b.db.Delete(b.prefix + key)
+}
+
+// GetInt64 is Db.GetInt64 within the bucket.
+func (b *Bucket) GetInt64(key string) (int64, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.db.GetInt64(b.prefix + key)
+}
+
+// PutInt64 is Db.PutInt64 within the bucket.
+func (b *Bucket) PutInt64(key string, value int64) error {
+	if b.err != nil {
+		return b.err
+	}
+	return b.db.PutInt64(b.prefix+key, value)
+}
+
+// Scan is Db.Scan over the keys of the bucket; start, end and the keys
+// passed to fn are without the bucket prefix.
+func (b *Bucket) Scan(start, end string, fn func(key, value string) error) error {
+	if b.err != nil {
+		return b.err
+	}
+	stored := PrefixEnd(b.prefix)
+	if end != "" {
+		stored = b.prefix + end
+	}
+	return b.db.scan(b.prefix+start, stored, func(key, value string) error {
+		return fn(strings.TrimPrefix(key, b.prefix), value)
+	})
+}
+
+// Buckets returns the sorted names of the buckets the store has records of,
+// including buckets whose keys were all deleted since the last merge.
+func (db *Db) Buckets() []string {
+	var names []string
+	for _, key := range db.keysInRange(bucketMarker, PrefixEnd(bucketMarker)) {
+		name, _ := SplitBucketKey(key)
+		if len(names) == 0 || names[len(names)-1] != name {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,85 @@
+package datastore
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestBucket(t *testing.T) {
+	db, err := Open(t.TempDir(), Mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	users, orders := db.Bucket("users"), db.Bucket("orders")
+
+	if err := users.Put("42", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := orders.PutInt64("42", 7); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("42", "plain"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := users.Get("42"); err != nil || v != "alice" {
+		t.Errorf("users.Get(42) = %q, %v", v, err)
+	}
+	if v, err := orders.GetInt64("42"); err != nil || v != 7 {
+		t.Errorf("orders.GetInt64(42) = %d, %v", v, err)
+	}
+	if v, err := db.Get("42"); err != nil || v != "plain" {
+		t.Errorf("Get(42) = %q, %v", v, err)
+	}
+	if _, err := db.Bucket("carts").Get("42"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get from an empty bucket: expected ErrNotFound, got %v", err)
+	}
+
+	if err := users.Put("43", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Delete("42"); err != nil {
+		t.Fatal(err)
+	}
+	var scanned [][2]string
+	err = users.Scan("", "", func(key, value string) error {
+		scanned = append(scanned, [2]string{key, value})
+		return nil
+	})
+	if want := [][2]string{{"43", "bob"}}; err != nil || !reflect.DeepEqual(scanned, want) {
+		t.Errorf("users.Scan: got %v, %v; want %v", scanned, err, want)
+	}
+	scanned = nil
+	_ = db.Scan("", "", func(key, value string) error {
+		scanned = append(scanned, [2]string{key, value})
+		return nil
+	})
+	if want := [][2]string{{"42", "plain"}}; !reflect.DeepEqual(scanned, want) {
+		t.Errorf("Db.Scan must leave bucket keys out: got %v", scanned)
+	}
+	if got, want := db.Buckets(), []string{"orders", "users"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Buckets() = %v, want %v", got, want)
+	}
+
+	for _, name := range []string{"", "a/b", "a\x00b"} {
+		if err := db.Bucket(name).Put("k", "v"); !errors.Is(err, ErrInvalidBucket) {
+			t.Errorf("bucket %q: expected ErrInvalidBucket, got %v", name, err)
+		}
+	}
+}
+
+func TestBucketKey(t *testing.T) {
+	stored, err := BucketKey("users", "42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bucket, key := SplitBucketKey(stored); bucket != "users" || key != "42" {
+		t.Errorf("SplitBucketKey(%q) = %q, %q", stored, bucket, key)
+	}
+	if bucket, key := SplitBucketKey("42"); bucket != "" || key != "42" {
+		t.Errorf("SplitBucketKey(42) = %q, %q", bucket, key)
+	}
+}
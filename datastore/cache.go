@@ -0,0 +1,78 @@
+package datastore
+
+import (
+	"container/list"
+	"sync"
+)
+
+// valueCache is an LRU cache of the latest entries read from the segments.
+// The io worker invalidates keys as it writes them; the generation counter
+// keeps a reader that raced with a write from caching the value it read
+// before the write.
+type valueCache struct {
+	mu         sync.Mutex
+	size       int
+	generation uint64
+	order      *list.List
+	items      map[string]*list.Element
+}
+
+func newValueCache(size int) *valueCache {
+	if size <= 0 {
+		return nil
+	}
+	return &valueCache{size: size, order: list.New(), items: make(map[string]*list.Element)}
+}
+
+// get returns the cached entry of key and the current generation. Cached
+// entries are shared and must not be modified.
+func (c *valueCache) get(key string) (*entry, uint64) {
+	if c == nil {
+		return nil, 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.order.MoveToFront(el)
+		return el.Value.(*entry), c.generation
+	}
+	return nil, c.generation
+}
+
+// add caches an entry read while the cache was at the given generation.
+func (c *valueCache) add(e *entry, generation uint64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return
+	}
+	if el, ok := c.items[e.key]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[e.key] = c.order.PushFront(e)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*entry).key)
+	}
+}
+
+func (c *valueCache) invalidate(keys []string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	for _, key := range keys {
+		if el, ok := c.items[key]; ok {
+			c.order.Remove(el)
+			delete(c.items, key)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package datastore
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+)
+
+// compressedFlag is set in the value type of records whose value is flate
+// compressed.
+const compressedFlag byte = 0x80
+
+// compress replaces a large enough string value with its compressed form
+// when that saves space.
+func (e *entry) compress(minSize int) {
+	if minSize <= 0 || e.valueType != StrValType || len(e.value) < minSize {
+		return
+	}
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+	_, _ = io.WriteString(w, e.value)
+	if err := w.Close(); err != nil || buf.Len() >= len(e.value) {
+		return
+	}
+	e.value = buf.String()
+	e.valueType |= compressedFlag
+}
+
+func (e *entry) decompress() error {
+	if e.valueType&compressedFlag == 0 {
+		return nil
+	}
+	value, err := io.ReadAll(flate.NewReader(bytes.NewReader([]byte(e.value))))
+	if err != nil {
+		return fmt.Errorf("%w: cannot decompress value of key %q: %w", ErrCorrupted, e.key, err)
+	}
+	e.value = string(value)
+	e.valueType &^= compressedFlag
+	return nil
+}
@@ -1,15 +1,25 @@
+// Package datastore is an embeddable, log-structured key-value store: writes
+// are appended to segment files, an in-memory hash index per segment points
+// at the latest record of every key, and merges reclaim the space of
+// overwritten and deleted keys. Store is its stable API.
 package datastore
 
 import (
 	"bufio"
-	"encoding/binary"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -17,8 +27,6 @@ const (
 	Mi          = int64(1024 * 1024)
 )
 
-var ErrNotFound = fmt.Errorf("record does not exist")
-
 type hashIndex map[string]int64
 
 type putRequest struct {
@@ -32,48 +40,224 @@ type mergeRequest struct {
 	respChan chan error
 }
 
+type batchRequest struct {
+	entries []*entry
+	// replicated entries come from a primary and bypass the quota.
+	replicated bool
+	respChan   chan error
+}
+
+type pingRequest struct {
+	respChan chan error
+}
+
+type syncRequest struct {
+	respChan chan error
+}
+
+type updateRequest struct {
+	key      string
+	update   func(current *entry, err error) (*entry, error)
+	respChan chan error
+}
+
+// Db is a store opened in a directory; see Open and OpenWithOptions. It is
+// safe for concurrent use.
 type Db struct {
 	dir           string
 	segmentSize   int64
-	segments      []*Segment
-	activeSegment *Segment
+	opts          Options
+	cache         *valueCache
+	segments      []*segment
+	activeSegment *segment
+	// nextSegmentID is never reused, so names stay unique across merges.
+	nextSegmentID int64
+	// startup is what recover found in the directory.
+	startup StartupReport
+	// lastVersion is the version of the latest entry written; only the io
+	// worker touches it after recovery.
+	lastVersion uint64
 
 	segmentsMutex sync.RWMutex
 
-	putRequests   chan putRequest
-	mergeRequests chan mergeRequest
-	shutdown      chan struct{}
-	wg            sync.WaitGroup
+	putRequests     chan putRequest
+	batchRequests   chan batchRequest
+	updateRequests  chan updateRequest
+	mergeRequests   chan mergeRequest
+	pingRequests    chan pingRequest
+	syncRequests    chan syncRequest
+	streamRequests  chan streamRequest
+	purgeRequests   chan purgeRequest
+	compactRequests chan compactRequest
+	// stopped is closed when the io worker returns.
+	stopped   chan struct{}
+	closeOnce sync.Once
+	tasks     *lifecycle
+
+	subsMu sync.Mutex
+	subs   map[chan []byte]struct{}
+
+	readOnly atomic.Bool
+	quota    atomic.Int64
+	// bucketQuotas maps bucket names to their quotas; see Bucket.SetQuota.
+	bucketQuotas sync.Map
+	// diskFull is set by the disk watchdog while free space is critically
+	// low.
+	diskFull  atomic.Bool
+	freeSpace func(dir string) (uint64, error)
+
+	stats dbStats
 }
 
-type Segment struct {
+type segment struct {
+	id       int64
 	file     *os.File
 	filePath string
 	offset   int64
 	index    hashIndex
-	idxMu    sync.RWMutex
+	// filter is built when the segment is sealed or produced by a merge,
+	// unless Options.BloomBitsPerKey disables it; segments without one fall
+	// back to the index lookup.
+	filter *bloomFilter
+	idxMu  sync.RWMutex
+	// maxVersion is the highest entry version seen by scan; segments loaded
+	// from hints leave it zero.
+	maxVersion uint64
+	// gen counts rewrites by PurgeTombstones; a rewritten segment keeps its
+	// ID and so its place among the others.
+	gen int64
+	// records and tombstones count the entries in the file and deadKeys
+	// holds the keys whose latest entry here is a tombstone. Segments
+	// loaded from hints are not counted until Garbage needs them.
+	records, tombstones int64
+	deadKeys            map[string]struct{}
+	counted             bool
+	// refs counts the references to the segment files; see acquire.
+	refs atomic.Int64
+}
+
+func (s *segment) seal(bloomBitsPerKey int) {
+	s.idxMu.Lock()
+	s.filter = buildBloomFilter(s.index, bloomBitsPerKey)
+	s.idxMu.Unlock()
+}
+
+func (s *segment) lookup(key string) (int64, bool) {
+	s.idxMu.RLock()
+	defer s.idxMu.RUnlock()
+	if s.filter != nil && !s.filter.MayContain(key) {
+		return 0, false
+	}
+	offset, ok := s.index[key]
+	return offset, ok
 }
 
-func newSegment(dir string, id int) (*Segment, error) {
-	name := fmt.Sprintf("%s-%d", outFileName, id)
-	path := filepath.Join(dir, name)
-	return &Segment{
-		filePath: path,
+func newSegment(dir string, id int64) (*segment, error) {
+	s := &segment{
+		id:       id,
+		filePath: filepath.Join(dir, segmentFileName(id)),
 		index:    make(hashIndex),
-	}, nil
+		deadKeys: make(map[string]struct{}),
+		counted:  true,
+	}
+	s.refs.Store(1)
+	return s, nil
+}
+
+// segmentFileName zero-pads the ID so that file names sort like the IDs.
+func segmentFileName(id int64) string {
+	return fmt.Sprintf("%s-%010d", outFileName, id)
+}
+
+// rewrittenFileName names generation gen of a segment rewritten by
+// PurgeTombstones.
+func rewrittenFileName(id, gen int64) string {
+	return fmt.Sprintf("%s.%d", segmentFileName(id), gen)
+}
+
+// parseSegmentID extracts the ID from a segment file name. Unpadded names
+// written by older versions are accepted as well.
+func parseSegmentID(name string) (int64, bool) {
+	id, _, ok := parseSegmentName(name)
+	return id, ok
+}
+
+// parseSegmentName extracts the ID and the generation from a segment file
+// name.
+func parseSegmentName(name string) (id, gen int64, ok bool) {
+	rest, ok := strings.CutPrefix(name, outFileName+"-")
+	if !ok {
+		return 0, 0, false
+	}
+	digits, genDigits, rewritten := strings.Cut(rest, ".")
+	if id, ok = parseDigits(digits); !ok {
+		return 0, 0, false
+	}
+	if rewritten {
+		if gen, ok = parseDigits(genDigits); !ok || gen == 0 {
+			return 0, 0, false
+		}
+	}
+	return id, gen, true
 }
 
+func parseDigits(digits string) (int64, bool) {
+	if digits == "" {
+		return 0, false
+	}
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+	}
+	n, err := strconv.ParseInt(digits, 10, 64)
+	return n, err == nil
+}
+
+// Open opens the store in dir with the default options and the given
+// segment size.
 func Open(dir string, segmentSize int64) (*Db, error) {
+	return OpenWithOptions(dir, WithSegmentSize(segmentSize))
+}
+
+// OpenWithOptions opens the store in dir, creating it if needed. Options not
+// given keep their DefaultOptions values.
+func OpenWithOptions(dir string, options ...Option) (*Db, error) {
+	opts := DefaultOptions
+	for _, option := range options {
+		option(&opts)
+	}
+	if opts.SegmentSize <= 0 {
+		return nil, fmt.Errorf("invalid segment size %d", opts.SegmentSize)
+	}
+
 	db := &Db{
-		dir:           dir,
-		segmentSize:   segmentSize,
-		segments:      []*Segment{},
-		putRequests:   make(chan putRequest),
-		mergeRequests: make(chan mergeRequest),
-		shutdown:      make(chan struct{}),
+		dir:             dir,
+		segmentSize:     opts.SegmentSize,
+		opts:            opts,
+		cache:           newValueCache(opts.CacheSize),
+		segments:        []*segment{},
+		putRequests:     make(chan putRequest),
+		batchRequests:   make(chan batchRequest),
+		updateRequests:  make(chan updateRequest),
+		mergeRequests:   make(chan mergeRequest),
+		pingRequests:    make(chan pingRequest),
+		syncRequests:    make(chan syncRequest),
+		streamRequests:  make(chan streamRequest),
+		purgeRequests:   make(chan purgeRequest),
+		compactRequests: make(chan compactRequest),
+		stopped:         make(chan struct{}),
+		tasks:           newLifecycle(),
+		subs:            make(map[chan []byte]struct{}),
+		freeSpace:       freeSpace,
 	}
+	db.readOnly.Store(opts.ReadOnly)
 
-	if err := os.MkdirAll(dir, 0o755); err != nil {
+	if opts.ReadOnly {
+		if _, err := os.Stat(dir); err != nil {
+			return nil, err
+		}
+	} else if err := os.MkdirAll(dir, 0o755); err != nil {
 		return nil, err
 	}
 
@@ -83,27 +267,71 @@ func Open(dir string, segmentSize int64) (*Db, error) {
 	}
 
 	if len(db.segments) == 0 {
-		segment, err := newSegment(db.dir, 0)
+		segment, err := newSegment(db.dir, db.nextSegmentID)
 		if err != nil {
 			return nil, err
 		}
+		db.nextSegmentID++
 		db.segments = append(db.segments, segment)
 		db.activeSegment = segment
 	} else {
-		sort.Slice(db.segments, func(i, j int) bool {
-			return db.segments[i].filePath < db.segments[j].filePath
-		})
 		db.activeSegment = db.segments[len(db.segments)-1]
+		for _, segment := range db.segments[:len(db.segments)-1] {
+			segment.seal(opts.BloomBitsPerKey)
+		}
 	}
 
-	db.wg.Add(1)
-	go db.ioWorker()
+	// The io worker owns the active segment, so it is not restarted after a
+	// panic: the store stops accepting writes and Ready reports it.
+	if opts.ReadOnly {
+		db.tasks.start("io-worker", false, db.readOnlyWorker)
+	} else {
+		db.tasks.start("io-worker", false, db.ioWorker)
+		if opts.DiskCheckInterval > 0 {
+			db.checkDisk()
+			db.tasks.start("disk-watchdog", true, db.watchDisk)
+		}
+		if opts.SyncInterval > 0 {
+			db.tasks.start("syncer", true, db.syncer)
+		}
+	}
 
 	return db, nil
 }
 
-func (db *Db) ioWorker() {
-	defer db.wg.Done()
+// readOnlyWorker stands in for ioWorker in stores opened with the ReadOnly
+// option: no segment is opened for writing and every write, replicated or
+// not, as well as every merge fails with ErrReadOnly.
+func (db *Db) readOnlyWorker(ctx context.Context) {
+	defer close(db.stopped)
+	// Writes are answered after rotating, so once a write returns the
+	// segment list no longer changes until the next write or merge.
+	for {
+		select {
+		case req := <-db.putRequests:
+			req.respChan <- ErrReadOnly
+		case req := <-db.batchRequests:
+			req.respChan <- ErrReadOnly
+		case req := <-db.updateRequests:
+			req.respChan <- ErrReadOnly
+		case req := <-db.mergeRequests:
+			req.respChan <- ErrReadOnly
+		case req := <-db.pingRequests:
+			req.respChan <- ErrReadOnly
+		case req := <-db.streamRequests:
+			req.respChan <- ErrReadOnly
+		case req := <-db.purgeRequests:
+			req.respChan <- ErrReadOnly
+		case req := <-db.compactRequests:
+			req.respChan <- ErrReadOnly
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (db *Db) ioWorker(ctx context.Context) {
+	defer close(db.stopped)
 
 	var err error
 
@@ -118,7 +346,9 @@ func (db *Db) ioWorker() {
 		db.activeSegment.file.Close()
 		return
 	}
+	db.activeSegment.idxMu.Lock()
 	db.activeSegment.offset = stat.Size()
+	db.activeSegment.idxMu.Unlock()
 
 	defer func() {
 		if db.activeSegment.file != nil {
@@ -129,77 +359,344 @@ func (db *Db) ioWorker() {
 	for {
 		select {
 		case req := <-db.putRequests:
-			e := entry{key: req.key, value: req.value, valueType: req.valueType}
-			encoded := e.Encode()
-			n, err := db.activeSegment.file.Write(encoded)
-			if err != nil {
-				req.respChan <- err
-				continue
+			db.groupCommit(req)
+			db.compactIfNeeded()
+
+		case req := <-db.batchRequests:
+			var err error
+			if !req.replicated {
+				err = db.checkWrite(req.entries)
+			}
+			if err == nil {
+				err = db.appendEntries(req.entries)
+			}
+			db.rotateIfNeeded()
+			req.respChan <- err
+			db.compactIfNeeded()
+
+		case req := <-db.updateRequests:
+			e, err := req.update(db.getEntry(req.key))
+			if err == nil && e != nil {
+				err = db.checkWrite([]*entry{e})
 			}
+			if err == nil && e != nil {
+				err = db.appendEntry(e)
+			}
+			db.rotateIfNeeded()
+			req.respChan <- err
+			db.compactIfNeeded()
 
-			db.activeSegment.idxMu.Lock()
-			db.activeSegment.index[req.key] = db.activeSegment.offset
-			db.activeSegment.idxMu.Unlock()
-			db.activeSegment.offset += int64(n)
+		case req := <-db.streamRequests:
+			err := db.appendStream(req)
+			db.rotateIfNeeded()
+			req.respChan <- err
+			db.compactIfNeeded()
 
-			req.respChan <- nil
-			if db.activeSegment.offset >= db.segmentSize {
-				if err := db.activeSegment.file.Close(); err != nil {
-					fmt.Fprintf(os.Stderr, "ioWorker: failed to close segment %s: %v\n", db.activeSegment.filePath, err)
-				}
-				db.activeSegment.file = nil
+		case req := <-db.mergeRequests:
+			req.respChan <- db.merge()
 
-				db.segmentsMutex.Lock()
-				newSegID := len(db.segments)
-				newActiveSegment, err := newSegment(db.dir, newSegID)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "ioWorker: failed to create new segment: %v\n", err)
-					db.segmentsMutex.Unlock()
+		case req := <-db.purgeRequests:
+			*req.purged, err = db.purge(req.threshold)
+			req.respChan <- err
 
-					continue
-				}
-				db.segments = append(db.segments, newActiveSegment)
-				db.activeSegment = newActiveSegment
+		case req := <-db.compactRequests:
+			*req.reclaimed, err = db.compactSegment(req.id)
+			req.respChan <- err
 
-				db.activeSegment.file, err = os.OpenFile(db.activeSegment.filePath, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0o600)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "ioWorker: failed to open new active segment %s: %v\n", db.activeSegment.filePath, err)
-					db.segmentsMutex.Unlock()
-					continue
-				}
-				db.activeSegment.offset = 0
-				db.segmentsMutex.Unlock()
+		case req := <-db.pingRequests:
+			if db.activeSegment.file == nil {
+				req.respChan <- fmt.Errorf("active segment %s is not open", db.activeSegment.filePath)
+			} else {
+				req.respChan <- nil
 			}
 
-		case req := <-db.mergeRequests:
+		case req := <-db.syncRequests:
 			if db.activeSegment.file != nil {
-				if err := db.activeSegment.file.Close(); err != nil {
-					req.respChan <- fmt.Errorf("ioWorker: failed to close active segment before merge: %w", err)
-					continue
-				}
-				db.activeSegment.file = nil
+				req.respChan <- db.activeSegment.file.Sync()
+			} else {
+				req.respChan <- nil
 			}
 
-			err := db.performMerge()
-			req.respChan <- err
-			if db.activeSegment != nil {
-				db.activeSegment.file, err = os.OpenFile(db.activeSegment.filePath, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0o600)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "ioWorker: failed to re-open active segment %s post-merge: %v\n", db.activeSegment.filePath, err)
-				} else {
-					stat, statErr := db.activeSegment.file.Stat()
-					if statErr != nil {
-						fmt.Fprintf(os.Stderr, "ioWorker: failed to stat re-opened active segment %s post-merge: %v\n", db.activeSegment.filePath, statErr)
-					} else {
-						db.activeSegment.offset = stat.Size()
-					}
-				}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// merge runs on the io worker: it closes the active segment, merges all
+// segments and reopens the resulting active segment for appends.
+func (db *Db) merge() error {
+	if db.activeSegment.file != nil {
+		if err := db.activeSegment.file.Close(); err != nil {
+			return fmt.Errorf("ioWorker: failed to close active segment before merge: %w", err)
+		}
+		db.activeSegment.file = nil
+	}
+
+	start, before := time.Now(), db.Usage()
+	err := db.performMerge()
+	db.stats.merges.Add(1)
+	db.stats.mergeNanos.Add(int64(time.Since(start)))
+	db.recordCompaction("merge", start, before, err)
+	if db.activeSegment != nil {
+		var openErr error
+		db.activeSegment.file, openErr = os.OpenFile(db.activeSegment.filePath, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0o600)
+		if openErr != nil {
+			fmt.Fprintf(os.Stderr, "ioWorker: failed to re-open active segment %s post-merge: %v\n", db.activeSegment.filePath, openErr)
+		} else {
+			stat, statErr := db.activeSegment.file.Stat()
+			if statErr != nil {
+				fmt.Fprintf(os.Stderr, "ioWorker: failed to stat re-opened active segment %s post-merge: %v\n", db.activeSegment.filePath, statErr)
+			} else {
+				db.activeSegment.idxMu.Lock()
+				db.activeSegment.offset = stat.Size()
+				db.activeSegment.idxMu.Unlock()
 			}
+		}
+	}
+	return err
+}
 
-		case <-db.shutdown:
-			return
+// compactIfNeeded merges the segments once there are as many as the
+// CompactionSegments option allows.
+func (db *Db) compactIfNeeded() {
+	if db.opts.CompactionSegments <= 0 {
+		return
+	}
+	db.segmentsMutex.RLock()
+	count := len(db.segments)
+	db.segmentsMutex.RUnlock()
+	if count < db.opts.CompactionSegments {
+		return
+	}
+	if err := db.merge(); err != nil {
+		fmt.Fprintf(os.Stderr, "ioWorker: automatic compaction failed: %v\n", err)
+	}
+}
+
+// maxGroupCommit bounds how many puts groupCommit writes together.
+const maxGroupCommit = 256
+
+// groupCommit writes req together with the puts already waiting behind it
+// in a single write and, with SyncAlways, a single fsync, and only then
+// answers them all. Under concurrent writers this amortizes the fsync over
+// the whole group instead of paying it once per put. A put that fails its
+// limits is answered on its own and leaves the others in the group.
+func (db *Db) groupCommit(req putRequest) {
+	reqs := []putRequest{req}
+	for len(reqs) < maxGroupCommit {
+		select {
+		case next := <-db.putRequests:
+			reqs = append(reqs, next)
+			continue
+		default:
+		}
+		break
+	}
+
+	entries := make([]*entry, 0, len(reqs))
+	accepted := reqs[:0:0]
+	for _, req := range reqs {
+		e := &entry{key: req.key, value: req.value, valueType: req.valueType}
+		// The quota is checked against the whole group so far.
+		if err := db.checkWrite(append(entries, e)); err != nil {
+			req.respChan <- err
+			continue
+		}
+		entries = append(entries, e)
+		accepted = append(accepted, req)
+	}
+	if len(entries) == 0 {
+		return
+	}
+	db.stats.groupCommits.Add(1)
+	err := db.appendEntries(entries)
+	db.rotateIfNeeded()
+	for _, req := range accepted {
+		req.respChan <- err
+	}
+}
+
+func (db *Db) appendEntry(e *entry) error {
+	return db.appendEntries([]*entry{e})
+}
+
+// appendEntries writes all entries to the active segment with a single write
+// call and indexes them only once the write succeeded.
+func (db *Db) appendEntries(entries []*entry) error {
+	for _, e := range entries {
+		if size := int64(len(e.key)) + int64(len(e.value)) + entryOverhead; size > maxRecordSize {
+			return fmt.Errorf("%w: entry for key of %d bytes needs %d bytes, limit is %d", ErrTooLarge, len(e.key), size, int64(maxRecordSize))
+		}
+	}
+
+	type keyOffset struct {
+		key       string
+		offset    int64
+		tombstone bool
+	}
+	var buf []byte
+	var keys []keyOffset
+	for _, e := range entries {
+		e, err := db.stamp(e)
+		if err != nil {
+			return err
+		}
+		e.compress(db.opts.CompressMinSize)
+		base := int64(len(buf))
+		err = e.expand(func(offset int64, e *entry) {
+			keys = append(keys, keyOffset{e.key, base + offset, e.valueType == tombstoneValType})
+			db.countWrite(e)
+		})
+		if err != nil {
+			return err
+		}
+		buf = append(buf, e.Encode()...)
+	}
+	n, err := db.activeSegment.file.Write(buf)
+	if err != nil {
+		return err
+	}
+	if db.opts.Sync == SyncAlways {
+		if err := db.activeSegment.file.Sync(); err != nil {
+			return fmt.Errorf("cannot sync segment %s: %w", db.activeSegment.filePath, err)
+		}
+	}
+
+	db.activeSegment.idxMu.Lock()
+	for _, k := range keys {
+		db.activeSegment.index[k.key] = db.activeSegment.offset + k.offset
+		db.activeSegment.track(k.key, k.tombstone)
+		if db.activeSegment.filter != nil {
+			db.activeSegment.filter.Add(k.key)
 		}
 	}
+	db.activeSegment.offset += int64(n)
+	db.activeSegment.idxMu.Unlock()
+	if db.cache != nil {
+		written := make([]string, len(keys))
+		for i, k := range keys {
+			written[i] = k.key
+		}
+		db.cache.invalidate(written)
+	}
+	db.publish(buf)
+	return nil
+}
+
+func (db *Db) rotateIfNeeded() {
+	if db.activeSegment.offset < db.segmentSize {
+		return
+	}
+	if err := db.activeSegment.file.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "ioWorker: failed to close segment %s: %v\n", db.activeSegment.filePath, err)
+	}
+	db.activeSegment.file = nil
+	db.activeSegment.seal(db.opts.BloomBitsPerKey)
+	if err := db.activeSegment.writeHint(); err != nil {
+		fmt.Fprintf(os.Stderr, "ioWorker: failed to write hint for segment %s: %v\n", db.activeSegment.filePath, err)
+	}
+
+	db.segmentsMutex.Lock()
+	defer db.segmentsMutex.Unlock()
+	newActiveSegment, err := newSegment(db.dir, db.nextSegmentID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ioWorker: failed to create new segment: %v\n", err)
+		return
+	}
+	db.nextSegmentID++
+	db.segments = append(db.segments, newActiveSegment)
+	db.activeSegment = newActiveSegment
+
+	db.activeSegment.file, err = os.OpenFile(db.activeSegment.filePath, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ioWorker: failed to open new active segment %s: %v\n", db.activeSegment.filePath, err)
+		return
+	}
+	db.activeSegment.offset = 0
+}
+
+// scan rebuilds the segment index by decoding every record of the file.
+func (s *segment) scan() error {
+	f, err := os.OpenFile(s.filePath, os.O_RDONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("recover: could not open segment file %s: %w", s.filePath, err)
+	}
+	defer f.Close()
+
+	s.index = make(hashIndex)
+	s.records, s.tombstones, s.deadKeys, s.counted = 0, 0, make(map[string]struct{}), true
+	var currentOffset int64 = 0
+	reader := bufio.NewReader(f)
+	for {
+		var rec entry
+		pos := currentOffset
+		n, readErr := rec.DecodeFromReader(reader)
+		if errors.Is(readErr, io.EOF) {
+			break
+		}
+		if readErr != nil {
+			return fmt.Errorf("recover: corrupt segment %s: %w", s.filePath, readErr)
+		}
+		err := rec.expand(func(offset int64, e *entry) {
+			s.index[e.key] = pos + offset
+			s.track(e.key, e.valueType == tombstoneValType)
+			s.maxVersion = max(s.maxVersion, e.version)
+		})
+		if err != nil {
+			return fmt.Errorf("recover: corrupt segment %s: %w", s.filePath, err)
+		}
+		currentOffset += int64(n)
+	}
+	s.offset = currentOffset
+	return nil
+}
+
+// loadIndexes builds the index of every segment, from its hint file or by
+// scanning it, with up to Options.RecoveryWorkers segments at a time. Each
+// segment keeps its own index, so the order they finish in does not matter;
+// the error returned is the one of the oldest failing segment.
+func (db *Db) loadIndexes(sizes map[*segment]int64) error {
+	workers := db.opts.RecoveryWorkers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	errs := make([]error, len(db.segments))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(workers, len(db.segments)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				errs[i] = db.segments[i].loadIndex(sizes[db.segments[i]])
+			}
+		}()
+	}
+	for i := range db.segments {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadIndex reads the segment index from its hint file, falling back to a
+// scan of the segment if there is no valid hint.
+func (s *segment) loadIndex(size int64) error {
+	err := s.loadHint(size)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "recover: rebuilding index of segment %s: %v\n", s.filePath, err)
+	}
+	return s.scan()
 }
 
 func (db *Db) recover() error {
@@ -211,68 +708,192 @@ func (db *Db) recover() error {
 		return err
 	}
 
-	segmentIDCounter := 0
+	type segmentFile struct {
+		name string
+		gen  int64
+		size int64
+	}
+	latest := make(map[int64]segmentFile)
+	// superseded are segments a purge rewrote but stopped before removing;
+	// the rewrite is only renamed into place once complete.
+	superseded := make(map[string]bool)
+	var others []os.DirEntry
 	for _, file := range files {
-		if file.IsDir() || !filepath.HasPrefix(file.Name(), outFileName) {
+		id, gen, ok := parseSegmentName(file.Name())
+		if !ok || file.IsDir() {
+			others = append(others, file)
 			continue
 		}
-		seg, _ := newSegment(db.dir, segmentIDCounter)
-		seg.filePath = filepath.Join(db.dir, file.Name())
-
-		f, err := os.OpenFile(seg.filePath, os.O_RDONLY, 0o600)
+		info, err := file.Info()
 		if err != nil {
-			return fmt.Errorf("recover: could not open segment file %s: %w", seg.filePath, err)
+			return fmt.Errorf("recover: could not stat segment file %s: %w", filepath.Join(db.dir, file.Name()), err)
 		}
-
-		var currentOffset int64 = 0
-		reader := bufio.NewReader(f)
-		for {
-			var rec entry
-			pos := currentOffset
-			n, readErr := rec.DecodeFromReader(reader)
-			if errors.Is(readErr, io.EOF) {
-				break
-			}
-			if readErr != nil {
-				f.Close()
-				return fmt.Errorf("recover: corrupt segment %s: %w", seg.filePath, readErr)
+		if prev, dup := latest[id]; dup {
+			if prev.gen > gen {
+				superseded[file.Name()] = true
+				continue
 			}
-			seg.index[rec.key] = pos
-			currentOffset += int64(n)
+			superseded[prev.name] = true
 		}
-		f.Close()
+		latest[id] = segmentFile{file.Name(), gen, info.Size()}
+	}
+
+	segmentNames := make(map[string]bool)
+	sizes := make(map[*segment]int64)
+	for id, file := range latest {
+		segmentNames[file.name] = true
+		seg, _ := newSegment(db.dir, id)
+		seg.gen = file.gen
+		seg.filePath = filepath.Join(db.dir, file.name)
+		sizes[seg] = file.size
 		db.segments = append(db.segments, seg)
-		segmentIDCounter++
+		if id >= db.nextSegmentID {
+			db.nextSegmentID = id + 1
+		}
+	}
+	sort.Slice(db.segments, func(i, j int) bool {
+		return db.segments[i].id < db.segments[j].id
+	})
+	if err := db.loadIndexes(sizes); err != nil {
+		return err
+	}
+	// The active segment keeps counting its entries as they are appended.
+	if n := len(db.segments); n > 0 && !db.segments[n-1].counted {
+		if err := db.segments[n-1].countRecords(); err != nil {
+			return fmt.Errorf("recover: %w", err)
+		}
+	}
+	for name := range superseded {
+		fmt.Fprintf(os.Stderr, "recover: ignoring %s: superseded by a rewritten segment\n", name)
+		if db.opts.ReadOnly {
+			continue
+		}
+		for _, path := range []string{filepath.Join(db.dir, name), hintPath(filepath.Join(db.dir, name))} {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("recover: %w", err)
+			}
+		}
+	}
+	for _, seg := range db.segments {
+		db.lastVersion = max(db.lastVersion, seg.maxVersion)
+	}
+
+	db.startup.Segments = len(db.segments)
+	for _, file := range others {
+		if superseded[strings.TrimSuffix(file.Name(), hintSuffix)] {
+			continue
+		}
+		if reason := orphanReason(file, segmentNames); reason != "" {
+			fmt.Fprintf(os.Stderr, "recover: ignoring %s: %s\n", file.Name(), reason)
+			db.startup.Orphans = append(db.startup.Orphans, OrphanFile{Name: file.Name(), Reason: reason})
+		}
+	}
+	if len(db.startup.Orphans) > 0 && db.opts.QuarantineOrphans && !db.opts.ReadOnly {
+		if err := db.quarantine(db.startup.Orphans); err != nil {
+			return err
+		}
+		db.startup.Quarantined = true
 	}
 	return nil
 }
 
 func (db *Db) Close() error {
-	close(db.shutdown)
-	db.wg.Wait()
+	db.closeOnce.Do(func() {
+		db.tasks.stop()
+		db.closeSubscribers()
+	})
 	return nil
 }
 
+// Ready reports whether the store accepts writes: the writer goroutine is
+// running and has the active segment open. Read-only stores report
+// ErrReadOnly.
+func (db *Db) Ready() error {
+	respChan := make(chan error, 1)
+	if err := send(context.Background(), db, db.pingRequests, pingRequest{respChan: respChan}, respChan); err != nil {
+		return err
+	}
+	return db.checkWritable()
+}
+
+// FreeSpace returns the number of bytes available to the store in its
+// directory.
+func (db *Db) FreeSpace() (uint64, error) {
+	return db.freeSpace(db.dir)
+}
+
 func (db *Db) Get(key string) (string, error) {
+	return db.GetContext(context.Background(), key)
+}
+
+// GetContext is Get that gives up once ctx is done.
+func (db *Db) GetContext(ctx context.Context, key string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
 	val, typ, err := db.getRaw(key)
+	db.countGet(err)
 	if err != nil {
 		return "", err
 	}
 	if typ != StrValType {
-		return "", fmt.Errorf("expected string, got type 0x%x", typ)
+		return "", fmt.Errorf("%w: expected string, got type 0x%x", ErrTypeMismatch, typ)
 	}
 	return val, nil
 }
 
+// SetReadOnly makes every subsequent client write fail with ErrReadOnly.
+// Entries applied from a replication stream are still accepted. A store
+// opened with the ReadOnly option stays read-only.
+func (db *Db) SetReadOnly(readOnly bool) {
+	db.readOnly.Store(readOnly || db.opts.ReadOnly)
+}
+
+func (db *Db) checkWritable() error {
+	if db.readOnly.Load() {
+		return ErrReadOnly
+	}
+	if db.diskFull.Load() {
+		return ErrDiskFull
+	}
+	return nil
+}
+
 func (db *Db) Put(key, value string) error {
-	respChan := make(chan error)
-	db.putRequests <- putRequest{
+	return db.PutContext(context.Background(), key, value)
+}
+
+// PutContext is Put that stops waiting once ctx is done. A write the io
+// worker already accepted may still be applied.
+func (db *Db) PutContext(ctx context.Context, key, value string) error {
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+	respChan := make(chan error, 1)
+	return send(ctx, db, db.putRequests, putRequest{
 		key:       key,
 		value:     value,
 		valueType: StrValType,
 		respChan:  respChan,
+	}, respChan)
+}
+
+// Delete removes key from the store by appending a tombstone; the space is
+// reclaimed by the next merge.
+func (db *Db) Delete(key string) error {
+	return db.DeleteContext(context.Background(), key)
+}
+
+func (db *Db) DeleteContext(ctx context.Context, key string) error {
+	if err := db.checkWritable(); err != nil {
+		return err
 	}
-	return <-respChan
+	respChan := make(chan error, 1)
+	return send(ctx, db, db.putRequests, putRequest{
+		key:       key,
+		valueType: tombstoneValType,
+		respChan:  respChan,
+	}, respChan)
 }
 
 func (db *Db) Size() (int64, error) {
@@ -290,171 +911,450 @@ func (db *Db) Size() (int64, error) {
 }
 
 func (db *Db) MergeSegments() error {
-	respChan := make(chan error)
-	db.mergeRequests <- mergeRequest{respChan: respChan}
-	return <-respChan
+	respChan := make(chan error, 1)
+	return send(context.Background(), db, db.mergeRequests, mergeRequest{respChan: respChan}, respChan)
 }
 
+// performMerge runs on the io worker, the only writer of the segment list,
+// so the segments it reads stay in the store until it swaps in the merged
+// one. The merged segment is written to a temporary file while readers keep
+// using the old segments, which are removed once the last reader is done.
+// Surviving entries keep the order they were written in.
 func (db *Db) performMerge() error {
-	db.segmentsMutex.Lock()
-	defer db.segmentsMutex.Unlock()
+	db.segmentsMutex.RLock()
+	segments := slices.Clone(db.segments)
+	db.segmentsMutex.RUnlock()
 
-	if len(db.segments) <= 1 {
+	if len(segments) <= 1 {
 		return nil
 	}
 
-	maxID := -1
-	for _, seg := range db.segments {
-		var id int
-
-		if n, _ := fmt.Sscanf(filepath.Base(seg.filePath), outFileName+"-%d", &id); n == 1 {
-			if id > maxID {
-				maxID = id
-			}
-		}
-	}
-	mergedSegmentID := maxID + 1
-
-	mergedSegment, err := newSegment(db.dir, mergedSegmentID)
+	mergedSegment, err := newSegment(db.dir, db.nextSegmentID)
 	if err != nil {
 		return fmt.Errorf("performMerge: failed to create new segment object: %w", err)
 	}
+	db.nextSegmentID++
 
-	mergedFile, err := os.OpenFile(mergedSegment.filePath, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0o600)
-	if err != nil {
-		return fmt.Errorf("performMerge: failed to open merged segment file %s: %w", mergedSegment.filePath, err)
+	type keyOffset struct {
+		key    string
+		offset int64
 	}
-	defer mergedFile.Close()
-	latestKeyOffsets := make(map[string]struct {
-		segmentPath string
-		offset      int64
-	})
-
-	for i := len(db.segments) - 1; i >= 0; i-- {
-		segment := db.segments[i]
+	latest := make([][]keyOffset, len(segments))
+	seen := make(map[string]struct{})
+	for i := len(segments) - 1; i >= 0; i-- {
+		segment := segments[i]
 		segment.idxMu.RLock()
 		for key, offset := range segment.index {
-			if _, exists := latestKeyOffsets[key]; !exists {
-				latestKeyOffsets[key] = struct {
-					segmentPath string
-					offset      int64
-				}{segment.filePath, offset}
+			if _, exists := seen[key]; !exists {
+				seen[key] = struct{}{}
+				latest[i] = append(latest[i], keyOffset{key, offset})
 			}
 		}
 		segment.idxMu.RUnlock()
+		sort.Slice(latest[i], func(a, b int) bool {
+			return latest[i][a].offset < latest[i][b].offset
+		})
 	}
 
-	var currentMergedOffset int64 = 0
-	for key, data := range latestKeyOffsets {
-		f, err := os.Open(data.segmentPath)
-		if err != nil {
-			return fmt.Errorf("performMerge: could not open source segment %s for key %s: %w", data.segmentPath, key, err)
+	tmp := mergedSegment.filePath + ".tmp"
+	mergedFile, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("performMerge: failed to open merged segment file %s: %w", tmp, err)
+	}
+	defer os.Remove(tmp)
+	defer mergedFile.Close()
+	out := bufio.NewWriter(mergedFile)
+
+	for i, segment := range segments {
+		if len(latest[i]) == 0 {
+			continue
 		}
-		_, err = f.Seek(data.offset, io.SeekStart)
+		f, err := os.Open(segment.filePath)
 		if err != nil {
-			f.Close()
-			return fmt.Errorf("performMerge: could not seek in source segment %s for key %s: %w", data.segmentPath, key, err)
+			return fmt.Errorf("performMerge: could not open source segment %s: %w", segment.filePath, err)
 		}
-
-		var record entry
-		if _, err := record.DecodeFromReader(bufio.NewReader(f)); err != nil {
-			f.Close()
-			return fmt.Errorf("performMerge: could not decode record from source segment %s for key %s: %w", data.segmentPath, key, err)
+		for _, ko := range latest[i] {
+			if _, err := f.Seek(ko.offset, io.SeekStart); err != nil {
+				f.Close()
+				return fmt.Errorf("performMerge: could not seek in source segment %s for key %s: %w", segment.filePath, ko.key, err)
+			}
+			var record entry
+			if _, err := record.DecodeFromReader(bufio.NewReader(f)); err != nil {
+				f.Close()
+				return fmt.Errorf("performMerge: could not decode record from source segment %s for key %s: %w", segment.filePath, ko.key, err)
+			}
+			if record.valueType == tombstoneValType {
+				continue
+			}
+			n, err := out.Write(record.Encode())
+			if err != nil {
+				f.Close()
+				return fmt.Errorf("performMerge: could not write entry for key %s to merged segment: %w", ko.key, err)
+			}
+			mergedSegment.index[ko.key] = mergedSegment.offset
+			mergedSegment.track(ko.key, false)
+			mergedSegment.offset += int64(n)
 		}
 		f.Close()
-
-		encodedEntry := record.Encode()
-		n, err := mergedFile.Write(encodedEntry)
-		if err != nil {
-			return fmt.Errorf("performMerge: could not write entry for key %s to merged segment: %w", key, err)
-		}
-
-		mergedSegment.idxMu.Lock()
-		mergedSegment.index[key] = currentMergedOffset
-		mergedSegment.idxMu.Unlock()
-		currentMergedOffset += int64(n)
 	}
-	mergedSegment.offset = currentMergedOffset
-
-	oldSegmentPaths := make([]string, len(db.segments))
-	for i, s := range db.segments {
-		oldSegmentPaths[i] = s.filePath
+	if err := out.Flush(); err != nil {
+		return fmt.Errorf("performMerge: %w", err)
+	}
+	if err := mergedFile.Sync(); err != nil {
+		return fmt.Errorf("performMerge: %w", err)
 	}
+	if err := os.Rename(tmp, mergedSegment.filePath); err != nil {
+		return fmt.Errorf("performMerge: %w", err)
+	}
+	mergedSegment.seal(db.opts.BloomBitsPerKey)
 
-	db.segments = []*Segment{mergedSegment}
+	db.segmentsMutex.Lock()
+	db.segments = []*segment{mergedSegment}
 	db.activeSegment = mergedSegment
+	db.segmentsMutex.Unlock()
 
-	for _, p := range oldSegmentPaths {
-		if p == mergedSegment.filePath {
-			continue
-		}
-		if err := os.Remove(p); err != nil {
-			fmt.Fprintf(os.Stderr, "performMerge: failed to remove old segment file %s: %v\n", p, err)
-		}
-	}
+	releaseSegments(segments)
 	return nil
 }
 
 func (db *Db) PutInt64(key string, value int64) error {
-	buf := make([]byte, 8)
-	binary.LittleEndian.PutUint64(buf, uint64(value))
-	respChan := make(chan error)
+	return db.PutInt64Context(context.Background(), key, value)
+}
 
-	db.putRequests <- putRequest{
+func (db *Db) PutInt64Context(ctx context.Context, key string, value int64) error {
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+	respChan := make(chan error, 1)
+	return send(ctx, db, db.putRequests, putRequest{
 		key:       key,
-		value:     string(buf),
+		value:     encodeInt64(value),
 		valueType: Int64ValType,
 		respChan:  respChan,
-	}
-	return <-respChan
+	}, respChan)
 }
 
 func (db *Db) GetInt64(key string) (int64, error) {
+	return db.GetInt64Context(context.Background(), key)
+}
+
+func (db *Db) GetInt64Context(ctx context.Context, key string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
 	val, typ, err := db.getRaw(key)
+	db.countGet(err)
 	if err != nil {
 		return 0, err
 	}
 	if typ != Int64ValType {
-		return 0, fmt.Errorf("expected int64, got type 0x%x", typ)
+		return 0, fmt.Errorf("%w: expected int64, got type 0x%x", ErrTypeMismatch, typ)
 	}
-	if len(val) != 8 {
-		return 0, fmt.Errorf("corrupt int64 encoding")
+	return decodeInt64(val)
+}
+
+// CompareAndSwap replaces the string value of key with newValue only if its
+// current value equals oldValue. It reports whether the swap took place.
+func (db *Db) CompareAndSwap(key, oldValue, newValue string) (bool, error) {
+	swapped := false
+	err := db.update(key, func(value string, valueType byte, err error) (*entry, error) {
+		if err != nil {
+			return nil, err
+		}
+		if valueType != StrValType {
+			return nil, fmt.Errorf("%w: expected string, got type 0x%x", ErrTypeMismatch, valueType)
+		}
+		if value != oldValue {
+			return nil, nil
+		}
+		swapped = true
+		return &entry{key: key, value: newValue, valueType: StrValType}, nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return swapped, nil
+}
+
+// PutIfAbsent writes value only if key does not exist yet and reports whether
+// it did.
+func (db *Db) PutIfAbsent(key, value string) (bool, error) {
+	return db.putIfAbsent(&entry{key: key, value: value, valueType: StrValType})
+}
+
+func (db *Db) PutInt64IfAbsent(key string, value int64) (bool, error) {
+	return db.putIfAbsent(&entry{key: key, value: encodeInt64(value), valueType: Int64ValType})
+}
+
+func (db *Db) putIfAbsent(e *entry) (bool, error) {
+	written := false
+	err := db.update(e.key, func(_ string, _ byte, err error) (*entry, error) {
+		if errors.Is(err, ErrNotFound) {
+			written = true
+			return e, nil
+		}
+		return nil, err
+	})
+	if err != nil {
+		return false, err
+	}
+	return written, nil
+}
+
+// Increment atomically adds delta to the int64 value of key and returns the
+// result. A missing key is treated as zero.
+func (db *Db) Increment(key string, delta int64) (int64, error) {
+	var result int64
+	err := db.update(key, func(value string, valueType byte, err error) (*entry, error) {
+		var current int64
+		switch {
+		case errors.Is(err, ErrNotFound):
+		case err != nil:
+			return nil, err
+		case valueType != Int64ValType:
+			return nil, fmt.Errorf("%w: expected int64, got type 0x%x", ErrTypeMismatch, valueType)
+		default:
+			if current, err = decodeInt64(value); err != nil {
+				return nil, err
+			}
+		}
+		result = current + delta
+		return &entry{key: key, value: encodeInt64(result), valueType: Int64ValType}, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return result, nil
+}
+
+// update runs fn on the ioWorker goroutine, so the read of the current value
+// and the write of the returned entry cannot interleave with other writers.
+// A nil entry means there is nothing to write.
+func (db *Db) update(key string, fn func(value string, valueType byte, err error) (*entry, error)) error {
+	return db.updateEntry(key, func(current *entry, err error) (*entry, error) {
+		if err != nil {
+			return fn("", 0, err)
+		}
+		return fn(current.value, current.valueType, nil)
+	})
+}
+
+// updateEntry is update for callers that need the whole current entry.
+func (db *Db) updateEntry(key string, fn func(current *entry, err error) (*entry, error)) error {
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+	respChan := make(chan error, 1)
+	return send(context.Background(), db, db.updateRequests, updateRequest{
+		key:      key,
+		update:   fn,
+		respChan: respChan,
+	}, respChan)
+}
+
+// send hands a request to the io worker and waits for its response unless
+// ctx is done first. respChan must be buffered so the worker never blocks on
+// a caller that gave up.
+//
+// The io worker is the only goroutine that appends to the active segment:
+// callers queue behind each other on the request channels instead of locking
+// around file I/O, and every write is applied whole before the next one
+// starts. If the worker has stopped, send fails with errWriterStopped rather
+// than waiting forever.
+func send[T any](ctx context.Context, db *Db, requests chan<- T, req T, respChan <-chan error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case requests <- req:
+	case <-db.stopped:
+		return errWriterStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-respChan:
+		return err
+	case <-db.stopped:
+		// The worker answers before it returns, unless it crashed while
+		// handling the request.
+		select {
+		case err := <-respChan:
+			return err
+		default:
+			return errWriterStopped
+		}
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	return int64(binary.LittleEndian.Uint64([]byte(val))), nil
 }
 
 func (db *Db) getRaw(key string) (string, byte, error) {
+	e, err := db.getEntry(key)
+	if err != nil {
+		return "", 0, err
+	}
+	return e.value, e.valueType, nil
+}
+
+// getEntry returns the latest entry of key with its value decompressed.
+func (db *Db) getEntry(key string) (*entry, error) {
+	cached, generation := db.cache.get(key)
+	if cached != nil {
+		return cached, nil
+	}
+
+	segment, offset, ok := db.locate(key)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	defer segment.release()
+	f, err := os.Open(segment.filePath)
+	if err != nil {
+		return nil, fmt.Errorf("could not open segment file %s: %w", segment.filePath, err)
+	}
+	defer f.Close()
+
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		return nil, fmt.Errorf("could not seek in segment file %s: %w", segment.filePath, err)
+	}
+
+	var rec entry
+	if _, err := rec.DecodeFromReader(bufio.NewReader(f)); err != nil {
+		return nil, fmt.Errorf("could not decode record from segment file %s: %w", segment.filePath, err)
+	}
+	if rec.valueType == tombstoneValType {
+		return nil, ErrNotFound
+	}
+	if err := rec.decompress(); err != nil {
+		return nil, err
+	}
+	db.cache.add(&rec, generation)
+	return &rec, nil
+}
+
+// locate returns the newest segment holding a record of key and the record
+// offset in it. The caller releases the segment.
+func (db *Db) locate(key string) (*segment, int64, bool) {
 	db.segmentsMutex.RLock()
-	segmentsSnapshot := make([]*Segment, len(db.segments))
-	copy(segmentsSnapshot, db.segments)
-	db.segmentsMutex.RUnlock()
+	defer db.segmentsMutex.RUnlock()
+	for i := len(db.segments) - 1; i >= 0; i-- {
+		if offset, ok := db.segments[i].lookup(key); ok {
+			db.segments[i].acquire()
+			return db.segments[i], offset, true
+		}
+	}
+	return nil, 0, false
+}
 
-	for i := len(segmentsSnapshot) - 1; i >= 0; i-- {
-		segment := segmentsSnapshot[i]
+// GetMany looks up several keys at once. Missing keys are left out of the
+// result; values are returned as string or int64 depending on their stored
+// type.
+func (db *Db) GetMany(keys []string) (map[string]any, error) {
+	return db.GetManyContext(context.Background(), keys)
+}
 
-		segment.idxMu.RLock()
-		offset, ok := segment.index[key]
-		segment.idxMu.RUnlock()
-		if !ok {
-			continue
+// GetManyContext is GetMany that stops reading once ctx is done. The values
+// read so far are returned along with the context error.
+func (db *Db) GetManyContext(ctx context.Context, keys []string) (map[string]any, error) {
+	res := make(map[string]any, len(keys))
+	err := db.forEachLatest(keys, func(key string, e *entry) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		switch e.valueType {
+		case Int64ValType:
+			val, err := decodeInt64(e.value)
+			if err != nil {
+				return err
+			}
+			res[key] = val
+		default:
+			res[key] = e.value
 		}
+		return nil
+	})
+	if ctxErr := ctx.Err(); ctxErr != nil && errors.Is(err, ctxErr) {
+		return res, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	db.stats.gets.Add(int64(len(keys)))
+	db.stats.misses.Add(int64(len(keys) - len(res)))
+	return res, nil
+}
 
-		f, err := os.Open(segment.filePath)
-		if err != nil {
-			return "", 0, fmt.Errorf("could not open segment file %s: %w", segment.filePath, err)
+// forEachLatest calls fn with the latest entry of each of the given keys, or
+// of every key in the store if keys is nil. Keys are grouped by the segment
+// that holds their latest value so every segment file is opened only once and
+// read in offset order.
+func (db *Db) forEachLatest(keys []string, fn func(key string, e *entry) error) error {
+	segmentsSnapshot := db.acquireSegments()
+	defer releaseSegments(segmentsSnapshot)
+
+	type keyOffset struct {
+		key    string
+		offset int64
+	}
+	groups := make(map[*segment][]keyOffset)
+	if keys == nil {
+		seen := make(map[string]struct{})
+		for i := len(segmentsSnapshot) - 1; i >= 0; i-- {
+			segment := segmentsSnapshot[i]
+			segment.idxMu.RLock()
+			for key, offset := range segment.index {
+				if _, ok := seen[key]; ok {
+					continue
+				}
+				seen[key] = struct{}{}
+				groups[segment] = append(groups[segment], keyOffset{key, offset})
+			}
+			segment.idxMu.RUnlock()
 		}
-		defer f.Close()
+	}
+	for _, key := range keys {
+		for i := len(segmentsSnapshot) - 1; i >= 0; i-- {
+			if offset, ok := segmentsSnapshot[i].lookup(key); ok {
+				groups[segmentsSnapshot[i]] = append(groups[segmentsSnapshot[i]], keyOffset{key, offset})
+				break
+			}
+		}
+	}
 
-		_, err = f.Seek(offset, io.SeekStart)
+	for segment, group := range groups {
+		sort.Slice(group, func(i, j int) bool {
+			return group[i].offset < group[j].offset
+		})
+
+		f, err := os.Open(segment.filePath)
 		if err != nil {
-			return "", 0, fmt.Errorf("could not seek in segment file %s: %w", segment.filePath, err)
+			return fmt.Errorf("could not open segment file %s: %w", segment.filePath, err)
 		}
-
-		var rec entry
-		if _, err := rec.DecodeFromReader(bufio.NewReader(f)); err != nil {
-			return "", 0, fmt.Errorf("could not decode record from segment file %s: %w", segment.filePath, err)
+		for _, ko := range group {
+			if _, err := f.Seek(ko.offset, io.SeekStart); err != nil {
+				f.Close()
+				return fmt.Errorf("could not seek in segment file %s: %w", segment.filePath, err)
+			}
+			var rec entry
+			if _, err := rec.DecodeFromReader(bufio.NewReader(f)); err != nil {
+				f.Close()
+				return fmt.Errorf("could not decode record from segment file %s: %w", segment.filePath, err)
+			}
+			if rec.valueType == tombstoneValType {
+				continue
+			}
+			if err := rec.decompress(); err != nil {
+				f.Close()
+				return err
+			}
+			if err := fn(ko.key, &rec); err != nil {
+				f.Close()
+				return err
+			}
 		}
-		return rec.value, rec.valueType, nil
+		f.Close()
 	}
-	return "", 0, ErrNotFound
+	return nil
 }
@@ -1,9 +1,17 @@
 package datastore
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestDb(t *testing.T) {
@@ -193,3 +201,550 @@ func TestMergeSegments(t *testing.T) {
 		t.Errorf("expected 1 file after merge, found %d", len(files))
 	}
 }
+
+func TestMergeSegments_ConcurrentReads(t *testing.T) {
+	db, err := Open(t.TempDir(), 200)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	const keys = 20
+	for round := 0; round < 3; round++ {
+		for i := 0; i < keys; i++ {
+			if err := db.Put(fmt.Sprintf("key%02d", i), fmt.Sprintf("value%d-%d", i, round)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	stop := make(chan struct{})
+	errs := make(chan error, 4)
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				key := fmt.Sprintf("key%02d", i%keys)
+				if _, err := db.Get(key); err != nil {
+					errs <- fmt.Errorf("Get(%s) during merge: %w", key, err)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		if err := db.Put(fmt.Sprintf("key%02d", i%keys), fmt.Sprintf("value%d-new", i%keys)); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.MergeSegments(); err != nil {
+			t.Fatalf("merge failed: %v", err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestMergeSegments_KeepsFilesOfOpenReaders(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, 50)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	for _, k := range []string{"a", "b", "c", "a"} {
+		if err := db.Put(k, k+"-0123456789012345678901234567890123456789"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	v, err := db.OpenValue("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := segmentFiles(t, dir)
+	if err := db.MergeSegments(); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	if kept := segmentFiles(t, dir); len(kept) != 2 {
+		t.Errorf("expected the merged segment and the one still read, got %v of %v", kept, before)
+	}
+	data, err := io.ReadAll(v)
+	if err != nil || string(data) != "b-0123456789012345678901234567890123456789" {
+		t.Errorf("reading a value across a merge returned %q, %v", data, err)
+	}
+	_ = v.Close()
+	if left := segmentFiles(t, dir); len(left) != 1 {
+		t.Errorf("expected the old segment removed after the reader closed, got %v", left)
+	}
+
+	// The merged segment holds the surviving entries in write order.
+	var order []string
+	db.segmentsMutex.RLock()
+	path := db.segments[0].filePath
+	db.segmentsMutex.RUnlock()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := readRecords(f, func(_ int64, e *entry) error {
+		order = append(order, e.key)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"b", "c", "a"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("merged entries in order %v, want %v", order, want)
+	}
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range files {
+		if _, ok := parseSegmentID(f.Name()); ok {
+			names = append(names, f.Name())
+		}
+	}
+	return names
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	db, err := Open(t.TempDir(), 1*Mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	if _, err := db.CompareAndSwap("missing", "a", "b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("CompareAndSwap on missing key: expected ErrNotFound, got %v", err)
+	}
+
+	if err := db.Put("k", "v1"); err != nil {
+		t.Fatal(err)
+	}
+
+	swapped, err := db.CompareAndSwap("k", "other", "v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if swapped {
+		t.Error("expected no swap when old value does not match")
+	}
+
+	swapped, err = db.CompareAndSwap("k", "v1", "v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !swapped {
+		t.Error("expected swap when old value matches")
+	}
+	if got, _ := db.Get("k"); got != "v2" {
+		t.Errorf("Get after swap = %q, want %q", got, "v2")
+	}
+
+	if err := db.PutInt64("int", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CompareAndSwap("int", "1", "2"); err == nil {
+		t.Error("expected type error for CompareAndSwap on int64 value")
+	}
+}
+
+func TestIncrement(t *testing.T) {
+	db, err := Open(t.TempDir(), 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	const workers, perWorker = 8, 50
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perWorker; j++ {
+				if _, err := db.Increment("counter", 1); err != nil {
+					t.Errorf("Increment failed: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := db.GetInt64("counter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != workers*perWorker {
+		t.Errorf("counter = %d, want %d", got, workers*perWorker)
+	}
+
+	val, err := db.Increment("counter", -10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val != workers*perWorker-10 {
+		t.Errorf("Increment returned %d, want %d", val, workers*perWorker-10)
+	}
+
+	if err := db.Put("str", "x"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Increment("str", 1); err == nil {
+		t.Error("expected type error for Increment on string value")
+	}
+}
+
+func TestGetMany(t *testing.T) {
+	db, err := Open(t.TempDir(), 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	for _, pair := range [][]string{{"a", "1"}, {"b", "2"}, {"c", "3"}, {"a", "1.1"}} {
+		if err := db.Put(pair[0], pair[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.PutInt64("n", 7); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := db.GetMany([]string{"a", "b", "c", "n", "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"a": "1.1", "b": "2", "c": "3", "n": int64(7)}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetMany() = %v, want %v", got, want)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	tmp := t.TempDir()
+	db, err := Open(tmp, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	for _, key := range []string{"a", "b", "c"} {
+		if err := db.Put(key, "value-"+key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Get("b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete: expected ErrNotFound, got %v", err)
+	}
+	if got, _ := db.GetMany([]string{"a", "b"}); len(got) != 1 {
+		t.Errorf("GetMany must skip deleted keys, got %v", got)
+	}
+
+	if err := db.MergeSegments(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Get("b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after merge: expected ErrNotFound, got %v", err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(tmp, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Get("b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after reopen: expected ErrNotFound, got %v", err)
+	}
+	if got, err := db.Get("c"); err != nil || got != "value-c" {
+		t.Errorf("Get(c) = %q, %v", got, err)
+	}
+}
+
+func TestPutIfAbsent(t *testing.T) {
+	db, err := Open(t.TempDir(), 1*Mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	written, err := db.PutIfAbsent("k", "first")
+	if err != nil || !written {
+		t.Fatalf("PutIfAbsent on missing key = %t, %v", written, err)
+	}
+	written, err = db.PutIfAbsent("k", "second")
+	if err != nil || written {
+		t.Errorf("PutIfAbsent on existing key = %t, %v", written, err)
+	}
+	if got, _ := db.Get("k"); got != "first" {
+		t.Errorf("Get = %q, want %q", got, "first")
+	}
+
+	if err := db.Delete("k"); err != nil {
+		t.Fatal(err)
+	}
+	if written, err := db.PutInt64IfAbsent("k", 1); err != nil || !written {
+		t.Errorf("PutInt64IfAbsent on deleted key = %t, %v", written, err)
+	}
+}
+
+func TestSegmentIDs(t *testing.T) {
+	tmp := t.TempDir()
+	db, err := Open(tmp, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	put := func(key, value string) {
+		t.Helper()
+		if err := db.Put(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put("a", "11111111111111111111")
+	put("b", "22222222222222222222")
+	if err := db.MergeSegments(); err != nil {
+		t.Fatal(err)
+	}
+	// Rotations after a merge must not reuse the names of live segments.
+	put("a", "33333333333333333333")
+	put("c", "44444444444444444444")
+	put("a", "55555555555555555555")
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := os.ReadDir(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), hintSuffix) {
+			continue
+		}
+		if _, ok := parseSegmentID(f.Name()); !ok || len(f.Name()) != len(segmentFileName(0)) {
+			t.Errorf("unexpected segment file name %q", f.Name())
+		}
+	}
+
+	db, err = Open(tmp, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	for key, want := range map[string]string{"a": "55555555555555555555", "b": "22222222222222222222", "c": "44444444444444444444"} {
+		if got, err := db.Get(key); err != nil || got != want {
+			t.Errorf("Get(%q) = %q, %v; want %q", key, got, err, want)
+		}
+	}
+}
+
+func TestRecoverOrdersLegacySegmentsByID(t *testing.T) {
+	tmp := t.TempDir()
+	// Lexicographically "segment-9" sorts after "segment-10".
+	for name, value := range map[string]string{"segment-9": "old", "segment-10": "new"} {
+		e := entry{key: "k", value: value, valueType: StrValType}
+		if err := os.WriteFile(filepath.Join(tmp, name), e.Encode(), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err := Open(tmp, 1*Mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	if got, _ := db.Get("k"); got != "new" {
+		t.Errorf("Get = %q, want value from the segment with the highest ID", got)
+	}
+	if db.nextSegmentID != 11 {
+		t.Errorf("nextSegmentID = %d, want 11", db.nextSegmentID)
+	}
+}
+
+func TestContextDeadline(t *testing.T) {
+	db, err := Open(t.TempDir(), 1*Mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Put("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	if _, err := db.GetContext(ctx, "key"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetContext: expected DeadlineExceeded, got %v", err)
+	}
+	if err := db.PutContext(ctx, "key", "other"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("PutContext: expected DeadlineExceeded, got %v", err)
+	}
+	if got, _ := db.Get("key"); got != "value" {
+		t.Errorf("a write past its deadline must not be applied, got %q", got)
+	}
+	values, err := db.GetManyContext(ctx, []string{"key"})
+	if !errors.Is(err, context.DeadlineExceeded) || values == nil {
+		t.Errorf("GetManyContext: expected partial values and DeadlineExceeded, got %v, %v", values, err)
+	}
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, 64*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const writers, writes = 16, 200
+	value := strings.Repeat("v", 100)
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				key := fmt.Sprintf("w%d-k%d", w, i%20)
+				var err error
+				if i%7 == 6 {
+					err = db.Delete(key)
+				} else {
+					err = db.Put(key, fmt.Sprintf("%s-%d", value, i))
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	want := make(map[string]string)
+	for w := 0; w < writers; w++ {
+		for k := 0; k < 20; k++ {
+			key := fmt.Sprintf("w%d-k%d", w, k)
+			got, err := db.Get(key)
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Get %s: %v", key, err)
+			}
+			want[key] = got
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Interleaved appends would leave records that fail their checksum.
+	report, err := VerifyDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() {
+		t.Errorf("expected clean segments, got %+v", report.Bad)
+	}
+	db, err = Open(dir, 64*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for key, value := range want {
+		if got, _ := db.Get(key); got != value {
+			t.Errorf("%s: got %q after reopening, want %q", key, got, value)
+		}
+	}
+}
+
+func TestGroupCommit(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir(), WithSync(SyncAlways), WithMaxKeySize(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	const writers = 64
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key := fmt.Sprintf("k%d", w)
+			if w%8 == 0 {
+				// Too long: must fail without failing its group.
+				key = "too-long-" + key
+			}
+			err := db.Put(key, "v")
+			if w%8 == 0 && !errors.Is(err, ErrKeyTooLarge) {
+				t.Errorf("put of %s: expected ErrKeyTooLarge, got %v", key, err)
+			} else if w%8 != 0 && err != nil {
+				t.Errorf("put of %s: %v", key, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for w := 1; w < writers; w++ {
+		if w%8 == 0 {
+			continue
+		}
+		if v, err := db.Get(fmt.Sprintf("k%d", w)); err != nil || v != "v" {
+			t.Errorf("k%d = %q, %v", w, v, err)
+		}
+	}
+	stats := db.Stats()
+	if stats.GroupCommits == 0 || stats.GroupCommits > stats.Puts {
+		t.Errorf("%d group commits for %d puts", stats.GroupCommits, stats.Puts)
+	}
+}
+
+func TestWriteAfterClose(t *testing.T) {
+	db, err := Open(t.TempDir(), 1*Mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("key", "value"); !errors.Is(err, errWriterStopped) {
+		t.Errorf("expected a write after Close to fail, got %v", err)
+	}
+	if _, err := db.Increment("counter", 1); !errors.Is(err, errWriterStopped) {
+		t.Errorf("expected an update after Close to fail, got %v", err)
+	}
+}
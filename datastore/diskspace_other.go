@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package datastore
+
+import "errors"
+
+func freeSpace(string) (uint64, error) {
+	return 0, errors.New("free space check is not supported on this platform")
+}
@@ -0,0 +1,45 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// watchDisk re-checks the free space every DiskCheckInterval until the store is
+// closed. Low space triggers a merge; critically low space makes client
+// writes fail with ErrDiskFull instead of failing halfway through a record.
+func (db *Db) watchDisk(ctx context.Context) {
+	ticker := time.NewTicker(db.opts.DiskCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			db.checkDisk()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (db *Db) checkDisk() {
+	free, err := db.freeSpace(db.dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "disk watchdog: cannot check free space in %s: %v\n", db.dir, err)
+		return
+	}
+	if free < db.opts.CompactBelowFreeBytes && db.Stats().Segments > 1 {
+		fmt.Fprintf(os.Stderr, "disk watchdog: %d bytes free, merging segments\n", free)
+		if err := db.MergeSegments(); err != nil {
+			fmt.Fprintf(os.Stderr, "disk watchdog: merge failed: %v\n", err)
+		}
+		if free, err = db.freeSpace(db.dir); err != nil {
+			return
+		}
+	}
+	full := free < db.opts.CriticalFreeBytes
+	if db.diskFull.Swap(full) != full {
+		fmt.Fprintf(os.Stderr, "disk watchdog: %d bytes free, writes blocked: %t\n", free, full)
+	}
+}
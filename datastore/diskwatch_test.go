@@ -0,0 +1,51 @@
+package datastore
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDiskWatchdog(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir(), WithSegmentSize(60), WithDiskWatchdog(0, 1000, 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	free := uint64(1 << 30)
+	db.freeSpace = func(string) (uint64, error) { return free, nil }
+
+	for i := 0; i < 5; i++ {
+		if err := db.Put("key", strings.Repeat("x", 40)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.checkDisk()
+	if db.Stats().Merges != 0 {
+		t.Error("no merge expected with plenty of free space")
+	}
+
+	free = 500
+	db.checkDisk()
+	if db.Stats().Merges != 1 || db.Stats().Segments != 1 {
+		t.Errorf("expected low space to merge the segments, got %+v", db.Stats())
+	}
+	if err := db.Put("key", "value"); err != nil {
+		t.Errorf("writes must still succeed above the critical threshold: %v", err)
+	}
+
+	free = 50
+	db.checkDisk()
+	if err := db.Put("key", "value"); !errors.Is(err, ErrDiskFull) {
+		t.Errorf("expected ErrDiskFull, got %v", err)
+	}
+	if err := db.Ready(); !errors.Is(err, ErrDiskFull) {
+		t.Errorf("Ready: expected ErrDiskFull, got %v", err)
+	}
+
+	free = 1 << 30
+	db.checkDisk()
+	if err := db.Put("key", "value"); err != nil {
+		t.Errorf("writes must resume once space is freed: %v", err)
+	}
+}
@@ -5,46 +5,176 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"math"
 )
 
 // magic numbers
 const (
 	StrValType   byte = 0x01
 	Int64ValType byte = 0x02
+
+	// tombstoneValType marks a deleted key.
+	tombstoneValType byte = 0x03
+	// txValType records carry the encoded entries of a committed transaction
+	// as their value, so the whole transaction shares a single checksum.
+	txValType byte = 0x04
 )
 
 type entry struct {
 	key, value string
 	valueType  byte
+	// version and timestamp (Unix nanoseconds) are assigned by the io worker
+	// on write; entries written before versioning have neither.
+	version   uint64
+	timestamp int64
 }
 
-// 0           4    8     kl+8  kl+12   kl + vl + 12  <-- offset
-// (full size) (kl) (key) (vl)  (value) (type)
-// 4           4    ....  4     .....   1  <-- length
+// metaFlag is set in the value type of records whose value starts with the
+// version and timestamp of the entry, 8 bytes each.
+const (
+	metaFlag byte = 0x40
+	metaSize      = 16
+)
+
+// 0           4    8     kl+8  kl+12   kl+vl+12  kl+vl+13  <-- offset
+// (full size) (kl) (key) (vl)  (value) (type)    (crc32)
+// 4           4    ....  4     .....   1         4  <-- length
 //                                      256 types possible
+//
+// crc32 (IEEE) covers every byte of the record before it.
+
+const (
+	entryOverhead = 17 // 12 + 1 (type) + 4 (crc32)
+	maxRecordSize = math.MaxUint32
+)
+
+var errBadChecksum = fmt.Errorf("%w: checksum mismatch", ErrCorrupted)
 
 func (e *entry) Encode() []byte {
-	kl, vl := len(e.key), len(e.value)
-	size := kl + vl + 13 // 12 + 1 (type)
+	valueType, meta := e.valueType, 0
+	if e.version != 0 {
+		valueType |= metaFlag
+		meta = metaSize
+	}
+	kl, vl := len(e.key), len(e.value)+meta
+	size := kl + vl + entryOverhead
 	res := make([]byte, size)
 
 	binary.LittleEndian.PutUint32(res[0:], uint32(size))
 	binary.LittleEndian.PutUint32(res[4:], uint32(kl))
 	copy(res[8:], e.key)
 	binary.LittleEndian.PutUint32(res[kl+8:], uint32(vl))
-	copy(res[kl+12:], e.value)
-	res[kl+vl+12] = e.valueType
+	if meta != 0 {
+		binary.LittleEndian.PutUint64(res[kl+12:], e.version)
+		binary.LittleEndian.PutUint64(res[kl+20:], uint64(e.timestamp))
+	}
+	copy(res[kl+12+meta:], e.value)
+	res[kl+vl+12] = valueType
+	binary.LittleEndian.PutUint32(res[size-4:], crc32.ChecksumIEEE(res[:size-4]))
 
 	return res
 }
 
+// checkRecord validates the framing and checksum of a single encoded record.
+func checkRecord(input []byte) error {
+	if len(input) < entryOverhead {
+		return fmt.Errorf("%w: record too short: %d bytes", ErrCorrupted, len(input))
+	}
+	if size := int(binary.LittleEndian.Uint32(input)); size != len(input) {
+		return fmt.Errorf("%w: record size %d does not match frame of %d bytes", ErrCorrupted, size, len(input))
+	}
+	kl := int(binary.LittleEndian.Uint32(input[4:]))
+	if kl > len(input)-entryOverhead {
+		return fmt.Errorf("%w: key length %d exceeds record", ErrCorrupted, kl)
+	}
+	vl := int(binary.LittleEndian.Uint32(input[kl+8:]))
+	if kl+vl+entryOverhead != len(input) {
+		return fmt.Errorf("%w: key length %d and value length %d do not match record size %d", ErrCorrupted, kl, vl, len(input))
+	}
+	if crc32.ChecksumIEEE(input[:len(input)-4]) != binary.LittleEndian.Uint32(input[len(input)-4:]) {
+		return errBadChecksum
+	}
+	return nil
+}
+
+// txEntriesOffset is where the nested entries of a tx record start; tx
+// records always have an empty key.
+const txEntriesOffset = 12
+
+func encodeTx(entries []*entry) *entry {
+	var value []byte
+	for _, e := range entries {
+		value = append(value, e.Encode()...)
+	}
+	return &entry{value: string(value), valueType: txValType}
+}
+
+// expand calls fn with every entry stored in the record and its offset
+// relative to the start of the record: the nested entries for a tx record,
+// the entry itself otherwise.
+func (e *entry) expand(fn func(offset int64, e *entry)) error {
+	if e.valueType != txValType {
+		fn(0, e)
+		return nil
+	}
+	value := []byte(e.value)
+	var nested []*entry
+	var offsets []int64
+	for pos := 0; pos < len(value); {
+		if len(value)-pos < 4 {
+			return fmt.Errorf("%w: truncated entry in transaction", ErrCorrupted)
+		}
+		size := int(binary.LittleEndian.Uint32(value[pos:]))
+		if size < entryOverhead || size > len(value)-pos {
+			return fmt.Errorf("%w: invalid entry size %d in transaction", ErrCorrupted, size)
+		}
+		if err := checkRecord(value[pos : pos+size]); err != nil {
+			return err
+		}
+		var n entry
+		n.Decode(value[pos : pos+size])
+		if n.valueType == txValType {
+			return fmt.Errorf("%w: nested transaction record", ErrCorrupted)
+		}
+		nested = append(nested, &n)
+		offsets = append(offsets, int64(txEntriesOffset+pos))
+		pos += size
+	}
+	for i, n := range nested {
+		fn(offsets[i], n)
+	}
+	return nil
+}
+
+func encodeInt64(value int64) string {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, uint64(value))
+	return string(buf)
+}
+
+func decodeInt64(value string) (int64, error) {
+	if len(value) != 8 {
+		return 0, fmt.Errorf("%w: invalid int64 encoding", ErrCorrupted)
+	}
+	return int64(binary.LittleEndian.Uint64([]byte(value))), nil
+}
+
 func (e *entry) Decode(input []byte) {
 	kl := int(binary.LittleEndian.Uint32(input[4:]))
 	vl := int(binary.LittleEndian.Uint32(input[kl+8:]))
 	e.key = string(input[8 : 8+kl])
-	e.value = string(input[kl+12 : kl+12+vl])
+	value := input[kl+12 : kl+12+vl]
 	e.valueType = input[kl+vl+12]
+	e.version, e.timestamp = 0, 0
+	if e.valueType&metaFlag != 0 && len(value) >= metaSize {
+		e.version = binary.LittleEndian.Uint64(value)
+		e.timestamp = int64(binary.LittleEndian.Uint64(value[8:]))
+		value = value[metaSize:]
+		e.valueType &^= metaFlag
+	}
+	e.value = string(value)
 }
 
 func (e *entry) DecodeFromReader(in *bufio.Reader) (int, error) {
@@ -53,15 +183,21 @@ func (e *entry) DecodeFromReader(in *bufio.Reader) (int, error) {
 		if errors.Is(err, io.EOF) {
 			return 0, err
 		}
-		return 0, fmt.Errorf("cannot read size: %w", err)
+		return 0, fmt.Errorf("%w: cannot read size: %w", ErrCorrupted, err)
 	}
 
 	size := int(binary.LittleEndian.Uint32(sizeBuf))
+	if size < entryOverhead {
+		return 0, fmt.Errorf("%w: invalid record size %d", ErrCorrupted, size)
+	}
 	buf := make([]byte, size)
 
-	n, err := in.Read(buf)
+	n, err := io.ReadFull(in, buf)
 	if err != nil {
-		return n, fmt.Errorf("cannot read record: %w", err)
+		return n, fmt.Errorf("%w: cannot read record: %w", ErrCorrupted, err)
+	}
+	if err := checkRecord(buf); err != nil {
+		return n, err
 	}
 
 	e.Decode(buf)
@@ -7,7 +7,7 @@ import (
 )
 
 func TestEntry_Encode(t *testing.T) {
-	e := entry{"key", "value", StrValType}
+	e := entry{key: "key", value: "value", valueType: StrValType}
 	e.Decode(e.Encode())
 	if e.key != "key" {
 		t.Error("incorrect key")
@@ -21,7 +21,7 @@ func TestReadValue(t *testing.T) {
 	var (
 		a, b entry
 	)
-	a = entry{"key", "10", Int64ValType}
+	a = entry{key: "key", value: "10", valueType: Int64ValType}
 	originalBytes := a.Encode()
 
 	b.Decode(originalBytes)
@@ -43,3 +43,14 @@ func TestReadValue(t *testing.T) {
 		t.Errorf("DecodeFromReader() read %d bytes, expected %d", n, len(originalBytes))
 	}
 }
+
+func TestDecodeFromReader_Checksum(t *testing.T) {
+	e := entry{key: "key", value: "value", valueType: StrValType}
+	data := e.Encode()
+	data[len(data)-5] ^= 0xff
+
+	var got entry
+	if _, err := got.DecodeFromReader(bufio.NewReader(bytes.NewReader(data))); err == nil {
+		t.Error("expected checksum error for corrupted record")
+	}
+}
@@ -0,0 +1,52 @@
+package datastore
+
+import "errors"
+
+// Errors returned by Db operations. They are wrapped with details about the
+// failure, so callers should match them with errors.Is.
+var (
+	ErrNotFound        = errors.New("record does not exist")
+	ErrCorrupted       = errors.New("data corrupted")
+	ErrTypeMismatch    = errors.New("value type mismatch")
+	ErrReadOnly        = errors.New("database is read-only")
+	ErrTooLarge        = errors.New("record too large")
+	ErrQuotaExceeded   = errors.New("storage quota exceeded")
+	ErrTxDone          = errors.New("transaction already committed or rolled back")
+	ErrVersionMismatch = errors.New("version mismatch")
+	ErrDiskFull        = errors.New("disk space critically low")
+	ErrSegmentNotFound = errors.New("segment does not exist")
+
+	// ErrKeyTooLarge and ErrValueTooLarge are returned for writes above the
+	// MaxKeySize and MaxValueSize options or the limits of the segment
+	// format; both match ErrTooLarge as well.
+	ErrKeyTooLarge   error = sizeError("key too large")
+	ErrValueTooLarge error = sizeError("value too large")
+
+	// ErrBucketQuotaExceeded is returned for writes beyond the quota of a
+	// bucket (see Bucket.SetQuota); it matches ErrQuotaExceeded as well.
+	ErrBucketQuotaExceeded error = quotaError("bucket quota exceeded")
+)
+
+type sizeError string
+
+func (e sizeError) Error() string {
+	return string(e)
+}
+
+func (e sizeError) Is(target error) bool {
+	return target == ErrTooLarge
+}
+
+type quotaError string
+
+func (e quotaError) Error() string {
+	return string(e)
+}
+
+func (e quotaError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// errWriterStopped is returned by writes once the io worker has exited,
+// after Close or a crash.
+var errWriterStopped = errors.New("datastore writer is not running")
@@ -0,0 +1,58 @@
+package datastore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestErrors(t *testing.T) {
+	tmp := t.TempDir()
+	db, err := Open(tmp, 1*Mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Get("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(missing): expected ErrNotFound, got %v", err)
+	}
+
+	if err := db.Put("s", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetInt64("s"); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("GetInt64 on string: expected ErrTypeMismatch, got %v", err)
+	}
+	if _, err := db.Increment("s", 1); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("Increment on string: expected ErrTypeMismatch, got %v", err)
+	}
+
+	db.SetReadOnly(true)
+	if err := db.Put("s", "v2"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Put on read-only db: expected ErrReadOnly, got %v", err)
+	}
+	var b Batch
+	b.Put("s", "v3")
+	if err := db.WriteBatch(&b); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("WriteBatch on read-only db: expected ErrReadOnly, got %v", err)
+	}
+	db.SetReadOnly(false)
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(tmp, segmentFileName(0))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(tmp, 1*Mi); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("Open of corrupted segment: expected ErrCorrupted, got %v", err)
+	}
+}
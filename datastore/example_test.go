@@ -0,0 +1,95 @@
+package datastore_test
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+func Example() {
+	dir, err := os.MkdirTemp("", "datastore-example")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var store datastore.Store
+	store, err = datastore.Open(dir, 10*datastore.Mi)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := store.Put("greeting", "hello"); err != nil {
+		log.Fatal(err)
+	}
+	value, err := store.Get("greeting")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(value)
+
+	_ = store.Delete("greeting")
+	_, err = store.Get("greeting")
+	fmt.Println(err == datastore.ErrNotFound)
+	// Output:
+	// hello
+	// true
+}
+
+func ExampleDb_Scan() {
+	dir, err := os.MkdirTemp("", "datastore-example")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := datastore.Open(dir, 10*datastore.Mi)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, id := range []int64{10, 2, -1} {
+		key := datastore.IntKey("user-42", "order", id)
+		_ = db.Put(key.String(), fmt.Sprintf("order #%d", id))
+	}
+	_ = db.Put(datastore.Key{Namespace: "user-7", Type: "order", ID: "x"}.String(), "not listed")
+
+	prefix := datastore.KeyPrefix("user-42", "order")
+	_ = db.Scan(prefix, datastore.PrefixEnd(prefix), func(key, value string) error {
+		fmt.Println(value)
+		return nil
+	})
+	// Output:
+	// order #-1
+	// order #2
+	// order #10
+}
+
+func ExampleDb_Bucket() {
+	dir, err := os.MkdirTemp("", "datastore-example")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := datastore.Open(dir, 10*datastore.Mi)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	_ = db.Bucket("users").Put("42", "alice")
+	_ = db.Bucket("teams").Put("42", "backend")
+
+	user, _ := db.Bucket("users").Get("42")
+	team, _ := db.Bucket("teams").Get("42")
+	fmt.Println(user, team)
+	fmt.Println(db.Buckets())
+	// Output:
+	// alice backend
+	// [teams users]
+}
@@ -0,0 +1,344 @@
+package datastore
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// SegmentGarbage describes the entries of a segment that compaction would
+// drop.
+type SegmentGarbage struct {
+	ID int64
+	// Bytes is the size of the segment file.
+	Bytes   int64
+	Records int64
+	// Tombstones are the delete markers in the segment.
+	Tombstones int64
+	// Superseded are values overwritten or deleted by a later entry.
+	Superseded int64
+}
+
+// Live is the number of keys whose current value the segment holds.
+func (g SegmentGarbage) Live() int64 {
+	return g.Records - g.Tombstones - g.Superseded
+}
+
+// Ratio is the share of the segment's entries that are garbage.
+func (g SegmentGarbage) Ratio() float64 {
+	if g.Records == 0 {
+		return 0
+	}
+	return float64(g.Tombstones+g.Superseded) / float64(g.Records)
+}
+
+type purgeRequest struct {
+	threshold float64
+	purged    *int
+	respChan  chan error
+}
+
+type compactRequest struct {
+	id        int64
+	reclaimed *int64
+	respChan  chan error
+}
+
+// track counts an entry appended to the segment index; the caller holds
+// idxMu or owns the segment.
+func (s *segment) track(key string, tombstone bool) {
+	s.records++
+	if tombstone {
+		s.tombstones++
+		s.deadKeys[key] = struct{}{}
+	} else {
+		delete(s.deadKeys, key)
+	}
+}
+
+// countRecords fills the entry counts of a segment loaded from a hint.
+func (s *segment) countRecords() error {
+	f, err := os.Open(s.filePath)
+	if err != nil {
+		return fmt.Errorf("could not open segment file %s: %w", s.filePath, err)
+	}
+	defer f.Close()
+
+	var records, tombstones int64
+	dead := make(map[string]int64)
+	_, err = readRecords(f, func(offset int64, e *entry) error {
+		records++
+		if e.valueType == tombstoneValType {
+			tombstones++
+			dead[e.key] = offset
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("corrupt segment %s: %w", s.filePath, err)
+	}
+
+	s.idxMu.Lock()
+	defer s.idxMu.Unlock()
+	s.records, s.tombstones, s.deadKeys = records, tombstones, make(map[string]struct{})
+	for key, offset := range dead {
+		if s.index[key] == offset {
+			s.deadKeys[key] = struct{}{}
+		}
+	}
+	s.counted = true
+	return nil
+}
+
+// readRecords calls fn with every entry of a segment, transaction entries
+// one by one, and its offset. It returns the number of bytes read.
+func readRecords(r io.Reader, fn func(offset int64, e *entry) error) (int64, error) {
+	reader := bufio.NewReader(r)
+	var pos int64
+	for {
+		var rec entry
+		n, err := rec.DecodeFromReader(reader)
+		if errors.Is(err, io.EOF) {
+			return pos, nil
+		}
+		if err != nil {
+			return pos, err
+		}
+		var fnErr error
+		err = rec.expand(func(offset int64, e *entry) {
+			if fnErr == nil {
+				fnErr = fn(pos+offset, e)
+			}
+		})
+		if err != nil {
+			return pos, err
+		}
+		if fnErr != nil {
+			return pos, fnErr
+		}
+		pos += int64(n)
+	}
+}
+
+// Garbage reports the tombstones and superseded values of every segment,
+// oldest first. Segments loaded from hint files are read once to count
+// their entries.
+func (db *Db) Garbage() ([]SegmentGarbage, error) {
+	segments := db.acquireSegments()
+	defer releaseSegments(segments)
+	return garbageOf(segments)
+}
+
+func garbageOf(segments []*segment) ([]SegmentGarbage, error) {
+	for _, s := range segments {
+		s.idxMu.RLock()
+		counted := s.counted
+		s.idxMu.RUnlock()
+		if !counted {
+			if err := s.countRecords(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	garbage := make([]SegmentGarbage, len(segments))
+	// shadowed holds the keys written again by a newer segment.
+	shadowed := make(map[string]struct{})
+	for i := len(segments) - 1; i >= 0; i-- {
+		s := segments[i]
+		s.idxMu.RLock()
+		var live int64
+		for key := range s.index {
+			if _, ok := shadowed[key]; ok {
+				continue
+			}
+			shadowed[key] = struct{}{}
+			if _, dead := s.deadKeys[key]; !dead {
+				live++
+			}
+		}
+		garbage[i] = SegmentGarbage{
+			ID:         s.id,
+			Bytes:      s.offset,
+			Records:    s.records,
+			Tombstones: s.tombstones,
+			Superseded: s.records - s.tombstones - live,
+		}
+		s.idxMu.RUnlock()
+	}
+	return garbage, nil
+}
+
+// PurgeTombstones rewrites the sealed segments whose garbage ratio exceeds
+// threshold without their garbage and leaves the other segments alone,
+// which is cheaper than MergeSegments when the garbage is concentrated in a
+// few segments. Tombstones that still hide a value in an older segment are
+// kept. It returns the number of segments rewritten.
+func (db *Db) PurgeTombstones(threshold float64) (int, error) {
+	var purged int
+	respChan := make(chan error, 1)
+	err := send(context.Background(), db, db.purgeRequests, purgeRequest{
+		threshold: threshold,
+		purged:    &purged,
+		respChan:  respChan,
+	}, respChan)
+	return purged, err
+}
+
+// purge runs on the io worker. Segments are rewritten oldest first, so a
+// tombstone whose value an older rewrite dropped goes too.
+func (db *Db) purge(threshold float64) (purged int, err error) {
+	start, before := time.Now(), db.Usage()
+	defer func() {
+		if purged > 0 || err != nil {
+			db.recordCompaction("purge", start, before, err)
+		}
+	}()
+	db.segmentsMutex.RLock()
+	segments := slices.Clone(db.segments)
+	db.segmentsMutex.RUnlock()
+	garbage, err := garbageOf(segments)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, s := range segments {
+		g := garbage[i]
+		if s == db.activeSegment || g.Tombstones+g.Superseded == 0 || g.Ratio() <= threshold {
+			continue
+		}
+		if _, err := db.replaceSegment(segments, i); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// CompactSegment rewrites the sealed segment with the given ID without its
+// garbage, as PurgeTombstones does for the segments above its threshold, and
+// returns the number of bytes reclaimed. It fails with ErrSegmentNotFound for
+// an unknown ID and refuses to compact the active segment.
+func (db *Db) CompactSegment(id int64) (int64, error) {
+	var reclaimed int64
+	respChan := make(chan error, 1)
+	err := send(context.Background(), db, db.compactRequests, compactRequest{
+		id:        id,
+		reclaimed: &reclaimed,
+		respChan:  respChan,
+	}, respChan)
+	return reclaimed, err
+}
+
+// compactSegment runs on the io worker.
+func (db *Db) compactSegment(id int64) (int64, error) {
+	db.segmentsMutex.RLock()
+	segments := slices.Clone(db.segments)
+	db.segmentsMutex.RUnlock()
+	i := slices.IndexFunc(segments, func(s *segment) bool { return s.id == id })
+	if i < 0 {
+		return 0, fmt.Errorf("%w: %d", ErrSegmentNotFound, id)
+	}
+	if segments[i] == db.activeSegment {
+		return 0, fmt.Errorf("segment %d is active and cannot be compacted", id)
+	}
+	start, usage := time.Now(), db.Usage()
+	before := segments[i].offset
+	rewritten, err := db.replaceSegment(segments, i)
+	db.recordCompaction("segment", start, usage, err)
+	if err != nil {
+		return 0, err
+	}
+	return before - rewritten.offset, nil
+}
+
+// replaceSegment rewrites segments[i] without its garbage, puts the result
+// in its place both in segments and in the store, and releases the old one,
+// whose file is removed once no reader uses it.
+func (db *Db) replaceSegment(segments []*segment, i int) (*segment, error) {
+	s := segments[i]
+	rewritten, err := db.rewriteSegment(s, segments[:i], segments[i+1:])
+	if err != nil {
+		return nil, err
+	}
+	db.segmentsMutex.Lock()
+	if pos := slices.Index(db.segments, s); pos >= 0 {
+		db.segments[pos] = rewritten
+	}
+	db.segmentsMutex.Unlock()
+	segments[i] = rewritten
+	s.release()
+	return rewritten, nil
+}
+
+// rewriteSegment copies the entries of s that are still needed into the
+// next generation of the segment: the latest value of keys no newer segment
+// overwrites and the tombstones of keys an older segment holds.
+func (db *Db) rewriteSegment(s *segment, older, newer []*segment) (*segment, error) {
+	rewritten, _ := newSegment(db.dir, s.id)
+	rewritten.gen = s.gen + 1
+	rewritten.filePath = filepath.Join(db.dir, rewrittenFileName(s.id, rewritten.gen))
+
+	src, err := os.Open(s.filePath)
+	if err != nil {
+		return nil, fmt.Errorf("purge: could not open segment file %s: %w", s.filePath, err)
+	}
+	defer src.Close()
+	tmp := rewritten.filePath + ".tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("purge: %w", err)
+	}
+	defer os.Remove(tmp)
+	defer dst.Close()
+
+	out := bufio.NewWriter(dst)
+	s.idxMu.RLock()
+	_, err = readRecords(src, func(offset int64, e *entry) error {
+		if s.index[e.key] != offset || anyHolds(newer, e.key) {
+			return nil
+		}
+		tombstone := e.valueType == tombstoneValType
+		if tombstone && !anyHolds(older, e.key) {
+			return nil
+		}
+		rewritten.index[e.key] = rewritten.offset
+		rewritten.track(e.key, tombstone)
+		n, err := out.Write(e.Encode())
+		rewritten.offset += int64(n)
+		return err
+	})
+	s.idxMu.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("purge: could not rewrite segment %s: %w", s.filePath, err)
+	}
+	if err := out.Flush(); err != nil {
+		return nil, fmt.Errorf("purge: %w", err)
+	}
+	if err := dst.Sync(); err != nil {
+		return nil, fmt.Errorf("purge: %w", err)
+	}
+	if err := os.Rename(tmp, rewritten.filePath); err != nil {
+		return nil, fmt.Errorf("purge: %w", err)
+	}
+	rewritten.seal(db.opts.BloomBitsPerKey)
+	if err := rewritten.writeHint(); err != nil {
+		fmt.Fprintf(os.Stderr, "purge: failed to write hint for segment %s: %v\n", rewritten.filePath, err)
+	}
+	return rewritten, nil
+}
+
+func anyHolds(segments []*segment, key string) bool {
+	for _, s := range segments {
+		if _, ok := s.lookup(key); ok {
+			return true
+		}
+	}
+	return false
+}
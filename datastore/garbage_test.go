@@ -0,0 +1,168 @@
+package datastore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGarbage(t *testing.T) {
+	// Every write fills a segment of its own.
+	db, err := Open(t.TempDir(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	for _, put := range [][2]string{{"a", "1"}, {"a", "2"}, {"b", "1"}} {
+		if err := db.Put(put[0], put[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+
+	garbage, err := db.Garbage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SegmentGarbage{
+		{ID: 0, Bytes: 35, Records: 1, Superseded: 1},
+		{ID: 1, Bytes: 35, Records: 1},
+		{ID: 2, Bytes: 35, Records: 1, Superseded: 1},
+		{ID: 3, Bytes: 34, Records: 1, Tombstones: 1},
+		{ID: 4},
+	}
+	if !reflect.DeepEqual(garbage, want) {
+		t.Fatalf("unexpected garbage\n got %+v\nwant %+v", garbage, want)
+	}
+
+	// Stale copy of a segment left behind by a purge that did not finish.
+	stale, err := os.ReadFile(filepath.Join(db.dir, segmentFileName(2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	purged, err := db.PurgeTombstones(0.5)
+	if err != nil || purged != 3 {
+		t.Fatalf("PurgeTombstones purged %d segments, %v; want 3", purged, err)
+	}
+	if v, err := db.Get("a"); err != nil || v != "2" {
+		t.Errorf("Get(a) = %q, %v after purge", v, err)
+	}
+	if _, err := db.Get("b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(b) after purge: expected ErrNotFound, got %v", err)
+	}
+	if garbage, _ := db.Garbage(); garbage[3].Records != 0 {
+		t.Errorf("tombstone with no value left to hide was kept: %+v", garbage[3])
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(db.dir, segmentFileName(2)), stale, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(db.dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Get("b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(b) after reopen: expected ErrNotFound, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(db.dir, segmentFileName(2))); !os.IsNotExist(err) {
+		t.Errorf("superseded segment was not removed: %v", err)
+	}
+	if len(db.StartupReport().Orphans) != 0 {
+		t.Errorf("unexpected orphans %+v", db.StartupReport().Orphans)
+	}
+	if garbage, err := db.Garbage(); err != nil || len(garbage) != 5 {
+		t.Errorf("garbage after reopen: %+v, %v", garbage, err)
+	}
+}
+
+func TestPurgeTombstones_KeepsHidingTombstones(t *testing.T) {
+	db, err := Open(t.TempDir(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	var batch Batch
+	batch.Put("b", "1")
+	batch.Put("c", "1")
+	batch.Put("d", "1")
+	if err := db.WriteBatch(&batch); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the tombstone segment is over the threshold, and its tombstone
+	// still hides b in the first segment.
+	if purged, err := db.PurgeTombstones(0.5); err != nil || purged != 1 {
+		t.Fatalf("PurgeTombstones purged %d segments, %v; want 1", purged, err)
+	}
+	if _, err := db.Get("b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(b): expected ErrNotFound, got %v", err)
+	}
+	garbage, err := db.Garbage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if garbage[1].Tombstones != 1 {
+		t.Errorf("hiding tombstone was dropped: %+v", garbage[1])
+	}
+	if purged, err := db.PurgeTombstones(1); err != nil || purged != 0 {
+		t.Errorf("threshold 1 purged %d segments, %v", purged, err)
+	}
+}
+
+func TestCompactSegment(t *testing.T) {
+	db, err := Open(t.TempDir(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	for _, put := range [][2]string{{"a", "1"}, {"b", "1"}, {"a", "2"}} {
+		if err := db.Put(put[0], put[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	before := db.Stats().Bytes
+	reclaimed, err := db.CompactSegment(0)
+	if err != nil || reclaimed <= 0 {
+		t.Fatalf("CompactSegment(0) reclaimed %d bytes, %v", reclaimed, err)
+	}
+	if after := db.Stats().Bytes; before-after != reclaimed {
+		t.Errorf("store shrank by %d bytes, CompactSegment reported %d", before-after, reclaimed)
+	}
+	if reclaimed, err := db.CompactSegment(1); err != nil || reclaimed != 0 {
+		t.Errorf("CompactSegment(1) of a segment without garbage reclaimed %d bytes, %v", reclaimed, err)
+	}
+	for key, want := range map[string]string{"a": "2", "b": "1"} {
+		if v, err := db.Get(key); err != nil || v != want {
+			t.Errorf("Get(%s) = %q, %v after compaction; want %q", key, v, err, want)
+		}
+	}
+
+	if _, err := db.CompactSegment(42); !errors.Is(err, ErrSegmentNotFound) {
+		t.Errorf("CompactSegment of an unknown segment: expected ErrSegmentNotFound, got %v", err)
+	}
+	if _, err := db.CompactSegment(db.activeSegment.id); err == nil {
+		t.Error("expected compacting the active segment to fail")
+	}
+
+	history := db.Stats().Compactions
+	if len(history) != 2 || history[0].Kind != "segment" || history[0].Reclaimed != reclaimed || history[1].Reclaimed != 0 {
+		t.Errorf("unexpected compaction history %+v", history)
+	}
+}
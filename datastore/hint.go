@@ -0,0 +1,128 @@
+package datastore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+)
+
+// A hint file stores the index of a sealed segment so that recovery does not
+// have to decode the whole segment:
+//
+//	(kl) (key) (offset) ... (segment size) (segment tail) (crc32)
+//	4    ....  8            8              4              4
+//
+// The segment size and tail (the checksum of its last record) tie the hint
+// to the exact segment contents it was written for; the final crc32 covers
+// every byte of the hint before it.
+const (
+	hintSuffix     = ".hint"
+	hintFooterSize = 16
+)
+
+var errStaleHint = errors.New("hint does not match segment")
+
+func hintPath(segmentPath string) string {
+	return segmentPath + hintSuffix
+}
+
+// segmentTail returns the checksum of the last record of a segment of the
+// given size.
+func segmentTail(path string, size int64) (uint32, error) {
+	if size == 0 {
+		return 0, nil
+	}
+	if size < entryOverhead {
+		return 0, fmt.Errorf("%w: segment of %d bytes", errStaleHint, size)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	buf := make([]byte, 4)
+	if _, err := f.ReadAt(buf, size-4); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(buf), nil
+}
+
+// writeHint persists the index of a sealed segment next to it. The file is
+// written under a temporary name and renamed so a crash never leaves a
+// partial hint behind.
+func (s *segment) writeHint() error {
+	s.idxMu.RLock()
+	var buf []byte
+	for key, offset := range s.index {
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(key)))
+		buf = append(buf, key...)
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(offset))
+	}
+	size := s.offset
+	s.idxMu.RUnlock()
+
+	tail, err := segmentTail(s.filePath, size)
+	if err != nil {
+		return err
+	}
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(size))
+	buf = binary.LittleEndian.AppendUint32(buf, tail)
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+
+	tmp := hintPath(s.filePath) + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, hintPath(s.filePath))
+}
+
+// loadHint fills the segment index from its hint file after checking that
+// the hint matches a segment of the given size. It returns an error wrapping
+// os.ErrNotExist if there is no hint.
+func (s *segment) loadHint(size int64) error {
+	buf, err := os.ReadFile(hintPath(s.filePath))
+	if err != nil {
+		return err
+	}
+	if len(buf) < hintFooterSize {
+		return fmt.Errorf("%w: hint of %d bytes is truncated", errStaleHint, len(buf))
+	}
+	body, footer := buf[:len(buf)-hintFooterSize], buf[len(buf)-hintFooterSize:]
+	if crc32.ChecksumIEEE(buf[:len(buf)-4]) != binary.LittleEndian.Uint32(footer[12:]) {
+		return fmt.Errorf("%w: hint checksum mismatch", errStaleHint)
+	}
+	if hinted := int64(binary.LittleEndian.Uint64(footer)); hinted != size {
+		return fmt.Errorf("%w: hint is for %d bytes, segment has %d", errStaleHint, hinted, size)
+	}
+	tail, err := segmentTail(s.filePath, size)
+	if err != nil {
+		return err
+	}
+	if tail != binary.LittleEndian.Uint32(footer[8:]) {
+		return fmt.Errorf("%w: last record checksum differs", errStaleHint)
+	}
+
+	index := make(hashIndex)
+	for len(body) > 0 {
+		if len(body) < 4 {
+			return fmt.Errorf("%w: truncated hint entry", errStaleHint)
+		}
+		kl := int(binary.LittleEndian.Uint32(body))
+		if kl > len(body)-12 {
+			return fmt.Errorf("%w: truncated hint entry", errStaleHint)
+		}
+		key := string(body[4 : 4+kl])
+		offset := int64(binary.LittleEndian.Uint64(body[4+kl:]))
+		if offset < 0 || offset+entryOverhead > size {
+			return fmt.Errorf("%w: offset %d of key %q is out of range", errStaleHint, offset, key)
+		}
+		index[key] = offset
+		body = body[12+kl:]
+	}
+	s.index = index
+	s.offset = size
+	s.counted = false
+	return nil
+}
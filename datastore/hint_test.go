@@ -0,0 +1,71 @@
+package datastore
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestHints(t *testing.T) {
+	tmp := t.TempDir()
+	db, err := Open(tmp, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pairs := map[string]string{"a": "1111111111", "b": "2222222222", "c": "3333333333", "d": "4444444444"}
+	for k, v := range pairs {
+		if err := db.Put(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sealed := db.segments[0]
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(hintPath(sealed.filePath)); err != nil {
+		t.Fatalf("expected a hint for the sealed segment: %v", err)
+	}
+
+	fromHint, _ := newSegment(tmp, sealed.id)
+	info, err := os.Stat(sealed.filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fromHint.loadHint(info.Size()); err != nil {
+		t.Fatalf("loadHint: %v", err)
+	}
+	scanned, _ := newSegment(tmp, sealed.id)
+	if err := scanned.scan(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fromHint.index, scanned.index) || fromHint.offset != scanned.offset {
+		t.Errorf("hint index %v differs from scanned index %v", fromHint.index, scanned.index)
+	}
+
+	// Appending to the sealed segment makes its hint stale.
+	extra := entry{key: "a", value: "stale hint", valueType: StrValType}
+	f, err := os.OpenFile(sealed.filePath, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(extra.Encode()); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if err := fromHint.loadHint(info.Size() + int64(len(extra.Encode()))); err == nil {
+		t.Error("expected loadHint to reject a hint for a different segment size")
+	}
+
+	db, err = Open(tmp, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	// The index of the segment must have been rebuilt from its records.
+	if got := db.segments[0].index["a"]; got != info.Size() {
+		t.Errorf("offset of the appended record = %d, want %d", got, info.Size())
+	}
+}
@@ -0,0 +1,120 @@
+package datastore
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// KeySeparator separates the components of a composite key.
+const KeySeparator = ":"
+
+// Key is a composite key of the form namespace:type:id, so that higher
+// layers can model one-to-many relationships, e.g. the orders of a user as
+// Key{"user-42", "order", id}, and list them by the prefix
+// KeyPrefix("user-42", "order").
+//
+// The encoding keeps key order useful for range scans: keys sharing leading
+// components share a string prefix, so they are contiguous in key order,
+// and the IDs of one namespace and type sort like their values unless they
+// hold escaped characters. Integer IDs from IntKey sort numerically.
+type Key struct {
+	Namespace string
+	Type      string
+	ID        string
+}
+
+// IntKey builds a key whose ID is an integer encoded to sort numerically,
+// negative IDs included.
+func IntKey(namespace, typ string, id int64) Key {
+	return Key{namespace, typ, fmt.Sprintf("%020d", uint64(id)^1<<63)}
+}
+
+// IntID decodes the ID of a key built by IntKey.
+func (k Key) IntID() (int64, error) {
+	if len(k.ID) != 20 {
+		return 0, fmt.Errorf("id %q is not an encoded integer", k.ID)
+	}
+	u, err := strconv.ParseUint(k.ID, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("id %q is not an encoded integer", k.ID)
+	}
+	return int64(u ^ 1<<63), nil
+}
+
+// String encodes the key. The separator and the escape character are
+// escaped within the components, so any component round-trips through
+// ParseKey.
+func (k Key) String() string {
+	return KeyPrefix(k.Namespace, k.Type) + escapeKeyComponent(k.ID)
+}
+
+// ParseKey decodes a key encoded by Key.String.
+func ParseKey(s string) (Key, error) {
+	parts := strings.Split(s, KeySeparator)
+	if len(parts) != 3 {
+		return Key{}, fmt.Errorf("key %q does not have the form namespace:type:id", s)
+	}
+	for i, part := range parts {
+		unescaped, err := unescapeKeyComponent(part)
+		if err != nil {
+			return Key{}, fmt.Errorf("key %q: %w", s, err)
+		}
+		parts[i] = unescaped
+	}
+	return Key{parts[0], parts[1], parts[2]}, nil
+}
+
+// KeyPrefix returns the prefix shared by the encoded keys whose leading
+// components are the given ones, e.g. every key of a namespace and type.
+func KeyPrefix(components ...string) string {
+	var b strings.Builder
+	for _, c := range components {
+		b.WriteString(escapeKeyComponent(c))
+		b.WriteString(KeySeparator)
+	}
+	return b.String()
+}
+
+// PrefixEnd returns the smallest key greater than every key that starts
+// with prefix, the exclusive end of a range scan over the prefix, or "" if
+// there is none.
+func PrefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
+
+var keyEscaper = strings.NewReplacer("%", "%25", KeySeparator, "%3A")
+
+func escapeKeyComponent(c string) string {
+	return keyEscaper.Replace(c)
+}
+
+func unescapeKeyComponent(c string) (string, error) {
+	if !strings.Contains(c, "%") {
+		return c, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(c); i++ {
+		if c[i] != '%' {
+			b.WriteByte(c[i])
+			continue
+		}
+		switch {
+		case strings.HasPrefix(c[i:], "%25"):
+			b.WriteByte('%')
+		case strings.HasPrefix(c[i:], "%3A"):
+			b.WriteString(KeySeparator)
+		default:
+			return "", fmt.Errorf("invalid escape at %d", i)
+		}
+		i += 2
+	}
+	return b.String(), nil
+}
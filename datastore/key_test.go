@@ -0,0 +1,55 @@
+package datastore
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestKey(t *testing.T) {
+	for _, k := range []Key{
+		{"user-42", "order", "a1"},
+		{"ns:with:colons", "100%", "id:%3A"},
+		{"", "", ""},
+	} {
+		got, err := ParseKey(k.String())
+		if err != nil || got != k {
+			t.Errorf("ParseKey(%q) = %+v, %v; want %+v", k.String(), got, err, k)
+		}
+	}
+	for _, bad := range []string{"a:b", "a:b:c:d", "a:b:%zz"} {
+		if _, err := ParseKey(bad); err == nil {
+			t.Errorf("ParseKey(%q) succeeded", bad)
+		}
+	}
+
+	ids := []int64{math.MinInt64, -10, -1, 0, 1, 9, 10, 1000, math.MaxInt64}
+	var encoded []string
+	for _, id := range ids {
+		k := IntKey("user-42", "order", id)
+		if got, err := k.IntID(); err != nil || got != id {
+			t.Errorf("IntID of %d = %d, %v", id, got, err)
+		}
+		encoded = append(encoded, k.String())
+	}
+	if !slices.IsSorted(encoded) {
+		t.Errorf("integer keys do not sort numerically: %q", encoded)
+	}
+
+	prefix := KeyPrefix("user-42", "order")
+	end := PrefixEnd(prefix)
+	for _, key := range encoded {
+		if !strings.HasPrefix(key, prefix) || key >= end {
+			t.Errorf("%q is outside [%q, %q)", key, prefix, end)
+		}
+	}
+	for _, other := range []Key{{"user-42", "orders", "1"}, {"user-43", "order", "1"}, {"user-4", "order", "1"}} {
+		if s := other.String(); s >= prefix && s < end {
+			t.Errorf("%q falls in the range of %q", s, prefix)
+		}
+	}
+	if got := PrefixEnd("a\xff\xff"); got != "b" {
+		t.Errorf("PrefixEnd carried to %q, want b", got)
+	}
+}
@@ -0,0 +1,157 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// TaskState is the state of a background task run by the store.
+type TaskState string
+
+const (
+	TaskRunning    TaskState = "running"
+	TaskRestarting TaskState = "restarting"
+	TaskStopped    TaskState = "stopped"
+	// TaskFailed tasks panicked and are not restarted.
+	TaskFailed TaskState = "failed"
+)
+
+// TaskStatus describes a background task, as reported in Stats.
+type TaskStatus struct {
+	Name      string
+	State     TaskState
+	Restarts  int
+	LastPanic string
+}
+
+// taskRestartDelay is how long a task that panicked waits before it is
+// restarted.
+var taskRestartDelay = time.Second
+
+// lifecycle owns the background goroutines of a store. Every task gets a
+// context that is cancelled by stop, and stop returns once all tasks have
+// returned, so nothing keeps running after Close.
+type lifecycle struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu    sync.Mutex
+	tasks []*TaskStatus
+}
+
+func newLifecycle() *lifecycle {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &lifecycle{ctx: ctx, cancel: cancel}
+}
+
+// start runs fn in a new goroutine until the lifecycle is stopped. A panic
+// in fn is recovered and logged; fn is then restarted after
+// taskRestartDelay if restart is set, or the task is marked failed.
+func (l *lifecycle) start(name string, restart bool, fn func(ctx context.Context)) {
+	status := &TaskStatus{Name: name, State: TaskRunning}
+	l.mu.Lock()
+	l.tasks = append(l.tasks, status)
+	l.mu.Unlock()
+
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		for {
+			recovered := l.runOnce(name, fn)
+			l.setState(status, recovered, restart)
+			if recovered == "" || !restart || l.ctx.Err() != nil {
+				return
+			}
+			select {
+			case <-time.After(taskRestartDelay):
+			case <-l.ctx.Done():
+				l.setState(status, "", restart)
+				return
+			}
+			l.mu.Lock()
+			status.State = TaskRunning
+			status.Restarts++
+			l.mu.Unlock()
+		}
+	}()
+}
+
+// runOnce calls fn and returns the recovered panic value, if any.
+func (l *lifecycle) runOnce(name string, fn func(ctx context.Context)) (recovered string) {
+	defer func() {
+		if v := recover(); v != nil {
+			recovered = fmt.Sprint(v)
+			fmt.Fprintf(os.Stderr, "task %s panicked: %v\n%s", name, v, debug.Stack())
+		}
+	}()
+	fn(l.ctx)
+	return ""
+}
+
+func (l *lifecycle) setState(status *TaskStatus, recovered string, restart bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch {
+	case recovered == "":
+		status.State = TaskStopped
+	case restart && l.ctx.Err() == nil:
+		status.State = TaskRestarting
+		status.LastPanic = recovered
+	default:
+		status.State = TaskFailed
+		status.LastPanic = recovered
+	}
+}
+
+// stop cancels every task and waits for them to return.
+func (l *lifecycle) stop() {
+	l.cancel()
+	l.wg.Wait()
+}
+
+func (l *lifecycle) status() []TaskStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	tasks := make([]TaskStatus, len(l.tasks))
+	for i, t := range l.tasks {
+		tasks[i] = *t
+	}
+	return tasks
+}
+
+// Go runs fn in the background until the store is closed; Close cancels ctx
+// and waits for fn to return. A panic in fn is recovered and fn is started
+// again. The task is listed in Stats under name.
+func (db *Db) Go(name string, fn func(ctx context.Context)) {
+	db.tasks.start(name, true, fn)
+}
+
+// syncer fsyncs the active segment every SyncInterval, bounding what a crash
+// can lose without paying for a sync on every write.
+func (db *Db) syncer(ctx context.Context) {
+	ticker := time.NewTicker(db.opts.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			respChan := make(chan error, 1)
+			select {
+			case db.syncRequests <- syncRequest{respChan: respChan}:
+			case <-db.stopped:
+				return
+			case <-ctx.Done():
+				return
+			}
+			if err := <-respChan; err != nil {
+				fmt.Fprintf(os.Stderr, "syncer: %v\n", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package datastore
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func taskStatus(db *Db, name string) (TaskStatus, bool) {
+	for _, task := range db.Stats().Tasks {
+		if task.Name == name {
+			return task, true
+		}
+	}
+	return TaskStatus{}, false
+}
+
+func TestTasks_RestartAfterPanic(t *testing.T) {
+	defer func(d time.Duration) { taskRestartDelay = d }(taskRestartDelay)
+	taskRestartDelay = time.Millisecond
+
+	db, err := OpenWithOptions(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var runs atomic.Int32
+	restarted := make(chan struct{})
+	db.Go("flaky", func(ctx context.Context) {
+		if runs.Add(1) == 1 {
+			panic("boom")
+		}
+		close(restarted)
+		<-ctx.Done()
+	})
+	select {
+	case <-restarted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("task was not restarted after a panic")
+	}
+
+	status, ok := taskStatus(db, "flaky")
+	if !ok {
+		t.Fatalf("task missing from stats: %+v", db.Stats().Tasks)
+	}
+	if status.State != TaskRunning || status.Restarts != 1 || status.LastPanic != "boom" {
+		t.Errorf("unexpected status after restart: %+v", status)
+	}
+	if err := db.Put("key", "value"); err != nil {
+		t.Errorf("store must keep working: %v", err)
+	}
+}
+
+func TestTasks_CloseStopsAll(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir(), WithSyncInterval(time.Millisecond), WithDiskWatchdog(time.Hour, 0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var returned atomic.Bool
+	db.Go("waiter", func(ctx context.Context) {
+		<-ctx.Done()
+		returned.Store(true)
+	})
+	if err := db.Put("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	names := map[string]bool{}
+	for _, task := range db.Stats().Tasks {
+		names[task.Name] = true
+		if task.State != TaskRunning {
+			t.Errorf("expected %s to be running, got %+v", task.Name, task)
+		}
+	}
+	for _, name := range []string{"io-worker", "disk-watchdog", "syncer", "waiter"} {
+		if !names[name] {
+			t.Errorf("task %s missing from stats", name)
+		}
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !returned.Load() {
+		t.Error("Close returned before the task stopped")
+	}
+	for _, task := range db.Stats().Tasks {
+		if task.State != TaskStopped {
+			t.Errorf("expected %s to be stopped after Close, got %+v", task.Name, task)
+		}
+	}
+}
+
+func TestTasks_FailedWorker(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// A nil update function panics on the io worker, which is not restarted.
+	respChan := make(chan error, 1)
+	db.updateRequests <- updateRequest{key: "key", respChan: respChan}
+	<-db.stopped
+
+	var status TaskStatus
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if status, _ = taskStatus(db, "io-worker"); status.State != TaskRunning {
+			break
+		}
+	}
+	if status.State != TaskFailed || status.LastPanic == "" {
+		t.Errorf("expected the io worker to be failed, got %+v", status)
+	}
+	if err := db.Ready(); err == nil {
+		t.Error("expected Ready to report the stopped writer")
+	}
+}
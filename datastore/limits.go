@@ -0,0 +1,60 @@
+package datastore
+
+import "fmt"
+
+// formatMaxSize bounds keys and values alike: the segment format stores the
+// size of a whole record, version included, in 32 bits.
+const formatMaxSize = maxRecordSize - entryOverhead - metaSize
+
+// keyLimit and valueLimit are the sizes above which client writes fail.
+func (db *Db) keyLimit() int64 {
+	if db.opts.MaxKeySize > 0 {
+		return min(int64(db.opts.MaxKeySize), formatMaxSize)
+	}
+	return formatMaxSize
+}
+
+func (db *Db) valueLimit() int64 {
+	if db.opts.MaxValueSize > 0 {
+		return min(db.opts.MaxValueSize, formatMaxSize)
+	}
+	return formatMaxSize
+}
+
+// checkSize checks a key and a value length against the limits.
+func (db *Db) checkSize(key string, valueSize int64) error {
+	if limit := db.keyLimit(); int64(len(key)) > limit {
+		return fmt.Errorf("%w: key of %d bytes exceeds %d bytes", ErrKeyTooLarge, len(key), limit)
+	}
+	if limit := db.valueLimit(); valueSize > limit {
+		return fmt.Errorf("%w: value of key %.64q has %d bytes, limit is %d", ErrValueTooLarge, key, valueSize, limit)
+	}
+	return nil
+}
+
+// checkLimits checks every entry, transaction entries one by one.
+func (db *Db) checkLimits(entries []*entry) error {
+	var err error
+	for _, e := range entries {
+		expandErr := e.expand(func(_ int64, e *entry) {
+			if err == nil {
+				err = db.checkSize(e.key, int64(len(e.value)))
+			}
+		})
+		if expandErr != nil {
+			return expandErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkWrite is called by the io worker before appending client entries.
+func (db *Db) checkWrite(entries []*entry) error {
+	if err := db.checkLimits(entries); err != nil {
+		return err
+	}
+	return db.checkQuota(entries)
+}
@@ -0,0 +1,74 @@
+package datastore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSizeLimits(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir(), WithMaxKeySize(8), WithMaxValueSize(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	longKey, longValue := strings.Repeat("k", 9), strings.Repeat("v", 17)
+
+	if err := db.Put("key", strings.Repeat("v", 16)); err != nil {
+		t.Fatalf("Put at the limits: %v", err)
+	}
+	if err := db.Put(longKey, "v"); !errors.Is(err, ErrKeyTooLarge) || !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Put with a long key: expected ErrKeyTooLarge, got %v", err)
+	}
+	if err := db.Put("key", longValue); !errors.Is(err, ErrValueTooLarge) || !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Put with a long value: expected ErrValueTooLarge, got %v", err)
+	}
+	if _, err := db.CompareAndSwap("key", strings.Repeat("v", 16), longValue); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("CompareAndSwap to a long value: expected ErrValueTooLarge, got %v", err)
+	}
+	if err := db.PutStream(context.Background(), "key", strings.NewReader(longValue)); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("PutStream with a long value: expected ErrValueTooLarge, got %v", err)
+	}
+
+	var b Batch
+	b.Put("a", "1")
+	b.Put(longKey, "2")
+	if err := db.WriteBatch(&b); !errors.Is(err, ErrKeyTooLarge) {
+		t.Errorf("WriteBatch with a long key: expected ErrKeyTooLarge, got %v", err)
+	}
+	tx := db.Begin()
+	_ = tx.Put("a", "1")
+	_ = tx.Put("b", longValue)
+	if err := tx.Commit(); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("Commit with a long value: expected ErrValueTooLarge, got %v", err)
+	}
+	if _, err := db.Get("a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("rejected writes were partially applied: %v", err)
+	}
+
+	// Replicated entries are applied whatever the limits.
+	primary, err := Open(t.TempDir(), Mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = primary.Close()
+	})
+	if err := primary.Put(longKey, longValue); err != nil {
+		t.Fatal(err)
+	}
+	var snapshot bytes.Buffer
+	if err := primary.Snapshot(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ApplyStream(&snapshot); err != nil {
+		t.Fatalf("ApplyStream: %v", err)
+	}
+	if got, err := db.Get(longKey); err != nil || got != longValue {
+		t.Errorf("replicated Get = %q, %v", got, err)
+	}
+}
@@ -0,0 +1,132 @@
+package datastore
+
+import "time"
+
+// SyncPolicy controls when appended records are flushed to stable storage.
+type SyncPolicy int
+
+const (
+	// SyncNever leaves flushing to the operating system.
+	SyncNever SyncPolicy = iota
+	// SyncAlways fsyncs the active segment after every write, before the
+	// write is acknowledged. Puts that queue up while one is written are
+	// committed together with a single fsync.
+	SyncAlways
+)
+
+// Options configure a store opened with OpenWithOptions.
+type Options struct {
+	// SegmentSize is the size after which the active segment is sealed and
+	// a new one is started.
+	SegmentSize int64
+	Sync        SyncPolicy
+	// SyncInterval fsyncs the active segment in the background this often;
+	// zero disables it. It bounds what SyncNever can lose in a crash.
+	SyncInterval time.Duration
+	// CompressMinSize enables flate compression of string values of at
+	// least this many bytes; zero disables compression. Stores always read
+	// compressed values, whatever the option.
+	CompressMinSize int
+	// CacheSize is the number of recently read values kept in memory; zero
+	// disables the cache.
+	CacheSize int
+	// CompactionSegments merges the segments automatically once there are
+	// this many of them; zero leaves merging to MergeSegments.
+	CompactionSegments int
+	// DiskCheckInterval enables a background check of the free space in the
+	// store directory. Below CompactBelowFreeBytes the segments are merged;
+	// below CriticalFreeBytes client writes fail with ErrDiskFull until
+	// space is freed.
+	DiskCheckInterval     time.Duration
+	CompactBelowFreeBytes uint64
+	CriticalFreeBytes     uint64
+	// ReadOnly opens the segments for reading only: all writes, including
+	// replicated ones, and merges fail with ErrReadOnly, and nothing in the
+	// directory is created or modified.
+	ReadOnly bool
+	// QuarantineOrphans moves files that are neither segments nor hints into
+	// the quarantine subdirectory on open. Read-only stores only report them.
+	QuarantineOrphans bool
+	// RecoveryWorkers bounds how many segments are indexed concurrently on
+	// open; zero uses GOMAXPROCS and 1 recovers them one at a time.
+	RecoveryWorkers int
+	// MaxKeySize and MaxValueSize limit client writes, which fail with
+	// ErrKeyTooLarge or ErrValueTooLarge above them; zero leaves only the
+	// limits of the segment format. Replicated entries are not limited.
+	MaxKeySize   int
+	MaxValueSize int64
+	// BloomBitsPerKey sizes the bloom filter built for every sealed
+	// segment, which lets Get skip segments that cannot hold a key. More
+	// bits mean fewer false positives and more memory; zero builds no
+	// filters and looks every key up in the segment indexes.
+	BloomBitsPerKey int
+}
+
+// DefaultOptions are used for every option not given to OpenWithOptions.
+var DefaultOptions = Options{
+	SegmentSize:     10 * Mi,
+	BloomBitsPerKey: bloomBitsPerKey,
+}
+
+type Option func(*Options)
+
+func WithSegmentSize(size int64) Option {
+	return func(o *Options) { o.SegmentSize = size }
+}
+
+func WithSync(policy SyncPolicy) Option {
+	return func(o *Options) { o.Sync = policy }
+}
+
+func WithSyncInterval(interval time.Duration) Option {
+	return func(o *Options) { o.SyncInterval = interval }
+}
+
+func WithCompression(minSize int) Option {
+	return func(o *Options) { o.CompressMinSize = minSize }
+}
+
+func WithCacheSize(entries int) Option {
+	return func(o *Options) { o.CacheSize = entries }
+}
+
+func WithCompactionThreshold(segments int) Option {
+	return func(o *Options) { o.CompactionSegments = segments }
+}
+
+func WithDiskWatchdog(interval time.Duration, compactBelow, critical uint64) Option {
+	return func(o *Options) {
+		o.DiskCheckInterval = interval
+		o.CompactBelowFreeBytes = compactBelow
+		o.CriticalFreeBytes = critical
+	}
+}
+
+func WithReadOnly() Option {
+	return func(o *Options) { o.ReadOnly = true }
+}
+
+func WithQuarantine() Option {
+	return func(o *Options) { o.QuarantineOrphans = true }
+}
+
+func WithRecoveryWorkers(workers int) Option {
+	return func(o *Options) { o.RecoveryWorkers = workers }
+}
+
+func WithMaxKeySize(bytes int) Option {
+	return func(o *Options) { o.MaxKeySize = bytes }
+}
+
+func WithMaxValueSize(bytes int64) Option {
+	return func(o *Options) { o.MaxValueSize = bytes }
+}
+
+func WithBloomFilter(bitsPerKey int) Option {
+	return func(o *Options) { o.BloomBitsPerKey = bitsPerKey }
+}
+
+// WithOptions replaces all options at once.
+func WithOptions(opts Options) Option {
+	return func(o *Options) { *o = opts }
+}
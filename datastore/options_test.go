@@ -0,0 +1,247 @@
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOpenWithOptions_InvalidSegmentSize(t *testing.T) {
+	if _, err := OpenWithOptions(t.TempDir(), WithSegmentSize(0)); err == nil {
+		t.Error("expected an error for a zero segment size")
+	}
+}
+
+func TestOpenWithOptions_Compression(t *testing.T) {
+	tmp := t.TempDir()
+	db, err := OpenWithOptions(tmp, WithCompression(64), WithSync(SyncAlways))
+	if err != nil {
+		t.Fatal(err)
+	}
+	large := strings.Repeat("compressible ", 100)
+	if err := db.Put("large", large); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("small", "tiny"); err != nil {
+		t.Fatal(err)
+	}
+	if usage := db.Usage(); usage >= int64(len(large)) {
+		t.Errorf("expected the large value to be stored compressed, segment has %d bytes", usage)
+	}
+	db.Close()
+
+	// Compressed values stay readable without the option.
+	db, err = Open(tmp, Mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if got, err := db.Get("large"); err != nil || got != large {
+		t.Errorf("Get(large) after reopen: %v", err)
+	}
+	if got, err := db.Get("small"); err != nil || got != "tiny" {
+		t.Errorf("Get(small) = %q, %v", got, err)
+	}
+	if got, err := db.GetMany([]string{"large"}); err != nil || got["large"] != large {
+		t.Errorf("GetMany(large) after reopen: %v", err)
+	}
+}
+
+func TestOpenWithOptions_Cache(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir(), WithCacheSize(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := db.Put(fmt.Sprintf("k%d", i), "v1"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Get(fmt.Sprintf("k%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(db.cache.items) != 2 {
+		t.Errorf("expected the cache to hold 2 values, got %d", len(db.cache.items))
+	}
+
+	if err := db.Put("k2", "v2"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := db.Get("k2"); got != "v2" {
+		t.Errorf("expected the cached value to be replaced by a write, got %q", got)
+	}
+	if err := db.Delete("k2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Get("k2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound after delete, got %v", err)
+	}
+}
+
+func TestOpenWithOptions_CompactionThreshold(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir(), WithSegmentSize(50), WithCompactionThreshold(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 20; i++ {
+		if err := db.Put("key", fmt.Sprintf("value-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+		// The ping is served after the worker is done with the previous write.
+		if err := db.Ready(); err != nil {
+			t.Fatal(err)
+		}
+		if segments := db.Stats().Segments; segments >= 3 {
+			t.Fatalf("expected segments to be merged before reaching 3, got %d", segments)
+		}
+	}
+	if db.Stats().Merges == 0 {
+		t.Error("expected automatic merges")
+	}
+	if got, _ := db.Get("key"); got != "value-19" {
+		t.Errorf("expected the latest value, got %q", got)
+	}
+}
+
+func TestOpenWithOptions_ReadOnly(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir(), WithReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Put("k", "v"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly, got %v", err)
+	}
+}
+
+func TestOpenWithOptions_ReadOnlyDir(t *testing.T) {
+	tmp := t.TempDir()
+	db, err := Open(tmp, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := db.Put(fmt.Sprintf("key-%d", i), "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+	before := dirListing(t, tmp)
+
+	db, err = OpenWithOptions(tmp, WithReadOnly(), WithCompactionThreshold(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.Get("key-3"); err != nil || got != "value" {
+		t.Errorf("Get(key-3) = %q, %v", got, err)
+	}
+	db.SetReadOnly(false)
+	if err := db.Put("key", "value"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Put: expected ErrReadOnly, got %v", err)
+	}
+	var snapshot strings.Builder
+	if err := db.Snapshot(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ApplyStream(strings.NewReader(snapshot.String())); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("ApplyStream: expected ErrReadOnly, got %v", err)
+	}
+	if err := db.MergeSegments(); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("MergeSegments: expected ErrReadOnly, got %v", err)
+	}
+	if err := db.Ready(); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Ready: expected ErrReadOnly, got %v", err)
+	}
+	db.Close()
+
+	if after := dirListing(t, tmp); after != before {
+		t.Errorf("read-only store modified its directory:\nbefore %s\nafter  %s", before, after)
+	}
+	if _, err := OpenWithOptions(filepath.Join(tmp, "missing"), WithReadOnly()); err == nil {
+		t.Error("expected an error for a missing directory")
+	}
+}
+
+func dirListing(t *testing.T, dir string) string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	for _, e := range entries {
+		info, _ := e.Info()
+		fmt.Fprintf(&b, "%s:%d ", e.Name(), info.Size())
+	}
+	return b.String()
+}
+
+func TestOpenWithOptions_RecoveryWorkers(t *testing.T) {
+	tmp := t.TempDir()
+	db, err := OpenWithOptions(tmp, WithSegmentSize(4096))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2000; i++ {
+		key := fmt.Sprintf("key-%d", i%300)
+		if i%11 == 10 {
+			err = db.Delete(key)
+		} else {
+			err = db.Put(key, fmt.Sprintf("value-%d", i))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	// Drop every other hint, so recovery both loads hints and scans.
+	hints, _ := filepath.Glob(filepath.Join(tmp, "*.hint"))
+	if len(hints) < 4 {
+		t.Fatalf("expected several sealed segments, got %d hints", len(hints))
+	}
+	for i := 0; i < len(hints); i += 2 {
+		if err := os.Remove(hints[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	open := func(workers int) *Db {
+		db, err := OpenWithOptions(tmp, WithSegmentSize(4096), WithReadOnly(), WithRecoveryWorkers(workers))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = db.Close() })
+		return db
+	}
+	sequential, parallel := open(1), open(8)
+	if len(sequential.segments) != len(parallel.segments) {
+		t.Fatalf("recovered %d segments sequentially, %d in parallel", len(sequential.segments), len(parallel.segments))
+	}
+	for i, seg := range sequential.segments {
+		other := parallel.segments[i]
+		if seg.id != other.id || seg.offset != other.offset || !reflect.DeepEqual(seg.index, other.index) {
+			t.Errorf("segment %d differs: id %d/%d, offset %d/%d", i, seg.id, other.id, seg.offset, other.offset)
+		}
+	}
+	if sequential.lastVersion != parallel.lastVersion || sequential.nextSegmentID != parallel.nextSegmentID {
+		t.Errorf("versions differ: last %d/%d, next segment %d/%d",
+			sequential.lastVersion, parallel.lastVersion, sequential.nextSegmentID, parallel.nextSegmentID)
+	}
+	for i := 0; i < 300; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		want, wantErr := sequential.Get(key)
+		got, err := parallel.Get(key)
+		if got != want || !errors.Is(err, wantErr) {
+			t.Errorf("%s: parallel recovery read %q, %v; sequential %q, %v", key, got, err, want, wantErr)
+		}
+	}
+}
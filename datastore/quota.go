@@ -0,0 +1,153 @@
+package datastore
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SetQuota limits the total size of all segments to the given number of
+// bytes; zero disables the limit. Writes that would exceed it fail with
+// ErrQuotaExceeded until a merge reclaims space. Entries applied from a
+// replication stream are not limited so replicas never diverge.
+func (db *Db) SetQuota(bytes int64) {
+	db.quota.Store(bytes)
+}
+
+// Usage reports the number of bytes occupied by all segments.
+func (db *Db) Usage() int64 {
+	db.segmentsMutex.RLock()
+	defer db.segmentsMutex.RUnlock()
+	return db.usageLocked()
+}
+
+func (db *Db) usageLocked() int64 {
+	var total int64
+	for _, s := range db.segments {
+		s.idxMu.RLock()
+		total += s.offset
+		s.idxMu.RUnlock()
+	}
+	return total
+}
+
+// SetQuota limits the bucket to the given number of bytes of live records;
+// zero removes the limit. Unlike the Db quota, which bounds the segment
+// files, it counts the records of the keys the bucket holds, so overwritten
+// and deleted keys stop counting at once. Writes to the bucket that would
+// exceed it fail with ErrBucketQuotaExceeded; deletes always succeed.
+// Checking the quota reads the bucket, so writes to a bucket with a quota
+// slow down as it grows. Quotas are not persisted.
+func (b *Bucket) SetQuota(bytes int64) error {
+	if b.err != nil {
+		return b.err
+	}
+	if bytes <= 0 {
+		b.db.bucketQuotas.Delete(b.name)
+	} else {
+		b.db.bucketQuotas.Store(b.name, bytes)
+	}
+	return nil
+}
+
+// Usage reports the number of bytes of the live records of the bucket: the
+// latest record of every key it holds, deleted keys left out.
+func (b *Bucket) Usage() (int64, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	var total int64
+	err := b.db.liveRecords(b.prefix, func(_ string, size int64) {
+		total += size
+	})
+	return total, err
+}
+
+// liveRecords calls fn with the record size of every live key starting with
+// prefix.
+func (db *Db) liveRecords(prefix string, fn func(key string, size int64)) error {
+	return db.forEachLatest(db.keysInRange(prefix, PrefixEnd(prefix)), func(key string, e *entry) error {
+		fn(key, recordSize(key, e.value))
+		return nil
+	})
+}
+
+func recordSize(key, value string) int64 {
+	return int64(len(key)) + int64(len(value)) + entryOverhead + metaSize
+}
+
+// checkQuota fails if appending the entries would exceed the quota of the
+// Db or of a bucket they write to.
+func (db *Db) checkQuota(entries []*entry) error {
+	var size int64
+	for _, e := range entries {
+		size += recordSize(e.key, e.value)
+	}
+	if err := db.checkQuotaSize(size); err != nil {
+		return err
+	}
+	written := make(map[string]int64)
+	for _, e := range entries {
+		err := e.expand(func(_ int64, e *entry) {
+			if e.valueType == tombstoneValType {
+				written[e.key] = 0
+			} else {
+				written[e.key] = recordSize(e.key, e.value)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return db.checkBucketQuotas(written)
+}
+
+// checkQuotaSize fails if appending size more bytes would exceed the quota.
+func (db *Db) checkQuotaSize(size int64) error {
+	quota := db.quota.Load()
+	if quota <= 0 {
+		return nil
+	}
+	if used := db.Usage(); used+size > quota {
+		return fmt.Errorf("%w: %d bytes used, write needs %d more, quota is %d", ErrQuotaExceeded, used, size, quota)
+	}
+	return nil
+}
+
+// checkBucketQuotas fails if a bucket with a quota would exceed it once the
+// records of the given sizes, by stored key, are written; a size of zero
+// deletes the key.
+func (db *Db) checkBucketQuotas(written map[string]int64) error {
+	buckets := make(map[string]bool)
+	for key := range written {
+		if strings.HasPrefix(key, bucketMarker) {
+			bucket, _ := SplitBucketKey(key)
+			buckets[bucket] = true
+		}
+	}
+	for bucket := range buckets {
+		quota, ok := db.bucketQuotas.Load(bucket)
+		if !ok {
+			continue
+		}
+		prefix := bucketPrefix(bucket)
+		var used, after int64
+		err := db.liveRecords(prefix, func(key string, size int64) {
+			used += size
+			if _, ok := written[key]; !ok {
+				after += size
+			}
+		})
+		if err != nil {
+			return err
+		}
+		for key, size := range written {
+			if strings.HasPrefix(key, prefix) {
+				after += size
+			}
+		}
+		if limit := quota.(int64); after > limit && after > used {
+			return fmt.Errorf("%w: bucket %q would hold %d bytes, quota is %d", ErrBucketQuotaExceeded, bucket, after, limit)
+		}
+	}
+	return nil
+}
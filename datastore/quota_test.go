@@ -0,0 +1,108 @@
+package datastore
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestQuota(t *testing.T) {
+	db, err := Open(t.TempDir(), 1*Mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	db.SetQuota(100)
+	if err := db.Put("k", "0123456789"); err != nil {
+		t.Fatalf("Put under quota failed: %v", err)
+	}
+	usage := db.Usage()
+	if usage != int64(len("k")+len("0123456789")+entryOverhead+metaSize) {
+		t.Errorf("Usage() = %d after a single entry", usage)
+	}
+
+	big := string(bytes.Repeat([]byte("x"), 100))
+	if err := db.Put("big", big); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Put over quota: expected ErrQuotaExceeded, got %v", err)
+	}
+	if _, err := db.Increment("n", 1); err != nil {
+		t.Errorf("Increment under quota failed: %v", err)
+	}
+	b := &Batch{}
+	b.Put("a", big)
+	if err := db.WriteBatch(b); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("WriteBatch over quota: expected ErrQuotaExceeded, got %v", err)
+	}
+	if _, err := db.Get("big"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("rejected entry must not be stored, Get returned %v", err)
+	}
+
+	db.SetQuota(0)
+	if err := db.Put("big", big); err != nil {
+		t.Errorf("Put with quota disabled failed: %v", err)
+	}
+}
+
+func TestBucketQuota(t *testing.T) {
+	db, err := Open(t.TempDir(), 1*Mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	users, orders := db.Bucket("users"), db.Bucket("orders")
+	record := recordSize(bucketPrefix("users")+"k", "0123456789")
+
+	if err := users.SetQuota(2 * record); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Put("k", "0123456789"); err != nil {
+		t.Fatalf("Put under the bucket quota failed: %v", err)
+	}
+	if usage, err := users.Usage(); err != nil || usage != record {
+		t.Errorf("Usage() = %d, %v after a single entry, want %d", usage, err, record)
+	}
+	// Overwriting a key replaces its record rather than adding to it.
+	for range 5 {
+		if err := users.Put("k", "9876543210"); err != nil {
+			t.Fatalf("overwrite under the bucket quota failed: %v", err)
+		}
+	}
+	if err := users.Put("j", "0123456789"); err != nil {
+		t.Fatalf("Put up to the bucket quota failed: %v", err)
+	}
+
+	err = users.Put("l", "0123456789")
+	if !errors.Is(err, ErrBucketQuotaExceeded) || !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Put over the bucket quota: expected ErrBucketQuotaExceeded, got %v", err)
+	}
+	b := &Batch{}
+	b.Put(bucketPrefix("users")+"l", "0123456789")
+	if err := db.WriteBatch(b); !errors.Is(err, ErrBucketQuotaExceeded) {
+		t.Errorf("WriteBatch over the bucket quota: expected ErrBucketQuotaExceeded, got %v", err)
+	}
+	if err := orders.Put("l", "0123456789"); err != nil {
+		t.Errorf("the quota of one bucket must not limit another: %v", err)
+	}
+
+	if err := users.Delete("j"); err != nil {
+		t.Fatalf("Delete in a full bucket failed: %v", err)
+	}
+	if err := users.Put("l", "0123456789"); err != nil {
+		t.Errorf("Put after a delete freed the bucket failed: %v", err)
+	}
+
+	if err := users.SetQuota(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Put("m", "0123456789"); err != nil {
+		t.Errorf("Put with the bucket quota removed failed: %v", err)
+	}
+	if err := db.Bucket("a/b").SetQuota(1); !errors.Is(err, ErrInvalidBucket) {
+		t.Errorf("SetQuota of an invalid bucket: expected ErrInvalidBucket, got %v", err)
+	}
+}
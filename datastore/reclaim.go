@@ -0,0 +1,48 @@
+package datastore
+
+import (
+	"fmt"
+	"os"
+	"slices"
+)
+
+// Segment files are reference counted so that merges and purges never
+// delete a file a reader is still using. The store holds one reference to
+// every segment in db.segments; readers take another one while they hold
+// db.segmentsMutex, and the files of a segment that has left the store are
+// removed once its last reference is released.
+
+// acquire takes a reference to s for a reader. The caller holds
+// db.segmentsMutex and s is in db.segments.
+func (s *segment) acquire() {
+	s.refs.Add(1)
+}
+
+// release drops a reference to s and removes its files after the last one.
+func (s *segment) release() {
+	if s.refs.Add(-1) != 0 {
+		return
+	}
+	for _, path := range []string{s.filePath, hintPath(s.filePath)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "failed to remove retired segment file %s: %v\n", path, err)
+		}
+	}
+}
+
+// acquireSegments returns the segments of the store, oldest first, with a
+// reference taken to each; they must be passed to releaseSegments.
+func (db *Db) acquireSegments() []*segment {
+	db.segmentsMutex.RLock()
+	defer db.segmentsMutex.RUnlock()
+	for _, s := range db.segments {
+		s.acquire()
+	}
+	return slices.Clone(db.segments)
+}
+
+func releaseSegments(segments []*segment) {
+	for _, s := range segments {
+		s.release()
+	}
+}
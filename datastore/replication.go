@@ -0,0 +1,123 @@
+package datastore
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+)
+
+// subscriberBuffer bounds how far a replica may fall behind before it gets
+// dropped and has to resynchronize from a snapshot.
+const subscriberBuffer = 1024
+
+// Subscribe returns a channel receiving the encoded form of every batch of
+// entries appended after the call. The channel is closed when the subscriber
+// falls too far behind, when cancel is called or when the Db is closed.
+func (db *Db) Subscribe() (<-chan []byte, func()) {
+	ch := make(chan []byte, subscriberBuffer)
+	db.subsMu.Lock()
+	db.subs[ch] = struct{}{}
+	db.subsMu.Unlock()
+
+	cancel := func() {
+		db.subsMu.Lock()
+		defer db.subsMu.Unlock()
+		if _, ok := db.subs[ch]; ok {
+			delete(db.subs, ch)
+			close(ch)
+		}
+	}
+	return ch, cancel
+}
+
+func (db *Db) publish(data []byte) {
+	db.subsMu.Lock()
+	defer db.subsMu.Unlock()
+	for ch := range db.subs {
+		select {
+		case ch <- data:
+		default:
+			delete(db.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (db *Db) hasSubscribers() bool {
+	db.subsMu.Lock()
+	defer db.subsMu.Unlock()
+	return len(db.subs) > 0
+}
+
+func (db *Db) closeSubscribers() {
+	db.subsMu.Lock()
+	defer db.subsMu.Unlock()
+	for ch := range db.subs {
+		delete(db.subs, ch)
+		close(ch)
+	}
+}
+
+// Snapshot writes the latest entry of every key to w in the segment encoding.
+func (db *Db) Snapshot(w io.Writer) error {
+	return db.forEachLatest(nil, func(_ string, e *entry) error {
+		_, err := w.Write(e.Encode())
+		return err
+	})
+}
+
+// ApplyStream reads encoded entries (as produced by Snapshot and Subscribe)
+// from r and appends them to the store until r is exhausted.
+func (db *Db) ApplyStream(r io.Reader) error {
+	return db.applyStream(r, func(*entry) {})
+}
+
+// ApplySnapshot applies a whole Snapshot of another store like ApplyStream
+// and then deletes the keys the snapshot does not have. A snapshot leaves
+// deleted keys out, so without that a key deleted at the source since this
+// store last caught up would stay here for good.
+func (db *Db) ApplySnapshot(r io.Reader) error {
+	seen := make(map[string]struct{})
+	err := db.applyStream(r, func(e *entry) {
+		_ = e.expand(func(_ int64, e *entry) {
+			seen[e.key] = struct{}{}
+		})
+	})
+	if err != nil {
+		return err
+	}
+	var stale []*entry
+	err = db.forEachLatest(nil, func(key string, _ *entry) error {
+		if _, ok := seen[key]; !ok {
+			stale = append(stale, &entry{key: key, valueType: tombstoneValType})
+		}
+		return nil
+	})
+	if err != nil || len(stale) == 0 {
+		return err
+	}
+	respChan := make(chan error, 1)
+	return send(context.Background(), db, db.batchRequests, batchRequest{entries: stale, replicated: true, respChan: respChan}, respChan)
+}
+
+// applyStream appends the entries read from r, calling fn with each once
+// it is stored.
+func (db *Db) applyStream(r io.Reader, fn func(e *entry)) error {
+	reader := bufio.NewReader(r)
+	for {
+		var e entry
+		if _, err := e.DecodeFromReader(reader); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		respChan := make(chan error, 1)
+		err := send(context.Background(), db, db.batchRequests, batchRequest{entries: []*entry{&e}, replicated: true, respChan: respChan}, respChan)
+		if err != nil {
+			return err
+		}
+		fn(&e)
+	}
+}
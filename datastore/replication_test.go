@@ -0,0 +1,115 @@
+package datastore
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestReplication(t *testing.T) {
+	primary, err := Open(t.TempDir(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = primary.Close()
+	})
+	replica, err := Open(t.TempDir(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = replica.Close()
+	})
+
+	if err := primary.Put("a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := primary.PutInt64("n", 5); err != nil {
+		t.Fatal(err)
+	}
+
+	updates, cancel := primary.Subscribe()
+	defer cancel()
+
+	var snapshot bytes.Buffer
+	if err := primary.Snapshot(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if err := replica.ApplyStream(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := primary.Put("a", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := primary.Increment("n", 1); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := replica.ApplyStream(bytes.NewReader(<-updates)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got, err := replica.Get("a"); err != nil || got != "2" {
+		t.Errorf("replica Get(a) = %q, %v; want 2", got, err)
+	}
+	if got, err := replica.GetInt64("n"); err != nil || got != 6 {
+		t.Errorf("replica GetInt64(n) = %d, %v; want 6", got, err)
+	}
+
+	cancel()
+	if _, ok := <-updates; ok {
+		t.Error("expected updates channel to be closed after cancel")
+	}
+}
+
+func TestApplySnapshot(t *testing.T) {
+	primary, err := Open(t.TempDir(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = primary.Close()
+	})
+	replica, err := Open(t.TempDir(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = replica.Close()
+	})
+
+	for _, db := range []*Db{primary, replica} {
+		for _, key := range []string{"a", "b"} {
+			if err := db.Put(key, "1"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// The merge drops the tombstone, so no record of b is left to send.
+	if err := primary.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := primary.Put("a", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := primary.MergeSegments(); err != nil {
+		t.Fatal(err)
+	}
+
+	var snapshot bytes.Buffer
+	if err := primary.Snapshot(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if err := replica.ApplySnapshot(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := replica.Get("a"); err != nil || got != "2" {
+		t.Errorf("replica Get(a) = %q, %v; want 2", got, err)
+	}
+	if _, err := replica.Get("b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("a key missing from the snapshot must be deleted, Get(b) returned %v", err)
+	}
+}
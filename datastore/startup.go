@@ -0,0 +1,71 @@
+package datastore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// quarantineDir is the subdirectory of the data directory orphaned files are
+// moved into.
+const quarantineDir = "quarantine"
+
+// OrphanFile is a file in the data directory that is neither a segment nor
+// the hint of one.
+type OrphanFile struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// StartupReport describes what Open found in the data directory.
+type StartupReport struct {
+	Segments int          `json:"segments"`
+	Orphans  []OrphanFile `json:"orphans,omitempty"`
+	// Quarantined is set if the orphans were moved into the quarantine
+	// subdirectory.
+	Quarantined bool `json:"quarantined"`
+}
+
+// StartupReport returns what Open found in the data directory.
+func (db *Db) StartupReport() StartupReport {
+	return db.startup
+}
+
+// orphanReason tells why a directory entry that is not a segment does not
+// belong in the data directory; "" means it does.
+func orphanReason(file os.DirEntry, segments map[string]bool) string {
+	name := file.Name()
+	switch {
+	case file.IsDir():
+		if name == quarantineDir {
+			return ""
+		}
+		return "unexpected directory"
+	case strings.HasSuffix(name, ".tmp"):
+		return "leftover temporary file"
+	case strings.HasSuffix(name, hintSuffix):
+		if segments[strings.TrimSuffix(name, hintSuffix)] {
+			return ""
+		}
+		return "hint of a missing segment"
+	case strings.HasPrefix(name, outFileName):
+		return "invalid segment name"
+	}
+	return "unknown file"
+}
+
+// quarantine moves the orphans out of the way, so they are not mistaken for
+// data by later versions or operators.
+func (db *Db) quarantine(orphans []OrphanFile) error {
+	dir := filepath.Join(db.dir, quarantineDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("quarantine: %w", err)
+	}
+	for _, orphan := range orphans {
+		if err := os.Rename(filepath.Join(db.dir, orphan.Name), filepath.Join(dir, orphan.Name)); err != nil {
+			return fmt.Errorf("quarantine: %w", err)
+		}
+	}
+	return nil
+}
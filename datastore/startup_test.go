@@ -0,0 +1,80 @@
+package datastore
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStartupReport(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"a", "b", "c"} {
+		if err := db.Put(k, "1111111111111111111111111"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	stray := map[string]string{
+		"segment-0000000001.hint.tmp": "leftover temporary file",
+		"segment-0000000099.hint":     "hint of a missing segment",
+		"segment-backup":              "invalid segment name",
+		"notes.txt":                   "unknown file",
+	}
+	for name := range stray {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("junk"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ro, err := OpenWithOptions(dir, WithReadOnly(), WithQuarantine())
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := ro.StartupReport()
+	_ = ro.Close()
+	got := make(map[string]string)
+	for _, orphan := range report.Orphans {
+		got[orphan.Name] = orphan.Reason
+	}
+	if !reflect.DeepEqual(got, stray) {
+		t.Errorf("orphans = %v, want %v", got, stray)
+	}
+	if report.Segments < 2 || report.Quarantined {
+		t.Errorf("unexpected report of a read-only open: %+v", report)
+	}
+
+	db, err = OpenWithOptions(dir, WithQuarantine())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if !db.StartupReport().Quarantined {
+		t.Error("expected the orphans to be quarantined")
+	}
+	for name := range stray {
+		if _, err := os.Stat(filepath.Join(dir, quarantineDir, name)); err != nil {
+			t.Errorf("%s not quarantined: %v", name, err)
+		}
+	}
+	if value, err := db.Get("b"); err != nil || value != "1111111111111111111111111" {
+		t.Errorf("data lost after quarantine: %q, %v", value, err)
+	}
+
+	_ = db.Close()
+	db, err = Open(dir, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if orphans := db.StartupReport().Orphans; len(orphans) != 0 {
+		t.Errorf("expected the quarantine directory to be ignored, got %v", orphans)
+	}
+}
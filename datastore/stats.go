@@ -0,0 +1,117 @@
+package datastore
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// compactionHistory is how many of the latest compactions Stats reports.
+const compactionHistory = 20
+
+// Compaction describes a run of MergeSegments, PurgeTombstones or
+// CompactSegment.
+type Compaction struct {
+	// Kind is "merge", "purge" or "segment".
+	Kind     string
+	Start    time.Time
+	Duration time.Duration
+	// Reclaimed is how much the segments shrank, in bytes.
+	Reclaimed int64
+	// Err is the error the run failed with, if any.
+	Err string
+}
+
+// Stats is a point-in-time view of the store's counters and gauges.
+type Stats struct {
+	Puts          int64
+	Deletes       int64
+	Gets          int64
+	Misses        int64
+	Merges        int64
+	MergeDuration time.Duration
+	Segments      int
+	Bytes         int64
+	// Tasks are the background goroutines run by the store.
+	Tasks []TaskStatus
+	// Compactions are the latest compactions, oldest first.
+	Compactions []Compaction
+	// GroupCommits counts the writes that committed queued Put, PutInt64
+	// and Delete calls together; see SyncAlways.
+	GroupCommits int64
+}
+
+type dbStats struct {
+	puts       atomic.Int64
+	deletes    atomic.Int64
+	gets       atomic.Int64
+	misses     atomic.Int64
+	merges     atomic.Int64
+	mergeNanos atomic.Int64
+	// groupCommits counts the writes of groupCommit.
+	groupCommits atomic.Int64
+
+	historyMu   sync.Mutex
+	compactions []Compaction
+}
+
+func (db *Db) Stats() Stats {
+	db.segmentsMutex.RLock()
+	segments := len(db.segments)
+	bytes := db.usageLocked()
+	db.segmentsMutex.RUnlock()
+	db.stats.historyMu.Lock()
+	compactions := slices.Clone(db.stats.compactions)
+	db.stats.historyMu.Unlock()
+
+	return Stats{
+		Puts:          db.stats.puts.Load(),
+		Deletes:       db.stats.deletes.Load(),
+		Gets:          db.stats.gets.Load(),
+		Misses:        db.stats.misses.Load(),
+		Merges:        db.stats.merges.Load(),
+		MergeDuration: time.Duration(db.stats.mergeNanos.Load()),
+		GroupCommits:  db.stats.groupCommits.Load(),
+		Segments:      segments,
+		Bytes:         bytes,
+		Tasks:         db.tasks.status(),
+		Compactions:   compactions,
+	}
+}
+
+// recordCompaction adds a compaction that started at start, when the
+// segments took up before bytes, to the history.
+func (db *Db) recordCompaction(kind string, start time.Time, before int64, err error) {
+	c := Compaction{
+		Kind:      kind,
+		Start:     start,
+		Duration:  time.Since(start),
+		Reclaimed: before - db.Usage(),
+	}
+	if err != nil {
+		c.Err = err.Error()
+	}
+	db.stats.historyMu.Lock()
+	defer db.stats.historyMu.Unlock()
+	db.stats.compactions = append(db.stats.compactions, c)
+	if n := len(db.stats.compactions); n > compactionHistory {
+		db.stats.compactions = slices.Delete(db.stats.compactions, 0, n-compactionHistory)
+	}
+}
+
+func (db *Db) countWrite(e *entry) {
+	if e.valueType == tombstoneValType {
+		db.stats.deletes.Add(1)
+	} else {
+		db.stats.puts.Add(1)
+	}
+}
+
+func (db *Db) countGet(err error) {
+	db.stats.gets.Add(1)
+	if errors.Is(err, ErrNotFound) {
+		db.stats.misses.Add(1)
+	}
+}
@@ -0,0 +1,93 @@
+package datastore
+
+import (
+	"sort"
+	"strconv"
+)
+
+// Store is the stable API of the datastore, for programs that embed it
+// instead of talking to the db service over HTTP. Db implements it; the
+// rest of Db's methods (versions, transactions, replication, maintenance)
+// may change between releases.
+type Store interface {
+	// Get returns the string value of key, or an error matching
+	// ErrNotFound or ErrTypeMismatch.
+	Get(key string) (string, error)
+	Put(key, value string) error
+	Delete(key string) error
+	// Scan calls fn in key order for every key in [start, end); an empty
+	// end leaves the range open. See KeyPrefix and PrefixEnd for scanning
+	// the keys of a prefix. An error from fn stops the scan and is
+	// returned.
+	Scan(start, end string, fn func(key, value string) error) error
+	Close() error
+}
+
+var _ Store = (*Db)(nil)
+
+// Scan implements Store. Values are read as of the start of the scan;
+// int64 values are passed in decimal. The keys of buckets are left out; see
+// Bucket.Scan.
+func (db *Db) Scan(start, end string, fn func(key, value string) error) error {
+	// Bucket keys sort before every other key.
+	start = max(start, PrefixEnd(bucketMarker))
+	if end != "" && end <= start {
+		return nil
+	}
+	return db.scan(start, end, fn)
+}
+
+func (db *Db) scan(start, end string, fn func(key, value string) error) error {
+	keys := db.keysInRange(start, end)
+	values := make(map[string]string, len(keys))
+	err := db.forEachLatest(keys, func(key string, e *entry) error {
+		if e.valueType == Int64ValType {
+			n, err := decodeInt64(e.value)
+			if err != nil {
+				return err
+			}
+			values[key] = strconv.FormatInt(n, 10)
+			return nil
+		}
+		values[key] = e.value
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		value, ok := values[key]
+		if !ok {
+			// Deleted.
+			continue
+		}
+		if err := fn(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keysInRange returns the sorted keys in [start, end) that any segment has
+// a record of, tombstones included.
+func (db *Db) keysInRange(start, end string) []string {
+	segments := db.acquireSegments()
+	defer releaseSegments(segments)
+
+	seen := make(map[string]struct{})
+	for _, s := range segments {
+		s.idxMu.RLock()
+		for key := range s.index {
+			if key >= start && (end == "" || key < end) {
+				seen[key] = struct{}{}
+			}
+		}
+		s.idxMu.RUnlock()
+	}
+	keys := make([]string, 0, len(seen))
+	for key := range seen {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,69 @@
+package datastore
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDb_Scan(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir(), WithSegmentSize(256))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	for _, key := range []string{"user:2", "user:1", "user:3", "order:1", "users"} {
+		if err := db.Put(key, "v-"+key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Put("user:1", "updated"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutInt64("user:4", -7); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("user:3"); err != nil {
+		t.Fatal(err)
+	}
+
+	scan := func(start, end string) map[string]string {
+		t.Helper()
+		got := make(map[string]string)
+		var keys []string
+		err := db.Scan(start, end, func(key, value string) error {
+			keys = append(keys, key)
+			got[key] = value
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 1; i < len(keys); i++ {
+			if keys[i-1] >= keys[i] {
+				t.Errorf("keys out of order: %v", keys)
+			}
+		}
+		return got
+	}
+
+	prefix := "user:"
+	want := map[string]string{"user:1": "updated", "user:2": "v-user:2", "user:4": "-7"}
+	if got := scan(prefix, PrefixEnd(prefix)); !reflect.DeepEqual(got, want) {
+		t.Errorf("prefix scan = %v, want %v", got, want)
+	}
+	if got := scan("", ""); len(got) != 5 {
+		t.Errorf("full scan found %d keys, want 5: %v", len(got), got)
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err = db.Scan("", "", func(string, string) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("scan returned %v after %d calls, want the callback error after 1", err, calls)
+	}
+}
@@ -0,0 +1,322 @@
+package datastore
+
+import (
+	"bufio"
+	"compress/flate"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+)
+
+type streamRequest struct {
+	key string
+	// spool holds the value; the io worker copies it into the active
+	// segment.
+	spool    *os.File
+	size     int64
+	respChan chan error
+}
+
+// PutStream writes the string value read from r under key without holding
+// it in memory: the value is spooled to a temporary file and the io worker
+// copies it into the active segment. Streamed values are never compressed.
+// ctx only bounds reading r; once the value is spooled PutStream waits for
+// the write to finish.
+func (db *Db) PutStream(ctx context.Context, key string, r io.Reader) error {
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+	if err := db.checkSize(key, 0); err != nil {
+		return err
+	}
+	spool, err := os.CreateTemp("", "datastore-value-*")
+	if err != nil {
+		return fmt.Errorf("cannot create spool file: %w", err)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	size, err := io.Copy(spool, io.LimitReader(r, db.valueLimit()+1))
+	if err != nil {
+		return fmt.Errorf("cannot spool value of key %q: %w", key, err)
+	}
+	if err := db.checkSize(key, size); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// The worker reads the spool file, so it must not be closed before the
+	// worker answers.
+	respChan := make(chan error, 1)
+	return send(context.Background(), db, db.streamRequests, streamRequest{
+		key:      key,
+		spool:    spool,
+		size:     size,
+		respChan: respChan,
+	}, respChan)
+}
+
+// appendStream runs on the io worker: it writes the record of a streamed
+// value piece by piece and drops a partially written record on failure.
+func (db *Db) appendStream(req streamRequest) error {
+	kl := len(req.key)
+	size := int64(kl) + req.size + metaSize + entryOverhead
+	if size > maxRecordSize {
+		return fmt.Errorf("%w: entry for key of %d bytes needs %d bytes, limit is %d", ErrTooLarge, kl, size, int64(maxRecordSize))
+	}
+	if err := db.checkQuotaSize(size); err != nil {
+		return err
+	}
+	if err := db.checkBucketQuotas(map[string]int64{req.key: size}); err != nil {
+		return err
+	}
+	e, err := db.stamp(&entry{key: req.key, valueType: StrValType})
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, 12+kl+metaSize)
+	binary.LittleEndian.PutUint32(header[0:], uint32(size))
+	binary.LittleEndian.PutUint32(header[4:], uint32(kl))
+	copy(header[8:], req.key)
+	binary.LittleEndian.PutUint32(header[kl+8:], uint32(req.size+metaSize))
+	binary.LittleEndian.PutUint64(header[kl+12:], e.version)
+	binary.LittleEndian.PutUint64(header[kl+20:], uint64(e.timestamp))
+
+	segment := db.activeSegment
+	start := segment.offset
+	err = writeStreamRecord(segment.file, header, io.NewSectionReader(req.spool, 0, req.size), StrValType|metaFlag)
+	if err == nil && db.opts.Sync == SyncAlways {
+		err = segment.file.Sync()
+	}
+	if err != nil {
+		if truncErr := segment.file.Truncate(start); truncErr != nil {
+			fmt.Fprintf(os.Stderr, "ioWorker: failed to drop partial record from segment %s: %v\n", segment.filePath, truncErr)
+		}
+		return fmt.Errorf("cannot write value of key %q to segment %s: %w", req.key, segment.filePath, err)
+	}
+
+	segment.idxMu.Lock()
+	segment.index[req.key] = start
+	segment.track(req.key, false)
+	if segment.filter != nil {
+		segment.filter.Add(req.key)
+	}
+	segment.offset += size
+	segment.idxMu.Unlock()
+	db.countWrite(e)
+	if db.cache != nil {
+		db.cache.invalidate([]string{req.key})
+	}
+	if db.hasSubscribers() {
+		// Subscribers get whole records, so only they pay for reading the
+		// value back.
+		record := make([]byte, size)
+		if _, err := segment.file.ReadAt(record, start); err != nil {
+			fmt.Fprintf(os.Stderr, "ioWorker: cannot read back streamed record of key %q: %v\n", req.key, err)
+			db.closeSubscribers()
+		} else {
+			db.publish(record)
+		}
+	}
+	return nil
+}
+
+func writeStreamRecord(f *os.File, header []byte, value io.Reader, valueType byte) error {
+	out := bufio.NewWriterSize(f, 64*1024)
+	sum := crc32.NewIEEE()
+	w := io.MultiWriter(out, sum)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, value); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte{valueType}); err != nil {
+		return err
+	}
+	if _, err := out.Write(binary.LittleEndian.AppendUint32(nil, sum.Sum32())); err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+// ValueReader streams a string value from its segment file.
+type ValueReader struct {
+	// Size is the length of the value, or -1 for compressed values whose
+	// length is only known once they are read.
+	Size int64
+	Meta Meta
+
+	r   io.Reader
+	raw *checkedReader
+	// compressed values are read through a decompressor on top of raw.
+	compressed bool
+	f          *os.File
+	// segment is released on Close, so a merge cannot remove its file while
+	// the value is read.
+	segment *segment
+}
+
+// OpenValue returns a reader over the string value of key that reads it
+// straight from its segment instead of loading it into memory. The record
+// checksum is verified once the value is read to the end; a mismatch is
+// returned by Read in place of io.EOF. The reader must be closed.
+func (db *Db) OpenValue(key string) (*ValueReader, error) {
+	if cached, _ := db.cache.get(key); cached != nil {
+		db.countGet(nil)
+		if cached.valueType != StrValType {
+			return nil, fmt.Errorf("%w: expected string, got type 0x%x", ErrTypeMismatch, cached.valueType)
+		}
+		return &ValueReader{Size: int64(len(cached.value)), Meta: cached.meta(), r: strings.NewReader(cached.value)}, nil
+	}
+	v, err := db.openValue(key)
+	db.countGet(err)
+	return v, err
+}
+
+func (db *Db) openValue(key string) (*ValueReader, error) {
+	segment, offset, ok := db.locate(key)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	f, err := os.Open(segment.filePath)
+	if err != nil {
+		segment.release()
+		return nil, fmt.Errorf("could not open segment file %s: %w", segment.filePath, err)
+	}
+	v, err := readValue(f, offset)
+	if err != nil {
+		f.Close()
+		segment.release()
+		if errors.Is(err, ErrCorrupted) {
+			err = fmt.Errorf("could not read record from segment file %s: %w", segment.filePath, err)
+		}
+		return nil, err
+	}
+	v.segment = segment
+	return v, nil
+}
+
+// readValue decodes the framing of the record at offset and sets up a
+// reader over its value.
+func readValue(f *os.File, offset int64) (*ValueReader, error) {
+	head := make([]byte, 8)
+	if _, err := f.ReadAt(head, offset); err != nil {
+		return nil, fmt.Errorf("%w: cannot read record header: %w", ErrCorrupted, err)
+	}
+	size := int64(binary.LittleEndian.Uint32(head))
+	kl := int64(binary.LittleEndian.Uint32(head[4:]))
+	if size < entryOverhead || kl > size-entryOverhead {
+		return nil, fmt.Errorf("%w: invalid record size %d with key length %d", ErrCorrupted, size, kl)
+	}
+	prefix := make([]byte, kl+12)
+	trailer := make([]byte, 5)
+	if _, err := f.ReadAt(prefix, offset); err != nil {
+		return nil, fmt.Errorf("%w: cannot read record header: %w", ErrCorrupted, err)
+	}
+	if _, err := f.ReadAt(trailer, offset+size-5); err != nil {
+		return nil, fmt.Errorf("%w: cannot read record trailer: %w", ErrCorrupted, err)
+	}
+	vl := int64(binary.LittleEndian.Uint32(prefix[kl+8:]))
+	if kl+vl+entryOverhead != size {
+		return nil, fmt.Errorf("%w: key length %d and value length %d do not match record size %d", ErrCorrupted, kl, vl, size)
+	}
+
+	valueType := trailer[0]
+	if valueType&^metaFlag == tombstoneValType {
+		return nil, ErrNotFound
+	}
+	v := &ValueReader{f: f}
+	valueStart := offset + kl + 12
+	if valueType&metaFlag != 0 && vl >= metaSize {
+		meta := make([]byte, metaSize)
+		if _, err := f.ReadAt(meta, valueStart); err != nil {
+			return nil, fmt.Errorf("%w: cannot read record header: %w", ErrCorrupted, err)
+		}
+		v.Meta = (&entry{
+			version:   binary.LittleEndian.Uint64(meta),
+			timestamp: int64(binary.LittleEndian.Uint64(meta[8:])),
+		}).meta()
+		prefix = append(prefix, meta...)
+		valueStart += metaSize
+		vl -= metaSize
+		valueType &^= metaFlag
+	}
+	if valueType&^compressedFlag != StrValType {
+		return nil, fmt.Errorf("%w: expected string, got type 0x%x", ErrTypeMismatch, valueType&^compressedFlag)
+	}
+
+	sum := crc32.NewIEEE()
+	sum.Write(prefix)
+	v.raw = &checkedReader{
+		r:       io.NewSectionReader(f, valueStart, vl),
+		sum:     sum,
+		trailer: trailer,
+	}
+	v.r, v.Size = v.raw, vl
+	if valueType&compressedFlag != 0 {
+		v.r, v.Size, v.compressed = flate.NewReader(v.raw), -1, true
+	}
+	return v, nil
+}
+
+func (v *ValueReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	if !v.compressed {
+		return n, err
+	}
+	switch {
+	case err == io.EOF:
+		// The decompressor may stop before the end of the raw value, which
+		// still has to be read for the checksum.
+		if _, drainErr := io.Copy(io.Discard, v.raw); drainErr != nil {
+			return n, drainErr
+		}
+	case err != nil && !errors.Is(err, ErrCorrupted):
+		err = fmt.Errorf("%w: cannot decompress value: %w", ErrCorrupted, err)
+	}
+	return n, err
+}
+
+func (v *ValueReader) Close() error {
+	if v.f == nil {
+		return nil
+	}
+	err := v.f.Close()
+	if v.segment != nil {
+		v.segment.release()
+		v.segment = nil
+	}
+	return err
+}
+
+// checkedReader hashes the value of a record as it is read and compares the
+// checksum of the whole record at the end.
+type checkedReader struct {
+	r   io.Reader
+	sum hash.Hash32
+	// trailer is the value type byte and the stored checksum.
+	trailer []byte
+	checked bool
+}
+
+func (c *checkedReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.sum.Write(p[:n])
+	if err == io.EOF && !c.checked {
+		c.checked = true
+		c.sum.Write(c.trailer[:1])
+		if c.sum.Sum32() != binary.LittleEndian.Uint32(c.trailer[1:]) {
+			return n, errBadChecksum
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,169 @@
+package datastore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPutStream(t *testing.T) {
+	db, err := Open(t.TempDir(), 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	updates, cancel := db.Subscribe()
+	defer cancel()
+
+	value := strings.Repeat("0123456789", 1000)
+	if err := db.PutStream(context.Background(), "big", strings.NewReader(value)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("small", "v"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := db.Get("big"); err != nil || got != value {
+		t.Errorf("Get(big) returned %d bytes, %v", len(got), err)
+	}
+	v, err := db.OpenValue("big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	if v.Size != int64(len(value)) || v.Meta.Version == 0 {
+		t.Errorf("unexpected size %d and meta %+v", v.Size, v.Meta)
+	}
+	if got, err := io.ReadAll(v); err != nil || string(got) != value {
+		t.Errorf("OpenValue(big) streamed %d bytes, %v", len(got), err)
+	}
+
+	replica, err := Open(t.TempDir(), 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = replica.Close()
+	})
+	if err := replica.ApplyStream(bytes.NewReader(<-updates)); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := replica.Get("big"); err != nil || got != value {
+		t.Errorf("replica Get(big) returned %d bytes, %v", len(got), err)
+	}
+
+	// The streamed record survives a restart like any other.
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(db.dir, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.Get("big"); err != nil || got != value {
+		t.Errorf("Get(big) after reopen returned %d bytes, %v", len(got), err)
+	}
+}
+
+func TestOpenValue(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir(), WithCompression(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	compressible := strings.Repeat("a", 4096)
+	if err := db.Put("compressed", compressible); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutInt64("n", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("gone", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("gone"); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := db.OpenValue("compressed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(v)
+	v.Close()
+	if err != nil || string(got) != compressible || v.Size != -1 {
+		t.Errorf("compressed value: %d bytes, size %d, %v", len(got), v.Size, err)
+	}
+
+	if _, err := db.OpenValue("n"); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("expected a type mismatch for an int64, got %v", err)
+	}
+	for _, key := range []string{"gone", "missing"} {
+		if _, err := db.OpenValue(key); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: expected ErrNotFound, got %v", key, err)
+		}
+	}
+}
+
+func TestOpenValue_Checksum(t *testing.T) {
+	db, err := Open(t.TempDir(), 1*Mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	if err := db.PutStream(context.Background(), "k", strings.NewReader("value")); err != nil {
+		t.Fatal(err)
+	}
+	// Flip the last byte of the value, right before the type and checksum.
+	f, err := os.OpenFile(db.activeSegment.filePath, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("E"), db.activeSegment.offset-6); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	v, err := db.OpenValue("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	got, err := io.ReadAll(v)
+	if !errors.Is(err, ErrCorrupted) {
+		t.Errorf("expected a checksum error, got %q, %v", got, err)
+	}
+}
+
+func TestPutStream_Limits(t *testing.T) {
+	db, err := Open(t.TempDir(), 1*Mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	db.SetQuota(100)
+	err = db.PutStream(context.Background(), "k", strings.NewReader(strings.Repeat("x", 200)))
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected ErrQuotaExceeded, got %v", err)
+	}
+	if size, _ := db.Size(); size != 0 {
+		t.Errorf("rejected write left %d bytes behind", size)
+	}
+
+	db.SetReadOnly(true)
+	if err := db.PutStream(context.Background(), "k", strings.NewReader("v")); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly, got %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+)
+
+// Tx buffers writes and commits them as a single record, so after a crash
+// either all of them are recovered or none is. Reads see the transaction's
+// own writes but are not isolated from concurrent commits.
+type Tx struct {
+	db      *Db
+	entries []*entry
+	pending map[string]*entry
+	done    bool
+}
+
+func (db *Db) Begin() *Tx {
+	return &Tx{db: db, pending: make(map[string]*entry)}
+}
+
+func (tx *Tx) Put(key, value string) error {
+	return tx.add(&entry{key: key, value: value, valueType: StrValType})
+}
+
+func (tx *Tx) PutInt64(key string, value int64) error {
+	return tx.add(&entry{key: key, value: encodeInt64(value), valueType: Int64ValType})
+}
+
+func (tx *Tx) Delete(key string) error {
+	return tx.add(&entry{key: key, valueType: tombstoneValType})
+}
+
+func (tx *Tx) add(e *entry) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.entries = append(tx.entries, e)
+	tx.pending[e.key] = e
+	return nil
+}
+
+func (tx *Tx) Get(key string) (string, error) {
+	if tx.done {
+		return "", ErrTxDone
+	}
+	e, ok := tx.pending[key]
+	if !ok {
+		return tx.db.Get(key)
+	}
+	switch e.valueType {
+	case tombstoneValType:
+		return "", ErrNotFound
+	case StrValType:
+		return e.value, nil
+	default:
+		return "", fmt.Errorf("%w: expected string, got type 0x%x", ErrTypeMismatch, e.valueType)
+	}
+}
+
+// Commit appends all buffered writes atomically. The transaction cannot be
+// used afterwards, even if the commit fails.
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	if len(tx.entries) == 0 {
+		return nil
+	}
+	if err := tx.db.checkWritable(); err != nil {
+		return err
+	}
+
+	respChan := make(chan error, 1)
+	return send(context.Background(), tx.db, tx.db.batchRequests, batchRequest{
+		entries:  []*entry{encodeTx(tx.entries)},
+		respChan: respChan,
+	}, respChan)
+}
+
+// Rollback discards the buffered writes.
+func (tx *Tx) Rollback() {
+	tx.done = true
+	tx.entries = nil
+	tx.pending = nil
+}
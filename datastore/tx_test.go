@@ -0,0 +1,118 @@
+package datastore
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestTx(t *testing.T) {
+	tmp := t.TempDir()
+	db, err := Open(tmp, 1*Mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	if err := db.Put("gone", "x"); err != nil {
+		t.Fatal(err)
+	}
+
+	tx := db.Begin()
+	_ = tx.Put("a", "1")
+	_ = tx.PutInt64("n", 5)
+	_ = tx.Delete("gone")
+	if got, err := tx.Get("a"); err != nil || got != "1" {
+		t.Errorf("tx.Get(a) = %q, %v; want buffered value", got, err)
+	}
+	if _, err := tx.Get("gone"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("tx.Get of deleted key: expected ErrNotFound, got %v", err)
+	}
+	if _, err := db.Get("a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("uncommitted write is visible: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Put("b", "2"); !errors.Is(err, ErrTxDone) {
+		t.Errorf("Put after commit: expected ErrTxDone, got %v", err)
+	}
+
+	rolledBack := db.Begin()
+	_ = rolledBack.Put("a", "rolled back")
+	rolledBack.Rollback()
+	if err := rolledBack.Commit(); !errors.Is(err, ErrTxDone) {
+		t.Errorf("Commit after rollback: expected ErrTxDone, got %v", err)
+	}
+
+	check := func(db *Db) {
+		t.Helper()
+		if got, err := db.Get("a"); err != nil || got != "1" {
+			t.Errorf("Get(a) = %q, %v; want %q", got, err, "1")
+		}
+		if got, err := db.GetInt64("n"); err != nil || got != 5 {
+			t.Errorf("GetInt64(n) = %d, %v; want 5", got, err)
+		}
+		if _, err := db.Get("gone"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get of deleted key: expected ErrNotFound, got %v", err)
+		}
+	}
+	check(db)
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(tmp, 1*Mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(db)
+
+	report, err := db.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() {
+		t.Errorf("unexpected verify problems: %+v", report.Bad)
+	}
+}
+
+func TestTx_Replication(t *testing.T) {
+	primary, err := Open(t.TempDir(), 1*Mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = primary.Close()
+	})
+	replica, err := Open(t.TempDir(), 1*Mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = replica.Close()
+	})
+
+	feed, cancel := primary.Subscribe()
+	defer cancel()
+
+	tx := primary.Begin()
+	_ = tx.Put("a", "1")
+	_ = tx.Put("b", "2")
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := replica.ApplyStream(bytes.NewReader(<-feed)); err != nil {
+		t.Fatal(err)
+	}
+	got, err := replica.GetMany([]string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["a"] != "1" || got["b"] != "2" {
+		t.Errorf("replica state after transaction: %v", got)
+	}
+}
@@ -0,0 +1,62 @@
+package datastore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	tmp := t.TempDir()
+	db, err := Open(tmp, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range []string{"a", "b", "c", "d"} {
+		if err := db.Put(k, "value-"+k); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := db.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() {
+		t.Fatalf("expected clean report, got %+v", report.Bad)
+	}
+	if report.Entries != 4 {
+		t.Errorf("expected 4 verified entries, got %d", report.Entries)
+	}
+	if report.Segments < 2 {
+		t.Errorf("expected several segments to be verified, got %d", report.Segments)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(tmp, segmentFileName(0))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[14] ^= 0xff
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err = VerifyDir(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Bad) != 1 {
+		t.Fatalf("expected 1 bad entry, got %+v", report.Bad)
+	}
+	if bad := report.Bad[0]; bad.Segment != segmentFileName(0) || bad.Offset != 0 {
+		t.Errorf("unexpected bad entry location: %+v", bad)
+	}
+	if report.Entries != 3 {
+		t.Errorf("expected 3 valid entries, got %d", report.Entries)
+	}
+}
@@ -0,0 +1,102 @@
+package datastore
+
+import (
+	"fmt"
+	"time"
+)
+
+// Meta describes the latest write of a key. Entries written before versions
+// were introduced have a zero Meta.
+type Meta struct {
+	// Version grows with every write to the store, so a key's version
+	// changes whenever its value does.
+	Version  uint64
+	Modified time.Time
+}
+
+func (e *entry) meta() Meta {
+	if e.version == 0 {
+		return Meta{}
+	}
+	return Meta{Version: e.version, Modified: time.Unix(0, e.timestamp)}
+}
+
+// stamp returns a copy of e with the next version and the current time; the
+// entries of a transaction are stamped one by one. Entries that already carry
+// a version, such as those applied from a replication stream, keep it.
+func (db *Db) stamp(e *entry) (*entry, error) {
+	if e.valueType == txValType {
+		var nested []*entry
+		err := e.expand(func(_ int64, n *entry) {
+			stamped, _ := db.stamp(n)
+			nested = append(nested, stamped)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return encodeTx(nested), nil
+	}
+	stamped := *e
+	if stamped.version == 0 {
+		now := time.Now().UnixNano()
+		// The clock keeps versions growing across restarts even for segments
+		// recovered from hints, which do not record versions.
+		db.lastVersion = max(db.lastVersion+1, uint64(now))
+		stamped.version, stamped.timestamp = db.lastVersion, now
+	} else {
+		db.lastVersion = max(db.lastVersion, stamped.version)
+	}
+	return &stamped, nil
+}
+
+func (db *Db) GetWithMeta(key string) (string, Meta, error) {
+	e, err := db.getEntry(key)
+	db.countGet(err)
+	if err != nil {
+		return "", Meta{}, err
+	}
+	if e.valueType != StrValType {
+		return "", Meta{}, fmt.Errorf("%w: expected string, got type 0x%x", ErrTypeMismatch, e.valueType)
+	}
+	return e.value, e.meta(), nil
+}
+
+func (db *Db) GetInt64WithMeta(key string) (int64, Meta, error) {
+	e, err := db.getEntry(key)
+	db.countGet(err)
+	if err != nil {
+		return 0, Meta{}, err
+	}
+	if e.valueType != Int64ValType {
+		return 0, Meta{}, fmt.Errorf("%w: expected int64, got type 0x%x", ErrTypeMismatch, e.valueType)
+	}
+	value, err := decodeInt64(e.value)
+	return value, e.meta(), err
+}
+
+// PutIfVersion writes value only if the current version of key is version,
+// and fails with ErrVersionMismatch otherwise, including when key does not
+// exist.
+func (db *Db) PutIfVersion(key, value string, version uint64) error {
+	return db.writeIfVersion(&entry{key: key, value: value, valueType: StrValType}, version)
+}
+
+func (db *Db) PutInt64IfVersion(key string, value int64, version uint64) error {
+	return db.writeIfVersion(&entry{key: key, value: encodeInt64(value), valueType: Int64ValType}, version)
+}
+
+func (db *Db) DeleteIfVersion(key string, version uint64) error {
+	return db.writeIfVersion(&entry{key: key, valueType: tombstoneValType}, version)
+}
+
+func (db *Db) writeIfVersion(e *entry, version uint64) error {
+	return db.updateEntry(e.key, func(current *entry, err error) (*entry, error) {
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrVersionMismatch, err)
+		}
+		if current.version != version {
+			return nil, fmt.Errorf("%w: key %q is at version %d, not %d", ErrVersionMismatch, e.key, current.version, version)
+		}
+		return e, nil
+	})
+}
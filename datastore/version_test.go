@@ -0,0 +1,106 @@
+package datastore
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestVersions(t *testing.T) {
+	tmp := t.TempDir()
+	db, err := Open(tmp, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := time.Now()
+	if err := db.Put("key", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	_, first, err := db.GetWithMeta("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Version == 0 || first.Modified.Before(before.Add(-time.Second)) {
+		t.Errorf("unexpected meta after put: %+v", first)
+	}
+
+	if err := db.PutIfVersion("key", "v2", first.Version+1); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("PutIfVersion with a wrong version: expected ErrVersionMismatch, got %v", err)
+	}
+	if err := db.PutIfVersion("key", "v2", first.Version); err != nil {
+		t.Fatalf("PutIfVersion with the current version: %v", err)
+	}
+	value, second, _ := db.GetWithMeta("key")
+	if value != "v2" || second.Version <= first.Version {
+		t.Errorf("expected v2 at a newer version than %d, got %q at %d", first.Version, value, second.Version)
+	}
+	if err := db.PutIfVersion("missing", "v", 0); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("PutIfVersion on a missing key: expected ErrVersionMismatch, got %v", err)
+	}
+
+	tx := db.Begin()
+	_ = tx.PutInt64("n", 7)
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	n, txMeta, err := db.GetInt64WithMeta("n")
+	if err != nil || n != 7 || txMeta.Version <= second.Version {
+		t.Errorf("transaction entries must be versioned: %d, %+v, %v", n, txMeta, err)
+	}
+
+	// Rotate a few times, merge and reopen: versions are part of the records.
+	for i := 0; i < 5; i++ {
+		if err := db.Put("filler", "0123456789012345678901234567890123456789"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.MergeSegments(); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	db, err = Open(tmp, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, meta, _ := db.GetWithMeta("key"); meta != second {
+		t.Errorf("meta after merge and reopen = %+v, want %+v", meta, second)
+	}
+	if err := db.Put("key", "v3"); err != nil {
+		t.Fatal(err)
+	}
+	if _, meta, _ := db.GetWithMeta("key"); meta.Version <= txMeta.Version {
+		t.Errorf("versions must keep growing after reopen: %d <= %d", meta.Version, txMeta.Version)
+	}
+}
+
+func TestVersions_Replicated(t *testing.T) {
+	primary, err := Open(t.TempDir(), Mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+	replica, err := Open(t.TempDir(), Mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replica.Close()
+
+	if err := primary.Put("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	r, w := io.Pipe()
+	go func() {
+		_ = primary.Snapshot(w)
+		w.Close()
+	}()
+	if err := replica.ApplyStream(r); err != nil {
+		t.Fatal(err)
+	}
+	_, want, _ := primary.GetWithMeta("key")
+	if _, got, _ := replica.GetWithMeta("key"); got != want {
+		t.Errorf("replica meta = %+v, want the primary's %+v", got, want)
+	}
+}
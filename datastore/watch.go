@@ -0,0 +1,76 @@
+package datastore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+)
+
+// Watch blocks until the version of key differs from since and returns the
+// new Meta, which is zero once the key is deleted. It returns right away if
+// the version already differs, so a client passing the version it last saw
+// never misses a change. It fails with the context error when ctx is done
+// first.
+func (db *Db) Watch(ctx context.Context, key string, since uint64) (Meta, error) {
+	for {
+		// Subscribing before reading the version means a write landing in
+		// between is still seen.
+		changes, cancel := db.Subscribe()
+		meta, err := db.currentMeta(key)
+		if err != nil || meta.Version != since {
+			cancel()
+			return meta, err
+		}
+		err = waitForKey(ctx, db, changes, key)
+		cancel()
+		if err != nil {
+			return Meta{}, err
+		}
+	}
+}
+
+// currentMeta returns the Meta of the latest write of key, zero if it does
+// not exist.
+func (db *Db) currentMeta(key string) (Meta, error) {
+	e, err := db.getEntry(key)
+	if errors.Is(err, ErrNotFound) {
+		return Meta{}, nil
+	}
+	if err != nil {
+		return Meta{}, err
+	}
+	return e.meta(), nil
+}
+
+// waitForKey returns once a batch written to key arrives on changes or the
+// subscription ends, for the caller to read the version again.
+func waitForKey(ctx context.Context, db *Db, changes <-chan []byte, key string) error {
+	for {
+		select {
+		case batch, ok := <-changes:
+			if !ok {
+				select {
+				case <-db.stopped:
+					return errWriterStopped
+				default:
+					return nil
+				}
+			}
+			if batchWrites(batch, key) {
+				return nil
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func batchWrites(batch []byte, key string) bool {
+	found := false
+	_, err := readRecords(bytes.NewReader(batch), func(_ int64, e *entry) error {
+		found = found || e.key == key
+		return nil
+	})
+	// A batch that cannot be decoded might hold the key.
+	return found || err != nil
+}
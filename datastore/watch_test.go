@@ -0,0 +1,56 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	db, err := Open(t.TempDir(), Mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	if err := db.Put("key", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	_, meta, _ := db.GetWithMeta("key")
+
+	if got, err := db.Watch(context.Background(), "key", 0); err != nil || got != meta {
+		t.Errorf("watching a stale version returned %+v, %v; want %+v", got, err, meta)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := db.Watch(ctx, "key", meta.Version); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the watch of an unchanged key to time out, got %v", err)
+	}
+
+	changed := make(chan Meta, 1)
+	go func() {
+		got, _ := db.Watch(context.Background(), "key", meta.Version)
+		changed <- got
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if err := db.Put("other", "x"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changed:
+		t.Fatal("a write to another key ended the watch")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if err := db.Delete("key"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-changed:
+		if got.Version != 0 {
+			t.Errorf("expected a zero Meta for the deleted key, got %+v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the watch missed the delete")
+	}
+}
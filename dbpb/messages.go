@@ -0,0 +1,183 @@
+package dbpb
+
+// Value holds either a string or an int64; at most one field is set.
+type Value struct {
+	StringValue *string
+	Int64Value  *int64
+}
+
+func (m *Value) Marshal() []byte {
+	var b []byte
+	switch {
+	case m.StringValue != nil:
+		b = appendBytesField(b, 1, []byte(*m.StringValue))
+	case m.Int64Value != nil:
+		b = appendVarintField(b, 2, uint64(*m.Int64Value))
+	}
+	return b
+}
+
+func (m *Value) Unmarshal(b []byte) error {
+	*m = Value{}
+	return forEachField(b, func(f field) error {
+		switch {
+		case f.num == 1 && f.typ == wireBytes:
+			s := string(f.data)
+			m.StringValue, m.Int64Value = &s, nil
+		case f.num == 2 && f.typ == wireVarint:
+			v := int64(f.v)
+			m.StringValue, m.Int64Value = nil, &v
+		}
+		return nil
+	})
+}
+
+type GetRequest struct {
+	Key string
+}
+
+func (m *GetRequest) Marshal() []byte {
+	return marshalKey(nil, m.Key)
+}
+
+func (m *GetRequest) Unmarshal(b []byte) error {
+	return unmarshalKey(b, &m.Key)
+}
+
+type GetResponse struct {
+	Value *Value
+}
+
+func (m *GetResponse) Marshal() []byte {
+	if m.Value == nil {
+		return nil
+	}
+	return appendBytesField(nil, 1, m.Value.Marshal())
+}
+
+func (m *GetResponse) Unmarshal(b []byte) error {
+	*m = GetResponse{}
+	return forEachField(b, func(f field) error {
+		if f.num == 1 && f.typ == wireBytes {
+			m.Value = &Value{}
+			return m.Value.Unmarshal(f.data)
+		}
+		return nil
+	})
+}
+
+type PutRequest struct {
+	Key   string
+	Value *Value
+}
+
+func (m *PutRequest) Marshal() []byte {
+	b := marshalKey(nil, m.Key)
+	if m.Value != nil {
+		b = appendBytesField(b, 2, m.Value.Marshal())
+	}
+	return b
+}
+
+func (m *PutRequest) Unmarshal(b []byte) error {
+	*m = PutRequest{}
+	return forEachField(b, func(f field) error {
+		switch {
+		case f.num == 1 && f.typ == wireBytes:
+			m.Key = string(f.data)
+		case f.num == 2 && f.typ == wireBytes:
+			m.Value = &Value{}
+			return m.Value.Unmarshal(f.data)
+		}
+		return nil
+	})
+}
+
+type DeleteRequest struct {
+	Key string
+}
+
+func (m *DeleteRequest) Marshal() []byte {
+	return marshalKey(nil, m.Key)
+}
+
+func (m *DeleteRequest) Unmarshal(b []byte) error {
+	return unmarshalKey(b, &m.Key)
+}
+
+// Mutation either puts a value or deletes the key.
+type Mutation struct {
+	Key    string
+	Put    *Value
+	Delete bool
+}
+
+func (m *Mutation) Marshal() []byte {
+	b := marshalKey(nil, m.Key)
+	switch {
+	case m.Put != nil:
+		b = appendBytesField(b, 2, m.Put.Marshal())
+	case m.Delete:
+		b = appendVarintField(b, 3, 1)
+	}
+	return b
+}
+
+func (m *Mutation) Unmarshal(b []byte) error {
+	*m = Mutation{}
+	return forEachField(b, func(f field) error {
+		switch {
+		case f.num == 1 && f.typ == wireBytes:
+			m.Key = string(f.data)
+		case f.num == 2 && f.typ == wireBytes:
+			m.Put, m.Delete = &Value{}, false
+			return m.Put.Unmarshal(f.data)
+		case f.num == 3 && f.typ == wireVarint:
+			m.Put, m.Delete = nil, f.v != 0
+		}
+		return nil
+	})
+}
+
+type BatchRequest struct {
+	Mutations []*Mutation
+}
+
+func (m *BatchRequest) Marshal() []byte {
+	var b []byte
+	for _, mutation := range m.Mutations {
+		b = appendBytesField(b, 1, mutation.Marshal())
+	}
+	return b
+}
+
+func (m *BatchRequest) Unmarshal(b []byte) error {
+	*m = BatchRequest{}
+	return forEachField(b, func(f field) error {
+		if f.num == 1 && f.typ == wireBytes {
+			mutation := &Mutation{}
+			if err := mutation.Unmarshal(f.data); err != nil {
+				return err
+			}
+			m.Mutations = append(m.Mutations, mutation)
+		}
+		return nil
+	})
+}
+
+func marshalKey(b []byte, key string) []byte {
+	if key == "" {
+		return b
+	}
+	return appendBytesField(b, 1, []byte(key))
+}
+
+func unmarshalKey(b []byte, key *string) error {
+	*key = ""
+	return forEachField(b, func(f field) error {
+		if f.num == 1 && f.typ == wireBytes {
+			*key = string(f.data)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,55 @@
+package dbpb
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestBatchRequestRoundtrip(t *testing.T) {
+	str, num := "value", int64(-7)
+	want := &BatchRequest{Mutations: []*Mutation{
+		{Key: "a", Put: &Value{StringValue: &str}},
+		{Key: "b", Put: &Value{Int64Value: &num}},
+		{Key: "c", Delete: true},
+	}}
+
+	var got BatchRequest
+	if err := got.Unmarshal(want.Marshal()); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&got, want) {
+		t.Errorf("roundtrip mismatch: got %+v", got.Mutations)
+	}
+}
+
+func TestUnmarshalSkipsUnknownFields(t *testing.T) {
+	b := appendVarintField(nil, 7, 1)
+	b = append(appendTag(b, 8, wireFixed64), make([]byte, 8)...)
+	b = appendBytesField(b, 1, []byte("key"))
+
+	var req GetRequest
+	if err := req.Unmarshal(b); err != nil {
+		t.Fatal(err)
+	}
+	if req.Key != "key" {
+		t.Errorf("Key = %q, want %q", req.Key, "key")
+	}
+	if err := req.Unmarshal([]byte{0x0a, 0x05, 'k'}); err == nil {
+		t.Error("expected error for truncated message")
+	}
+}
+
+func TestFrame(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteFrame(&buf, []byte("payload")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadFrame(bytes.NewReader(buf.Bytes()), 3); err == nil {
+		t.Error("expected error for message over the limit")
+	}
+	msg, err := ReadFrame(&buf, 0)
+	if err != nil || string(msg) != "payload" {
+		t.Errorf("ReadFrame = %q, %v", msg, err)
+	}
+}
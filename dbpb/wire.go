@@ -0,0 +1,117 @@
+// Package dbpb holds the messages of the gRPC API described in db.proto
+// together with their protobuf wire encoding and the gRPC message framing.
+// The encoding is written by hand on top of encoding/binary to keep the
+// module free of external dependencies.
+package dbpb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errMalformed = errors.New("malformed protobuf message")
+
+func appendTag(b []byte, num, typ int) []byte {
+	return binary.AppendUvarint(b, uint64(num)<<3|uint64(typ))
+}
+
+func appendVarintField(b []byte, num int, v uint64) []byte {
+	return binary.AppendUvarint(appendTag(b, num, wireVarint), v)
+}
+
+func appendBytesField(b []byte, num int, data []byte) []byte {
+	b = binary.AppendUvarint(appendTag(b, num, wireBytes), uint64(len(data)))
+	return append(b, data...)
+}
+
+// field is a single decoded field: v holds varint values, data the payload
+// of length-delimited ones.
+type field struct {
+	num, typ int
+	v        uint64
+	data     []byte
+}
+
+// forEachField decodes the fields of a message in order, skipping fixed-size
+// fields that none of the messages use.
+func forEachField(b []byte, fn func(f field) error) error {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errMalformed
+		}
+		b = b[n:]
+		f := field{num: int(tag >> 3), typ: int(tag & 7)}
+		switch f.typ {
+		case wireVarint:
+			if f.v, n = binary.Uvarint(b); n <= 0 {
+				return errMalformed
+			}
+			b = b[n:]
+		case wireBytes:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || size > uint64(len(b)-n) {
+				return errMalformed
+			}
+			f.data = b[n : n+int(size)]
+			b = b[n+int(size):]
+		case wireFixed64, wireFixed32:
+			size := 8
+			if f.typ == wireFixed32 {
+				size = 4
+			}
+			if len(b) < size {
+				return errMalformed
+			}
+			b = b[size:]
+			continue
+		default:
+			return fmt.Errorf("%w: unsupported wire type %d", errMalformed, f.typ)
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteFrame writes msg as a single uncompressed gRPC length-prefixed message.
+func WriteFrame(w io.Writer, msg []byte) error {
+	header := make([]byte, 5)
+	binary.BigEndian.PutUint32(header[1:], uint32(len(msg)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(msg)
+	return err
+}
+
+// ReadFrame reads a single gRPC length-prefixed message of at most maxSize
+// bytes. Compressed messages are not supported.
+func ReadFrame(r io.Reader, maxSize int) ([]byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if header[0] != 0 {
+		return nil, errors.New("compressed messages are not supported")
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if maxSize > 0 && int64(size) > int64(maxSize) {
+		return nil, fmt.Errorf("message of %d bytes exceeds limit of %d", size, maxSize)
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
@@ -0,0 +1,83 @@
+package httptools
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig lists what browsers on other origins may do. An origin of "*"
+// allows every origin.
+type CORSConfig struct {
+	AllowedOrigins []string
+	AllowedMethods []string
+	AllowedHeaders []string
+	ExposedHeaders []string
+	MaxAge         time.Duration
+}
+
+// SplitList splits a comma-separated flag value, dropping empty items.
+func SplitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// CORS adds CORS headers to responses for allowed origins and answers
+// preflight requests itself. Requests from other origins are served without
+// the headers, so browsers refuse to hand the response to the page. With no
+// allowed origins next is returned as is.
+func CORS(cfg CORSConfig, next http.Handler) http.Handler {
+	if len(cfg.AllowedOrigins) == 0 {
+		return next
+	}
+	anyOrigin := slices.Contains(cfg.AllowedOrigins, "*")
+	methods := strings.Join(cfg.AllowedMethods, ", ")
+	headers := strings.Join(cfg.AllowedHeaders, ", ")
+	exposed := strings.Join(cfg.ExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(cfg.MaxAge.Seconds()))
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		rw.Header().Add("Vary", "Origin")
+		allowed := anyOrigin || slices.Contains(cfg.AllowedOrigins, origin)
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		if preflight {
+			rw.Header().Add("Vary", "Access-Control-Request-Method")
+			rw.Header().Add("Vary", "Access-Control-Request-Headers")
+			if !allowed || !slices.Contains(cfg.AllowedMethods, r.Header.Get("Access-Control-Request-Method")) {
+				rw.WriteHeader(http.StatusForbidden)
+				return
+			}
+			rw.Header().Set("Access-Control-Allow-Origin", origin)
+			rw.Header().Set("Access-Control-Allow-Methods", methods)
+			if headers != "" {
+				rw.Header().Set("Access-Control-Allow-Headers", headers)
+			}
+			if cfg.MaxAge > 0 {
+				rw.Header().Set("Access-Control-Max-Age", maxAge)
+			}
+			rw.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if allowed {
+			rw.Header().Set("Access-Control-Allow-Origin", origin)
+			if exposed != "" {
+				rw.Header().Set("Access-Control-Expose-Headers", exposed)
+			}
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
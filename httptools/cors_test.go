@@ -0,0 +1,87 @@
+package httptools
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSplitList(t *testing.T) {
+	got := SplitList(" GET, POST,,")
+	if want := []string{"GET", "POST"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SplitList() = %v, want %v", got, want)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	next := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusTeapot)
+	})
+	h := CORS(CORSConfig{
+		AllowedOrigins: []string{"http://localhost:3000"},
+		AllowedMethods: []string{"GET", "POST"},
+		AllowedHeaders: []string{"Content-Type"},
+		ExposedHeaders: []string{"ETag"},
+		MaxAge:         10 * time.Minute,
+	}, next)
+
+	do := func(method, origin, requestMethod string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/db/key", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if requestMethod != "" {
+			req.Header.Set("Access-Control-Request-Method", requestMethod)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := do("GET", "http://localhost:3000", "")
+	if rr.Code != http.StatusTeapot || rr.Header().Get("Access-Control-Allow-Origin") != "http://localhost:3000" {
+		t.Errorf("allowed origin: got %d, %v", rr.Code, rr.Header())
+	}
+	if rr.Header().Get("Access-Control-Expose-Headers") != "ETag" {
+		t.Errorf("expected exposed headers, got %v", rr.Header())
+	}
+
+	rr = do("GET", "http://evil.example", "")
+	if rr.Code != http.StatusTeapot || rr.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("other origin: got %d, %v", rr.Code, rr.Header())
+	}
+
+	rr = do("GET", "", "")
+	if rr.Code != http.StatusTeapot || len(rr.Header()) != 0 {
+		t.Errorf("same-origin request: got %d, %v", rr.Code, rr.Header())
+	}
+
+	rr = do("OPTIONS", "http://localhost:3000", "POST")
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("preflight: expected status 204, got %d", rr.Code)
+	}
+	for name, want := range map[string]string{
+		"Access-Control-Allow-Origin":  "http://localhost:3000",
+		"Access-Control-Allow-Methods": "GET, POST",
+		"Access-Control-Allow-Headers": "Content-Type",
+		"Access-Control-Max-Age":       "600",
+	} {
+		if got := rr.Header().Get(name); got != want {
+			t.Errorf("preflight %s = %q, want %q", name, got, want)
+		}
+	}
+
+	if rr = do("OPTIONS", "http://localhost:3000", "DELETE"); rr.Code != http.StatusForbidden {
+		t.Errorf("preflight for a disallowed method: expected status 403, got %d", rr.Code)
+	}
+	if rr = do("OPTIONS", "http://evil.example", "GET"); rr.Code != http.StatusForbidden {
+		t.Errorf("preflight from another origin: expected status 403, got %d", rr.Code)
+	}
+
+	h = CORS(CORSConfig{}, next)
+	if rr = do("GET", "http://localhost:3000", ""); rr.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("disabled CORS must not add headers, got %v", rr.Header())
+	}
+}
@@ -0,0 +1,83 @@
+package integration
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCluster_Balancing(t *testing.T) {
+	c := startCluster(t, 3)
+	today := time.Now().Format(time.DateOnly)
+
+	seen := make(map[string]int)
+	for i := 0; i < 30; i++ {
+		resp, err := client.Get(c.balancer + "/api/v1/some-data?key=kpi3-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body struct {
+			Value string `json:"value"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || err != nil {
+			t.Fatalf("request %d: status %d, decode error %v", i+1, resp.StatusCode, err)
+		}
+		if body.Value != today {
+			t.Errorf("request %d: expected %s, got %s", i+1, today, body.Value)
+		}
+		seen[resp.Header.Get("lb-from")]++
+	}
+	if len(seen) < 2 {
+		t.Errorf("expected responses from several backends, got %v", seen)
+	}
+	for from := range seen {
+		if c.procs[from] == nil {
+			t.Errorf("response from unknown backend %q", from)
+		}
+	}
+}
+
+func TestCluster_MissingKey(t *testing.T) {
+	c := startCluster(t, 1)
+	if status, _ := c.get(t, "/api/v1/some-data?key=missing"); status != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing key, got %d", status)
+	}
+}
+
+func TestCluster_Failover(t *testing.T) {
+	c := startCluster(t, 2)
+	failed := c.servers[0]
+	c.procs[failed].stop()
+
+	// Requests may still reach the stopped backend until a health check or a
+	// failed forward marks it dead.
+	time.Sleep(3 * clusterHealthInterval)
+	for i := 0; i < 10; i++ {
+		status, from := c.get(t, "/api/v1/some-data?key=kpi3-test")
+		if status != http.StatusOK {
+			t.Fatalf("request %d: expected 200 after failover, got %d", i+1, status)
+		}
+		if from == failed {
+			t.Fatalf("request %d was sent to the stopped backend", i+1)
+		}
+	}
+}
+
+func TestCluster_LatencyHeaders(t *testing.T) {
+	c := startCluster(t, 1)
+	resp, err := client.Get(c.balancer + "/api/v1/some-data?key=kpi3-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get("x-cache") != "miss" {
+		t.Errorf("expected an uncached key to be a cache miss, got %q", resp.Header.Get("x-cache"))
+	}
+	if ms, err := strconv.ParseFloat(resp.Header.Get("x-db-latency-ms"), 64); err != nil || ms < 0 {
+		t.Errorf("expected the db latency in x-db-latency-ms, got %q", resp.Header.Get("x-db-latency-ms"))
+	}
+}
@@ -0,0 +1,128 @@
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+const (
+	drillBackend = "server1:8080"
+	// detectionWindow is the balancer health interval (10s) plus its
+	// request timeout (3s) and some slack for scheduling.
+	detectionWindow = 15 * time.Second
+	sampleInterval  = 100 * time.Millisecond
+)
+
+type failoverReport struct {
+	Backend           string  `json:"backend"`
+	DetectionWindowMs int64   `json:"detection_window_ms"`
+	DetectionMs       int64   `json:"detection_ms"`
+	RecoveryMs        int64   `json:"recovery_ms"`
+	RequestsToFailed  int     `json:"requests_to_failed_backend"`
+	TotalRequests     int     `json:"total_requests"`
+	ErrorRate         float64 `json:"error_rate"`
+}
+
+func setBackendHealth(t *testing.T, backend string, fail bool) {
+	t.Helper()
+	resp, err := client.Post(fmt.Sprintf("http://%s/chaos/health?fail=%t", backend, fail), "", nil)
+	if err != nil {
+		t.Fatalf("chaos API call to %s failed: %v", backend, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("chaos API call to %s returned %s", backend, resp.Status)
+	}
+}
+
+// sample sends one request through the balancer and returns the backend that
+// served it, or "" if the request failed.
+func sample() string {
+	resp, err := client.Get(fmt.Sprintf("%s/api/v1/some-data?key=%s", baseAddress, "kpi3-test"))
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return ""
+	}
+	return resp.Header.Get("lb-from")
+}
+
+func TestFailoverDrill(t *testing.T) {
+	if _, exists := os.LookupEnv("INTEGRATION_TEST"); !exists {
+		t.Skip("Integration test is not enabled")
+	}
+
+	report := failoverReport{Backend: drillBackend, DetectionWindowMs: detectionWindow.Milliseconds()}
+	failed := 0
+
+	setBackendHealth(t, drillBackend, true)
+	defer setBackendHealth(t, drillBackend, false)
+	disabledAt := time.Now()
+	lastSeen := disabledAt
+
+	// Keep sampling for the whole window plus a quiet period, so a backend
+	// that is never taken out of rotation fails the drill.
+	for time.Since(disabledAt) < detectionWindow+5*time.Second {
+		from := sample()
+		report.TotalRequests++
+		switch from {
+		case "":
+			failed++
+		case drillBackend:
+			report.RequestsToFailed++
+			lastSeen = time.Now()
+		}
+		time.Sleep(sampleInterval)
+	}
+	report.DetectionMs = lastSeen.Sub(disabledAt).Milliseconds()
+	if detection := lastSeen.Sub(disabledAt); detection > detectionWindow {
+		t.Errorf("balancer kept sending traffic to %s for %s, expected at most %s", drillBackend, detection, detectionWindow)
+	}
+
+	setBackendHealth(t, drillBackend, false)
+	enabledAt := time.Now()
+	recovered := false
+	for time.Since(enabledAt) < detectionWindow {
+		from := sample()
+		report.TotalRequests++
+		if from == "" {
+			failed++
+		}
+		if from == drillBackend {
+			recovered = true
+			break
+		}
+		time.Sleep(sampleInterval)
+	}
+	report.RecoveryMs = time.Since(enabledAt).Milliseconds()
+	if !recovered {
+		t.Errorf("traffic to %s did not resume within %s", drillBackend, detectionWindow)
+	}
+
+	report.ErrorRate = float64(failed) / float64(report.TotalRequests)
+	writeFailoverReport(t, report)
+}
+
+func writeFailoverReport(t *testing.T, report failoverReport) {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("failover report:\n%s", data)
+
+	path := os.Getenv("FAILOVER_REPORT_PATH")
+	if path == "" {
+		path = "failover-report.json"
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Errorf("cannot write failover report to %s: %v", path, err)
+	}
+}
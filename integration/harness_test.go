@@ -0,0 +1,206 @@
+package integration
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/internal/lb"
+)
+
+// The harness runs the API servers and the db from ./cmd on loopback ports,
+// with the balancer in front of them in the test process, so the end-to-end
+// behaviour can be tested with plain `go test` instead of docker-compose. The
+// commands are built once per test binary.
+
+var (
+	buildOnce sync.Once
+	binDir    string
+	buildErr  error
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if binDir != "" {
+		os.RemoveAll(binDir)
+	}
+	os.Exit(code)
+}
+
+func buildCommands(t *testing.T) string {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("the cluster harness builds and starts every service")
+	}
+	buildOnce.Do(func() {
+		binDir, buildErr = os.MkdirTemp("", "integration-bin")
+		if buildErr != nil {
+			return
+		}
+		cmd := exec.Command("go", "build", "-o", binDir+string(filepath.Separator), "./cmd/server", "./cmd/db")
+		cmd.Dir = ".."
+		if out, err := cmd.CombinedOutput(); err != nil {
+			buildErr = fmt.Errorf("go build: %w\n%s", err, out)
+		}
+	})
+	if buildErr != nil {
+		t.Fatal(buildErr)
+	}
+	return binDir
+}
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// process is a service started by the harness. Its output is kept and logged
+// if the test fails.
+type process struct {
+	name string
+	cmd  *exec.Cmd
+	out  bytes.Buffer
+	done chan struct{}
+}
+
+func startProcess(t *testing.T, name, bin string, args ...string) *process {
+	t.Helper()
+	p := &process{name: name, done: make(chan struct{})}
+	p.cmd = exec.Command(filepath.Join(binDir, bin), args...)
+	p.cmd.Stdout, p.cmd.Stderr = &p.out, &p.out
+	if err := p.cmd.Start(); err != nil {
+		t.Fatalf("start %s: %v", name, err)
+	}
+	go func() {
+		_ = p.cmd.Wait()
+		close(p.done)
+	}()
+	t.Cleanup(func() {
+		p.stop()
+		if t.Failed() {
+			t.Logf("%s output:\n%s", name, p.out.String())
+		}
+	})
+	return p
+}
+
+// stop sends SIGTERM and kills the process if it does not exit in time.
+func (p *process) stop() {
+	_ = p.cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case <-p.done:
+	case <-time.After(5 * time.Second):
+		_ = p.cmd.Process.Kill()
+		<-p.done
+	}
+}
+
+// waitHealthy polls url until it answers 200.
+func waitHealthy(t *testing.T, p *process, url string) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		resp, err := client.Get(url)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return
+			}
+		}
+		select {
+		case <-p.done:
+			t.Fatalf("%s exited:\n%s", p.name, p.out.String())
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	t.Fatalf("%s did not become healthy at %s", p.name, url)
+}
+
+// cluster is a balancer in front of API servers sharing one db. admin
+// serves the balancer's runtime API.
+type cluster struct {
+	balancer string
+	admin    string
+	servers  []string
+	procs    map[string]*process
+}
+
+const clusterHealthInterval = 200 * time.Millisecond
+
+func startCluster(t *testing.T, servers int) *cluster {
+	t.Helper()
+	return startClusterWith(t, servers, nil)
+}
+
+// startClusterWith is startCluster that lets configure adjust the balancer
+// config, e.g. to put a proxy in front of a backend, before the balancer
+// starts.
+func startClusterWith(t *testing.T, servers int, configure func(*lb.Config)) *cluster {
+	t.Helper()
+	buildCommands(t)
+	c := &cluster{procs: make(map[string]*process)}
+
+	dbAddr := fmt.Sprintf("127.0.0.1:%d", freePort(t))
+	db := startProcess(t, "db", "db", "-addr", dbAddr, "-path", t.TempDir(), "-grpc-addr", "", "-disk-check-interval", "0")
+	waitHealthy(t, db, "http://"+dbAddr+"/health")
+
+	for i := 0; i < servers; i++ {
+		port := freePort(t)
+		addr := fmt.Sprintf("127.0.0.1:%d", port)
+		name := fmt.Sprintf("server%d", i+1)
+		p := startProcess(t, name, "server", "-port", fmt.Sprint(port), "-db-url", "http://"+dbAddr, "-instance-id", name, "-test-mode")
+		waitHealthy(t, p, "http://"+addr+"/health")
+		c.servers = append(c.servers, addr)
+		c.procs[addr] = p
+	}
+
+	cfg := lb.DefaultConfig()
+	cfg.Backends = c.servers
+	cfg.Trace = true
+	cfg.HealthInterval = clusterHealthInterval
+	if configure != nil {
+		configure(&cfg)
+	}
+	balancer, err := lb.NewBalancer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frontend := httptest.NewServer(balancer)
+	admin := httptest.NewServer(balancer.AdminHandler())
+	t.Cleanup(func() {
+		admin.Close()
+		frontend.Close()
+		balancer.Close()
+	})
+	c.balancer, c.admin = frontend.URL, admin.URL
+	if status, _ := c.get(t, "/api/v1/some-data?key=kpi3-test"); status != http.StatusOK {
+		t.Fatalf("balancer answered %d", status)
+	}
+	return c
+}
+
+// get sends a request through the balancer and returns the status and the
+// backend that served it.
+func (c *cluster) get(t *testing.T, path string) (int, string) {
+	t.Helper()
+	resp, err := client.Get(c.balancer + path)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode, resp.Header.Get("lb-from")
+}
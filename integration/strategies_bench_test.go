@@ -0,0 +1,177 @@
+//go:build bench
+
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/internal/lb"
+)
+
+// The strategy comparison runs one workload through the cluster under every
+// selection strategy, switched through /admin/strategy, with one backend
+// slowed down by a proxy, and reports the latency percentiles and how evenly
+// the requests were spread. It is slow, so it only builds with the bench tag:
+//
+//	go test -tags bench -run TestStrategyComparison -v ./integration
+
+const (
+	benchRequests    = 600
+	benchConcurrency = 8
+	benchSlowdown    = 20 * time.Millisecond
+)
+
+var benchStrategies = []string{"least-traffic", "least-connections", "round-robin", "latency-aware", "least-latency"}
+
+type strategyResult struct {
+	Strategy string         `json:"strategy"`
+	Requests int            `json:"requests"`
+	Errors   int            `json:"errors"`
+	P50Ms    float64        `json:"p50_ms"`
+	P90Ms    float64        `json:"p90_ms"`
+	P99Ms    float64        `json:"p99_ms"`
+	Backends map[string]int `json:"backends"`
+	// Skew is the share of the busiest backend over an even share: 1 means
+	// the requests were spread evenly.
+	Skew float64 `json:"skew"`
+	// SlowShare is the fraction of requests sent to the slowed backend.
+	SlowShare float64 `json:"slow_share"`
+}
+
+func TestStrategyComparison(t *testing.T) {
+	var slow string
+	c := startClusterWith(t, 3, func(cfg *lb.Config) {
+		slow = slowProxy(t, cfg.Backends[2], benchSlowdown)
+		cfg.Backends[2] = slow
+	})
+
+	var results []strategyResult
+	for _, strategy := range benchStrategies {
+		setStrategy(t, c, strategy)
+		res := runWorkload(c)
+		res.Strategy = strategy
+		res.SlowShare = float64(res.Backends[slow]) / float64(res.Requests)
+		results = append(results, res)
+		t.Logf("%-18s p50 %6.1fms  p90 %6.1fms  p99 %6.1fms  skew %.2f  slow backend %4.1f%%  errors %d",
+			strategy, res.P50Ms, res.P90Ms, res.P99Ms, res.Skew, res.SlowShare*100, res.Errors)
+		if res.Errors > 0 {
+			t.Errorf("%s: %d of %d requests failed", strategy, res.Errors, res.Requests)
+		}
+	}
+	writeStrategyReport(t, results)
+}
+
+// slowProxy starts a proxy that delays every request to backend and returns
+// its address.
+func slowProxy(t *testing.T, backend string, delay time.Duration) string {
+	t.Helper()
+	proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: backend})
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		proxy.ServeHTTP(rw, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.Listener.Addr().String()
+}
+
+func setStrategy(t *testing.T, c *cluster, strategy string) {
+	t.Helper()
+	resp, err := client.Post(c.admin+"/admin/strategy?strategy="+strategy, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("switching to %s: %s", strategy, resp.Status)
+	}
+}
+
+// runWorkload sends benchRequests requests through the balancer from
+// benchConcurrency clients.
+func runWorkload(c *cluster) strategyResult {
+	var (
+		mu        sync.Mutex
+		latencies []time.Duration
+		res       = strategyResult{Requests: benchRequests, Backends: make(map[string]int)}
+	)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < benchConcurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				start := time.Now()
+				resp, err := client.Get(fmt.Sprintf("%s/api/v1/some-data?key=kpi3-test&nocache=1&n=%d", c.balancer, i))
+				elapsed := time.Since(start)
+				from := ""
+				if err == nil {
+					_, _ = io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+					if resp.StatusCode == http.StatusOK {
+						from = resp.Header.Get("lb-from")
+					}
+				}
+				mu.Lock()
+				if from == "" {
+					res.Errors++
+				} else {
+					res.Backends[from]++
+					latencies = append(latencies, elapsed)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < benchRequests; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	slices.Sort(latencies)
+	res.P50Ms, res.P90Ms, res.P99Ms = percentileMs(latencies, 0.5), percentileMs(latencies, 0.9), percentileMs(latencies, 0.99)
+	busiest := 0
+	for _, n := range res.Backends {
+		busiest = max(busiest, n)
+	}
+	if served := len(latencies); served > 0 {
+		res.Skew = float64(busiest) / (float64(served) / float64(len(c.servers)))
+	}
+	return res
+}
+
+// percentileMs returns the p-th percentile of sorted latencies in
+// milliseconds.
+func percentileMs(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := min(int(p*float64(len(sorted))), len(sorted)-1)
+	return float64(sorted[i]) / float64(time.Millisecond)
+}
+
+func writeStrategyReport(t *testing.T, results []strategyResult) {
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("STRATEGY_REPORT_PATH")
+	if path == "" {
+		path = "strategy-report.json"
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Errorf("cannot write strategy report to %s: %v", path, err)
+	}
+}
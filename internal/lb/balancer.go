@@ -0,0 +1,815 @@
+// Package lb implements the load balancer: a pool of health checked backends
+// and the HTTP handler that picks one of them for every request and forwards
+// it there.
+package lb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/internal/telemetry"
+)
+
+// Config configures a Balancer. cmd/lb fills it from its flags; DefaultConfig
+// holds the flag defaults.
+type Config struct {
+	// Port is where the frontend listens. The Balancer does not listen
+	// itself, but a reload reports a new port as a restart-only change.
+	Port int
+	// Timeout limits health checks and forwarded requests. Routes and the
+	// X-LB-Timeout header override it for requests, the latter up to
+	// MaxTimeout, if set.
+	Timeout    time.Duration
+	MaxTimeout time.Duration
+	// SlowThreshold, if set, is the duration above which a request is
+	// logged and counted as slow.
+	SlowThreshold time.Duration
+	// HTTPS makes the balancer talk over TLS to the backends configured
+	// without an http:// or https:// prefix.
+	HTTPS bool
+	// Trace adds lb-from, lb-traffic-before and request ID headers to
+	// responses and an lb-traffic-after trailer.
+	Trace    bool
+	Strategy string
+	HashKey  string
+
+	// Backends is the default pool; Groups are named pools that routes send
+	// requests to.
+	Backends []string
+	Groups   map[string][]string
+	Routes   []Route
+	// Discovery, if set (see ParseDiscovery), replaces the default pool
+	// with the backends it finds every DiscoveryInterval.
+	Discovery         string
+	DiscoveryInterval time.Duration
+	// Versions tags backends with a version label, for canary releases.
+	Versions      map[string]string
+	CanaryWeight  float64
+	CanaryVersion string
+
+	// Maintenance takes backends out of rotation on a schedule.
+	Maintenance []MaintenanceWindow
+	// DrainTimeout is how long a removed backend may finish its requests
+	// in flight before they are cut.
+	DrainTimeout time.Duration
+
+	// MaxRetries is how many other backends a bodiless idempotent request
+	// is sent to when its backend gives no response; RetryBudget, if set,
+	// limits the total time spent on all attempts.
+	MaxRetries  int
+	RetryBudget time.Duration
+
+	// Queue configures the per-backend in-flight limit and the queue of
+	// requests waiting for a backend.
+	Queue QueueOptions
+
+	HealthInterval time.Duration
+	// HealthJitter randomly stretches or shrinks every health check
+	// interval by up to this fraction, so backends added together are not
+	// checked in lockstep.
+	HealthJitter    float64
+	DegradedLatency time.Duration
+	// SlowStart is how long the traffic of a backend that recovered ramps
+	// up; 0 sends it its full share right away.
+	SlowStart     time.Duration
+	TrafficWindow time.Duration
+
+	StripResponseHeaders string
+	AllowResponseHeaders string
+	PreserveHost         bool
+	TrustForwarded       bool
+
+	// Compress configures the compression of responses for clients that
+	// accept it.
+	Compress CompressOptions
+
+	Echo      EchoOptions
+	Transport TransportOptions
+
+	ShadowTo      string
+	ShadowPercent float64
+
+	JournalPath       string
+	JournalMaxEntries int
+
+	// Scale configures the hooks that ask external tooling to resize the
+	// server tier.
+	Scale ScaleOptions
+
+	// ConfigFile is a JSON file (see FileConfig) applied on top of the
+	// config by NewBalancer and again by every Reload. Pinned lists the
+	// settings, by cmd/lb flag name, that the file must not override.
+	ConfigFile string
+	Pinned     map[string]bool
+}
+
+// DefaultConfig returns the configuration of a balancer started without
+// flags.
+func DefaultConfig() Config {
+	return Config{
+		Port:       8090,
+		Timeout:    3 * time.Second,
+		MaxTimeout: 30 * time.Second,
+		Strategy:   "least-traffic",
+		HashKey:    "path",
+		Backends: []string{
+			"server1:8080",
+			"server2:8080",
+			"server3:8080",
+		},
+		CanaryVersion:   "canary",
+		DrainTimeout:    30 * time.Second,
+		HealthInterval:  10 * time.Second,
+		HealthJitter:    0.2,
+		DegradedLatency: time.Second,
+		TrafficWindow:   time.Minute,
+		Echo:            EchoOptions{PayloadBytes: 1024},
+		Transport: TransportOptions{
+			MaxIdleConnsPerHost: 64,
+			IdleConnTimeout:     90 * time.Second,
+			DialTimeout:         2 * time.Second,
+		},
+		ShadowPercent:     100,
+		JournalMaxEntries: 10000,
+		DiscoveryInterval: 10 * time.Second,
+		Compress:          CompressOptions{Types: defaultCompressTypes, MinBytes: 1024},
+		Queue:             QueueOptions{MaxLength: 1000},
+		Scale: ScaleOptions{
+			UpPercent: 80,
+			Sustain:   30 * time.Second,
+			Cooldown:  5 * time.Minute,
+		},
+	}
+}
+
+func (c *Config) scheme() string {
+	if c.HTTPS {
+		return "https"
+	}
+	return "http"
+}
+
+// validate checks the settings a reload can change and parses the
+// maintenance schedules.
+func (c *Config) validate() error {
+	if len(c.Backends) == 0 && len(c.Groups) == 0 {
+		return fmt.Errorf("no backends")
+	}
+	if err := validateStrategy(c.Strategy, c.HashKey); err != nil {
+		return err
+	}
+	if err := validateRoutes(c.Groups, c.Routes); err != nil {
+		return err
+	}
+	// The windows may be shared with a config in use, so they are parsed
+	// into a copy.
+	windows := slices.Clone(c.Maintenance)
+	if err := parseMaintenance(windows); err != nil {
+		return err
+	}
+	c.Maintenance = windows
+	if c.Discovery != "" && c.DiscoveryInterval <= 0 {
+		return fmt.Errorf("discovery interval must be positive")
+	}
+	if err := validateBackends(c.Backends, c.Groups); err != nil {
+		return err
+	}
+	if c.HealthJitter < 0 || c.HealthJitter >= 1 {
+		return fmt.Errorf("health jitter must be in [0, 1), got %g", c.HealthJitter)
+	}
+	if c.MaxRetries < 0 || c.RetryBudget < 0 {
+		return fmt.Errorf("retry limits must not be negative")
+	}
+	if err := c.Scale.validate(); err != nil {
+		return err
+	}
+	if err := c.Compress.validate(); err != nil {
+		return err
+	}
+	if err := c.Queue.validate(); err != nil {
+		return err
+	}
+	if c.SlowStart < 0 {
+		return fmt.Errorf("slow start must not be negative")
+	}
+	if c.MaxTimeout < 0 || c.SlowThreshold < 0 {
+		return fmt.Errorf("max timeout and slow threshold must not be negative")
+	}
+	return validCanaryWeight(c.CanaryWeight)
+}
+
+// Balancer spreads requests over a pool of backends. It is an http.Handler;
+// AdminHandler serves its runtime API.
+type Balancer struct {
+	// mu guards cfg and headerFilter, the settings that can change at
+	// runtime. The backend pool is guarded by serversMu.
+	mu           sync.RWMutex
+	cfg          Config
+	headerFilter *headerFilter
+	// reloadMu serializes reloads.
+	reloadMu sync.Mutex
+
+	serversMu sync.RWMutex
+	servers   []*ServerInfo
+	// healthChecks and drains track the health check and drain goroutines,
+	// so Close can wait for them.
+	healthChecks sync.WaitGroup
+	drains       sync.WaitGroup
+	// background tracks the scaling and discovery goroutines, which
+	// stopBackground ends.
+	background     sync.WaitGroup
+	stopBackground context.CancelFunc
+
+	// canaryVersion is Config.CanaryVersion, which reloads do not change.
+	canaryVersion string
+	rings         ringCache
+	roundRobin    atomic.Uint64
+	queue         waitQueue
+	shadowSlots   chan struct{}
+	shadowClient  *http.Client
+	journal       *journal
+	metrics       metrics
+	// tracer traces backend selection and forwarding; nil disables it.
+	tracer *telemetry.Tracer
+}
+
+// NewBalancer applies cfg.ConfigFile, if any, to cfg, validates the result
+// and starts health checking the backends.
+func NewBalancer(cfg Config) (*Balancer, error) {
+	if cfg.ConfigFile != "" {
+		fileCfg, err := loadConfig(cfg.ConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		fileCfg.apply(&cfg)
+	}
+	var discovery Discoverer
+	if cfg.Discovery != "" {
+		d, err := ParseDiscovery(cfg.Discovery)
+		if err != nil {
+			return nil, err
+		}
+		discovery = d
+		cfg.Backends = discoverInitial(d, cfg.Backends, cfg.Timeout)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if _, err := cfg.Transport.tlsConfig(""); err != nil {
+		return nil, err
+	}
+	b := newBalancer(cfg)
+	if cfg.JournalPath != "" {
+		j, err := openJournal(cfg.JournalPath, cfg.JournalMaxEntries)
+		if err != nil {
+			return nil, err
+		}
+		b.journal = j
+	}
+	b.setServerPool(cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	b.stopBackground = cancel
+	b.startScaling(ctx)
+	if discovery != nil {
+		b.startDiscovery(ctx, discovery)
+	}
+	return b, nil
+}
+
+// newBalancer returns a balancer without backends.
+func newBalancer(cfg Config) *Balancer {
+	return &Balancer{
+		cfg:           cfg,
+		headerFilter:  newHeaderFilter(cfg.StripResponseHeaders, cfg.AllowResponseHeaders),
+		canaryVersion: cfg.CanaryVersion,
+		shadowSlots:   make(chan struct{}, shadowMaxInFlight),
+		shadowClient:  &http.Client{Transport: newBackendTransport(cfg.Transport, cfg.Transport.backendTLS(cfg.ShadowTo))},
+	}
+}
+
+// SetTracer makes the balancer trace the selection of a backend and every
+// forwarding attempt, as children of the span in the request context. It
+// must be called before the balancer serves requests.
+func (b *Balancer) SetTracer(t *telemetry.Tracer) {
+	b.tracer = t
+}
+
+// Config returns the settings in effect.
+func (b *Balancer) Config() Config {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.cfg
+}
+
+// Close stops the health checks, discovery and the scaling hooks and closes
+// the journal. Requests in flight are not interrupted, except on draining
+// backends.
+func (b *Balancer) Close() error {
+	if b.stopBackground != nil {
+		b.stopBackground()
+	}
+	b.serversMu.Lock()
+	for _, s := range b.servers {
+		s.stop()
+		if state, _ := s.State(); state == stateDraining {
+			s.cutInFlight()
+		}
+	}
+	b.servers = nil
+	b.serversMu.Unlock()
+	b.healthChecks.Wait()
+	b.drains.Wait()
+	b.background.Wait()
+	if b.journal != nil {
+		return b.journal.Close()
+	}
+	return nil
+}
+
+// AdminHandler serves the runtime API: /admin/reload, /admin/backends,
+// /admin/canary, /admin/strategy and /admin/journal.
+func (b *Balancer) AdminHandler() http.Handler {
+	admin := http.NewServeMux()
+	admin.HandleFunc("/admin/reload", b.handleReload)
+	admin.HandleFunc("/admin/backends", b.handleBackends)
+	admin.HandleFunc("/admin/canary", b.handleCanary)
+	admin.HandleFunc("/admin/strategy", b.handleStrategy)
+	admin.HandleFunc("/admin/journal", b.handleJournal)
+	return admin
+}
+
+func (b *Balancer) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	b.metrics.requests.Add(1)
+	b.metrics.inFlight.Add(1)
+	defer b.metrics.inFlight.Add(-1)
+
+	if r.URL.Path == echoPath && b.serveEcho(rw, r) {
+		return
+	}
+	if r.URL.Path == statsPath {
+		b.serveStats(rw, r)
+		return
+	}
+
+	if _, err := b.requestTimeout(r); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	start := time.Now()
+	_, span := b.tracer.Start(r.Context(), "lb.select", telemetry.KindInternal)
+	selectedServer := b.selectServer(r)
+	if selectedServer == nil {
+		span.SetAttribute("lb.queued", true)
+		selectedServer = b.awaitServer(r)
+	}
+	b.mu.RLock()
+	span.SetAttribute("lb.strategy", b.cfg.Strategy)
+	b.mu.RUnlock()
+	if selectedServer != nil {
+		span.SetAttribute("lb.backend", selectedServer.GetURL())
+	} else {
+		span.SetError(errNoBackend)
+	}
+	span.End()
+
+	if selectedServer == nil {
+		b.metrics.noBackend.Add(1)
+		log.Println("No healthy servers available to handle the request.")
+		http.Error(rw, "Service unavailable", http.StatusServiceUnavailable)
+		b.journalRequest(r, "", http.StatusServiceUnavailable, start, errNoBackend)
+		return
+	}
+
+	log.Printf("Selected server %s with traffic %d bytes", selectedServer.GetURL(), selectedServer.GetTraffic())
+	if b.shadowSampled() {
+		b.shadow(r)
+	}
+	rec := &statusRecorder{ResponseWriter: rw}
+	selectedServer, err := b.forwardWithRetries(selectedServer, rec, r)
+	if err != nil {
+		b.metrics.forwardErrors.Add(1)
+		log.Printf("Error during forwarding to %s: %v", selectedServer.GetURL(), err)
+	}
+	b.observeLatency(selectedServer, r, rec.status, time.Since(start))
+	b.journalRequest(r, selectedServer.GetURL(), rec.status, start, err)
+}
+
+type ServerInfo struct {
+	URL        string
+	Group      string // backend group; "" is the default pool
+	Version    string // version label; see Config.CanaryVersion
+	stateMu    sync.Mutex
+	state      backendState
+	stateSince time.Time
+	// recoveredAt is when the backend last came back from dead or
+	// maintenance; see Config.SlowStart.
+	recoveredAt  time.Time
+	trafficBytes atomic.Int64
+	traffic      *decayingRate
+	inFlight     atomic.Int64
+	maxInFlight  atomic.Int64 // Config.Queue.MaxInFlight
+	slowRequests atomic.Int64
+	latency      latencyAverage
+	// scheme, if set, overrides Config.HTTPS for the backend; addr is its
+	// host:port.
+	scheme       string
+	addr         string
+	client       *http.Client
+	healthClient *http.Client
+	// checks lives while the backend is in the pool; it scopes the health
+	// check goroutine and its requests. done is checks.Done().
+	checks     context.Context
+	stopChecks context.CancelFunc
+	done       <-chan struct{}
+	// cut is cancelled to abort the requests in flight of a backend that
+	// did not drain in time.
+	cut         context.Context
+	cutInFlight context.CancelFunc
+}
+
+// ServerSnapshot is a point-in-time copy of a backend's state.
+type ServerSnapshot struct {
+	URL          string       `json:"url"`
+	Group        string       `json:"group,omitempty"`
+	Version      string       `json:"version,omitempty"`
+	Alive        bool         `json:"alive"`
+	State        backendState `json:"state"`
+	StateSince   time.Time    `json:"state_since"`
+	TrafficBytes int64        `json:"traffic_bytes"`
+	TrafficRate  float64      `json:"traffic_rate"`
+	InFlight     int64        `json:"in_flight"`
+	SlowRequests int64        `json:"slow_requests"`
+	LatencyMs    float64      `json:"latency_ms"` // moving average of response times
+}
+
+func newServerInfo(url string, alive bool, cfg *Config) *ServerInfo {
+	tlsConfig := cfg.Transport.backendTLS(url)
+	s := &ServerInfo{
+		URL:          url,
+		traffic:      newDecayingRate(cfg.TrafficWindow),
+		client:       &http.Client{Transport: newBackendTransport(cfg.Transport, tlsConfig)},
+		healthClient: newHealthClient(tlsConfig),
+	}
+	// Invalid backends are rejected by Config.validate.
+	s.scheme, s.addr, _ = parseBackend(url)
+	s.checks, s.stopChecks = context.WithCancel(context.Background())
+	s.done = s.checks.Done()
+	s.cut, s.cutInFlight = context.WithCancel(context.Background())
+	s.state, s.stateSince = stateHealthy, time.Now()
+	s.maxInFlight.Store(int64(cfg.Queue.MaxInFlight))
+	if !alive {
+		s.state = stateDead
+	}
+	return s
+}
+
+// SetAlive marks a backend dead, or brings a dead one back as warming.
+func (s *ServerInfo) SetAlive(alive bool) {
+	if alive {
+		s.transition(stateWarming)
+	} else {
+		s.transition(stateDead)
+	}
+}
+
+// IsAlive reports whether the backend's state lets it receive requests.
+func (s *ServerInfo) IsAlive() bool {
+	state, _ := s.State()
+	return state.serving()
+}
+
+// AddTraffic accounts bytes sent by the backend and returns the new total.
+func (s *ServerInfo) AddTraffic(bytes int64) int64 {
+	return s.AddWeightedTraffic(bytes, 1)
+}
+
+// AddWeightedTraffic is AddTraffic for responses that cost the backend more
+// (or less) than their size: the traffic rate used for selection grows by
+// bytes*cost, while the total counts the actual bytes.
+func (s *ServerInfo) AddWeightedTraffic(bytes int64, cost float64) int64 {
+	s.traffic.Add(float64(bytes) * cost)
+	return s.trafficBytes.Add(bytes)
+}
+
+// TrafficRate returns the recent traffic in bytes per second, decayed over
+// Config.TrafficWindow.
+func (s *ServerInfo) TrafficRate() float64 {
+	return s.traffic.Rate()
+}
+
+func (s *ServerInfo) GetTraffic() int64 {
+	return s.trafficBytes.Load()
+}
+
+// InFlight returns the number of requests currently forwarded to the backend.
+func (s *ServerInfo) InFlight() int64 {
+	return s.inFlight.Load()
+}
+
+func (s *ServerInfo) GetURL() string {
+	return s.URL
+}
+
+// target returns the scheme and address requests to the backend go to.
+func (s *ServerInfo) target(defaultScheme string) (scheme, addr string) {
+	if s.scheme != "" {
+		return s.scheme, s.addr
+	}
+	return defaultScheme, s.addr
+}
+
+// stop ends the health checks of a backend removed from the pool, including
+// one in progress.
+func (s *ServerInfo) stop() {
+	s.stopChecks()
+}
+
+func (s *ServerInfo) Snapshot() ServerSnapshot {
+	state, since := s.State()
+	return ServerSnapshot{
+		URL:          s.URL,
+		Group:        s.Group,
+		Version:      s.Version,
+		Alive:        state.serving(),
+		State:        state,
+		StateSince:   since,
+		TrafficBytes: s.trafficBytes.Load(),
+		TrafficRate:  s.traffic.Rate(),
+		InFlight:     s.inFlight.Load(),
+		SlowRequests: s.slowRequests.Load(),
+		LatencyMs:    float64(s.latency.load()) / float64(time.Millisecond),
+	}
+}
+
+// Backends returns a snapshot of every backend in the pool.
+func (b *Balancer) Backends() []ServerSnapshot {
+	b.serversMu.RLock()
+	defer b.serversMu.RUnlock()
+	snapshots := make([]ServerSnapshot, 0, len(b.servers))
+	for _, s := range b.servers {
+		snapshots = append(snapshots, s.Snapshot())
+	}
+	return snapshots
+}
+
+func (b *Balancer) health(server *ServerInfo) {
+	b.mu.RLock()
+	timeout, scheme, degradedLatency := b.cfg.Timeout, b.cfg.scheme(), b.cfg.DegradedLatency
+	windows := b.cfg.Maintenance
+	b.mu.RUnlock()
+	if server.checkMaintenance(windows, time.Now()) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(server.checks, timeout)
+	defer cancel()
+
+	scheme, addr := server.target(scheme)
+	req, _ := http.NewRequestWithContext(ctx, "GET",
+		fmt.Sprintf("%s://%s/health", scheme, addr), nil)
+	start := time.Now()
+	resp, err := server.healthClient.Do(req)
+	latency := time.Since(start)
+
+	currentStatus := false
+	if err == nil && resp.StatusCode == http.StatusOK {
+		currentStatus = true
+	}
+	if resp != nil {
+		resp.Body.Close()
+	}
+	if server.checks.Err() != nil {
+		// The backend left the pool during the check.
+		return
+	}
+	server.observeHealth(currentStatus, latency, degradedLatency)
+	if server.IsAlive() {
+		b.queue.wake()
+	}
+}
+
+// forward sends r to server and copies the response to rw, answering 503 if
+// the backend gives no response.
+func (b *Balancer) forward(server *ServerInfo, rw http.ResponseWriter, r *http.Request) error {
+	err := b.tryForward(server, rw, r)
+	if errors.Is(err, errBackendUnreachable) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}
+	return err
+}
+
+// tryForward is forward without the 503: if the backend gives no response,
+// it returns an error wrapping errBackendUnreachable and leaves rw untouched
+// apart from headers.
+func (b *Balancer) tryForward(server *ServerInfo, rw http.ResponseWriter, r *http.Request) error {
+	server.inFlight.Add(1)
+	defer func() {
+		server.inFlight.Add(-1)
+		b.queue.wake()
+	}()
+
+	b.mu.RLock()
+	headerFilter := b.headerFilter
+	scheme, trace, trustForwarded := b.cfg.scheme(), b.cfg.Trace, b.cfg.TrustForwarded
+	compress := b.cfg.Compress
+	b.mu.RUnlock()
+	requestTimeout, _ := b.requestTimeout(r)
+
+	scheme, dst := server.target(scheme)
+	ctx, span := b.tracer.Start(r.Context(), "lb.forward", telemetry.KindClient)
+	defer span.End()
+	span.SetAttribute("http.request.method", r.Method)
+	span.SetAttribute("server.address", dst)
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	defer context.AfterFunc(server.cut, cancel)()
+
+	fwdRequest := r.Clone(ctx)
+	fwdRequest.RequestURI = ""
+	fwdRequest.URL.Host = dst
+	fwdRequest.URL.Scheme = scheme
+	fwdRequest.Header.Del(timeoutHeader)
+	if !b.shouldPreserveHost(r) {
+		fwdRequest.Host = dst
+	}
+	removeHopHeaders(fwdRequest.Header)
+	setForwardedHeaders(fwdRequest, r, trustForwarded)
+	var logPrefix string
+	if trace {
+		requestID := setTraceHeaders(fwdRequest.Header)
+		rw.Header().Set(requestIDHeader, requestID)
+		logPrefix = "[" + requestID + "] "
+	}
+	// The forward span, if traced, is the parent of the backend's spans.
+	telemetry.Inject(ctx, fwdRequest.Header)
+
+	sent := time.Now()
+	resp, err := server.client.Do(fwdRequest)
+	if err != nil {
+		log.Printf("%sFailed to get response from %s: %s", logPrefix, dst, err)
+		server.SetAlive(false)
+		span.SetError(err)
+		return fmt.Errorf("%w: %w", errBackendUnreachable, err)
+	}
+	defer resp.Body.Close()
+	span.SetHTTPStatus(resp.StatusCode)
+	// The average is of the time to the response headers of this attempt
+	// only: retries on other backends and a slow client copying the body
+	// say nothing about this backend.
+	server.latency.observe(time.Since(sent))
+
+	removeHopHeaders(resp.Header)
+	headerFilter.apply(resp.Header)
+	for k, values := range resp.Header {
+		for _, value := range values {
+			rw.Header().Add(k, value)
+		}
+	}
+	encoding := compress.encodingFor(r, resp)
+	if encoding != "" {
+		prepareCompressed(rw.Header(), encoding)
+		b.metrics.compressed.Add(1)
+	}
+	trafficBefore := server.GetTraffic()
+	if trace {
+		rw.Header().Set("lb-from", dst)
+		rw.Header().Set("lb-traffic-before", fmt.Sprintf("%d", trafficBefore))
+		// The traffic after the response is only known once the body is
+		// copied, so it is sent as a trailer, which needs a chunked body.
+		rw.Header().Add("Trailer", trafficAfterHeader)
+		rw.Header().Del("Content-Length")
+	}
+
+	rw.WriteHeader(resp.StatusCode)
+
+	// The traffic of a compressed response is what it costs on the wire.
+	bytesWritten, copyErr := copyBody(rw, resp.Body, encoding)
+	if copyErr != nil {
+		log.Printf("%sFailed to write response body for %s: %s", logPrefix, dst, copyErr)
+		span.SetError(copyErr)
+		return copyErr
+	}
+
+	trafficAfter := trafficBefore
+	if bytesWritten > 0 {
+		trafficAfter = server.AddWeightedTraffic(bytesWritten, b.routeCost(r))
+	}
+	if trace {
+		rw.Header().Set(trafficAfterHeader, fmt.Sprintf("%d", trafficAfter))
+	}
+	if bytesWritten > 0 {
+		log.Printf("%sForwarded to %s, status %d, bytes written: %d, total traffic: %d",
+			logPrefix, dst, resp.StatusCode, bytesWritten, trafficAfter)
+	} else {
+		log.Printf("%sForwarded to %s, status %d, no bytes written (or HEAD request)", logPrefix, dst, resp.StatusCode)
+	}
+
+	return nil
+}
+
+// trafficSample is a backend's traffic rate as read by one selection.
+type trafficSample struct {
+	server   *ServerInfo
+	rate     rateSample
+	admitted bool
+}
+
+// trafficSamples holds the slices selectServerLeastTraffic reuses.
+var trafficSamples = sync.Pool{New: func() any { return new([]trafficSample) }}
+
+// selectServerLeastTraffic picks the alive backend of the pool with the
+// lowest traffic rate. The rates are loaded once each and decayed to a
+// single clock reading, so concurrent updates cannot make the comparison mix
+// values from different moments. Backends in slow start are skipped unless
+// admitted, or unless no other backend is left.
+func (b *Balancer) selectServerLeastTraffic(p pool) *ServerInfo {
+	ss := b.slowStart()
+	buf := trafficSamples.Get().(*[]trafficSample)
+	samples := (*buf)[:0]
+	defer func() {
+		clear(samples)
+		*buf = samples[:0]
+		trafficSamples.Put(buf)
+	}()
+
+	b.serversMu.RLock()
+	for _, server := range b.servers {
+		if b.inPool(p, server) && server.available() {
+			samples = append(samples, trafficSample{server, server.traffic.load(), ss.admits(server)})
+		}
+	}
+	b.serversMu.RUnlock()
+	if len(samples) == 0 {
+		return nil
+	}
+
+	now := samples[0].server.traffic.now()
+	var selectedServer *ServerInfo
+	var minTraffic float64
+	var admitted bool
+	for _, sample := range samples {
+		rate := sample.rate.at(now, sample.server.traffic.window)
+		if selectedServer == nil || (sample.admitted && !admitted) ||
+			(sample.admitted == admitted && rate < minTraffic) {
+			minTraffic, admitted = rate, sample.admitted
+			selectedServer = sample.server
+		}
+	}
+	return selectedServer
+}
+
+// selectServerLeastConnections picks the alive backend of the pool with the
+// fewest requests in flight, preferring the one with less traffic on ties.
+// Backends in slow start are skipped as by selectServerLeastTraffic.
+func (b *Balancer) selectServerLeastConnections(p pool) *ServerInfo {
+	ss := b.slowStart()
+	b.serversMu.RLock()
+	defer b.serversMu.RUnlock()
+
+	var selectedServer *ServerInfo
+	var best ServerSnapshot
+	var admitted bool
+
+	for _, server := range b.servers {
+		if !b.inPool(p, server) || !server.available() {
+			continue
+		}
+		snapshot := server.Snapshot()
+		ok := ss.admits(server)
+		if selectedServer == nil || (ok && !admitted) || (ok == admitted && (snapshot.InFlight < best.InFlight ||
+			(snapshot.InFlight == best.InFlight && snapshot.TrafficRate < best.TrafficRate))) {
+			best, admitted = snapshot, ok
+			selectedServer = server
+		}
+	}
+
+	return selectedServer
+}
+
+func (b *Balancer) selectServer(r *http.Request) *ServerInfo {
+	b.mu.RLock()
+	strategy, hashKey := b.cfg.Strategy, b.cfg.HashKey
+	b.mu.RUnlock()
+	p := b.choosePool(b.routeGroup(r))
+
+	switch strategy {
+	case "hash":
+		return b.selectServerHash(p, requestHashKey(r, hashKey))
+	case "least-connections":
+		return b.selectServerLeastConnections(p)
+	case "round-robin":
+		return b.selectServerRoundRobin(p)
+	case "latency-aware":
+		return b.selectServerLatencyAware(p)
+	case "least-latency":
+		return b.selectServerLeastLatency(p)
+	}
+	return b.selectServerLeastTraffic(p)
+}
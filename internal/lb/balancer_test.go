@@ -1,315 +1,456 @@
-package main
-
-import (
-	"fmt"
-	"net/http"
-	"net/http/httptest"
-	"strings"
-	"testing"
-	"time"
-)
-
-func TestServerInfo_Methods(t *testing.T) {
-	s := &ServerInfo{URL: "test", Alive: true, TrafficBytes: 100}
-
-	if !s.IsAlive() {
-		t.Error("Expected IsAlive to be true")
-	}
-	s.SetAlive(false)
-	if s.IsAlive() {
-		t.Error("Expected IsAlive to be false after SetAlive(false)")
-	}
-
-	if s.GetTraffic() != 100 {
-		t.Errorf("Expected GetTraffic to be 100, got %d", s.GetTraffic())
-	}
-	s.AddTraffic(50)
-	if s.GetTraffic() != 150 {
-		t.Errorf("Expected GetTraffic to be 150 after AddTraffic(50), got %d", s.GetTraffic())
-	}
-	if s.GetURL() != "test" {
-		t.Errorf("Expected GetURL to be 'test', got '%s'", s.GetURL())
-	}
-}
-
-func TestHealth(t *testing.T) {
-	originalTimeout := timeout
-	timeout = 100 * time.Millisecond
-	defer func() { timeout = originalTimeout }()
-
-	tests := []struct {
-		name           string
-		handler        http.HandlerFunc
-		expectedAlive  bool
-		initialAlive   bool
-	}{
-		{
-			name: "healthy server",
-			handler: func(rw http.ResponseWriter, r *http.Request) {
-				rw.WriteHeader(http.StatusOK)
-			},
-			expectedAlive: true,
-			initialAlive:  false,
-		},
-		{
-			name: "unhealthy server (500)",
-			handler: func(rw http.ResponseWriter, r *http.Request) {
-				rw.WriteHeader(http.StatusInternalServerError)
-			},
-			expectedAlive: false,
-			initialAlive:  true,
-		},
-		{
-			name: "server not reachable (timeout)",
-			handler: func(rw http.ResponseWriter, r *http.Request) {
-				time.Sleep(200 * time.Millisecond)
-				rw.WriteHeader(http.StatusOK)
-			},
-			expectedAlive: false,
-			initialAlive:  true,
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			testServer := httptest.NewServer(tt.handler)
-			defer testServer.Close()
-			serverURL := strings.TrimPrefix(testServer.URL, "http://")
-			sInfo := &ServerInfo{URL: serverURL, Alive: tt.initialAlive}
-			health(sInfo)
-			if sInfo.IsAlive() != tt.expectedAlive {
-				t.Errorf("Expected server %s alive status to be %t, got %t", sInfo.URL, tt.expectedAlive, sInfo.IsAlive())
-			}
-		})
-	}
-}
-
-func TestSelectServerLeastTraffic(t *testing.T) {
-	tests := []struct {
-		name           string
-		setupServers   func() []*ServerInfo
-		expectedURL    string
-		expectNil      bool
-	}{
-		{
-			name: "no servers",
-			setupServers: func() []*ServerInfo {
-				return []*ServerInfo{}
-			},
-			expectNil: true,
-		},
-		{
-			name: "all servers unhealthy",
-			setupServers: func() []*ServerInfo {
-				return []*ServerInfo{
-					{URL: "s1", Alive: false, TrafficBytes: 10},
-					{URL: "s2", Alive: false, TrafficBytes: 0},
-				}
-			},
-			expectNil: true,
-		},
-		{
-			name: "one healthy server",
-			setupServers: func() []*ServerInfo {
-				return []*ServerInfo{
-					{URL: "s1", Alive: true, TrafficBytes: 100},
-					{URL: "s2", Alive: false, TrafficBytes: 0},
-				}
-			},
-			expectedURL: "s1",
-		},
-		{
-			name: "multiple healthy servers, select least traffic",
-			setupServers: func() []*ServerInfo {
-				return []*ServerInfo{
-					{URL: "s1", Alive: true, TrafficBytes: 100},
-					{URL: "s2", Alive: true, TrafficBytes: 50},
-					{URL: "s3", Alive: true, TrafficBytes: 200},
-					{URL: "s4", Alive: false, TrafficBytes: 10},
-				}
-			},
-			expectedURL: "s2",
-		},
-		{
-			name: "multiple healthy servers, same least traffic (picks first one found typically)",
-			setupServers: func() []*ServerInfo {
-				return []*ServerInfo{
-					{URL: "s1", Alive: true, TrafficBytes: 100},
-					{URL: "s2", Alive: true, TrafficBytes: 50},
-					{URL: "s3", Alive: true, TrafficBytes: 50},
-					{URL: "s4", Alive: true, TrafficBytes: 200},
-				}
-			},
-			expectedURL: "s2",
-		},
-	}
-
-	originalServers := servers
-	defer func() { servers = originalServers }()
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			servers = tt.setupServers()
-			selected := selectServerLeastTraffic()
-
-			if tt.expectNil {
-				if selected != nil {
-					t.Errorf("Expected nil server, got %v", selected)
-				}
-				return
-			}
-
-			if selected == nil {
-				t.Errorf("Expected server %s, got nil", tt.expectedURL)
-				return
-			}
-			if selected.GetURL() != tt.expectedURL {
-				t.Errorf("Expected server %s, got %s", tt.expectedURL, selected.GetURL())
-			}
-		})
-	}
-}
-
-
-func TestForward(t *testing.T) {
-	originalTimeout := timeout
-	timeout = 200 * time.Millisecond
-	defer func() { timeout = originalTimeout }()
-
-	serverBody := "Hello from backend"
-	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-		rw.Header().Set("X-Backend-Header", "BackendValue")
-		rw.WriteHeader(http.StatusOK)
-		fmt.Fprint(rw, serverBody)
-	}))
-	defer backendServer.Close()
-
-	backendURL := strings.TrimPrefix(backendServer.URL, "http://")
-	sInfo := &ServerInfo{URL: backendURL, Alive: true, TrafficBytes: 0}
-
-	req, err := http.NewRequest("GET", "/testpath", nil)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	rr := httptest.NewRecorder()
-	
-	originalTraceEnabled := *traceEnabled
-	*traceEnabled = true
-	defer func() { *traceEnabled = originalTraceEnabled }()
-
-
-	err = forward(sInfo, rr, req)
-	if err != nil {
-		t.Fatalf("forward returned an error: %v", err)
-	}
-
-	if status := rr.Code; status != http.StatusOK {
-		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
-	}
-
-	if rr.Body.String() != serverBody {
-		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), serverBody)
-	}
-
-	if rr.Header().Get("X-Backend-Header") != "BackendValue" {
-		t.Errorf("X-Backend-Header not copied: got '%s'", rr.Header().Get("X-Backend-Header"))
-	}
-	
-	if rr.Header().Get("lb-from") != backendURL {
-		t.Errorf("lb-from header is incorrect: got '%s', want '%s'", rr.Header().Get("lb-from"), backendURL)
-	}
-	if rr.Header().Get("lb-traffic-before") != "0" {
-		t.Errorf("lb-traffic-before header is incorrect: got '%s'", rr.Header().Get("lb-traffic-before"))
-	}
-
-	expectedTraffic := int64(len(serverBody))
-	if sInfo.GetTraffic() != expectedTraffic {
-		t.Errorf("Server traffic not updated correctly: got %d, want %d", sInfo.GetTraffic(), expectedTraffic)
-	}
-	if rr.Header().Get("lb-traffic-after") != fmt.Sprintf("%d", expectedTraffic) {
-			t.Errorf("lb-traffic-after header is incorrect: got '%s', want '%s'", rr.Header().Get("lb-traffic-after"), fmt.Sprintf("%d", expectedTraffic))
-	}
-
-	sInfoError := &ServerInfo{URL: "invalid-host-that-will-fail:1234", Alive: true, TrafficBytes: 0}
-	rrError := httptest.NewRecorder()
-	err = forward(sInfoError, rrError, req)
-	if err == nil {
-		t.Fatalf("forward should have returned an error for unreachable backend")
-	}
-	if rrError.Code != http.StatusServiceUnavailable {
-		t.Errorf("Expected StatusServiceUnavailable for failed backend, got %d", rrError.Code)
-	}
-	if sInfoError.IsAlive() {
-		t.Error("Server should be marked as not alive after a forwarding error")
-	}
-}
-
-func TestBalancerHandler(t *testing.T) {
-	backendResponses := []string{"Resp1", "Resp22", "Resp333"}
-	var testServers []*httptest.Server
-	var testServerInfos []*ServerInfo
-
-	for _, respBody := range backendResponses {
-		body := respBody
-		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			fmt.Fprint(w, body)
-		}))
-		testServers = append(testServers, server)
-		serverURL := strings.TrimPrefix(server.URL, "http://")
-		testServerInfos = append(testServerInfos, &ServerInfo{URL: serverURL, Alive: true, TrafficBytes: 0})
-	}
-	defer func() {
-		for _, ts := range testServers {
-			ts.Close()
-		}
-	}()
-
-	originalGlobalServers := servers
-	servers = testServerInfos
-	defer func() { servers = originalGlobalServers }()
-	
-	balancerHandler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-		selectedServer := selectServerLeastTraffic()
-		if selectedServer == nil {
-			http.Error(rw, "Service unavailable", http.StatusServiceUnavailable)
-			return
-		}
-		forward(selectedServer, rw, r)
-	})
-
-	numRequests := len(testServerInfos) * 2
-
-	for i := 0; i < numRequests; i++ {
-		req := httptest.NewRequest("GET", "/", nil)
-		rr := httptest.NewRecorder()
-		balancerHandler.ServeHTTP(rr, req)
-
-		if rr.Code != http.StatusOK {
-			t.Fatalf("Request %d: Expected status 200, got %d", i, rr.Code)
-		}
-	}
-	
-	expectedTraffics := []int64{
-		int64(2 * len(backendResponses[0])),
-		int64(2 * len(backendResponses[1])),
-		int64(2 * len(backendResponses[2])),
-	}
-
-	for i, sInfo := range testServerInfos {
-		if sInfo.GetTraffic() != expectedTraffics[i] {
-			t.Errorf("Server %s (%s) traffic: expected %d, got %d after %d requests",
-				sInfo.GetURL(), backendResponses[i], expectedTraffics[i], sInfo.GetTraffic(), numRequests)
-		}
-	}
-
-	for _, sInfo := range testServerInfos {
-		sInfo.SetAlive(false)
-	}
-	req := httptest.NewRequest("GET", "/", nil)
-	rr := httptest.NewRecorder()
-	balancerHandler.ServeHTTP(rr, req)
-	if rr.Code != http.StatusServiceUnavailable {
-		t.Errorf("Expected 503 when all servers are unhealthy, got %d", rr.Code)
-	}
-}
\ No newline at end of file
+package lb
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testBalancer returns a balancer with the default config and the given
+// backends. It does not run health checks.
+func testBalancer(servers ...*ServerInfo) *Balancer {
+	b := newBalancer(DefaultConfig())
+	b.servers = servers
+	return b
+}
+
+func testServerInfo(url string, alive bool, trafficBytes int64) *ServerInfo {
+	cfg := DefaultConfig()
+	s := newServerInfo(url, alive, &cfg)
+	s.AddTraffic(trafficBytes)
+	return s
+}
+
+func TestServerInfo_Methods(t *testing.T) {
+	s := testServerInfo("test", true, 100)
+
+	if !s.IsAlive() {
+		t.Error("Expected IsAlive to be true")
+	}
+	s.SetAlive(false)
+	if s.IsAlive() {
+		t.Error("Expected IsAlive to be false after SetAlive(false)")
+	}
+
+	if s.GetTraffic() != 100 {
+		t.Errorf("Expected GetTraffic to be 100, got %d", s.GetTraffic())
+	}
+	s.AddTraffic(50)
+	if s.GetTraffic() != 150 {
+		t.Errorf("Expected GetTraffic to be 150 after AddTraffic(50), got %d", s.GetTraffic())
+	}
+	if s.GetURL() != "test" {
+		t.Errorf("Expected GetURL to be 'test', got '%s'", s.GetURL())
+	}
+}
+
+func TestServerInfo_Snapshot(t *testing.T) {
+	s := testServerInfo("snap", true, 10)
+	if got := s.AddTraffic(5); got != 15 {
+		t.Errorf("AddTraffic should return the new total 15, got %d", got)
+	}
+	want := ServerSnapshot{URL: "snap", Alive: true, TrafficBytes: 15}
+	got := s.Snapshot()
+	if got.TrafficRate <= 0 {
+		t.Errorf("Snapshot() should include the traffic rate, got %v", got.TrafficRate)
+	}
+	if got.State != stateHealthy || got.StateSince.IsZero() {
+		t.Errorf("Snapshot() should include the state, got %s since %v", got.State, got.StateSince)
+	}
+	got.TrafficRate, got.State, got.StateSince = 0, 0, time.Time{}
+	if got != want {
+		t.Errorf("Snapshot() = %+v, want %+v", got, want)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	b := testBalancer()
+	b.cfg.Timeout = 100 * time.Millisecond
+
+	tests := []struct {
+		name          string
+		handler       http.HandlerFunc
+		expectedAlive bool
+		initialAlive  bool
+	}{
+		{
+			name: "healthy server",
+			handler: func(rw http.ResponseWriter, r *http.Request) {
+				rw.WriteHeader(http.StatusOK)
+			},
+			expectedAlive: true,
+			initialAlive:  false,
+		},
+		{
+			name: "unhealthy server (500)",
+			handler: func(rw http.ResponseWriter, r *http.Request) {
+				rw.WriteHeader(http.StatusInternalServerError)
+			},
+			expectedAlive: false,
+			initialAlive:  true,
+		},
+		{
+			name: "server not reachable (timeout)",
+			handler: func(rw http.ResponseWriter, r *http.Request) {
+				time.Sleep(200 * time.Millisecond)
+				rw.WriteHeader(http.StatusOK)
+			},
+			expectedAlive: false,
+			initialAlive:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testServer := httptest.NewServer(tt.handler)
+			defer testServer.Close()
+			serverURL := strings.TrimPrefix(testServer.URL, "http://")
+			sInfo := testServerInfo(serverURL, tt.initialAlive, 0)
+			b.health(sInfo)
+			if sInfo.IsAlive() != tt.expectedAlive {
+				t.Errorf("Expected server %s alive status to be %t, got %t", sInfo.URL, tt.expectedAlive, sInfo.IsAlive())
+			}
+		})
+	}
+}
+
+func TestSelectServerLeastTraffic(t *testing.T) {
+	tests := []struct {
+		name         string
+		setupServers func() []*ServerInfo
+		expectedURL  string
+		expectNil    bool
+	}{
+		{
+			name: "no servers",
+			setupServers: func() []*ServerInfo {
+				return []*ServerInfo{}
+			},
+			expectNil: true,
+		},
+		{
+			name: "all servers unhealthy",
+			setupServers: func() []*ServerInfo {
+				return []*ServerInfo{
+					testServerInfo("s1", false, 10),
+					testServerInfo("s2", false, 0),
+				}
+			},
+			expectNil: true,
+		},
+		{
+			name: "one healthy server",
+			setupServers: func() []*ServerInfo {
+				return []*ServerInfo{
+					testServerInfo("s1", true, 100),
+					testServerInfo("s2", false, 0),
+				}
+			},
+			expectedURL: "s1",
+		},
+		{
+			name: "multiple healthy servers, select least traffic",
+			setupServers: func() []*ServerInfo {
+				return []*ServerInfo{
+					testServerInfo("s1", true, 100),
+					testServerInfo("s2", true, 50),
+					testServerInfo("s3", true, 200),
+					testServerInfo("s4", false, 10),
+				}
+			},
+			expectedURL: "s2",
+		},
+		{
+			name: "multiple healthy servers, same least traffic (picks first one found typically)",
+			setupServers: func() []*ServerInfo {
+				return []*ServerInfo{
+					testServerInfo("s1", true, 100),
+					testServerInfo("s2", true, 50),
+					testServerInfo("s3", true, 50),
+					testServerInfo("s4", true, 200),
+				}
+			},
+			expectedURL: "s2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := testBalancer(tt.setupServers()...)
+			selected := b.selectServerLeastTraffic(pool{})
+
+			if tt.expectNil {
+				if selected != nil {
+					t.Errorf("Expected nil server, got %v", selected)
+				}
+				return
+			}
+
+			if selected == nil {
+				t.Errorf("Expected server %s, got nil", tt.expectedURL)
+				return
+			}
+			if selected.GetURL() != tt.expectedURL {
+				t.Errorf("Expected server %s, got %s", tt.expectedURL, selected.GetURL())
+			}
+		})
+	}
+}
+
+func TestForward(t *testing.T) {
+	b := testBalancer()
+	b.cfg.Timeout = 200 * time.Millisecond
+	b.cfg.Trace = true
+
+	serverBody := "Hello from backend"
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("X-Backend-Header", "BackendValue")
+		rw.WriteHeader(http.StatusOK)
+		fmt.Fprint(rw, serverBody)
+	}))
+	defer backendServer.Close()
+
+	backendURL := strings.TrimPrefix(backendServer.URL, "http://")
+	sInfo := testServerInfo(backendURL, true, 0)
+
+	req, err := http.NewRequest("GET", "/testpath", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	err = b.forward(sInfo, rr, req)
+	if err != nil {
+		t.Fatalf("forward returned an error: %v", err)
+	}
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	if rr.Body.String() != serverBody {
+		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), serverBody)
+	}
+
+	if rr.Header().Get("X-Backend-Header") != "BackendValue" {
+		t.Errorf("X-Backend-Header not copied: got '%s'", rr.Header().Get("X-Backend-Header"))
+	}
+
+	if rr.Header().Get("lb-from") != backendURL {
+		t.Errorf("lb-from header is incorrect: got '%s', want '%s'", rr.Header().Get("lb-from"), backendURL)
+	}
+	if rr.Header().Get("lb-traffic-before") != "0" {
+		t.Errorf("lb-traffic-before header is incorrect: got '%s'", rr.Header().Get("lb-traffic-before"))
+	}
+
+	expectedTraffic := int64(len(serverBody))
+	if sInfo.GetTraffic() != expectedTraffic {
+		t.Errorf("Server traffic not updated correctly: got %d, want %d", sInfo.GetTraffic(), expectedTraffic)
+	}
+	if rr.Header().Get("lb-traffic-after") != fmt.Sprintf("%d", expectedTraffic) {
+		t.Errorf("lb-traffic-after header is incorrect: got '%s', want '%s'", rr.Header().Get("lb-traffic-after"), fmt.Sprintf("%d", expectedTraffic))
+	}
+	if got := rr.Result().Trailer.Get("lb-traffic-after"); got != fmt.Sprintf("%d", expectedTraffic) {
+		t.Errorf("lb-traffic-after trailer is incorrect: got '%s', want '%d'", got, expectedTraffic)
+	}
+
+	sInfoError := testServerInfo("invalid-host-that-will-fail:1234", true, 0)
+	rrError := httptest.NewRecorder()
+	err = b.forward(sInfoError, rrError, req)
+	if err == nil {
+		t.Fatalf("forward should have returned an error for unreachable backend")
+	}
+	if rrError.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected StatusServiceUnavailable for failed backend, got %d", rrError.Code)
+	}
+	if sInfoError.IsAlive() {
+		t.Error("Server should be marked as not alive after a forwarding error")
+	}
+}
+
+func TestForward_TrafficAfterTrailer(t *testing.T) {
+	b := testBalancer()
+	b.cfg.Trace = true
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, "0123456789")
+	}))
+	defer backend.Close()
+	s := testServerInfo(strings.TrimPrefix(backend.URL, "http://"), true, 5)
+	lb := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		_ = b.forward(s, rw, r)
+	}))
+	defer lb.Close()
+
+	resp, err := http.Get(lb.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("lb-traffic-before"); got != "5" {
+		t.Errorf("lb-traffic-before = %q, want 5", got)
+	}
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.Trailer.Get("lb-traffic-after"); got != "15" {
+		t.Errorf("lb-traffic-after trailer = %q, want 15", got)
+	}
+}
+func TestBalancerHandler(t *testing.T) {
+	backendResponses := []string{"Resp1", "Resp22", "Resp333"}
+	var testServers []*httptest.Server
+	var testServerInfos []*ServerInfo
+
+	for _, respBody := range backendResponses {
+		body := respBody
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, body)
+		}))
+		testServers = append(testServers, server)
+		serverURL := strings.TrimPrefix(server.URL, "http://")
+		testServerInfos = append(testServerInfos, testServerInfo(serverURL, true, 0))
+	}
+	defer func() {
+		for _, ts := range testServers {
+			ts.Close()
+		}
+	}()
+
+	balancerHandler := testBalancer(testServerInfos...)
+
+	numRequests := len(testServerInfos) * 2
+
+	for i := 0; i < numRequests; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		rr := httptest.NewRecorder()
+		balancerHandler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Request %d: Expected status 200, got %d", i, rr.Code)
+		}
+	}
+
+	expectedTraffics := []int64{
+		int64(2 * len(backendResponses[0])),
+		int64(2 * len(backendResponses[1])),
+		int64(2 * len(backendResponses[2])),
+	}
+
+	for i, sInfo := range testServerInfos {
+		if sInfo.GetTraffic() != expectedTraffics[i] {
+			t.Errorf("Server %s (%s) traffic: expected %d, got %d after %d requests",
+				sInfo.GetURL(), backendResponses[i], expectedTraffics[i], sInfo.GetTraffic(), numRequests)
+		}
+	}
+
+	for _, sInfo := range testServerInfos {
+		sInfo.SetAlive(false)
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	rr := httptest.NewRecorder()
+	balancerHandler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 when all servers are unhealthy, got %d", rr.Code)
+	}
+}
+
+func BenchmarkSelectServerLeastTraffic(b *testing.B) {
+	balancer := testBalancer(
+		testServerInfo("s1", true, 0),
+		testServerInfo("s2", true, 0),
+		testServerInfo("s3", true, 0),
+	)
+
+	// Emulate ~10k concurrent requests each selecting a backend and accounting its response.
+	b.SetParallelism(10000 / runtime.GOMAXPROCS(0))
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if s := balancer.selectServerLeastTraffic(pool{}); s != nil {
+				s.AddTraffic(128)
+			}
+		}
+	})
+}
+
+func TestForward_ReusesBackendConnections(t *testing.T) {
+	var conns atomic.Int32
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, "ok")
+	}))
+	backend.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	backend.Start()
+	defer backend.Close()
+
+	b := testBalancer()
+	sInfo := testServerInfo(strings.TrimPrefix(backend.URL, "http://"), true, 0)
+	for i := 0; i < 5; i++ {
+		if err := b.forward(sInfo, httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := conns.Load(); n != 1 {
+		t.Errorf("expected sequential requests to reuse 1 connection, got %d", n)
+	}
+}
+
+func TestSelectServerLeastConnections(t *testing.T) {
+	busy := testServerInfo("busy", true, 0)
+	busy.inFlight.Store(5)
+	idleHeavy := testServerInfo("idle-heavy", true, 1000)
+	idleLight := testServerInfo("idle-light", true, 10)
+	dead := testServerInfo("dead", false, 0)
+	b := testBalancer(busy, idleHeavy, dead, idleLight)
+
+	if got := b.selectServerLeastConnections(pool{}); got != idleLight {
+		t.Errorf("expected idle-light, got %v", got.GetURL())
+	}
+
+	idleLight.inFlight.Store(2)
+	if got := b.selectServerLeastConnections(pool{}); got != idleHeavy {
+		t.Errorf("expected idle-heavy, got %v", got.GetURL())
+	}
+
+	b.servers = []*ServerInfo{dead}
+	if got := b.selectServerLeastConnections(pool{}); got != nil {
+		t.Errorf("expected nil without alive servers, got %v", got.GetURL())
+	}
+}
+
+func TestForward_TracksInFlight(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+	}))
+	defer backend.Close()
+
+	b := testBalancer()
+	sInfo := testServerInfo(strings.TrimPrefix(backend.URL, "http://"), true, 0)
+	done := make(chan error)
+	go func() {
+		done <- b.forward(sInfo, httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}()
+
+	<-entered
+	if n := sInfo.InFlight(); n != 1 {
+		t.Errorf("InFlight during request = %d, want 1", n)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if n := sInfo.InFlight(); n != 0 {
+		t.Errorf("InFlight after request = %d, want 0", n)
+	}
+}
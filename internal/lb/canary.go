@@ -0,0 +1,94 @@
+package lb
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+)
+
+// pool identifies the backends a request may go to: those of a backend group
+// that run either the canary or the stable version.
+type pool struct {
+	group  string
+	canary bool
+}
+
+// inPool reports whether s belongs to p. Backends tagged with
+// Config.CanaryVersion are canaries; untagged ones are stable.
+func (b *Balancer) inPool(p pool, s *ServerInfo) bool {
+	return s.Group == p.group && (s.Version == b.canaryVersion) == p.canary
+}
+
+// choosePool splits the requests of a group between the canary and stable
+// backends by Config.CanaryWeight, unless canary picks the side. If the
+// chosen side has no alive backend, the other one takes the request.
+func (b *Balancer) choosePool(group string, canary *bool) pool {
+	b.mu.RLock()
+	weight := b.cfg.CanaryWeight
+	b.mu.RUnlock()
+
+	p := pool{group: group, canary: weight > 0 && rand.Float64()*100 < weight}
+	if canary != nil {
+		p.canary = *canary
+	} else if weight <= 0 {
+		return p
+	}
+	if b.hasAlive(p) {
+		return p
+	}
+	p.canary = !p.canary
+	return p
+}
+
+func (b *Balancer) hasAlive(p pool) bool {
+	b.serversMu.RLock()
+	defer b.serversMu.RUnlock()
+	for _, s := range b.servers {
+		if b.inPool(p, s) && s.IsAlive() {
+			return true
+		}
+	}
+	return false
+}
+
+func validCanaryWeight(weight float64) error {
+	if weight < 0 || weight > 100 {
+		return fmt.Errorf("canary weight %g is not a percentage", weight)
+	}
+	return nil
+}
+
+// handleCanary reports the canary split on GET and changes its weight on
+// POST ?weight=<percent>. The change lasts until the next config reload.
+func (b *Balancer) handleCanary(rw http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		weight, err := strconv.ParseFloat(r.URL.Query().Get("weight"), 64)
+		if err == nil {
+			err = validCanaryWeight(weight)
+		}
+		if err != nil {
+			http.Error(rw, "weight must be a percentage between 0 and 100", http.StatusBadRequest)
+			return
+		}
+		b.mu.Lock()
+		previous := b.cfg.CanaryWeight
+		b.cfg.CanaryWeight = weight
+		b.mu.Unlock()
+		log.Printf("Canary weight changed from %g%% to %g%% via the admin API", previous, weight)
+	default:
+		rw.Header().Set("Allow", "GET, POST")
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	b.mu.RLock()
+	state := map[string]any{"version": b.cfg.CanaryVersion, "weight": b.cfg.CanaryWeight}
+	b.mu.RUnlock()
+	rw.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(rw).Encode(state)
+}
@@ -0,0 +1,72 @@
+package lb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestChoosePool_CanaryWeight(t *testing.T) {
+	stable := testServerInfo("stable:8080", true, 0)
+	canary := testServerInfo("canary:8080", true, 0)
+	b := testBalancer(stable, canary)
+	canary.Version = b.canaryVersion
+
+	count := func() int {
+		hits := 0
+		for i := 0; i < 1000; i++ {
+			if b.selectServer(httptest.NewRequest("GET", "/", nil)) == canary {
+				hits++
+			}
+		}
+		return hits
+	}
+
+	b.cfg.CanaryWeight = 0
+	if hits := count(); hits != 0 {
+		t.Errorf("weight 0: %d requests went to the canary", hits)
+	}
+	b.cfg.CanaryWeight = 100
+	if hits := count(); hits != 1000 {
+		t.Errorf("weight 100: only %d requests went to the canary", hits)
+	}
+	b.cfg.CanaryWeight = 10
+	if hits := count(); hits < 50 || hits > 170 {
+		t.Errorf("weight 10: %d of 1000 requests went to the canary", hits)
+	}
+
+	canary.SetAlive(false)
+	b.cfg.CanaryWeight = 100
+	if got := b.selectServer(httptest.NewRequest("GET", "/", nil)); got != stable {
+		t.Errorf("expected stable backends to take over from a dead canary, got %v", got)
+	}
+}
+
+func TestHandleCanary(t *testing.T) {
+	b := testBalancer()
+	b.cfg.CanaryWeight = 5
+
+	rw := httptest.NewRecorder()
+	b.handleCanary(rw, httptest.NewRequest(http.MethodPost, "/admin/canary?weight=25", nil))
+	if rw.Code != http.StatusOK || b.cfg.CanaryWeight != 25 {
+		t.Fatalf("POST: status %d, weight %g", rw.Code, b.cfg.CanaryWeight)
+	}
+
+	rw = httptest.NewRecorder()
+	b.handleCanary(rw, httptest.NewRequest(http.MethodGet, "/admin/canary", nil))
+	if !strings.Contains(rw.Body.String(), `"weight":25`) {
+		t.Errorf("GET: unexpected body %s", rw.Body.String())
+	}
+
+	for _, weight := range []string{"-1", "101", "lots", ""} {
+		rw = httptest.NewRecorder()
+		b.handleCanary(rw, httptest.NewRequest(http.MethodPost, "/admin/canary?weight="+weight, nil))
+		if rw.Code != http.StatusBadRequest {
+			t.Errorf("weight %q: expected 400, got %d", weight, rw.Code)
+		}
+	}
+	if b.cfg.CanaryWeight != 25 {
+		t.Errorf("an invalid weight must not change the split, got %g", b.cfg.CanaryWeight)
+	}
+}
@@ -0,0 +1,176 @@
+package lb
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// defaultCompressTypes are the content types compressed unless
+// Config.Compress.Types says otherwise. Streams such as text/event-stream
+// are left out on purpose: the compressor buffers them.
+const defaultCompressTypes = "text/html,text/plain,text/css,text/csv,text/xml,application/json,application/javascript,application/xml,image/svg+xml"
+
+// CompressOptions configure the compression of backend responses for
+// clients that accept it. Types is a comma-separated list of content types;
+// an entry ending in "/" matches every subtype. Responses declaring fewer
+// than MinBytes are sent as they are, and so are responses the backend
+// already encoded.
+type CompressOptions struct {
+	Enabled  bool
+	Types    string
+	MinBytes int64
+}
+
+// CompressConfig is the compress section of the config file.
+type CompressConfig struct {
+	Enabled  *bool    `json:"enabled"`
+	Types    []string `json:"types"`
+	MinBytes *int64   `json:"min_bytes"`
+}
+
+func (o *CompressOptions) validate() error {
+	if o.MinBytes < 0 {
+		return fmt.Errorf("compression minimum size must not be negative")
+	}
+	return nil
+}
+
+// encodingFor returns the content coding the response to r is compressed
+// with, or "" to send it unchanged.
+func (o *CompressOptions) encodingFor(r *http.Request, resp *http.Response) string {
+	if !o.Enabled || r.Method == http.MethodHead {
+		return ""
+	}
+	switch resp.StatusCode {
+	case http.StatusNoContent, http.StatusPartialContent, http.StatusNotModified:
+		return ""
+	}
+	if resp.Header.Get("Content-Encoding") != "" {
+		return ""
+	}
+	if resp.ContentLength >= 0 && resp.ContentLength < o.MinBytes {
+		return ""
+	}
+	if !o.compressible(resp.Header.Get("Content-Type")) {
+		return ""
+	}
+	return negotiateEncoding(r.Header.Values("Accept-Encoding"))
+}
+
+func (o *CompressOptions) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range strings.Split(o.Types, ",") {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" {
+			continue
+		}
+		if mediaType == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t)) {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateEncoding picks gzip or deflate from Accept-Encoding header
+// values by their quality, preferring gzip on a tie, or returns "" if the
+// client accepts neither.
+func negotiateEncoding(accept []string) string {
+	quality := map[string]float64{}
+	for _, value := range accept {
+		for _, part := range strings.Split(value, ",") {
+			coding, params, _ := strings.Cut(part, ";")
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			q := 1.0
+			if name, v, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+				parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+				if err != nil {
+					continue
+				}
+				q = parsed
+			}
+			quality[coding] = q
+		}
+	}
+	best, bestQ := "", 0.0
+	for _, coding := range []string{"gzip", "deflate"} {
+		q, ok := quality[coding]
+		if !ok {
+			q = quality["*"]
+		}
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// prepareCompressed adjusts the response headers for a body compressed with
+// encoding: its length is no longer known, ranges no longer apply to it and
+// a strong validator of the original no longer describes it.
+func prepareCompressed(header http.Header, encoding string) {
+	header.Del("Content-Length")
+	header.Del("Accept-Ranges")
+	header.Set("Content-Encoding", encoding)
+	header.Add("Vary", "Accept-Encoding")
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		header.Set("ETag", "W/"+etag)
+	}
+}
+
+// Compressors are large, so they are reused between responses.
+var (
+	gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }}
+	zlibWriters = sync.Pool{New: func() any { return zlib.NewWriter(io.Discard) }}
+)
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// copyBody copies body to w, compressed with encoding unless it is "", and
+// returns the bytes that reached w, which is what the response costs on the
+// wire.
+func copyBody(w io.Writer, body io.Reader, encoding string) (int64, error) {
+	switch encoding {
+	case "gzip":
+		wire := &countingWriter{w: w}
+		zw := gzipWriters.Get().(*gzip.Writer)
+		defer gzipWriters.Put(zw)
+		zw.Reset(wire)
+		return finishCompressed(wire, zw, body)
+	case "deflate":
+		// The deflate coding of HTTP is the zlib format, not raw deflate.
+		wire := &countingWriter{w: w}
+		zw := zlibWriters.Get().(*zlib.Writer)
+		defer zlibWriters.Put(zw)
+		zw.Reset(wire)
+		return finishCompressed(wire, zw, body)
+	}
+	return io.Copy(w, body)
+}
+
+func finishCompressed(wire *countingWriter, zw io.WriteCloser, body io.Reader) (int64, error) {
+	if _, err := io.Copy(zw, body); err != nil {
+		return wire.n, err
+	}
+	err := zw.Close()
+	return wire.n, err
+}
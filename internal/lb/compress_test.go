@@ -0,0 +1,111 @@
+package lb
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate, gzip", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"gzip;q=0, deflate;q=0", ""},
+		{"*", "gzip"},
+		{"*;q=0.1, gzip;q=0", "deflate"},
+		{"br", ""},
+	}
+	for _, tt := range tests {
+		if got := negotiateEncoding([]string{tt.accept}); got != tt.want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestCompressible(t *testing.T) {
+	o := CompressOptions{Types: "text/, application/json"}
+	for _, ct := range []string{"text/html; charset=utf-8", "TEXT/plain", "application/json"} {
+		if !o.compressible(ct) {
+			t.Errorf("%q must be compressible", ct)
+		}
+	}
+	for _, ct := range []string{"", "image/png", "application/jsonx"} {
+		if o.compressible(ct) {
+			t.Errorf("%q must not be compressible", ct)
+		}
+	}
+}
+
+func TestForward_Compress(t *testing.T) {
+	body := strings.Repeat("compressible text ", 500)
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/small":
+			rw.Header().Set("Content-Type", "text/plain")
+			_, _ = io.WriteString(rw, "tiny")
+		case "/encoded":
+			rw.Header().Set("Content-Type", "text/plain")
+			rw.Header().Set("Content-Encoding", "br")
+			_, _ = io.WriteString(rw, body)
+		case "/image":
+			rw.Header().Set("Content-Type", "image/png")
+			_, _ = io.WriteString(rw, body)
+		default:
+			rw.Header().Set("Content-Type", "text/plain")
+			rw.Header().Set("ETag", `"v1"`)
+			_, _ = io.WriteString(rw, body)
+		}
+	}))
+	defer backend.Close()
+	server := testServerInfo(strings.TrimPrefix(backend.URL, "http://"), true, 0)
+	b := testBalancer(server)
+	b.cfg.Compress.Enabled = true
+
+	get := func(path, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", accept)
+		rec := httptest.NewRecorder()
+		if err := b.forward(server, rec, req); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+
+	before := server.GetTraffic()
+	rec := get("/", "gzip")
+	if rec.Header().Get("Content-Encoding") != "gzip" || rec.Header().Get("ETag") != `W/"v1"` {
+		t.Fatalf("unexpected headers %v", rec.Header())
+	}
+	if got := server.GetTraffic() - before; got != int64(rec.Body.Len()) {
+		t.Errorf("counted %d bytes of traffic, %d went on the wire", got, rec.Body.Len())
+	}
+	if rec.Body.Len() >= len(body) {
+		t.Errorf("compressed body of %d bytes is not smaller than %d", rec.Body.Len(), len(body))
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(zr); string(got) != body {
+		t.Error("the decompressed body differs from the backend's")
+	}
+
+	for _, tt := range []struct{ path, accept string }{
+		{"/", ""},
+		{"/small", "gzip"},
+		{"/encoded", "gzip"},
+		{"/image", "gzip"},
+	} {
+		if rec := get(tt.path, tt.accept); rec.Header().Get("Content-Encoding") == "gzip" {
+			t.Errorf("%s with Accept-Encoding %q must not be compressed", tt.path, tt.accept)
+		}
+	}
+}
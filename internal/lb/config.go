@@ -0,0 +1,199 @@
+package lb
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// FileConfig is the balancer configuration file. Every field is optional and
+// overrides the corresponding Config setting, unless that setting is pinned
+// by a flag given on the command line.
+type FileConfig struct {
+	Port       *int     `json:"port"`
+	TimeoutSec *int     `json:"timeout_sec"`
+	HTTPS      *bool    `json:"https"`
+	Trace      *bool    `json:"trace"`
+	Strategy   *string  `json:"strategy"`
+	HashKey    *string  `json:"hash_key"`
+	Backends   []string `json:"backends"`
+
+	// Groups are named backend pools that routes can send requests to.
+	Groups map[string][]string `json:"groups"`
+	// Versions tags backends with a version label, for canary releases.
+	Versions     map[string]string `json:"versions"`
+	CanaryWeight *float64          `json:"canary_weight"`
+
+	StripResponseHeaders []string `json:"strip_response_headers"`
+	AllowResponseHeaders []string `json:"allow_response_headers"`
+
+	PreserveHost *bool   `json:"preserve_host"`
+	Routes       []Route `json:"routes"`
+
+	Echo     *EchoConfig     `json:"echo"`
+	Compress *CompressConfig `json:"compress"`
+	Queue    *QueueConfig    `json:"queue"`
+
+	Maintenance []MaintenanceConfig `json:"maintenance"`
+
+	MaxRetries    *int `json:"max_retries"`
+	RetryBudgetMs *int `json:"retry_budget_ms"`
+
+	MaxTimeoutMs    *int `json:"max_timeout_ms"`
+	SlowThresholdMs *int `json:"slow_threshold_ms"`
+	SlowStartSec    *int `json:"slow_start_sec"`
+}
+
+var envRefPattern = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// interpolateEnv replaces ${VAR} and ${VAR:-default} references with values
+// from the environment; "$$" yields a literal "$". A reference to an unset
+// variable without a default is an error.
+func interpolateEnv(text string, lookup func(string) (string, bool)) (string, error) {
+	var missing []string
+	res := envRefPattern.ReplaceAllStringFunc(text, func(ref string) string {
+		if ref == "$$" {
+			return "$"
+		}
+		m := envRefPattern.FindStringSubmatch(ref)
+		name, hasDefault := m[1], strings.Contains(ref, ":-")
+		if value, ok := lookup(name); ok && (value != "" || !hasDefault) {
+			return value
+		}
+		if hasDefault {
+			return m[2]
+		}
+		missing = append(missing, name)
+		return ""
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("undefined environment variables: %s", strings.Join(missing, ", "))
+	}
+	return res, nil
+}
+
+func loadConfig(path string) (*FileConfig, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	text, err := interpolateEnv(string(raw), os.LookupEnv)
+	if err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	var cfg FileConfig
+	if err := json.Unmarshal([]byte(text), &cfg); err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// apply copies the configured values into cfg, except for the settings
+// pinned by cfg.Pinned.
+func (c *FileConfig) apply(cfg *Config) {
+	set := cfg.Pinned
+
+	if c.Port != nil && !set["port"] {
+		cfg.Port = *c.Port
+	}
+	if c.TimeoutSec != nil && !set["timeout-sec"] {
+		cfg.Timeout = time.Duration(*c.TimeoutSec) * time.Second
+	}
+	if c.HTTPS != nil && !set["https"] {
+		cfg.HTTPS = *c.HTTPS
+	}
+	if c.Trace != nil && !set["trace"] {
+		cfg.Trace = *c.Trace
+	}
+	if c.Strategy != nil && !set["strategy"] {
+		cfg.Strategy = *c.Strategy
+	}
+	if c.HashKey != nil && !set["hash-key"] {
+		cfg.HashKey = *c.HashKey
+	}
+	if len(c.StripResponseHeaders) > 0 && !set["strip-response-headers"] {
+		cfg.StripResponseHeaders = strings.Join(c.StripResponseHeaders, ",")
+	}
+	if len(c.AllowResponseHeaders) > 0 && !set["allow-response-headers"] {
+		cfg.AllowResponseHeaders = strings.Join(c.AllowResponseHeaders, ",")
+	}
+	if c.PreserveHost != nil && !set["preserve-host"] {
+		cfg.PreserveHost = *c.PreserveHost
+	}
+	if c.Echo != nil {
+		if c.Echo.Enabled != nil && !set["echo"] {
+			cfg.Echo.Enabled = *c.Echo.Enabled
+		}
+		if c.Echo.PayloadBytes != nil && !set["echo-payload-bytes"] {
+			cfg.Echo.PayloadBytes = *c.Echo.PayloadBytes
+		}
+		if c.Echo.LatencyMs != nil && !set["echo-latency"] {
+			cfg.Echo.Latency = time.Duration(*c.Echo.LatencyMs) * time.Millisecond
+		}
+	}
+	if c.Compress != nil {
+		if c.Compress.Enabled != nil && !set["compress"] {
+			cfg.Compress.Enabled = *c.Compress.Enabled
+		}
+		if len(c.Compress.Types) > 0 && !set["compress-types"] {
+			cfg.Compress.Types = strings.Join(c.Compress.Types, ",")
+		}
+		if c.Compress.MinBytes != nil && !set["compress-min-bytes"] {
+			cfg.Compress.MinBytes = *c.Compress.MinBytes
+		}
+	}
+	if c.Queue != nil {
+		if c.Queue.MaxInFlight != nil && !set["max-in-flight"] {
+			cfg.Queue.MaxInFlight = *c.Queue.MaxInFlight
+		}
+		if c.Queue.WaitMs != nil && !set["queue-wait"] {
+			cfg.Queue.Wait = time.Duration(*c.Queue.WaitMs) * time.Millisecond
+		}
+		if c.Queue.MaxLength != nil && !set["queue-max-length"] {
+			cfg.Queue.MaxLength = *c.Queue.MaxLength
+		}
+	}
+	if len(c.Routes) > 0 {
+		cfg.Routes = c.Routes
+	}
+	if len(c.Backends) > 0 {
+		cfg.Backends = c.Backends
+	}
+	if c.Groups != nil {
+		cfg.Groups = c.Groups
+	}
+	if c.Versions != nil {
+		cfg.Versions = c.Versions
+	}
+	if c.CanaryWeight != nil && !set["canary-weight"] {
+		cfg.CanaryWeight = *c.CanaryWeight
+	}
+	if c.MaxRetries != nil && !set["max-retries"] {
+		cfg.MaxRetries = *c.MaxRetries
+	}
+	if c.RetryBudgetMs != nil && !set["retry-budget"] {
+		cfg.RetryBudget = time.Duration(*c.RetryBudgetMs) * time.Millisecond
+	}
+	if c.MaxTimeoutMs != nil && !set["max-timeout"] {
+		cfg.MaxTimeout = time.Duration(*c.MaxTimeoutMs) * time.Millisecond
+	}
+	if c.SlowThresholdMs != nil && !set["slow-threshold"] {
+		cfg.SlowThreshold = time.Duration(*c.SlowThresholdMs) * time.Millisecond
+	}
+	if c.SlowStartSec != nil && !set["slow-start"] {
+		cfg.SlowStart = time.Duration(*c.SlowStartSec) * time.Second
+	}
+	if c.Maintenance != nil {
+		cfg.Maintenance = make([]MaintenanceWindow, len(c.Maintenance))
+		for i, m := range c.Maintenance {
+			cfg.Maintenance[i] = MaintenanceWindow{
+				Backend:  m.Backend,
+				Schedule: m.Schedule,
+				Duration: time.Duration(m.DurationMin) * time.Minute,
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package lb
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestInterpolateEnv(t *testing.T) {
+	env := map[string]string{"HOST": "server1", "EMPTY": ""}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	tests := []struct {
+		in, want string
+		wantErr  bool
+	}{
+		{in: "${HOST}:8080", want: "server1:8080"},
+		{in: "${PORT:-8080}", want: "8080"},
+		{in: "${HOST:-other}", want: "server1"},
+		{in: "${EMPTY:-fallback}", want: "fallback"},
+		{in: "${EMPTY}", want: ""},
+		{in: "cost $$5", want: "cost $5"},
+		{in: "${MISSING}", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := interpolateEnv(tt.in, lookup)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("interpolateEnv(%q): expected error", tt.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("interpolateEnv(%q): %v", tt.in, err)
+		}
+		if got != tt.want {
+			t.Errorf("interpolateEnv(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("LB_TEST_BACKEND", "backend-a:9000")
+	path := filepath.Join(t.TempDir(), "lb.json")
+	content := `{
+		"port": ${LB_TEST_PORT:-9090},
+		"strategy": "hash",
+		"backends": ["${LB_TEST_BACKEND}", "backend-b:${LB_TEST_BACKEND_PORT:-9001}"]
+	}`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port == nil || *cfg.Port != 9090 {
+		t.Errorf("unexpected port %v", cfg.Port)
+	}
+	if cfg.Strategy == nil || *cfg.Strategy != "hash" {
+		t.Errorf("unexpected strategy %v", cfg.Strategy)
+	}
+	if cfg.TimeoutSec != nil {
+		t.Errorf("expected timeout to be unset, got %d", *cfg.TimeoutSec)
+	}
+	want := []string{"backend-a:9000", "backend-b:9001"}
+	if !reflect.DeepEqual(cfg.Backends, want) {
+		t.Errorf("backends = %v, want %v", cfg.Backends, want)
+	}
+}
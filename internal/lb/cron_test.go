@@ -0,0 +1,56 @@
+package lb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron_Errors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+	} {
+		if _, err := parseCron(spec); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
+
+func TestCronSchedule_Next(t *testing.T) {
+	// 2026-10-16 is a Friday.
+	from := time.Date(2026, 10, 16, 10, 30, 20, 0, time.UTC)
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2026, 10, 16, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, 10, 16, 10, 45, 0, 0, time.UTC)},
+		{"0 3 * * 0", time.Date(2026, 10, 18, 3, 0, 0, 0, time.UTC)},
+		{"0 3 * * 7", time.Date(2026, 10, 18, 3, 0, 0, 0, time.UTC)},
+		{"30 2 1 * *", time.Date(2026, 11, 1, 2, 30, 0, 0, time.UTC)},
+		{"0 0 1 1 *", time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 9-17/4 * * 1-5", time.Date(2026, 10, 16, 13, 0, 0, 0, time.UTC)},
+		{"10,40 10 * * *", time.Date(2026, 10, 16, 10, 40, 0, 0, time.UTC)},
+		// Both day fields restricted: either one matching is enough.
+		{"0 0 20 * 6", time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		s, err := parseCron(tt.spec)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.spec, err)
+		}
+		if got := s.next(from); !got.Equal(tt.want) {
+			t.Errorf("%q: next is %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package lb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Discoverer finds the backends of the default pool, as host:port
+// addresses.
+type Discoverer interface {
+	Discover(ctx context.Context) ([]string, error)
+}
+
+// ParseDiscovery returns the Discoverer described by spec:
+//
+//	static:host:port,host:port  a fixed list
+//	dns:name:port               the A/AAAA records of name, with port
+//	srv:name                    the SRV records of name, e.g. _http._tcp.server
+//	http://... or https://...   a registry answering GET with a JSON array
+func ParseDiscovery(spec string) (Discoverer, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "static":
+		var backends []string
+		for _, b := range strings.Split(arg, ",") {
+			if b = strings.TrimSpace(b); b != "" {
+				backends = append(backends, b)
+			}
+		}
+		return StaticDiscovery(backends), nil
+	case "dns":
+		host, port, err := net.SplitHostPort(arg)
+		if err != nil {
+			return nil, fmt.Errorf("discovery %q: %w", spec, err)
+		}
+		return &DNSDiscovery{Name: host, Port: port}, nil
+	case "srv":
+		if arg == "" {
+			return nil, fmt.Errorf("discovery %q: no SRV name", spec)
+		}
+		return &DNSDiscovery{Name: arg, SRV: true}, nil
+	case "http", "https":
+		return &HTTPDiscovery{URL: spec}, nil
+	}
+	return nil, fmt.Errorf("unknown discovery %q", spec)
+}
+
+// StaticDiscovery always finds the same backends.
+type StaticDiscovery []string
+
+func (d StaticDiscovery) Discover(context.Context) ([]string, error) {
+	return slices.Clone(d), nil
+}
+
+// resolver is the part of net.Resolver DNSDiscovery uses.
+type resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// DNSDiscovery finds backends by resolving Name: its addresses joined with
+// Port, or, if SRV is set, the targets and ports of its SRV records. Docker
+// Compose and Kubernetes headless services answer with one record per
+// replica.
+type DNSDiscovery struct {
+	Name     string
+	Port     string
+	SRV      bool
+	resolver resolver // net.DefaultResolver if nil
+}
+
+func (d *DNSDiscovery) Discover(ctx context.Context) ([]string, error) {
+	r := d.resolver
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	var backends []string
+	if d.SRV {
+		_, records, err := r.LookupSRV(ctx, "", "", d.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, rec := range records {
+			host := strings.TrimSuffix(rec.Target, ".")
+			backends = append(backends, net.JoinHostPort(host, fmt.Sprint(rec.Port)))
+		}
+		return backends, nil
+	}
+	addrs, err := r.LookupHost(ctx, d.Name)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		backends = append(backends, net.JoinHostPort(addr, d.Port))
+	}
+	return backends, nil
+}
+
+// HTTPDiscovery fetches the backends from a registry that answers GET URL
+// with a JSON array of host:port strings.
+type HTTPDiscovery struct {
+	URL    string
+	Client *http.Client // http.DefaultClient if nil
+}
+
+func (d *HTTPDiscovery) Discover(ctx context.Context) ([]string, error) {
+	client := d.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("registry %s: status %s", d.URL, resp.Status)
+	}
+	var backends []string
+	if err := json.NewDecoder(resp.Body).Decode(&backends); err != nil {
+		return nil, fmt.Errorf("registry %s: %w", d.URL, err)
+	}
+	return backends, nil
+}
+
+// discover runs d with timeout and returns the backends sorted and without
+// duplicates. Finding none is an error: an empty pool is more likely a
+// broken registry than a tier scaled to zero.
+func discover(d Discoverer, timeout time.Duration) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	backends, err := d.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(backends)
+	backends = slices.Compact(backends)
+	if len(backends) == 0 {
+		return nil, fmt.Errorf("no backends found")
+	}
+	return backends, nil
+}
+
+// discoverInitial returns the backends d finds at startup, or fallback if it
+// fails, so the balancer still starts when the registry is down.
+func discoverInitial(d Discoverer, fallback []string, timeout time.Duration) []string {
+	backends, err := discover(d, timeout)
+	if err != nil {
+		log.Printf("Discovery failed, starting with %d configured backends: %v", len(fallback), err)
+		return fallback
+	}
+	log.Printf("Discovered %d backends", len(backends))
+	return backends
+}
+
+// startDiscovery polls d every Config.DiscoveryInterval until ctx is done
+// and makes what it finds the default pool. A failed poll keeps the pool
+// as it is.
+func (b *Balancer) startDiscovery(ctx context.Context, d Discoverer) {
+	b.background.Add(1)
+	go func() {
+		defer b.background.Done()
+		timer := time.NewTimer(b.Config().DiscoveryInterval)
+		defer timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+			cfg := b.Config()
+			backends, err := discover(d, cfg.Timeout)
+			if err != nil {
+				log.Printf("Discovery failed, keeping the backend pool: %v", err)
+			} else {
+				b.setDiscovered(backends)
+			}
+			timer.Reset(cfg.DiscoveryInterval)
+		}
+	}()
+}
+
+// setDiscovered makes backends the default pool, if it changed. Backends
+// that are gone are drained as on a reload.
+func (b *Balancer) setDiscovered(backends []string) {
+	b.reloadMu.Lock()
+	defer b.reloadMu.Unlock()
+
+	b.mu.Lock()
+	if slices.Equal(b.cfg.Backends, backends) {
+		b.mu.Unlock()
+		return
+	}
+	old, next := b.cfg, b.cfg
+	next.Backends = backends
+	if err := next.validate(); err != nil {
+		b.mu.Unlock()
+		log.Printf("Discovered backends rejected: %v", err)
+		return
+	}
+	b.cfg = next
+	b.mu.Unlock()
+
+	b.setServerPool(next)
+	for _, c := range old.diff(&next) {
+		log.Printf("Discovery: %s", c)
+	}
+}
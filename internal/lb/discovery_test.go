@@ -0,0 +1,92 @@
+package lb
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+type fakeResolver struct {
+	hosts []string
+	srv   []*net.SRV
+}
+
+func (r fakeResolver) LookupHost(context.Context, string) ([]string, error) {
+	return r.hosts, nil
+}
+
+func (r fakeResolver) LookupSRV(context.Context, string, string, string) (string, []*net.SRV, error) {
+	return "", r.srv, nil
+}
+
+func TestParseDiscovery(t *testing.T) {
+	for _, spec := range []string{"static:a:1,b:2", "dns:server:8080", "srv:_http._tcp.server", "http://registry/backends"} {
+		if _, err := ParseDiscovery(spec); err != nil {
+			t.Errorf("%s: %v", spec, err)
+		}
+	}
+	for _, spec := range []string{"", "consul:x", "dns:server", "srv:"} {
+		if _, err := ParseDiscovery(spec); err == nil {
+			t.Errorf("%q must be rejected", spec)
+		}
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	registry := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		_, _ = rw.Write([]byte(`["server2:8080", "server1:8080"]`))
+	}))
+	defer registry.Close()
+
+	tests := []struct {
+		name string
+		d    Discoverer
+		want []string
+	}{
+		{"static", StaticDiscovery{"b:1", "a:1", "b:1"}, []string{"a:1", "b:1"}},
+		{"dns", &DNSDiscovery{Name: "server", Port: "8080", resolver: fakeResolver{hosts: []string{"10.0.0.2", "10.0.0.1"}}},
+			[]string{"10.0.0.1:8080", "10.0.0.2:8080"}},
+		{"srv", &DNSDiscovery{Name: "_http._tcp.server", SRV: true, resolver: fakeResolver{srv: []*net.SRV{{Target: "server1.", Port: 8080}}}},
+			[]string{"server1:8080"}},
+		{"http", &HTTPDiscovery{URL: registry.URL}, []string{"server1:8080", "server2:8080"}},
+	}
+	for _, tt := range tests {
+		got, err := discover(tt.d, time.Second)
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %v, %v; want %v", tt.name, got, err, tt.want)
+		}
+	}
+
+	if _, err := discover(StaticDiscovery{}, time.Second); err == nil {
+		t.Error("finding no backends must be an error")
+	}
+}
+
+func TestSetDiscovered(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Backends = []string{"server1:8080", "server2:8080"}
+	b := newBalancer(cfg)
+	b.setServerPool(cfg)
+	defer b.Close()
+	kept := b.servers[0]
+
+	b.setDiscovered([]string{"server1:8080", "server3:8080"})
+
+	if got := b.Config().Backends; !slices.Equal(got, []string{"server1:8080", "server3:8080"}) {
+		t.Errorf("unexpected default pool %v", got)
+	}
+	var urls []string
+	for _, s := range b.Backends() {
+		urls = append(urls, s.URL)
+	}
+	if !slices.Equal(urls, []string{"server1:8080", "server3:8080"}) {
+		t.Errorf("unexpected backends %v", urls)
+	}
+	if b.servers[0] != kept {
+		t.Error("a backend that is still discovered must keep its state")
+	}
+}
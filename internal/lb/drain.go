@@ -0,0 +1,124 @@
+package lb
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// drainPollInterval is how often a draining backend is checked for requests
+// still in flight.
+const drainPollInterval = 50 * time.Millisecond
+
+// drainLocked takes a backend removed from the pool out of rotation and
+// reports whether it has to stay in the pool for now: a backend with
+// requests in flight is kept, as draining, until they finish or the timeout
+// passes, when they are cut. b.serversMu is held.
+func (b *Balancer) drainLocked(s *ServerInfo, timeout time.Duration) bool {
+	s.transition(stateDraining)
+	s.stop()
+	if s.InFlight() == 0 {
+		s.client.CloseIdleConnections()
+		return false
+	}
+	log.Printf("Draining %s: %d requests in flight", s.URL, s.InFlight())
+	b.drains.Add(1)
+	go b.awaitDrain(s, timeout)
+	return true
+}
+
+func (b *Balancer) awaitDrain(s *ServerInfo, timeout time.Duration) {
+	defer b.drains.Done()
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for s.InFlight() > 0 {
+		select {
+		case <-ticker.C:
+			continue
+		case <-deadline.C:
+			log.Printf("Draining %s timed out, cutting %d requests", s.URL, s.InFlight())
+			s.cutInFlight()
+		case <-s.cut.Done():
+		}
+		break
+	}
+
+	b.serversMu.Lock()
+	b.servers = slices.DeleteFunc(b.servers, func(other *ServerInfo) bool { return other == s })
+	b.serversMu.Unlock()
+	s.client.CloseIdleConnections()
+	log.Printf("Server %s drained and removed from the pool", s.URL)
+}
+
+// RemoveBackend drains a backend of the default pool, or of the given group,
+// and removes it from the config in effect. Like the canary weight, the
+// change lasts until the next config reload.
+func (b *Balancer) RemoveBackend(url, group string) error {
+	b.reloadMu.Lock()
+	defer b.reloadMu.Unlock()
+
+	b.mu.Lock()
+	next := b.cfg
+	if group == "" {
+		next.Backends = slices.DeleteFunc(slices.Clone(next.Backends), func(u string) bool { return u == url })
+		if len(next.Backends) == len(b.cfg.Backends) {
+			b.mu.Unlock()
+			return fmt.Errorf("backend %s is not in the default pool", url)
+		}
+	} else {
+		members := b.cfg.Groups[group]
+		kept := slices.DeleteFunc(slices.Clone(members), func(u string) bool { return u == url })
+		if len(kept) == len(members) {
+			b.mu.Unlock()
+			return fmt.Errorf("backend %s is not in group %q", url, group)
+		}
+		next.Groups = make(map[string][]string, len(b.cfg.Groups))
+		for name, urls := range b.cfg.Groups {
+			next.Groups[name] = urls
+		}
+		next.Groups[group] = kept
+	}
+	if err := next.validate(); err != nil {
+		b.mu.Unlock()
+		return err
+	}
+	b.cfg = next
+	b.mu.Unlock()
+
+	b.setServerPool(next)
+	if group != "" {
+		url = group + "/" + url
+	}
+	log.Printf("Backend %s removed via the admin API", url)
+	return nil
+}
+
+// handleBackends lists the backends on GET and removes one on DELETE
+// ?backend=<host:port>[&group=<name>].
+func (b *Balancer) handleBackends(rw http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodDelete:
+		q := r.URL.Query()
+		if q.Get("backend") == "" {
+			http.Error(rw, `"backend" is required`, http.StatusBadRequest)
+			return
+		}
+		if err := b.RemoveBackend(q.Get("backend"), q.Get("group")); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		rw.Header().Set("Allow", "GET, DELETE")
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(rw).Encode(b.Backends())
+}
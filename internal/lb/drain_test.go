@@ -0,0 +1,95 @@
+package lb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRemoveBackend_Drain(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		case "/hang":
+			<-r.Context().Done()
+		}
+	}))
+	t.Cleanup(backend.Close)
+	other := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(other.Close)
+	url := strings.TrimPrefix(backend.URL, "http://")
+
+	for _, tt := range []struct {
+		name    string
+		timeout time.Duration
+		path    string
+		cut     bool
+	}{
+		{"finishes", time.Minute, "/slow", false},
+		{"times out", 50 * time.Millisecond, "/hang", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Backends = []string{url, strings.TrimPrefix(other.URL, "http://")}
+			cfg.HealthInterval = time.Hour
+			cfg.DrainTimeout = tt.timeout
+			b := newBalancer(cfg)
+			b.setServerPool(cfg)
+			defer b.Close()
+
+			s := b.servers[0]
+			done := make(chan error, 1)
+			go func() { done <- b.forward(s, httptest.NewRecorder(), httptest.NewRequest("GET", tt.path, nil)) }()
+			for s.InFlight() == 0 {
+				time.Sleep(time.Millisecond)
+			}
+
+			if err := b.RemoveBackend(url, ""); err != nil {
+				t.Fatal(err)
+			}
+			if state, _ := s.State(); state != stateDraining {
+				t.Errorf("expected the removed backend to drain, got %s", state)
+			}
+			if !slices.ContainsFunc(b.Backends(), func(snap ServerSnapshot) bool { return snap.URL == url }) {
+				t.Error("a draining backend must stay in the pool while requests are in flight")
+			}
+			if got := b.selectServer(httptest.NewRequest("GET", "/", nil)); got == s {
+				t.Error("a draining backend must not get new requests")
+			}
+			if slices.Contains(b.Config().Backends, url) {
+				t.Error("the removed backend is still configured")
+			}
+
+			if !tt.cut {
+				close(release)
+			}
+			if err := <-done; (err != nil) != tt.cut {
+				t.Errorf("forward returned %v, expected cut %t", err, tt.cut)
+			}
+			deadline := time.Now().Add(time.Second)
+			for len(b.Backends()) != 1 && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if len(b.Backends()) != 1 {
+				t.Errorf("expected the drained backend to leave the pool, got %+v", b.Backends())
+			}
+		})
+	}
+
+	b := testBalancer()
+	b.cfg.Backends = []string{"a:80"}
+	if err := b.RemoveBackend("b:80", ""); err == nil {
+		t.Error("expected removing an unknown backend to fail")
+	}
+	if err := b.RemoveBackend("a:80", ""); err == nil {
+		t.Error("expected removing the last backend to fail")
+	}
+}
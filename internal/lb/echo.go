@@ -0,0 +1,79 @@
+package lb
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const echoPath = "/lb/echo"
+
+// Limits for the query overrides, so a client cannot make the balancer
+// allocate or hold connections without bound.
+const (
+	maxEchoPayloadBytes = 16 << 20
+	maxEchoLatency      = 30 * time.Second
+)
+
+// EchoOptions configure the synthetic /lb/echo route, answered by the
+// balancer itself to measure its overhead. The size and latency_ms query
+// parameters override PayloadBytes and Latency.
+type EchoOptions struct {
+	Enabled      bool
+	PayloadBytes int
+	Latency      time.Duration
+}
+
+// EchoConfig is the echo section of the config file.
+type EchoConfig struct {
+	Enabled      *bool `json:"enabled"`
+	PayloadBytes *int  `json:"payload_bytes"`
+	LatencyMs    *int  `json:"latency_ms"`
+}
+
+// serveEcho answers the synthetic route if it is enabled and reports whether
+// it did; otherwise the request is proxied like any other.
+func (b *Balancer) serveEcho(rw http.ResponseWriter, r *http.Request) bool {
+	b.mu.RLock()
+	echo := b.cfg.Echo
+	b.mu.RUnlock()
+	if !echo.Enabled {
+		return false
+	}
+	b.metrics.echo.Add(1)
+	size, latency := max(echo.PayloadBytes, 0), echo.Latency
+
+	q := r.URL.Query()
+	if v := q.Get("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > maxEchoPayloadBytes {
+			http.Error(rw, "invalid size", http.StatusBadRequest)
+			return true
+		}
+		size = n
+	}
+	if v := q.Get("latency_ms"); v != "" {
+		ms, err := strconv.Atoi(v)
+		if err != nil || ms < 0 || time.Duration(ms)*time.Millisecond > maxEchoLatency {
+			http.Error(rw, "invalid latency_ms", http.StatusBadRequest)
+			return true
+		}
+		latency = time.Duration(ms) * time.Millisecond
+	}
+
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		select {
+		case <-timer.C:
+		case <-r.Context().Done():
+			timer.Stop()
+			return true
+		}
+	}
+	rw.Header().Set("Content-Type", "application/octet-stream")
+	rw.Header().Set("Content-Length", strconv.Itoa(size))
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write(bytes.Repeat([]byte{'x'}, size))
+	return true
+}
@@ -0,0 +1,47 @@
+package lb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServeEcho(t *testing.T) {
+	b := testBalancer()
+	if b.serveEcho(httptest.NewRecorder(), httptest.NewRequest("GET", echoPath, nil)) {
+		t.Error("a disabled echo route must not answer")
+	}
+
+	b.cfg.Echo = EchoOptions{Enabled: true, PayloadBytes: 10}
+	tests := []struct {
+		target string
+		status int
+		size   int
+	}{
+		{echoPath, http.StatusOK, 10},
+		{echoPath + "?size=0", http.StatusOK, 0},
+		{echoPath + "?size=2048", http.StatusOK, 2048},
+		{echoPath + "?size=-1", http.StatusBadRequest, -1},
+		{echoPath + "?size=1000000000", http.StatusBadRequest, -1},
+		{echoPath + "?latency_ms=abc", http.StatusBadRequest, -1},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		if !b.serveEcho(rr, httptest.NewRequest("GET", tt.target, nil)) {
+			t.Fatalf("%s: not answered", tt.target)
+		}
+		if rr.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.target, tt.status, rr.Code)
+		}
+		if tt.size >= 0 && rr.Body.Len() != tt.size {
+			t.Errorf("%s: expected %d bytes, got %d", tt.target, tt.size, rr.Body.Len())
+		}
+	}
+
+	start := time.Now()
+	b.serveEcho(httptest.NewRecorder(), httptest.NewRequest("GET", echoPath+"?latency_ms=50", nil))
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("expected the response to be delayed by 50ms, took %v", elapsed)
+	}
+}
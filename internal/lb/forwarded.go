@@ -0,0 +1,82 @@
+package lb
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+var forwardedHeaders = []string{"Forwarded", "X-Forwarded-For", "X-Forwarded-Proto", "X-Forwarded-Host"}
+
+// setForwardedHeaders records the client of in on the outgoing request using
+// both the X-Forwarded-* headers and the RFC 7239 Forwarded header. Values
+// received from the client are appended to when trusted and dropped otherwise.
+func setForwardedHeaders(out, in *http.Request, trusted bool) {
+	if !trusted {
+		for _, name := range forwardedHeaders {
+			out.Header.Del(name)
+		}
+	}
+
+	clientIP, _, err := net.SplitHostPort(in.RemoteAddr)
+	if err != nil {
+		clientIP = in.RemoteAddr
+	}
+	proto := "http"
+	if in.TLS != nil {
+		proto = "https"
+	}
+
+	if clientIP != "" {
+		if prior := out.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+			out.Header.Set("X-Forwarded-For", strings.Join(prior, ", ")+", "+clientIP)
+		} else {
+			out.Header.Set("X-Forwarded-For", clientIP)
+		}
+	}
+	if out.Header.Get("X-Forwarded-Proto") == "" {
+		out.Header.Set("X-Forwarded-Proto", proto)
+	}
+	if out.Header.Get("X-Forwarded-Host") == "" && in.Host != "" {
+		out.Header.Set("X-Forwarded-Host", in.Host)
+	}
+
+	var elem []string
+	if clientIP != "" {
+		elem = append(elem, "for="+forwardedNode(clientIP))
+	}
+	if in.Host != "" {
+		elem = append(elem, "host="+quoteForwarded(in.Host))
+	}
+	elem = append(elem, "proto="+proto)
+	value := strings.Join(elem, ";")
+	if prior := out.Header.Values("Forwarded"); len(prior) > 0 {
+		value = strings.Join(prior, ", ") + ", " + value
+	}
+	out.Header.Set("Forwarded", value)
+}
+
+// forwardedNode formats an address as a Forwarded "for" node: IPv6 addresses
+// are bracketed and quoted as required by RFC 7239.
+func forwardedNode(ip string) string {
+	if strings.Contains(ip, ":") {
+		return `"[` + ip + `]"`
+	}
+	return ip
+}
+
+func quoteForwarded(value string) string {
+	for _, c := range value {
+		if !isTokenChar(c) {
+			return `"` + strings.ReplaceAll(strings.ReplaceAll(value, `\`, `\\`), `"`, `\"`) + `"`
+		}
+	}
+	return value
+}
+
+func isTokenChar(c rune) bool {
+	if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+		return true
+	}
+	return strings.ContainsRune("!#$%&'*+-.^_`|~", c)
+}
@@ -0,0 +1,51 @@
+package lb
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSetForwardedHeaders(t *testing.T) {
+	in := httptest.NewRequest("GET", "http://example.com/api", nil)
+	in.RemoteAddr = "10.0.0.5:4321"
+	in.Header.Set("X-Forwarded-For", "1.2.3.4")
+	in.Header.Set("Forwarded", "for=1.2.3.4")
+
+	t.Run("untrusted", func(t *testing.T) {
+		out := in.Clone(in.Context())
+		setForwardedHeaders(out, in, false)
+		if got := out.Header.Get("X-Forwarded-For"); got != "10.0.0.5" {
+			t.Errorf("X-Forwarded-For = %q, want %q", got, "10.0.0.5")
+		}
+		if got := out.Header.Get("Forwarded"); got != "for=10.0.0.5;host=example.com;proto=http" {
+			t.Errorf("Forwarded = %q", got)
+		}
+		if got := out.Header.Get("X-Forwarded-Proto"); got != "http" {
+			t.Errorf("X-Forwarded-Proto = %q, want %q", got, "http")
+		}
+		if got := out.Header.Get("X-Forwarded-Host"); got != "example.com" {
+			t.Errorf("X-Forwarded-Host = %q, want %q", got, "example.com")
+		}
+	})
+
+	t.Run("trusted", func(t *testing.T) {
+		out := in.Clone(in.Context())
+		setForwardedHeaders(out, in, true)
+		if got := out.Header.Get("X-Forwarded-For"); got != "1.2.3.4, 10.0.0.5" {
+			t.Errorf("X-Forwarded-For = %q, want %q", got, "1.2.3.4, 10.0.0.5")
+		}
+		if got := out.Header.Get("Forwarded"); got != "for=1.2.3.4, for=10.0.0.5;host=example.com;proto=http" {
+			t.Errorf("Forwarded = %q", got)
+		}
+	})
+
+	t.Run("ipv6", func(t *testing.T) {
+		in := httptest.NewRequest("GET", "http://example.com/", nil)
+		in.RemoteAddr = "[2001:db8::1]:80"
+		out := in.Clone(in.Context())
+		setForwardedHeaders(out, in, false)
+		if got := out.Header.Get("Forwarded"); got != `for="[2001:db8::1]";host=example.com;proto=http` {
+			t.Errorf("Forwarded = %q", got)
+		}
+	})
+}
@@ -0,0 +1,72 @@
+package lb
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// routeGroup returns the backend group of the route matching r, or "" for
+// the default pool of Config.Backends, and the side of the canary split the
+// route asks for, if any.
+func (b *Balancer) routeGroup(r *http.Request) (string, *bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if route := matchRoute(b.cfg.Routes, r); route != nil {
+		return route.Group, route.Canary
+	}
+	return "", nil
+}
+
+// validateRoutes checks that every group has backends and that routes only
+// refer to defined groups and have valid costs.
+func validateRoutes(groups map[string][]string, routes []Route) error {
+	for name, backends := range groups {
+		if name == "" {
+			return fmt.Errorf("backend group without a name")
+		}
+		if len(backends) == 0 {
+			return fmt.Errorf("backend group %q has no backends", name)
+		}
+	}
+	for _, route := range routes {
+		if route.Cost < 0 {
+			return fmt.Errorf("route %q has a negative cost", route.PathPrefix)
+		}
+		if route.TimeoutMs < 0 {
+			return fmt.Errorf("route %q has a negative timeout", route.PathPrefix)
+		}
+		for _, m := range route.Methods {
+			if m == "" || strings.ContainsAny(m, " \t") {
+				return fmt.Errorf("route %q has an invalid method %q", route.PathPrefix, m)
+			}
+		}
+		for name := range route.Headers {
+			if name == "" {
+				return fmt.Errorf("route %q matches a header without a name", route.PathPrefix)
+			}
+		}
+		if _, ok := groups[route.Group]; route.Group != "" && !ok {
+			return fmt.Errorf("route %q refers to unknown backend group %q", route.PathPrefix, route.Group)
+		}
+	}
+	return nil
+}
+
+// poolMembers lists the backends of the default pool and of every group,
+// the latter as "group/url", in a stable order.
+func poolMembers(backends []string, groups map[string][]string) []string {
+	members := append([]string(nil), backends...)
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, url := range groups[name] {
+			members = append(members, name+"/"+url)
+		}
+	}
+	return members
+}
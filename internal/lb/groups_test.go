@@ -0,0 +1,80 @@
+package lb
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestValidateGroups(t *testing.T) {
+	groups := map[string][]string{"app": {"app1:8080"}, "static": {"static1:8080"}}
+	if err := validateRoutes(groups, []Route{{PathPrefix: "/api/", Group: "app"}, {PathPrefix: "/"}}); err != nil {
+		t.Errorf("valid groups rejected: %v", err)
+	}
+	if err := validateRoutes(groups, []Route{{PathPrefix: "/img/", Group: "images"}}); err == nil {
+		t.Error("expected a route to an unknown group to be rejected")
+	}
+	if err := validateRoutes(map[string][]string{"empty": nil}, nil); err == nil {
+		t.Error("expected a group without backends to be rejected")
+	}
+	if err := validateRoutes(groups, []Route{{PathPrefix: "/api/", Methods: []string{""}}}); err == nil {
+		t.Error("expected an empty method to be rejected")
+	}
+}
+
+func TestSelectServer_Groups(t *testing.T) {
+	app := testServerInfo("app1:8080", true, 100)
+	static := testServerInfo("static1:8080", true, 100)
+	deflt := testServerInfo("server1:8080", true, 0)
+	app.Group, static.Group = "app", "static"
+	b := testBalancer(deflt, app, static)
+	b.cfg.Routes = []Route{{PathPrefix: "/api/", Group: "app"}, {PathPrefix: "/static/", Group: "static"}}
+
+	tests := []struct {
+		path string
+		want *ServerInfo
+	}{
+		{"/api/v1/some-data", app},
+		{"/static/logo.png", static},
+		{"/other", deflt},
+	}
+	for _, tt := range tests {
+		if got := b.selectServer(httptest.NewRequest("GET", tt.path, nil)); got != tt.want {
+			t.Errorf("%s: selected %v, want %s", tt.path, got, tt.want.GetURL())
+		}
+	}
+
+	app.SetAlive(false)
+	if got := b.selectServer(httptest.NewRequest("GET", "/api/x", nil)); got != nil {
+		t.Errorf("expected no fallback to other groups, got %s", got.GetURL())
+	}
+}
+
+func TestPoolMembers(t *testing.T) {
+	got := poolMembers([]string{"s1"}, map[string][]string{"b": {"b1"}, "a": {"a1", "a2"}})
+	if want := []string{"s1", "a/a1", "a/a2", "b/b1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("poolMembers() = %v, want %v", got, want)
+	}
+}
+
+func TestSetServerPool_Groups(t *testing.T) {
+	b := testBalancer()
+	defer b.Close()
+
+	cfg := b.cfg
+	cfg.Backends, cfg.Groups = []string{"shared:80"}, map[string][]string{"app": {"shared:80", "app:80"}}
+	b.setServerPool(cfg)
+	if len(b.servers) != 3 {
+		t.Fatalf("expected a backend per group membership, got %+v", b.Backends())
+	}
+	if b.servers[0].Group != "" || b.servers[1].Group != "app" || b.servers[0] == b.servers[1] {
+		t.Errorf("a backend shared by groups must be tracked per group: %+v", b.Backends())
+	}
+
+	kept := b.servers[1]
+	cfg.Backends, cfg.Groups = nil, map[string][]string{"app": {"shared:80"}}
+	b.setServerPool(cfg)
+	if len(b.servers) != 1 || b.servers[0] != kept {
+		t.Errorf("expected the app backend to be kept, got %+v", b.Backends())
+	}
+}
@@ -0,0 +1,133 @@
+package lb
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const ringVirtualNodes = 100
+
+// hashRing maps keys onto backends so that a key keeps its backend as long
+// as that backend stays in the ring, and only ~1/n of keys move when the
+// pool changes.
+type hashRing struct {
+	points []uint32
+	owners map[uint32]*ServerInfo
+}
+
+// hashString is FNV-1a followed by the murmur3 finalizer: plain FNV spreads
+// short, similar strings like "server1:8080#1" poorly over the ring.
+func hashString(s string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(s))
+	x := h.Sum32()
+	x ^= x >> 16
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	x *= 0xc2b2ae35
+	x ^= x >> 16
+	return x
+}
+
+func newHashRing(members []*ServerInfo) *hashRing {
+	ring := &hashRing{owners: make(map[uint32]*ServerInfo, len(members)*ringVirtualNodes)}
+	for _, server := range members {
+		for i := 0; i < ringVirtualNodes; i++ {
+			point := hashString(fmt.Sprintf("%s#%d", server.GetURL(), i))
+			if _, taken := ring.owners[point]; taken {
+				continue
+			}
+			ring.owners[point] = server
+			ring.points = append(ring.points, point)
+		}
+	}
+	sort.Slice(ring.points, func(i, j int) bool { return ring.points[i] < ring.points[j] })
+	return ring
+}
+
+func (ring *hashRing) Get(key string) *ServerInfo {
+	if len(ring.points) == 0 {
+		return nil
+	}
+	h := hashString(key)
+	i := sort.Search(len(ring.points), func(i int) bool { return ring.points[i] >= h })
+	if i == len(ring.points) {
+		i = 0
+	}
+	return ring.owners[ring.points[i]]
+}
+
+// ringCache keeps a ring per pool, rebuilt only when the set of alive
+// backends of the pool changes.
+type ringCache struct {
+	sync.Mutex
+	rings map[pool]*cachedRing
+}
+
+// cachedRing remembers the backends its ring was built from. They are
+// compared by identity, not URL: a backend removed and added back with the
+// same URL is a new ServerInfo, and the ring must not keep the drained one.
+type cachedRing struct {
+	members []*ServerInfo
+	ring    *hashRing
+}
+
+func (b *Balancer) selectServerHash(p pool, key string) *ServerInfo {
+	b.serversMu.RLock()
+	alive := make([]*ServerInfo, 0, len(b.servers))
+	for _, server := range b.servers {
+		if b.inPool(p, server) && server.IsAlive() {
+			alive = append(alive, server)
+		}
+	}
+	b.serversMu.RUnlock()
+
+	b.rings.Lock()
+	if b.rings.rings == nil {
+		b.rings.rings = make(map[pool]*cachedRing)
+	}
+	cached := b.rings.rings[p]
+	if cached == nil || !slices.Equal(cached.members, alive) {
+		cached = &cachedRing{members: alive, ring: newHashRing(alive)}
+		b.rings.rings[p] = cached
+	}
+	b.rings.Unlock()
+
+	// A key stays with its backend while that is at its in-flight limit:
+	// the request queues rather than moving to a backend without its data.
+	server := cached.ring.Get(key)
+	if server != nil && !server.available() {
+		return nil
+	}
+	return server
+}
+
+// requestHashKey extracts the routing key configured by Config.HashKey:
+// "path", "query:<name>" or "header:<name>".
+func requestHashKey(r *http.Request, spec string) string {
+	source, name, _ := strings.Cut(spec, ":")
+	switch source {
+	case "query":
+		return r.URL.Query().Get(name)
+	case "header":
+		return r.Header.Get(name)
+	default:
+		return r.URL.Path
+	}
+}
+
+func validHashKey(spec string) bool {
+	source, name, _ := strings.Cut(spec, ":")
+	switch source {
+	case "path":
+		return name == ""
+	case "query", "header":
+		return name != ""
+	}
+	return false
+}
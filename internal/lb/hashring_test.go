@@ -0,0 +1,116 @@
+package lb
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHashRing_Stability(t *testing.T) {
+	s1, s2, s3 := testServerInfo("s1", true, 0), testServerInfo("s2", true, 0), testServerInfo("s3", true, 0)
+	full := newHashRing([]*ServerInfo{s1, s2, s3})
+	reduced := newHashRing([]*ServerInfo{s1, s3})
+
+	moved := 0
+	const keys = 1000
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if before := full.Get(key); before != s2 && before != reduced.Get(key) {
+			moved++
+		}
+	}
+	if moved != 0 {
+		t.Errorf("%d keys not owned by the removed backend changed owner", moved)
+	}
+}
+
+func TestSelectServerHash(t *testing.T) {
+	b := testBalancer(
+		testServerInfo("s1", true, 0),
+		testServerInfo("s2", true, 0),
+		testServerInfo("s3", false, 0),
+	)
+
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		first := b.selectServerHash(pool{}, key)
+		if first == nil {
+			t.Fatal("expected a server, got nil")
+		}
+		if !first.IsAlive() {
+			t.Fatalf("selected dead server %s", first.GetURL())
+		}
+		if again := b.selectServerHash(pool{}, key); again != first {
+			t.Errorf("key %s mapped to %s then %s", key, first.GetURL(), again.GetURL())
+		}
+		seen[first.GetURL()] = true
+	}
+	if len(seen) != 2 {
+		t.Errorf("expected keys spread over both alive servers, got %v", seen)
+	}
+
+	for _, s := range b.servers {
+		s.SetAlive(false)
+	}
+	if s := b.selectServerHash(pool{}, "key"); s != nil {
+		t.Errorf("expected nil with no alive servers, got %s", s.GetURL())
+	}
+}
+
+func TestRequestHashKey(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/v1/some-data?key=abc", nil)
+	r.Header.Set("X-User", "u1")
+
+	tests := map[string]string{
+		"path":          "/api/v1/some-data",
+		"query:key":     "abc",
+		"header:X-User": "u1",
+	}
+	for spec, want := range tests {
+		if got := requestHashKey(r, spec); got != want {
+			t.Errorf("requestHashKey(%q) = %q, want %q", spec, got, want)
+		}
+	}
+
+	for spec, valid := range map[string]bool{"path": true, "query:key": true, "query:": false, "cookie:x": false} {
+		if validHashKey(spec) != valid {
+			t.Errorf("validHashKey(%q) = %t, want %t", spec, !valid, valid)
+		}
+	}
+}
+
+func TestSelectServerHash_ReAddedBackend(t *testing.T) {
+	var urls []string
+	for range 2 {
+		backend := httptest.NewServer(http.NotFoundHandler())
+		t.Cleanup(backend.Close)
+		urls = append(urls, strings.TrimPrefix(backend.URL, "http://"))
+	}
+	cfg := DefaultConfig()
+	cfg.Backends = urls
+	cfg.HealthInterval = time.Hour
+	b := newBalancer(cfg)
+	b.setServerPool(cfg)
+	defer b.Close()
+
+	removed := b.servers[0]
+	key := ""
+	for i := 0; key == ""; i++ {
+		if k := fmt.Sprintf("key-%d", i); b.selectServerHash(pool{}, k) == removed {
+			key = k
+		}
+	}
+	if err := b.RemoveBackend(urls[0], ""); err != nil {
+		t.Fatal(err)
+	}
+	b.setServerPool(cfg)
+
+	got := b.selectServerHash(pool{}, key)
+	if got == nil || got == removed || got.GetURL() != urls[0] {
+		t.Errorf("a backend added back under the same URL must own its keys again, got %v", got)
+	}
+}
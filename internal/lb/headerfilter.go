@@ -0,0 +1,49 @@
+package lb
+
+import (
+	"net/http"
+	"strings"
+)
+
+// headerFilter drops backend response headers as configured by
+// Config.StripResponseHeaders and Config.AllowResponseHeaders. It is rebuilt
+// on reload; nil keeps every header.
+type headerFilter struct {
+	deny  map[string]bool
+	allow map[string]bool
+}
+
+func newHeaderFilter(deny, allow string) *headerFilter {
+	f := &headerFilter{deny: headerSet(deny), allow: headerSet(allow)}
+	if f.deny == nil && f.allow == nil {
+		return nil
+	}
+	return f
+}
+
+func headerSet(list string) map[string]bool {
+	var set map[string]bool
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if set == nil {
+			set = make(map[string]bool)
+		}
+		set[http.CanonicalHeaderKey(name)] = true
+	}
+	return set
+}
+
+// apply removes denied headers and, in allowlist mode, every header that is
+// not allowed. Denying wins over allowing.
+func (f *headerFilter) apply(h http.Header) {
+	if f == nil {
+		return
+	}
+	for name := range h {
+		if f.deny[name] || (f.allow != nil && !f.allow[name]) {
+			delete(h, name)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package lb
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestHeaderFilter(t *testing.T) {
+	newHeader := func() http.Header {
+		h := http.Header{}
+		h.Set("Server", "nginx")
+		h.Set("X-Debug-Trace", "abc")
+		h.Set("Content-Type", "application/json")
+		h.Set("Cache-Control", "no-store")
+		return h
+	}
+
+	tests := []struct {
+		name, deny, allow string
+		want              []string
+	}{
+		{"no filter", "", "", []string{"Cache-Control", "Content-Type", "Server", "X-Debug-Trace"}},
+		{"deny", "server, x-debug-trace", "", []string{"Cache-Control", "Content-Type"}},
+		{"allow", "", "Content-Type,Cache-Control", []string{"Cache-Control", "Content-Type"}},
+		{"deny wins", "cache-control", "Content-Type,Cache-Control", []string{"Content-Type"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHeader()
+			newHeaderFilter(tt.deny, tt.allow).apply(h)
+			var got []string
+			for _, name := range []string{"Cache-Control", "Content-Type", "Server", "X-Debug-Trace"} {
+				if h.Get(name) != "" {
+					got = append(got, name)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("kept headers %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
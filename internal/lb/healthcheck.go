@@ -0,0 +1,41 @@
+package lb
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// startHealthChecks checks s in the background until s.stop is called. The
+// goroutine is tracked by b.healthChecks, so Close can wait for it.
+func (b *Balancer) startHealthChecks(s *ServerInfo) {
+	b.healthChecks.Add(1)
+	go b.healthLoop(s)
+}
+
+// healthLoop checks the backend right away and then every
+// Config.HealthInterval, with Config.HealthJitter applied, until it is
+// removed from the pool.
+func (b *Balancer) healthLoop(server *ServerInfo) {
+	defer b.healthChecks.Done()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-server.done:
+			return
+		}
+		b.health(server)
+		cfg := b.Config()
+		timer.Reset(jittered(cfg.HealthInterval, cfg.HealthJitter, rand.Float64))
+	}
+}
+
+// jittered changes interval by a random fraction in [-jitter, jitter); rnd
+// returns numbers in [0, 1).
+func jittered(interval time.Duration, jitter float64, rnd func() float64) time.Duration {
+	if jitter <= 0 {
+		return interval
+	}
+	return time.Duration(float64(interval) * (1 + jitter*(2*rnd()-1)))
+}
@@ -0,0 +1,59 @@
+package lb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJittered(t *testing.T) {
+	for _, tt := range []struct {
+		jitter, rnd float64
+		want        time.Duration
+	}{
+		{0, 0, 10 * time.Second},
+		{0.2, 0, 8 * time.Second},
+		{0.2, 0.5, 10 * time.Second},
+		{0.2, 0.75, 11 * time.Second},
+	} {
+		if got := jittered(10*time.Second, tt.jitter, func() float64 { return tt.rnd }); got != tt.want {
+			t.Errorf("jittered(10s, %g) with %g = %s, want %s", tt.jitter, tt.rnd, got, tt.want)
+		}
+	}
+}
+
+func TestHealthChecks_StopCancelsCheck(t *testing.T) {
+	var checks atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		checks.Add(1)
+		<-r.Context().Done()
+	}))
+	t.Cleanup(backend.Close)
+
+	cfg := DefaultConfig()
+	cfg.Timeout = time.Minute
+	b := newBalancer(cfg)
+	s := newServerInfo(strings.TrimPrefix(backend.URL, "http://"), true, &cfg)
+	b.startHealthChecks(s)
+	for checks.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		s.stop()
+		b.healthChecks.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("stopping the backend did not cancel its health check")
+	}
+	if state, _ := s.State(); state != stateHealthy {
+		t.Errorf("a cancelled check must not change the state, got %s", state)
+	}
+}
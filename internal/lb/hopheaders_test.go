@@ -0,0 +1,61 @@
+package lb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRemoveHopHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set("Connection", "keep-alive, X-Custom-Hop")
+	h.Set("Keep-Alive", "timeout=5")
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Upgrade", "websocket")
+	h.Set("X-Custom-Hop", "1")
+	h.Set("Content-Type", "application/json")
+
+	removeHopHeaders(h)
+
+	for _, name := range []string{"Connection", "Keep-Alive", "Transfer-Encoding", "Upgrade", "X-Custom-Hop"} {
+		if h.Get(name) != "" {
+			t.Errorf("expected %s to be removed", name)
+		}
+	}
+	if h.Get("Content-Type") != "application/json" {
+		t.Error("expected end-to-end header to be kept")
+	}
+}
+
+func TestForward_StripsHopHeaders(t *testing.T) {
+	var got http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		rw.Header().Set("Connection", "X-Backend-Hop")
+		rw.Header().Set("X-Backend-Hop", "1")
+		rw.Header().Set("X-End-To-End", "1")
+	}))
+	defer backend.Close()
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Connection", "X-Client-Hop")
+	req.Header.Set("X-Client-Hop", "1")
+	req.Header.Set("Proxy-Authorization", "secret")
+
+	rr := httptest.NewRecorder()
+	sInfo := testServerInfo(strings.TrimPrefix(backend.URL, "http://"), true, 0)
+	if err := testBalancer().forward(sInfo, rr, req); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Get("X-Client-Hop") != "" || got.Get("Proxy-Authorization") != "" {
+		t.Errorf("hop-by-hop request headers reached the backend: %v", got)
+	}
+	if rr.Header().Get("X-Backend-Hop") != "" || rr.Header().Get("Connection") != "" {
+		t.Errorf("hop-by-hop response headers reached the client: %v", rr.Header())
+	}
+	if rr.Header().Get("X-End-To-End") != "1" {
+		t.Error("expected end-to-end response header to be copied")
+	}
+}
@@ -0,0 +1,235 @@
+package lb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// journalSlotSize is the size of a journal record on disk. Longer paths and
+// errors are cut to fit.
+const journalSlotSize = 512
+
+// JournalEntry is a request that ended in a 5xx response.
+type JournalEntry struct {
+	Seq       uint64    `json:"seq"`
+	Time      time.Time `json:"time"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Backend   string    `json:"backend,omitempty"`
+	Status    int       `json:"status"`
+	LatencyMs int64     `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// journal is a ring buffer of fixed-size JSON records in a file: record n is
+// stored in slot n % slots, so the file never grows beyond slots records and
+// survives restarts of the balancer. It is enabled by Config.JournalPath.
+type journal struct {
+	mu    sync.Mutex
+	file  *os.File
+	slots int
+	next  uint64
+}
+
+var errNoBackend = errors.New("no healthy backend")
+
+func openJournal(path string, slots int) (*journal, error) {
+	if slots <= 0 {
+		return nil, fmt.Errorf("invalid journal size %d", slots)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	j := &journal{file: file, slots: slots}
+	if err := file.Truncate(int64(slots) * journalSlotSize); err != nil {
+		file.Close()
+		return nil, err
+	}
+	entries, err := j.Entries()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if len(entries) > 0 {
+		j.next = entries[0].Seq + 1
+	}
+	return j, nil
+}
+
+// Record appends e to the journal, overwriting the oldest entry once the
+// journal is full.
+func (j *journal) Record(e JournalEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e.Seq = j.next
+	record, err := encodeJournalEntry(e)
+	if err != nil {
+		return err
+	}
+	if _, err := j.file.WriteAt(record, int64(e.Seq%uint64(j.slots))*journalSlotSize); err != nil {
+		return fmt.Errorf("journal: %w", err)
+	}
+	j.next++
+	return nil
+}
+
+// encodeJournalEntry returns e as a slot: JSON padded with spaces and ending
+// with a newline, so the file stays readable with standard tools.
+func encodeJournalEntry(e JournalEntry) ([]byte, error) {
+	for _, limit := range []int{journalSlotSize, 128, 32, 0} {
+		e.Path, e.Error = truncate(e.Path, limit), truncate(e.Error, limit)
+		data, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) < journalSlotSize {
+			record := bytes.Repeat([]byte{' '}, journalSlotSize)
+			copy(record, data)
+			record[journalSlotSize-1] = '\n'
+			return record, nil
+		}
+	}
+	return nil, fmt.Errorf("journal entry does not fit in %d bytes", journalSlotSize)
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
+
+// Entries returns the journaled requests, newest first.
+func (j *journal) Entries() ([]JournalEntry, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	data := make([]byte, j.slots*journalSlotSize)
+	if _, err := j.file.ReadAt(data, 0); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("journal: %w", err)
+	}
+	var entries []JournalEntry
+	for i := 0; i < j.slots; i++ {
+		slot := bytes.TrimRight(data[i*journalSlotSize:(i+1)*journalSlotSize], " \n\x00")
+		if len(slot) == 0 {
+			continue
+		}
+		var e JournalEntry
+		if err := json.Unmarshal(slot, &e); err != nil {
+			// A slot torn by a crash mid-write is skipped.
+			continue
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(a, b int) bool { return entries[a].Seq > entries[b].Seq })
+	return entries, nil
+}
+
+func (j *journal) Close() error {
+	return j.file.Close()
+}
+
+// journalRequest records a request that ended in a 5xx.
+func (b *Balancer) journalRequest(r *http.Request, backend string, status int, start time.Time, err error) {
+	if b.journal == nil || status < http.StatusInternalServerError {
+		return
+	}
+	e := JournalEntry{
+		Time:      start.UTC(),
+		Method:    r.Method,
+		Path:      r.URL.RequestURI(),
+		Backend:   backend,
+		Status:    status,
+		LatencyMs: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if err := b.journal.Record(e); err != nil {
+		log.Printf("Failed to journal request: %v", err)
+	}
+}
+
+// handleJournal serves the journal as JSON, newest first. The backend, since
+// (RFC 3339) and limit query parameters filter the entries.
+func (b *Balancer) handleJournal(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		rw.Header().Set("Allow", http.MethodGet)
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if b.journal == nil {
+		http.Error(rw, "journal is disabled", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	limit := -1
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(rw, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	var since time.Time
+	if v := q.Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(rw, "invalid since", http.StatusBadRequest)
+			return
+		}
+		since = t
+	}
+	entries, err := b.journal.Entries()
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res := []JournalEntry{}
+	for _, e := range entries {
+		if limit >= 0 && len(res) == limit {
+			break
+		}
+		if (q.Has("backend") && e.Backend != q.Get("backend")) || e.Time.Before(since) {
+			continue
+		}
+		res = append(res, e)
+	}
+	rw.Header().Set("content-type", "application/json")
+	_ = json.NewEncoder(rw).Encode(res)
+}
+
+// statusRecorder remembers the status written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,118 @@
+package lb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJournal_RingBuffer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	j, err := openJournal(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := j.Record(JournalEntry{Path: fmt.Sprintf("/%d", i), Status: 502}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := j.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, e := range entries {
+		paths = append(paths, e.Path)
+	}
+	if got := strings.Join(paths, ","); got != "/4,/3,/2" {
+		t.Errorf("expected the 3 newest entries, newest first, got %s", got)
+	}
+	j.Close()
+
+	j, err = openJournal(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if err := j.Record(JournalEntry{Path: "/5", Status: 500}); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ = j.Entries()
+	if len(entries) != 3 || entries[0].Path != "/5" || entries[0].Seq != 5 || entries[2].Path != "/3" {
+		t.Errorf("expected the journal to continue after reopening, got %+v", entries)
+	}
+}
+
+func TestJournal_LongEntry(t *testing.T) {
+	j, err := openJournal(filepath.Join(t.TempDir(), "journal"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	long := strings.Repeat("x", 4*journalSlotSize)
+	if err := j.Record(JournalEntry{Path: "/" + long, Error: long, Status: 503}); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := j.Entries()
+	if len(entries) != 1 || entries[0].Status != 503 || len(entries[0].Path) >= journalSlotSize {
+		t.Errorf("expected a truncated entry, got %d entries", len(entries))
+	}
+}
+
+func TestHandleJournal(t *testing.T) {
+	j, err := openJournal(filepath.Join(t.TempDir(), "journal"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	b := testBalancer()
+	b.journal = j
+
+	start := time.Now()
+	r := httptest.NewRequest("GET", "/api/v1/some-data?key=a", nil)
+	b.journalRequest(r, "server1:8080", http.StatusOK, start, nil)
+	b.journalRequest(r, "server1:8080", http.StatusBadGateway, start, nil)
+	b.journalRequest(r, "server2:8080", http.StatusServiceUnavailable, start, errors.New("connection refused"))
+	b.journalRequest(r, "", http.StatusServiceUnavailable, start, errNoBackend)
+
+	get := func(query string) []JournalEntry {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		b.handleJournal(rr, httptest.NewRequest("GET", "/admin/journal"+query, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("GET /admin/journal%s: status %d", query, rr.Code)
+		}
+		var entries []JournalEntry
+		if err := json.NewDecoder(rr.Body).Decode(&entries); err != nil {
+			t.Fatal(err)
+		}
+		return entries
+	}
+
+	if entries := get(""); len(entries) != 3 {
+		t.Fatalf("expected only the 5xx responses to be journaled, got %+v", entries)
+	}
+	entries := get("?backend=server2:8080")
+	if len(entries) != 1 || entries[0].Error != "connection refused" || entries[0].Path != "/api/v1/some-data?key=a" {
+		t.Errorf("unexpected entries for server2: %+v", entries)
+	}
+	if entries := get("?limit=1"); len(entries) != 1 || entries[0].Error != errNoBackend.Error() {
+		t.Errorf("expected the newest entry, got %+v", entries)
+	}
+	if entries := get("?since=" + start.Add(time.Hour).Format(time.RFC3339)); len(entries) != 0 {
+		t.Errorf("expected no entries after since, got %+v", entries)
+	}
+
+	rr := httptest.NewRecorder()
+	b.handleJournal(rr, httptest.NewRequest("GET", "/admin/journal?limit=x", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid limit, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,154 @@
+package lb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const statsPath = "/lb/stats"
+
+// MaintenanceWindow takes a backend out of rotation on a recurring schedule.
+// While a window is open the backend gets no new requests and is not health
+// checked; afterwards it warms up like a recovered backend. Windows are
+// checked on every health check, so they open and close up to
+// Config.HealthInterval late.
+type MaintenanceWindow struct {
+	// Backend is the backend address, in every pool it belongs to.
+	Backend string
+	// Schedule is a cron spec of the window starts in the balancer's local
+	// time, e.g. "0 3 * * 0" for Sundays at 03:00.
+	Schedule string
+	Duration time.Duration
+
+	schedule *cronSchedule
+}
+
+// MaintenanceConfig is a maintenance window in the config file.
+type MaintenanceConfig struct {
+	Backend     string `json:"backend"`
+	Schedule    string `json:"schedule"`
+	DurationMin int    `json:"duration_min"`
+}
+
+// parseMaintenance checks the windows and parses their schedules.
+func parseMaintenance(windows []MaintenanceWindow) error {
+	for i := range windows {
+		w := &windows[i]
+		if w.Backend == "" {
+			return fmt.Errorf("maintenance window %d: no backend", i)
+		}
+		if w.Duration <= 0 {
+			return fmt.Errorf("maintenance window for %s: duration must be positive", w.Backend)
+		}
+		s, err := parseCron(w.Schedule)
+		if err != nil {
+			return fmt.Errorf("maintenance window for %s: %w", w.Backend, err)
+		}
+		w.schedule = s
+	}
+	return nil
+}
+
+// window returns the window of w open at now or, if none is, the next one
+// to open. Both times are zero if the schedule never fires.
+func (w *MaintenanceWindow) window(now time.Time) (start, end time.Time) {
+	// The first start after now-Duration is either open at now or the
+	// next one.
+	start = w.schedule.next(now.Add(-w.Duration))
+	if start.IsZero() {
+		return start, start
+	}
+	return start, start.Add(w.Duration)
+}
+
+// maintenanceSpecs describes the windows for comparison, without the parsed
+// schedules.
+func maintenanceSpecs(windows []MaintenanceWindow) string {
+	var specs []string
+	for _, w := range windows {
+		specs = append(specs, fmt.Sprintf("%s %q %v", w.Backend, w.Schedule, w.Duration))
+	}
+	return strings.Join(specs, "; ")
+}
+
+// inMaintenance reports whether one of the windows of the backend is open.
+func inMaintenance(windows []MaintenanceWindow, backend string, now time.Time) bool {
+	for i := range windows {
+		w := &windows[i]
+		if w.Backend != backend {
+			continue
+		}
+		if start, _ := w.window(now); !start.IsZero() && !start.After(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkMaintenance moves the backend in and out of maintenance and reports
+// whether it is in maintenance, in which case it must not be health checked.
+func (s *ServerInfo) checkMaintenance(windows []MaintenanceWindow, now time.Time) bool {
+	state, _ := s.State()
+	switch open := inMaintenance(windows, s.URL, now); {
+	case open && state != stateDraining:
+		s.transition(stateMaintenance)
+		return true
+	case !open && state == stateMaintenance:
+		s.transition(stateWarming)
+	}
+	return false
+}
+
+// MaintenanceSnapshot is the current or next window of a MaintenanceWindow.
+type MaintenanceSnapshot struct {
+	Backend  string    `json:"backend"`
+	Schedule string    `json:"schedule"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Active   bool      `json:"active"`
+}
+
+// Maintenance returns the open or upcoming window of every configured
+// maintenance schedule, soonest first.
+func (b *Balancer) Maintenance(now time.Time) []MaintenanceSnapshot {
+	b.mu.RLock()
+	windows := b.cfg.Maintenance
+	b.mu.RUnlock()
+
+	snapshots := make([]MaintenanceSnapshot, 0, len(windows))
+	for i := range windows {
+		w := &windows[i]
+		start, end := w.window(now)
+		if start.IsZero() {
+			continue
+		}
+		snapshots = append(snapshots, MaintenanceSnapshot{
+			Backend:  w.Backend,
+			Schedule: w.Schedule,
+			Start:    start,
+			End:      end,
+			Active:   !start.After(now),
+		})
+	}
+	sort.SliceStable(snapshots, func(i, j int) bool { return snapshots[i].Start.Before(snapshots[j].Start) })
+	return snapshots
+}
+
+// serveStats answers /lb/stats with the backend pool and the maintenance
+// windows.
+func (b *Balancer) serveStats(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		rw.Header().Set("Allow", http.MethodGet)
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(rw).Encode(map[string]any{
+		"backends":    b.Backends(),
+		"maintenance": b.Maintenance(time.Now()),
+	})
+}
@@ -0,0 +1,118 @@
+package lb
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testMaintenance(t *testing.T, windows ...MaintenanceWindow) []MaintenanceWindow {
+	t.Helper()
+	if err := parseMaintenance(windows); err != nil {
+		t.Fatal(err)
+	}
+	return windows
+}
+
+func TestParseMaintenance_Errors(t *testing.T) {
+	for _, w := range []MaintenanceWindow{
+		{Schedule: "0 3 * * *", Duration: time.Hour},
+		{Backend: "backend", Schedule: "0 3 * * *"},
+		{Backend: "backend", Schedule: "0 3 * *", Duration: time.Hour},
+	} {
+		if err := parseMaintenance([]MaintenanceWindow{w}); err == nil {
+			t.Errorf("%+v: expected an error", w)
+		}
+	}
+}
+
+func TestInMaintenance(t *testing.T) {
+	windows := testMaintenance(t, MaintenanceWindow{Backend: "backend", Schedule: "0 3 * * *", Duration: 30 * time.Minute})
+	day := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		at   time.Duration
+		want bool
+	}{
+		{2*time.Hour + 59*time.Minute, false},
+		{3 * time.Hour, true},
+		{3*time.Hour + 29*time.Minute, true},
+		{3*time.Hour + 30*time.Minute, false},
+	}
+	for _, tt := range tests {
+		now := day.Add(tt.at)
+		if got := inMaintenance(windows, "backend", now); got != tt.want {
+			t.Errorf("at %s: in maintenance %t, want %t", now.Format(time.TimeOnly), got, tt.want)
+		}
+		if inMaintenance(windows, "other", now) {
+			t.Errorf("at %s: a backend without windows is in maintenance", now.Format(time.TimeOnly))
+		}
+	}
+}
+
+func TestServerInfo_CheckMaintenance(t *testing.T) {
+	windows := testMaintenance(t, MaintenanceWindow{Backend: "backend", Schedule: "0 3 * * *", Duration: time.Hour})
+	open := time.Date(2026, 10, 16, 3, 15, 0, 0, time.UTC)
+	closed := open.Add(time.Hour)
+
+	s := testServerInfo("backend", true, 0)
+	if s.checkMaintenance(windows, closed) {
+		t.Error("backend in maintenance outside its window")
+	}
+	if !s.checkMaintenance(windows, open) || s.IsAlive() {
+		t.Error("backend not taken out of rotation during its window")
+	}
+	s.observeHealth(true, time.Millisecond, 0)
+	if state, _ := s.State(); state != stateMaintenance {
+		t.Errorf("health checks must not end maintenance, got %s", state)
+	}
+	if s.checkMaintenance(windows, closed) {
+		t.Error("backend still in maintenance after its window")
+	}
+	if state, _ := s.State(); state != stateWarming {
+		t.Errorf("expected the backend to warm up after maintenance, got %s", state)
+	}
+
+	s.transition(stateDraining)
+	s.checkMaintenance(windows, open)
+	if state, _ := s.State(); state != stateDraining {
+		t.Errorf("maintenance must not end draining, got %s", state)
+	}
+}
+
+func TestBalancer_Stats(t *testing.T) {
+	b := testBalancer(testServerInfo("backend-a:80", true, 0), testServerInfo("backend-b:80", true, 0))
+	defer b.Close()
+	b.cfg.Maintenance = testMaintenance(t,
+		MaintenanceWindow{Backend: "backend-a:80", Schedule: "0 3 * * 0", Duration: time.Hour},
+		MaintenanceWindow{Backend: "backend-b:80", Schedule: "* * * * *", Duration: 2 * time.Minute},
+	)
+
+	rec := httptest.NewRecorder()
+	b.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, statsPath, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d", rec.Code)
+	}
+	var stats struct {
+		Backends    []json.RawMessage     `json:"backends"`
+		Maintenance []MaintenanceSnapshot `json:"maintenance"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Backends) != 2 {
+		t.Errorf("expected 2 backends, got %d", len(stats.Backends))
+	}
+	if len(stats.Maintenance) != 2 {
+		t.Fatalf("expected 2 windows, got %+v", stats.Maintenance)
+	}
+	// A window opens every minute for backend-b, so it is the soonest.
+	first, second := stats.Maintenance[0], stats.Maintenance[1]
+	if first.Backend != "backend-b:80" || !first.Active || first.End.Sub(first.Start) != 2*time.Minute {
+		t.Errorf("unexpected first window %+v", first)
+	}
+	if second.Backend != "backend-a:80" || second.Active || second.Start.Weekday() != time.Sunday {
+		t.Errorf("unexpected second window %+v", second)
+	}
+}
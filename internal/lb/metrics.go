@@ -0,0 +1,64 @@
+package lb
+
+import "expvar"
+
+// metrics are the counters of a balancer. PublishMetrics serves them through
+// expvar, which costs nothing beyond the standard library.
+type metrics struct {
+	requests       expvar.Int
+	inFlight       expvar.Int
+	noBackend      expvar.Int
+	forwardErrors  expvar.Int
+	retries        expvar.Int
+	slowRequests   expvar.Int
+	echo           expvar.Int
+	shadowRequests expvar.Int
+	shadowErrors   expvar.Int
+	shadowDropped  expvar.Int
+	compressed     expvar.Int
+	// queued counts the requests that waited for a backend; queueRejected
+	// those turned away by a full queue and queueTimeouts those that found
+	// no backend in time.
+	queued        expvar.Int
+	queueRejected expvar.Int
+	queueTimeouts expvar.Int
+	// scaleEvents counts the events of the scaling hooks; scaleHookErrors
+	// the webhook calls and commands that failed.
+	scaleEvents     expvar.Int
+	scaleHookErrors expvar.Int
+}
+
+// PublishMetrics registers the counters and backend stats of b with expvar,
+// so they appear at /debug/vars. Like expvar.Publish, it panics if called for
+// a second balancer in the same process.
+func (b *Balancer) PublishMetrics() {
+	expvar.Publish("lb_requests_total", &b.metrics.requests)
+	expvar.Publish("lb_requests_in_flight", &b.metrics.inFlight)
+	expvar.Publish("lb_no_backend_total", &b.metrics.noBackend)
+	expvar.Publish("lb_forward_errors_total", &b.metrics.forwardErrors)
+	expvar.Publish("lb_retries_total", &b.metrics.retries)
+	expvar.Publish("lb_slow_requests_total", &b.metrics.slowRequests)
+	expvar.Publish("lb_echo_total", &b.metrics.echo)
+	expvar.Publish("lb_shadow_requests_total", &b.metrics.shadowRequests)
+	expvar.Publish("lb_shadow_errors_total", &b.metrics.shadowErrors)
+	expvar.Publish("lb_shadow_dropped_total", &b.metrics.shadowDropped)
+	expvar.Publish("lb_compressed_responses_total", &b.metrics.compressed)
+	expvar.Publish("lb_queued_total", &b.metrics.queued)
+	expvar.Publish("lb_queue_rejected_total", &b.metrics.queueRejected)
+	expvar.Publish("lb_queue_timeouts_total", &b.metrics.queueTimeouts)
+	expvar.Publish("lb_queue_length", expvar.Func(func() any { return b.queue.length.Load() }))
+	expvar.Publish("lb_scale_events_total", &b.metrics.scaleEvents)
+	expvar.Publish("lb_scale_hook_errors_total", &b.metrics.scaleHookErrors)
+	expvar.Publish("lb_backends", expvar.Func(func() any { return b.Backends() }))
+	expvar.Publish("lb_backends_down", expvar.Func(func() any { return b.backendsDown() }))
+}
+
+func (b *Balancer) backendsDown() int {
+	down := 0
+	for _, s := range b.Backends() {
+		if !s.Alive {
+			down++
+		}
+	}
+	return down
+}
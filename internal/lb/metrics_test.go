@@ -0,0 +1,18 @@
+package lb
+
+import "testing"
+
+func TestBackendStats(t *testing.T) {
+	b := testBalancer(
+		testServerInfo("server1:8080", true, 10),
+		testServerInfo("server2:8080", false, 20),
+	)
+
+	got := b.Backends()
+	if len(got) != 2 || got[0].URL != "server1:8080" || got[1].TrafficBytes != 20 {
+		t.Errorf("unexpected backend stats: %+v", got)
+	}
+	if down := b.backendsDown(); down != 1 {
+		t.Errorf("expected 1 backend down, got %d", down)
+	}
+}
@@ -0,0 +1,134 @@
+package lb
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// QueueOptions configure admission control. Strategies skip backends with
+// MaxInFlight requests in flight; requests selected at the same moment may
+// still take a backend briefly past it. A request that finds no alive
+// backend with room waits up to Wait for one, unless MaxLength requests are
+// waiting already.
+type QueueOptions struct {
+	// MaxInFlight is the per-backend limit; 0 leaves backends unlimited.
+	MaxInFlight int
+	// Wait is how long a request may queue; 0 answers 503 right away.
+	Wait time.Duration
+	// MaxLength caps the queue, so a long outage cannot pile up requests
+	// without bound.
+	MaxLength int
+}
+
+// QueueConfig is the queue section of the config file.
+type QueueConfig struct {
+	MaxInFlight *int `json:"max_in_flight"`
+	WaitMs      *int `json:"wait_ms"`
+	MaxLength   *int `json:"max_length"`
+}
+
+func (o *QueueOptions) validate() error {
+	if o.MaxInFlight < 0 || o.Wait < 0 || o.MaxLength < 0 {
+		return fmt.Errorf("queue limits must not be negative")
+	}
+	if o.Wait > 0 && o.MaxLength == 0 {
+		return fmt.Errorf("a queue wait needs a maximum queue length")
+	}
+	return nil
+}
+
+// waitQueue tracks the requests waiting for a backend and wakes them when
+// one may have become available.
+type waitQueue struct {
+	length atomic.Int64
+	mu     sync.Mutex
+	// changed is closed by wake; waiters get a new one for every wait.
+	changed chan struct{}
+}
+
+// enter adds a request to the queue, unless it already holds maxLength.
+func (q *waitQueue) enter(maxLength int) bool {
+	if q.length.Add(1) > int64(maxLength) {
+		q.length.Add(-1)
+		return false
+	}
+	return true
+}
+
+func (q *waitQueue) leave() {
+	q.length.Add(-1)
+}
+
+// next returns a channel closed by the next wake.
+func (q *waitQueue) next() <-chan struct{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.changed == nil {
+		q.changed = make(chan struct{})
+	}
+	return q.changed
+}
+
+// wake lets every waiting request try to select a backend again. Those that
+// find none wait on.
+func (q *waitQueue) wake() {
+	if q.length.Load() == 0 {
+		return
+	}
+	q.mu.Lock()
+	if q.changed != nil {
+		close(q.changed)
+		q.changed = nil
+	}
+	q.mu.Unlock()
+}
+
+// available reports whether strategies may pick s: it must be alive and
+// below Config.Queue.MaxInFlight.
+func (s *ServerInfo) available() bool {
+	if !s.IsAlive() {
+		return false
+	}
+	limit := s.maxInFlight.Load()
+	return limit <= 0 || s.inFlight.Load() < limit
+}
+
+// awaitServer queues r until a backend is available, for up to
+// Config.Queue.Wait. It returns nil if none became available in time, the
+// client went away or the queue is full.
+func (b *Balancer) awaitServer(r *http.Request) *ServerInfo {
+	b.mu.RLock()
+	opts := b.cfg.Queue
+	b.mu.RUnlock()
+	if opts.Wait <= 0 {
+		return nil
+	}
+	if !b.queue.enter(opts.MaxLength) {
+		b.metrics.queueRejected.Add(1)
+		return nil
+	}
+	defer b.queue.leave()
+	b.metrics.queued.Add(1)
+
+	timer := time.NewTimer(opts.Wait)
+	defer timer.Stop()
+	for {
+		// The channel is taken before selecting, so a backend freed in
+		// between still wakes the request.
+		changed := b.queue.next()
+		if server := b.selectServer(r); server != nil {
+			return server
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			b.metrics.queueTimeouts.Add(1)
+			return nil
+		case <-r.Context().Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package lb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQueue_WaitsForCapacity(t *testing.T) {
+	for _, strategy := range []string{"least-traffic", "least-connections"} {
+		t.Run(strategy, func(t *testing.T) { testQueueWaitsForCapacity(t, strategy) })
+	}
+}
+
+func testQueueWaitsForCapacity(t *testing.T, strategy string) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+	}))
+	defer backend.Close()
+	// Close waits for the slow request, so release it even if the test fails.
+	unblock := sync.OnceFunc(func() { close(release) })
+	defer unblock()
+	server := testServerInfo(strings.TrimPrefix(backend.URL, "http://"), true, 0)
+	server.maxInFlight.Store(1)
+	b := testBalancer(server)
+	b.cfg.Strategy = strategy
+	b.cfg.Queue = QueueOptions{MaxInFlight: 1, Wait: 5 * time.Second, MaxLength: 1}
+
+	serve := func(path string) <-chan int {
+		codes := make(chan int, 1)
+		go func() {
+			rec := httptest.NewRecorder()
+			b.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+			codes <- rec.Code
+		}()
+		return codes
+	}
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	slow := serve("/slow")
+	waitFor("the first request to reach the backend", func() bool { return server.InFlight() == 1 })
+	queued := serve("/")
+	waitFor("the second request to queue", func() bool { return b.queue.length.Load() == 1 })
+
+	// The queue holds one request, so a third is turned away at once.
+	rec := httptest.NewRecorder()
+	b.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusServiceUnavailable || b.metrics.queueRejected.Value() != 1 {
+		t.Errorf("a request beyond the queue length got %d, rejected %d", rec.Code, b.metrics.queueRejected.Value())
+	}
+
+	unblock()
+	if code := <-slow; code != http.StatusOK {
+		t.Errorf("first request: status %d", code)
+	}
+	if code := <-queued; code != http.StatusOK {
+		t.Errorf("queued request: status %d", code)
+	}
+	if b.queue.length.Load() != 0 || b.metrics.queued.Value() != 1 {
+		t.Errorf("queue length %d, queued %d", b.queue.length.Load(), b.metrics.queued.Value())
+	}
+}
+
+func TestQueue_Timeout(t *testing.T) {
+	b := testBalancer(testServerInfo("dead", false, 0))
+	b.cfg.Queue = QueueOptions{Wait: 20 * time.Millisecond, MaxLength: 10}
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	b.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %d", rec.Code)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("answered after %s, before the queue wait", elapsed)
+	}
+	if b.metrics.queueTimeouts.Value() != 1 {
+		t.Errorf("expected one queue timeout, got %d", b.metrics.queueTimeouts.Value())
+	}
+}
+
+func TestQueueOptions_Validate(t *testing.T) {
+	for _, o := range []QueueOptions{
+		{MaxInFlight: -1},
+		{Wait: time.Second},
+		{Wait: -time.Second, MaxLength: 1},
+	} {
+		if err := o.validate(); err == nil {
+			t.Errorf("%+v must be rejected", o)
+		}
+	}
+	if o := DefaultConfig().Queue; o.validate() != nil {
+		t.Errorf("the default %+v must be valid", o)
+	}
+}
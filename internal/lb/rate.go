@@ -0,0 +1,65 @@
+package lb
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// decayingRate is an exponentially decayed rate in units per second: a
+// steady stream converges to its actual rate, and past bursts fade out with
+// the time constant window instead of counting forever. The rate is updated
+// atomically, so readers never block writers.
+type decayingRate struct {
+	window time.Duration
+	sample atomic.Pointer[rateSample]
+	now    func() time.Time
+}
+
+// rateSample is the rate as of the last update.
+type rateSample struct {
+	rate float64
+	last time.Time
+}
+
+// at returns the sample decayed until now.
+func (s rateSample) at(now time.Time, window time.Duration) float64 {
+	if s.last.IsZero() || window <= 0 {
+		return s.rate
+	}
+	return s.rate * math.Exp(-float64(now.Sub(s.last))/float64(window))
+}
+
+func newDecayingRate(window time.Duration) *decayingRate {
+	return &decayingRate{window: window, now: time.Now}
+}
+
+// load returns the current sample; a rate never added to is zero.
+func (d *decayingRate) load() rateSample {
+	if s := d.sample.Load(); s != nil {
+		return *s
+	}
+	return rateSample{}
+}
+
+func (d *decayingRate) Add(n float64) {
+	if d.window > 0 {
+		n /= d.window.Seconds()
+	}
+	for {
+		old := d.sample.Load()
+		var prev rateSample
+		if old != nil {
+			prev = *old
+		}
+		now := d.now()
+		next := &rateSample{rate: prev.at(now, d.window) + n, last: now}
+		if d.sample.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+func (d *decayingRate) Rate() float64 {
+	return d.load().at(d.now(), d.window)
+}
@@ -0,0 +1,99 @@
+package lb
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDecayingRate(t *testing.T) {
+	now := time.Unix(0, 0)
+	r := newDecayingRate(10 * time.Second)
+	r.now = func() time.Time { return now }
+
+	// A steady 100 bytes/s converges to a rate of 100.
+	for i := 0; i < 300; i++ {
+		r.Add(100)
+		now = now.Add(time.Second)
+	}
+	if got := r.Rate(); math.Abs(got-100) > 10 {
+		t.Errorf("steady rate = %.1f, want about 100", got)
+	}
+
+	// Without traffic the rate fades out.
+	now = now.Add(time.Minute)
+	if got := r.Rate(); got > 1 {
+		t.Errorf("rate after a quiet minute = %.2f, want close to 0", got)
+	}
+}
+
+func TestSelectServerLeastTraffic_ForgetsOldBursts(t *testing.T) {
+	now := time.Unix(0, 0)
+	clock := func() time.Time { return now }
+	burst, steady := testServerInfo("burst", true, 0), testServerInfo("steady", true, 0)
+	burst.traffic.now, steady.traffic.now = clock, clock
+	b := testBalancer(burst, steady)
+
+	burst.AddTraffic(1 << 20)
+	now = now.Add(time.Hour)
+	steady.AddTraffic(1 << 10)
+
+	if got := b.selectServerLeastTraffic(pool{}); got != burst {
+		t.Errorf("expected the backend with an hour-old burst to be selected, got %s", got.GetURL())
+	}
+	if burst.GetTraffic() <= steady.GetTraffic() {
+		t.Error("total traffic must still be accounted")
+	}
+}
+
+func TestSelectServerLeastTraffic_Concurrent(t *testing.T) {
+	const workers, selections, chunk = 8, 500, 100
+	cfg := DefaultConfig()
+	cfg.TrafficWindow = 0 // the rate is the total, so the outcome is exact
+	var servers []*ServerInfo
+	for i, initial := range []int64{0, 1000, 3000} {
+		s := newServerInfo(fmt.Sprintf("s%d", i), true, &cfg)
+		s.AddTraffic(initial)
+		servers = append(servers, s)
+	}
+	b := testBalancer(servers...)
+
+	counts := make([]atomic.Int64, len(servers))
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range selections {
+				s := b.selectServerLeastTraffic(pool{})
+				counts[slices.Index(servers, s)].Add(1)
+				s.AddTraffic(chunk)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var total float64
+	minRate, maxRate := math.Inf(1), math.Inf(-1)
+	for _, s := range servers {
+		rate := s.TrafficRate()
+		total += rate
+		minRate, maxRate = min(minRate, rate), max(maxRate, rate)
+	}
+	if want := float64(4000 + workers*selections*chunk); total != want {
+		t.Errorf("lost traffic updates: total %.0f, want %.0f", total, want)
+	}
+	// A selection can only be outrun by the other workers' updates.
+	if maxRate-minRate > workers*chunk {
+		t.Errorf("traffic spread %.0f exceeds %d", maxRate-minRate, workers*chunk)
+	}
+	for i := 1; i < len(servers); i++ {
+		if counts[i].Load() > counts[i-1].Load() {
+			t.Errorf("a backend with more initial traffic got more requests: %d > %d", counts[i].Load(), counts[i-1].Load())
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package lb
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// diff describes every setting that differs between c and next.
+func (c *Config) diff(next *Config) []string {
+	var changes []string
+	change := func(name string, from, to any) {
+		changes = append(changes, fmt.Sprintf("%s: %v -> %v", name, from, to))
+	}
+	if c.Timeout != next.Timeout {
+		change("timeout-sec", c.Timeout.Seconds(), next.Timeout.Seconds())
+	}
+	if c.Strategy != next.Strategy {
+		change("strategy", c.Strategy, next.Strategy)
+	}
+	if c.HashKey != next.HashKey {
+		change("hash-key", c.HashKey, next.HashKey)
+	}
+	if c.StripResponseHeaders != next.StripResponseHeaders {
+		change("strip-response-headers", c.StripResponseHeaders, next.StripResponseHeaders)
+	}
+	if c.AllowResponseHeaders != next.AllowResponseHeaders {
+		change("allow-response-headers", c.AllowResponseHeaders, next.AllowResponseHeaders)
+	}
+	if c.PreserveHost != next.PreserveHost {
+		change("preserve-host", c.PreserveHost, next.PreserveHost)
+	}
+	if fmt.Sprint(c.Routes) != fmt.Sprint(next.Routes) {
+		changes = append(changes, fmt.Sprintf("routes: %d -> %d entries", len(c.Routes), len(next.Routes)))
+	}
+	if c.Echo != next.Echo {
+		change("echo", fmt.Sprintf("%+v", c.Echo), fmt.Sprintf("%+v", next.Echo))
+	}
+	if c.Compress != next.Compress {
+		change("compress", fmt.Sprintf("%+v", c.Compress), fmt.Sprintf("%+v", next.Compress))
+	}
+	if c.Queue != next.Queue {
+		change("queue", fmt.Sprintf("%+v", c.Queue), fmt.Sprintf("%+v", next.Queue))
+	}
+	if c.CanaryWeight != next.CanaryWeight {
+		change("canary-weight", c.CanaryWeight, next.CanaryWeight)
+	}
+	if fmt.Sprint(c.Versions) != fmt.Sprint(next.Versions) {
+		changes = append(changes, fmt.Sprintf("versions: %d -> %d tagged backends", len(c.Versions), len(next.Versions)))
+	}
+	if c.MaxRetries != next.MaxRetries {
+		change("max-retries", c.MaxRetries, next.MaxRetries)
+	}
+	if c.RetryBudget != next.RetryBudget {
+		change("retry-budget", c.RetryBudget, next.RetryBudget)
+	}
+	if c.SlowStart != next.SlowStart {
+		change("slow-start", c.SlowStart, next.SlowStart)
+	}
+	if c.MaxTimeout != next.MaxTimeout {
+		change("max-timeout", c.MaxTimeout, next.MaxTimeout)
+	}
+	if c.SlowThreshold != next.SlowThreshold {
+		change("slow-threshold", c.SlowThreshold, next.SlowThreshold)
+	}
+	if c.Scale != next.Scale {
+		change("scale", fmt.Sprintf("%+v", c.Scale), fmt.Sprintf("%+v", next.Scale))
+	}
+	if maintenanceSpecs(c.Maintenance) != maintenanceSpecs(next.Maintenance) {
+		changes = append(changes, fmt.Sprintf("maintenance: %d -> %d windows", len(c.Maintenance), len(next.Maintenance)))
+	}
+	before, after := poolMembers(c.Backends, c.Groups), poolMembers(next.Backends, next.Groups)
+	for _, b := range after {
+		if !slices.Contains(before, b) {
+			changes = append(changes, "backend added: "+b)
+		}
+	}
+	for _, b := range before {
+		if !slices.Contains(after, b) {
+			changes = append(changes, "backend removed: "+b)
+		}
+	}
+	return changes
+}
+
+func validateStrategy(strategy, hashKey string) error {
+	switch strategy {
+	case "least-traffic", "least-connections", "round-robin", "latency-aware", "least-latency":
+	case "hash":
+		if !validHashKey(hashKey) {
+			return fmt.Errorf("invalid hash key %q", hashKey)
+		}
+	default:
+		return fmt.Errorf("unknown strategy %q", strategy)
+	}
+	return nil
+}
+
+// reload re-reads Config.ConfigFile and applies it to the running balancer.
+// Pinned settings still win over the file. The port, HTTPS and Trace only
+// take effect on restart. Requests already in flight keep their backend. On
+// error nothing is changed.
+func (b *Balancer) reload() ([]string, error) {
+	b.reloadMu.Lock()
+	defer b.reloadMu.Unlock()
+
+	b.mu.RLock()
+	old := b.cfg
+	b.mu.RUnlock()
+	if old.ConfigFile == "" {
+		return nil, fmt.Errorf("no config file to reload")
+	}
+	fileCfg, err := loadConfig(old.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	if old.Discovery != "" {
+		// Discovery owns the default pool.
+		fileCfg.Backends = nil
+	} else if len(fileCfg.Backends) == 0 && len(fileCfg.Groups) == 0 {
+		return nil, fmt.Errorf("config %s: no backends", old.ConfigFile)
+	}
+
+	var restartOnly []string
+	if fileCfg.Port != nil && *fileCfg.Port != old.Port {
+		restartOnly = append(restartOnly, "port")
+	}
+	if fileCfg.HTTPS != nil && *fileCfg.HTTPS != old.HTTPS {
+		restartOnly = append(restartOnly, "https")
+	}
+	if fileCfg.Trace != nil && *fileCfg.Trace != old.Trace {
+		restartOnly = append(restartOnly, "trace")
+	}
+	fileCfg.Port, fileCfg.HTTPS, fileCfg.Trace = nil, nil, nil
+
+	next := old
+	fileCfg.apply(&next)
+	if err := next.validate(); err != nil {
+		return nil, fmt.Errorf("config %s: %w", old.ConfigFile, err)
+	}
+	b.mu.Lock()
+	b.cfg = next
+	b.headerFilter = newHeaderFilter(next.StripResponseHeaders, next.AllowResponseHeaders)
+	b.mu.Unlock()
+
+	b.setServerPool(next)
+	if len(restartOnly) > 0 {
+		log.Printf("Config reload: %s only change on restart", strings.Join(restartOnly, ", "))
+	}
+	return old.diff(&next), nil
+}
+
+// setServerPool replaces the backend pool with the default pool and the
+// backend groups of cfg. Backends that stay in the pool keep their state and
+// counters; new ones start health checks, and removed ones are drained: they
+// get no new requests and stay in the pool until the requests in flight
+// finish or Config.DrainTimeout cuts them.
+func (b *Balancer) setServerPool(cfg Config) {
+	b.serversMu.Lock()
+	defer b.serversMu.Unlock()
+
+	type member struct{ group, url string }
+	existing := make(map[member]*ServerInfo, len(b.servers))
+	var draining []*ServerInfo
+	for _, s := range b.servers {
+		if state, _ := s.State(); state == stateDraining {
+			draining = append(draining, s)
+			continue
+		}
+		existing[member{s.Group, s.GetURL()}] = s
+	}
+	var next []*ServerInfo
+	add := func(group string, urls []string) {
+		for _, url := range urls {
+			if s, ok := existing[member{group, url}]; ok {
+				s.Version = cfg.Versions[url]
+				s.maxInFlight.Store(int64(cfg.Queue.MaxInFlight))
+				next = append(next, s)
+				delete(existing, member{group, url})
+				continue
+			}
+			s := newServerInfo(url, true, &cfg)
+			s.Group, s.Version = group, cfg.Versions[url]
+			b.startHealthChecks(s)
+			next = append(next, s)
+		}
+	}
+	add("", cfg.Backends)
+	names := make([]string, 0, len(cfg.Groups))
+	for name := range cfg.Groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		add(name, cfg.Groups[name])
+	}
+	next = append(next, draining...)
+	for _, s := range existing {
+		if b.drainLocked(s, cfg.DrainTimeout) {
+			next = append(next, s)
+		}
+	}
+	b.servers = next
+	b.queue.wake()
+}
+
+func (b *Balancer) handleReload(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		rw.Header().Set("Allow", http.MethodPost)
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	changes, err := b.Reload()
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rw.Header().Set("content-type", "text/plain")
+	for _, c := range changes {
+		fmt.Fprintln(rw, c)
+	}
+}
+
+// Reload re-reads the config file, logs the outcome and returns the changes
+// it made.
+func (b *Balancer) Reload() ([]string, error) {
+	changes, err := b.reload()
+	if err != nil {
+		log.Printf("Config reload failed: %v", err)
+		return nil, err
+	}
+	configFile := b.Config().ConfigFile
+	if len(changes) == 0 {
+		log.Printf("Config reloaded from %s: no changes", configFile)
+	}
+	for _, c := range changes {
+		log.Printf("Config reloaded from %s: %s", configFile, c)
+	}
+	return changes, nil
+}
@@ -0,0 +1,111 @@
+package lb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReloadConfig(t *testing.T) {
+	kept := testServerInfo("backend-a:80", true, 42)
+	removed := testServerInfo("backend-b:80", true, 0)
+	b := testBalancer(kept, removed)
+	defer b.Close()
+	b.cfg.Backends = []string{"backend-a:80", "backend-b:80"}
+	b.cfg.ConfigFile = filepath.Join(t.TempDir(), "lb.json")
+
+	write := func(content string) {
+		if err := os.WriteFile(b.cfg.ConfigFile, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`{"strategy": "least-connections", "timeout_sec": 7, "port": 1,
+		"echo": {"enabled": true, "payload_bytes": 64, "latency_ms": 5},
+		"backends": ["backend-a:80", "backend-c:80"]}`)
+	changes, err := b.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := strings.Join(changes, "\n")
+	for _, want := range []string{"strategy: least-traffic -> least-connections", "timeout-sec: 3 -> 7", "backend added: backend-c:80", "backend removed: backend-b:80", "echo: "} {
+		if !strings.Contains(got, want) {
+			t.Errorf("changes %q do not mention %q", got, want)
+		}
+	}
+	cfg := b.Config()
+	if cfg.Strategy != "least-connections" || cfg.Timeout != 7*time.Second {
+		t.Errorf("settings not applied: strategy %s, timeout %v", cfg.Strategy, cfg.Timeout)
+	}
+	if echo := cfg.Echo; !echo.Enabled || echo.PayloadBytes != 64 || echo.Latency != 5*time.Millisecond {
+		t.Errorf("echo settings not applied: %+v", echo)
+	}
+	if cfg.Port == 1 {
+		t.Error("port must only change on restart")
+	}
+	if len(b.servers) != 2 || b.servers[0] != kept || b.servers[1].GetURL() != "backend-c:80" {
+		t.Fatalf("unexpected pool after reload: %v", b.Backends())
+	}
+	if kept.GetTraffic() != 42 {
+		t.Error("kept backend lost its counters")
+	}
+	select {
+	case <-removed.done:
+	default:
+		t.Error("removed backend is still health checked")
+	}
+
+	write(`{"strategy": "random", "backends": ["backend-d:80"]}`)
+	if _, err := b.Reload(); err == nil {
+		t.Error("expected an invalid strategy to be rejected")
+	}
+	if b.Config().Strategy != "least-connections" || len(b.Backends()) != 2 {
+		t.Error("a failed reload must not change the running config")
+	}
+}
+
+func TestHandleReload_RequiresPost(t *testing.T) {
+	rw := httptest.NewRecorder()
+	testBalancer().handleReload(rw, httptest.NewRequest(http.MethodGet, "/admin/reload", nil))
+	if rw.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405, got %d", rw.Code)
+	}
+}
+
+func TestReloadConfig_Maintenance(t *testing.T) {
+	b := testBalancer(testServerInfo("backend-a:80", true, 0))
+	defer b.Close()
+	b.cfg.Backends = []string{"backend-a:80"}
+	b.cfg.ConfigFile = filepath.Join(t.TempDir(), "lb.json")
+
+	write := func(content string) {
+		if err := os.WriteFile(b.cfg.ConfigFile, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`{"backends": ["backend-a:80"], "maintenance": [{"backend": "backend-a:80", "schedule": "0 3 * * 0", "duration_min": 45}]}`)
+	changes, err := b.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(changes, "\n"); !strings.Contains(got, "maintenance: 0 -> 1 windows") {
+		t.Errorf("changes %q do not mention the maintenance window", got)
+	}
+	if windows := b.Config().Maintenance; len(windows) != 1 || windows[0].Duration != 45*time.Minute || windows[0].schedule == nil {
+		t.Errorf("maintenance window not applied: %+v", windows)
+	}
+
+	write(`{"backends": ["backend-a:80"], "maintenance": [{"backend": "backend-a:80", "schedule": "0 3 * *", "duration_min": 45}]}`)
+	if _, err := b.Reload(); err == nil {
+		t.Error("expected an invalid schedule to be rejected")
+	}
+	if len(b.Config().Maintenance) != 1 {
+		t.Error("a rejected reload changed the maintenance windows")
+	}
+}
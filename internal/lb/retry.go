@@ -0,0 +1,80 @@
+package lb
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// In trace mode, with retries enabled, responses tell how many backends the
+// request was sent to and which of them failed before the last attempt.
+const (
+	attemptsHeader      = "lb-attempts"
+	retryBackendsHeader = "lb-retry-backends"
+)
+
+// errBackendUnreachable marks forward errors where the backend gave no
+// response at all, so nothing has been written to the client yet.
+var errBackendUnreachable = errors.New("backend unreachable")
+
+// retryable reports whether r can safely be sent to another backend: it must
+// be idempotent and have no body, as forward consumes it.
+func retryable(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodDelete:
+	default:
+		return false
+	}
+	return r.ContentLength == 0 && (r.Body == nil || r.Body == http.NoBody)
+}
+
+// forwardWithRetries forwards r to server and, while backends give no
+// response, to the backends the strategy picks next, up to Config.MaxRetries
+// times and within Config.RetryBudget. Failed backends are marked dead by
+// forward, so they are not picked again. It returns the backend of the last
+// attempt.
+func (b *Balancer) forwardWithRetries(server *ServerInfo, rw http.ResponseWriter, r *http.Request) (*ServerInfo, error) {
+	b.mu.RLock()
+	maxRetries, budget, trace := b.cfg.MaxRetries, b.cfg.RetryBudget, b.cfg.Trace
+	b.mu.RUnlock()
+
+	retries := maxRetries
+	if !retryable(r) {
+		retries = 0
+	}
+	if retries > 0 && budget > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), budget)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+
+	var failed []string
+	for {
+		if trace && maxRetries > 0 {
+			rw.Header().Set(attemptsHeader, strconv.Itoa(len(failed)+1))
+			if len(failed) > 0 {
+				rw.Header().Set(retryBackendsHeader, strings.Join(failed, ","))
+			}
+		}
+		err := b.tryForward(server, rw, r)
+		if !errors.Is(err, errBackendUnreachable) {
+			return server, err
+		}
+		failed = append(failed, server.GetURL())
+		var next *ServerInfo
+		if len(failed) <= retries && r.Context().Err() == nil {
+			next = b.selectServer(r)
+		}
+		if next == nil || slices.Contains(failed, next.GetURL()) {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return server, err
+		}
+		b.metrics.retries.Add(1)
+		log.Printf("Retrying on %s after %s gave no response: %v", next.GetURL(), server.GetURL(), err)
+		server = next
+	}
+}
@@ -0,0 +1,80 @@
+package lb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBalancer_Retries(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		_, _ = rw.Write([]byte("ok"))
+	}))
+	t.Cleanup(backend.Close)
+	stopped := httptest.NewServer(http.NotFoundHandler())
+	stopped.Close()
+	liveURL := strings.TrimPrefix(backend.URL, "http://")
+	deadURL := strings.TrimPrefix(stopped.URL, "http://")
+
+	// The stopped backend has less traffic, so least-traffic picks it first.
+	setup := func(maxRetries int) *Balancer {
+		b := testBalancer(testServerInfo(deadURL, true, 0), testServerInfo(liveURL, true, 100))
+		b.cfg.Trace = true
+		b.cfg.MaxRetries = maxRetries
+		return b
+	}
+
+	b := setup(1)
+	rr := httptest.NewRecorder()
+	b.ServeHTTP(rr, httptest.NewRequest("GET", "/api", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "ok" {
+		t.Fatalf("expected the retry to succeed, got %d %q", rr.Code, rr.Body)
+	}
+	if got := rr.Header().Get(attemptsHeader); got != "2" {
+		t.Errorf("expected 2 attempts, got %q", got)
+	}
+	if got := rr.Header().Get(retryBackendsHeader); got != deadURL {
+		t.Errorf("expected %s in %s, got %q", deadURL, retryBackendsHeader, got)
+	}
+	if got := rr.Header().Get("lb-from"); got != liveURL {
+		t.Errorf("expected the response from %s, got %q", liveURL, got)
+	}
+	if b.metrics.retries.Value() != 1 {
+		t.Errorf("expected 1 retry, got %d", b.metrics.retries.Value())
+	}
+
+	b = setup(1)
+	rr = httptest.NewRecorder()
+	b.ServeHTTP(rr, httptest.NewRequest("POST", "/api", strings.NewReader("body")))
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get(attemptsHeader) != "1" {
+		t.Errorf("expected a request with a body to fail without retries, got %d after %q attempts",
+			rr.Code, rr.Header().Get(attemptsHeader))
+	}
+
+	b = setup(0)
+	rr = httptest.NewRecorder()
+	b.ServeHTTP(rr, httptest.NewRequest("GET", "/api", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 with retries disabled, got %d", rr.Code)
+	}
+	if rr.Header().Get(attemptsHeader) != "" {
+		t.Errorf("unexpected %s header with retries disabled", attemptsHeader)
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	for _, tc := range []struct {
+		req  *http.Request
+		want bool
+	}{
+		{httptest.NewRequest("GET", "/", nil), true},
+		{httptest.NewRequest("DELETE", "/", nil), true},
+		{httptest.NewRequest("POST", "/", nil), false},
+		{httptest.NewRequest("GET", "/", strings.NewReader("x")), false},
+	} {
+		if got := retryable(tc.req); got != tc.want {
+			t.Errorf("retryable(%s with body %t) = %t", tc.req.Method, tc.req.ContentLength > 0, got)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package lb
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Route overrides proxy options for requests whose path starts with
+// PathPrefix. Unset options fall back to the Config, and requests of a route
+// without a Group go to the default pool. Cost multiplies the response bytes
+// counted by the least-traffic strategy, so small but expensive responses
+// weigh more; zero means 1. TimeoutMs, if set, replaces Config.Timeout for
+// the route's requests.
+//
+// Methods, Headers and Query narrow a route further: the request method
+// must be one of Methods, and every listed header and query parameter must
+// have the given value, or any value for "*". Canary, if set, sends the
+// route's requests to the canary (or stable) backends of its group instead
+// of splitting them by Config.CanaryWeight.
+type Route struct {
+	PathPrefix   string            `json:"path_prefix"`
+	Methods      []string          `json:"methods"`
+	Headers      map[string]string `json:"headers"`
+	Query        map[string]string `json:"query"`
+	PreserveHost *bool             `json:"preserve_host"`
+	Group        string            `json:"group"`
+	Canary       *bool             `json:"canary"`
+	Cost         float64           `json:"cost"`
+	TimeoutMs    int               `json:"timeout_ms"`
+}
+
+// matches reports whether r satisfies the prefix and the matchers of the
+// route.
+func (route *Route) matches(r *http.Request) bool {
+	if !strings.HasPrefix(r.URL.Path, route.PathPrefix) {
+		return false
+	}
+	if len(route.Methods) > 0 && !slices.ContainsFunc(route.Methods, func(m string) bool {
+		return strings.EqualFold(m, r.Method)
+	}) {
+		return false
+	}
+	for name, want := range route.Headers {
+		if !matchValue(r.Header.Values(name), want) {
+			return false
+		}
+	}
+	if len(route.Query) > 0 {
+		query := r.URL.Query()
+		for name, want := range route.Query {
+			if !matchValue(query[name], want) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// matchValue reports whether one of values is want, or, for "*", whether
+// there is any value.
+func matchValue(values []string, want string) bool {
+	if want == "*" {
+		return len(values) > 0
+	}
+	return slices.Contains(values, want)
+}
+
+// specificity ranks the routes that match a request: the longest prefix
+// wins, then the route with more matchers.
+func (route *Route) specificity() (int, int) {
+	return len(route.PathPrefix), len(route.Headers) + len(route.Query) + min(len(route.Methods), 1)
+}
+
+// matchRoute returns the most specific route matching r, if any; of equally
+// specific ones, the first.
+func matchRoute(routes []Route, r *http.Request) *Route {
+	var best *Route
+	for i := range routes {
+		route := &routes[i]
+		if !route.matches(r) {
+			continue
+		}
+		if best == nil {
+			best = route
+			continue
+		}
+		prefix, matchers := route.specificity()
+		bestPrefix, bestMatchers := best.specificity()
+		if prefix > bestPrefix || (prefix == bestPrefix && matchers > bestMatchers) {
+			best = route
+		}
+	}
+	return best
+}
+
+func (b *Balancer) shouldPreserveHost(r *http.Request) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if route := matchRoute(b.cfg.Routes, r); route != nil && route.PreserveHost != nil {
+		return *route.PreserveHost
+	}
+	return b.cfg.PreserveHost
+}
+
+// routeCost returns the byte cost multiplier of the route matching r.
+func (b *Balancer) routeCost(r *http.Request) float64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if route := matchRoute(b.cfg.Routes, r); route != nil && route.Cost > 0 {
+		return route.Cost
+	}
+	return 1
+}
@@ -0,0 +1,149 @@
+package lb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestShouldPreserveHost(t *testing.T) {
+	b := testBalancer()
+	yes, no := true, false
+	b.cfg.Routes = []Route{
+		{PathPrefix: "/vhost/", PreserveHost: &yes},
+		{PathPrefix: "/vhost/internal/", PreserveHost: &no},
+		{PathPrefix: "/plain/"},
+	}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/vhost/page", true},
+		{"/vhost/internal/page", false},
+		{"/plain/page", false},
+		{"/other", false},
+	}
+	for _, tt := range tests {
+		if got := b.shouldPreserveHost(httptest.NewRequest("GET", tt.path, nil)); got != tt.want {
+			t.Errorf("shouldPreserveHost(%q) = %t, want %t", tt.path, got, tt.want)
+		}
+	}
+
+	b.cfg.PreserveHost = true
+	if !b.shouldPreserveHost(httptest.NewRequest("GET", "/plain/page", nil)) {
+		t.Error("route without the option must fall back to the flag")
+	}
+}
+
+func TestForward_PreserveHost(t *testing.T) {
+	b := testBalancer()
+	var gotHost string
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		gotHost = r.Host
+	}))
+	defer backend.Close()
+	dst := strings.TrimPrefix(backend.URL, "http://")
+	sInfo := testServerInfo(dst, true, 0)
+
+	for _, preserve := range []bool{false, true} {
+		b.cfg.PreserveHost = preserve
+		req := httptest.NewRequest("GET", "http://public.example.com/", nil)
+		if err := b.forward(sInfo, httptest.NewRecorder(), req); err != nil {
+			t.Fatal(err)
+		}
+		want := dst
+		if preserve {
+			want = "public.example.com"
+		}
+		if gotHost != want {
+			t.Errorf("preserve=%t: backend saw Host %q, want %q", preserve, gotHost, want)
+		}
+	}
+}
+
+func TestRouteCost_WeightsLeastTraffic(t *testing.T) {
+	reports, data := testServerInfo("reports", true, 0), testServerInfo("data", true, 0)
+	b := testBalancer(reports, data)
+	b.cfg.Routes = []Route{{PathPrefix: "/report", Cost: 10}, {PathPrefix: "/free", Cost: 0}}
+
+	if got := b.routeCost(httptest.NewRequest("GET", "/report", nil)); got != 10 {
+		t.Errorf("b.routeCost(/report) = %v, want 10", got)
+	}
+	if got := b.routeCost(httptest.NewRequest("GET", "/free", nil)); got != 1 {
+		t.Errorf("a zero cost must count as 1, got %v", got)
+	}
+
+	reports.AddWeightedTraffic(100, b.routeCost(httptest.NewRequest("GET", "/report", nil)))
+	data.AddWeightedTraffic(500, b.routeCost(httptest.NewRequest("GET", "/api/v1/some-data", nil)))
+
+	if reports.GetTraffic() != 100 {
+		t.Errorf("total traffic must count actual bytes, got %d", reports.GetTraffic())
+	}
+	if got := b.selectServerLeastTraffic(pool{}); got != data {
+		t.Errorf("expected the backend serving costly reports to be avoided, got %s", got.GetURL())
+	}
+}
+
+func TestMatchRoute_Matchers(t *testing.T) {
+	routes := []Route{
+		{PathPrefix: "/api/", Group: "read"},
+		{PathPrefix: "/api/", Methods: []string{"POST", "put"}, Group: "write"},
+		{PathPrefix: "/api/", Headers: map[string]string{"X-Beta": "1"}, Group: "beta"},
+		{PathPrefix: "/api/", Query: map[string]string{"debug": "*"}, Group: "debug"},
+		{PathPrefix: "/api/reports/", Group: "reports"},
+	}
+	request := func(method, target string, header ...string) *http.Request {
+		r := httptest.NewRequest(method, target, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		return r
+	}
+	tests := []struct {
+		r    *http.Request
+		want string
+	}{
+		{request("GET", "/api/data"), "read"},
+		{request("POST", "/api/data"), "write"},
+		{request("PUT", "/api/data"), "write"},
+		{request("GET", "/api/data", "X-Beta", "1"), "beta"},
+		{request("GET", "/api/data", "X-Beta", "0"), "read"},
+		{request("GET", "/api/data?debug"), "debug"},
+		{request("POST", "/api/reports/daily"), "reports"},
+	}
+	for _, tt := range tests {
+		route := matchRoute(routes, tt.r)
+		if route == nil || route.Group != tt.want {
+			t.Errorf("%s %s: got %+v, want group %q", tt.r.Method, tt.r.URL, route, tt.want)
+		}
+	}
+	if route := matchRoute(routes, request("GET", "/other")); route != nil {
+		t.Errorf("unexpected route %+v", route)
+	}
+}
+
+func TestSelectServer_CanaryRoute(t *testing.T) {
+	stable := testServerInfo("stable:8080", true, 0)
+	canary := testServerInfo("canary:8080", true, 0)
+	b := testBalancer(stable, canary)
+	canary.Version = b.canaryVersion
+	yes := true
+	b.cfg.Routes = []Route{{Headers: map[string]string{"X-Beta": "1"}, Canary: &yes}}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Beta", "1")
+	for i := 0; i < 10; i++ {
+		if got := b.selectServer(r); got != canary {
+			t.Fatalf("beta request went to %v", got)
+		}
+	}
+	if got := b.selectServer(httptest.NewRequest("GET", "/", nil)); got != stable {
+		t.Errorf("request without the header went to %v", got)
+	}
+
+	canary.SetAlive(false)
+	if got := b.selectServer(r); got != stable {
+		t.Errorf("expected stable backends to take over from a dead canary, got %v", got)
+	}
+}
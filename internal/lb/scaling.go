@@ -0,0 +1,222 @@
+package lb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// scaleCheckInterval is how often the load is compared with the scaling
+// thresholds.
+const scaleCheckInterval = time.Second
+
+// ScaleOptions configure the scaling hooks: when the load stays above (or
+// below) a threshold for Sustain, an event is POSTed as JSON to Webhook
+// and/or piped to Command, run with sh -c, so external tooling can resize
+// the server tier. Cooldown is the minimum time between two events.
+type ScaleOptions struct {
+	Webhook string
+	Command string
+	// Capacity is how many requests in flight a backend handles at full
+	// load. The tier is overloaded when no serving backend is below
+	// UpPercent of it, and idle when every one is below DownPercent; zero
+	// disables either check.
+	Capacity    int
+	UpPercent   float64
+	DownPercent float64
+	// QueueDepth, if set, is the number of requests in flight at the
+	// balancer that counts as overloaded regardless of the backends.
+	QueueDepth int
+	Sustain    time.Duration
+	Cooldown   time.Duration
+}
+
+func (o ScaleOptions) enabled() bool {
+	return o.Webhook != "" || o.Command != ""
+}
+
+func (o ScaleOptions) validate() error {
+	if o.Capacity < 0 || o.QueueDepth < 0 || o.Sustain < 0 || o.Cooldown < 0 {
+		return fmt.Errorf("scaling thresholds must not be negative")
+	}
+	if o.UpPercent < 0 || o.UpPercent > 100 || o.DownPercent < 0 || o.DownPercent > 100 {
+		return fmt.Errorf("scaling percentages must be in [0, 100]")
+	}
+	if o.DownPercent > 0 && o.UpPercent > 0 && o.DownPercent >= o.UpPercent {
+		return fmt.Errorf("scale-down percentage must be below the scale-up one")
+	}
+	return nil
+}
+
+// ScaleEvent is the payload of a scaling hook.
+type ScaleEvent struct {
+	Event    string    `json:"event"` // "scale_up" or "scale_down"
+	Reason   string    `json:"reason"`
+	Time     time.Time `json:"time"`
+	Backends int       `json:"backends"`
+	Alive    int       `json:"alive"`
+	InFlight int64     `json:"in_flight"`
+	// Queue is the number of requests in flight at the balancer.
+	Queue int64 `json:"queue_depth"`
+	// MinLoad and MaxLoad are the least and most loaded serving backends,
+	// in percent of ScaleOptions.Capacity.
+	MinLoad float64 `json:"min_load_percent"`
+	MaxLoad float64 `json:"max_load_percent"`
+}
+
+// scaler tracks how long the load has been past a threshold and when the
+// last event was emitted. It is only used by the scaling goroutine.
+type scaler struct {
+	event     string
+	since     time.Time
+	lastEvent time.Time
+}
+
+// observe records the load at now and returns the event to emit, if the
+// load has been past a threshold for opts.Sustain and the cooldown is over.
+func (s *scaler) observe(opts ScaleOptions, load ScaleEvent, now time.Time) (ScaleEvent, bool) {
+	event, reason := scaleDecision(opts, load)
+	if event != s.event {
+		s.event, s.since = event, now
+	}
+	if event == "" || now.Sub(s.since) < opts.Sustain {
+		return ScaleEvent{}, false
+	}
+	if !s.lastEvent.IsZero() && now.Sub(s.lastEvent) < opts.Cooldown {
+		return ScaleEvent{}, false
+	}
+	s.lastEvent = now
+	load.Event, load.Reason, load.Time = event, reason, now
+	return load, true
+}
+
+// scaleDecision compares the load with the thresholds of opts.
+func scaleDecision(opts ScaleOptions, load ScaleEvent) (event, reason string) {
+	switch {
+	case opts.QueueDepth > 0 && load.Queue >= int64(opts.QueueDepth):
+		return "scale_up", fmt.Sprintf("%d requests in flight at the balancer", load.Queue)
+	case opts.Capacity <= 0:
+		return "", ""
+	case opts.UpPercent > 0 && load.Alive == 0 && load.Backends > 0:
+		return "scale_up", "no backend alive"
+	case opts.UpPercent > 0 && load.Alive > 0 && load.MinLoad >= opts.UpPercent:
+		return "scale_up", fmt.Sprintf("no backend below %g%% of capacity", opts.UpPercent)
+	case opts.DownPercent > 0 && load.Alive > 0 && load.MaxLoad < opts.DownPercent:
+		return "scale_down", fmt.Sprintf("every backend below %g%% of capacity", opts.DownPercent)
+	}
+	return "", ""
+}
+
+// scaleLoad sums up the load of the backends in the pool; draining ones no
+// longer count.
+func (b *Balancer) scaleLoad(capacity int) ScaleEvent {
+	load := ScaleEvent{Queue: b.metrics.inFlight.Value()}
+	for _, s := range b.Backends() {
+		if s.State == stateDraining {
+			continue
+		}
+		load.Backends++
+		if !s.Alive {
+			continue
+		}
+		var percent float64
+		if capacity > 0 {
+			percent = float64(s.InFlight) / float64(capacity) * 100
+		}
+		if load.Alive == 0 || percent < load.MinLoad {
+			load.MinLoad = percent
+		}
+		load.MaxLoad = max(load.MaxLoad, percent)
+		load.Alive++
+		load.InFlight += s.InFlight
+	}
+	return load
+}
+
+// startScaling watches the load in the background until ctx is done. The
+// hooks and thresholds are read on every check, so a reload can change them.
+func (b *Balancer) startScaling(ctx context.Context) {
+	b.background.Add(1)
+	go func() {
+		defer b.background.Done()
+		ticker := time.NewTicker(scaleCheckInterval)
+		defer ticker.Stop()
+		var s scaler
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				cfg := b.Config()
+				if !cfg.Scale.enabled() {
+					s = scaler{}
+					continue
+				}
+				if event, ok := s.observe(cfg.Scale, b.scaleLoad(cfg.Scale.Capacity), now); ok {
+					b.emitScaleEvent(ctx, cfg, event)
+				}
+			}
+		}
+	}()
+}
+
+// emitScaleEvent runs the hooks of cfg.Scale for event, each limited by
+// cfg.Timeout. Failures are logged and counted.
+func (b *Balancer) emitScaleEvent(ctx context.Context, cfg Config, event ScaleEvent) {
+	b.metrics.scaleEvents.Add(1)
+	log.Printf("Scaling event %s: %s", event.Event, event.Reason)
+	body, _ := json.Marshal(event)
+
+	if cfg.Scale.Webhook != "" {
+		if err := postScaleEvent(ctx, cfg.Scale.Webhook, body, cfg.Timeout); err != nil {
+			b.metrics.scaleHookErrors.Add(1)
+			log.Printf("Scaling webhook %s failed: %v", cfg.Scale.Webhook, err)
+		}
+	}
+	if cfg.Scale.Command != "" {
+		if err := runScaleCommand(ctx, cfg.Scale.Command, event.Event, body, cfg.Timeout); err != nil {
+			b.metrics.scaleHookErrors.Add(1)
+			log.Printf("Scaling command failed: %v", err)
+		}
+	}
+}
+
+func postScaleEvent(ctx context.Context, url string, body []byte, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
+
+// runScaleCommand runs command with the event JSON on its standard input
+// and the event name in LB_SCALE_EVENT.
+func runScaleCommand(ctx context.Context, command, event string, body []byte, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(), "LB_SCALE_EVENT="+event)
+	out, err := cmd.CombinedOutput()
+	if err != nil && len(out) > 0 {
+		return fmt.Errorf("%w: %s", err, bytes.TrimSpace(out))
+	}
+	return err
+}
@@ -0,0 +1,95 @@
+package lb
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestScaler_SustainAndCooldown(t *testing.T) {
+	opts := ScaleOptions{Capacity: 10, UpPercent: 80, DownPercent: 10, Sustain: 30 * time.Second, Cooldown: time.Minute}
+	busy := ScaleEvent{Backends: 2, Alive: 2, MinLoad: 90, MaxLoad: 100}
+	idle := ScaleEvent{Backends: 2, Alive: 2, MinLoad: 0, MaxLoad: 5}
+	start := time.Unix(0, 0)
+	at := func(sec int) time.Time { return start.Add(time.Duration(sec) * time.Second) }
+
+	var s scaler
+	checks := []struct {
+		sec  int
+		load ScaleEvent
+		want string
+	}{
+		{0, busy, ""},
+		{20, busy, ""},
+		{30, busy, "scale_up"},
+		{40, busy, ""}, // cooldown
+		{50, idle, ""}, // the spike ended, sustain starts over
+		{85, idle, ""}, // sustained, but still in the cooldown
+		{95, idle, "scale_down"},
+	}
+	for _, check := range checks {
+		event, ok := s.observe(opts, check.load, at(check.sec))
+		if got := event.Event; ok != (check.want != "") || got != check.want {
+			t.Errorf("at %ds: got event %q (%t), want %q", check.sec, got, ok, check.want)
+		}
+	}
+}
+
+func TestScaleDecision(t *testing.T) {
+	opts := ScaleOptions{Capacity: 10, UpPercent: 80, QueueDepth: 100}
+	tests := []struct {
+		load ScaleEvent
+		want string
+	}{
+		{ScaleEvent{Backends: 2, Alive: 2, MinLoad: 50, MaxLoad: 100}, ""},
+		{ScaleEvent{Backends: 2, Alive: 2, MinLoad: 80, MaxLoad: 100}, "scale_up"},
+		{ScaleEvent{Backends: 2, Alive: 0}, "scale_up"},
+		{ScaleEvent{Backends: 2, Alive: 2, Queue: 100}, "scale_up"},
+		{ScaleEvent{Backends: 2, Alive: 2}, ""}, // scale-down is disabled
+	}
+	for i, tt := range tests {
+		if got, _ := scaleDecision(opts, tt.load); got != tt.want {
+			t.Errorf("case %d: got %q, want %q", i, got, tt.want)
+		}
+	}
+}
+
+func TestScaleLoad(t *testing.T) {
+	idle, busy := testServerInfo("idle", true, 0), testServerInfo("busy", true, 0)
+	busy.inFlight.Add(5)
+	b := testBalancer(idle, busy, testServerInfo("dead", false, 0))
+
+	load := b.scaleLoad(10)
+	if load.Backends != 3 || load.Alive != 2 || load.InFlight != 5 || load.MinLoad != 0 || load.MaxLoad != 50 {
+		t.Errorf("unexpected load %+v", load)
+	}
+}
+
+func TestEmitScaleEvent_Webhook(t *testing.T) {
+	events := make(chan ScaleEvent, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var event ScaleEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("decoding the event: %v", err)
+		}
+		events <- event
+	}))
+	defer hook.Close()
+
+	b := testBalancer()
+	cfg := b.Config()
+	cfg.Scale.Webhook = hook.URL
+	cfg.Scale.Command = "exit 1"
+	b.emitScaleEvent(context.Background(), cfg, ScaleEvent{Event: "scale_up", Alive: 3})
+
+	if event := <-events; event.Event != "scale_up" || event.Alive != 3 {
+		t.Errorf("unexpected event %+v", event)
+	}
+	if b.metrics.scaleEvents.Value() != 1 || b.metrics.scaleHookErrors.Value() != 1 {
+		t.Errorf("expected 1 event and 1 failed command, got %d and %d",
+			b.metrics.scaleEvents.Value(), b.metrics.scaleHookErrors.Value())
+	}
+}
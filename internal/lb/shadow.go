@@ -0,0 +1,97 @@
+package lb
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+)
+
+const (
+	// shadowMaxBody is the largest request body copied; requests with larger
+	// bodies are not mirrored.
+	shadowMaxBody = 1 << 20
+	// shadowMaxInFlight bounds the concurrent shadow requests, so a slow
+	// shadow backend cannot pile up goroutines; extra copies are dropped.
+	shadowMaxInFlight = 64
+	shadowHeader      = "X-Shadow-Request"
+)
+
+// shadowSampled decides whether a request is copied to the shadow backend,
+// Config.ShadowTo.
+func (b *Balancer) shadowSampled() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.cfg.ShadowTo != "" && rand.Float64()*100 < b.cfg.ShadowPercent
+}
+
+// shadow sends a copy of r to the shadow backend in the background. It must
+// be called before r is forwarded: the body is buffered and r.Body replaced
+// with a reader over the same bytes.
+func (b *Balancer) shadow(r *http.Request) {
+	body, ok := bufferBody(r)
+	if !ok {
+		b.metrics.shadowDropped.Add(1)
+		return
+	}
+	select {
+	case b.shadowSlots <- struct{}{}:
+	default:
+		b.metrics.shadowDropped.Add(1)
+		return
+	}
+
+	b.mu.RLock()
+	requestTimeout, shadowTo, scheme := b.cfg.Timeout, b.cfg.ShadowTo, b.cfg.scheme()
+	b.mu.RUnlock()
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), requestTimeout)
+	req := r.Clone(ctx)
+	req.RequestURI = ""
+	req.URL.Host = shadowTo
+	req.URL.Scheme = scheme
+	req.Host = shadowTo
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	removeHopHeaders(req.Header)
+	req.Header.Set(shadowHeader, "1")
+
+	b.metrics.shadowRequests.Add(1)
+	go func() {
+		defer func() { <-b.shadowSlots }()
+		defer cancel()
+		resp, err := b.shadowClient.Do(req)
+		if err != nil {
+			b.metrics.shadowErrors.Add(1)
+			log.Printf("Shadow request to %s failed: %v", shadowTo, err)
+			return
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+}
+
+// bufferBody reads the body of r, so it can be sent twice, and puts an
+// equivalent reader back. It reports false for bodies over shadowMaxBody, in
+// which case r still gets its whole body.
+func bufferBody(r *http.Request) ([]byte, bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true
+	}
+	if r.ContentLength > shadowMaxBody {
+		return nil, false
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, shadowMaxBody+1))
+	if err != nil || len(body) > shadowMaxBody {
+		r.Body = readCloser{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		return nil, false
+	}
+	r.Body = readCloser{bytes.NewReader(body), r.Body}
+	return body, true
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,59 @@
+package lb
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShadow(t *testing.T) {
+	received := make(chan string, 1)
+	shadowSrv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r.Method + " " + r.URL.Path + " " + r.Header.Get(shadowHeader) + " " + string(body)
+	}))
+	defer shadowSrv.Close()
+	u, _ := url.Parse(shadowSrv.URL)
+
+	b := testBalancer()
+	b.cfg.ShadowTo, b.cfg.ShadowPercent = u.Host, 100
+
+	if !b.shadowSampled() {
+		t.Error("expected every request to be sampled at 100%")
+	}
+
+	r := httptest.NewRequest("POST", "/api/v1/some-data", strings.NewReader("payload"))
+	b.shadow(r)
+	if body, _ := io.ReadAll(r.Body); string(body) != "payload" {
+		t.Errorf("the forwarded request lost its body: %q", body)
+	}
+	select {
+	case got := <-received:
+		if want := "POST /api/v1/some-data 1 payload"; got != want {
+			t.Errorf("shadow got %q, want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("shadow backend got no request")
+	}
+
+	large := strings.Repeat("x", shadowMaxBody+1)
+	r = httptest.NewRequest("POST", "/upload", strings.NewReader(large))
+	r.ContentLength = -1
+	dropped := b.metrics.shadowDropped.Value()
+	b.shadow(r)
+	if body, _ := io.ReadAll(r.Body); string(body) != large {
+		t.Errorf("a request too large to mirror must keep its body, got %d bytes", len(body))
+	}
+	if b.metrics.shadowDropped.Value() != dropped+1 {
+		t.Error("expected the oversized request to be dropped from mirroring")
+	}
+
+	b.cfg.ShadowPercent = 0
+	if b.shadowSampled() {
+		t.Error("expected no request to be sampled at 0%")
+	}
+}
@@ -0,0 +1,43 @@
+package lb
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// slowStart ramps up the traffic of backends that recovered within window:
+// instead of getting every request at once because their traffic counters
+// are low, they take part in a least-traffic or least-connections selection
+// with a probability that grows linearly from 0 to 1 over the window.
+type slowStart struct {
+	window time.Duration
+	now    time.Time
+	rnd    func() float64
+}
+
+func (b *Balancer) slowStart() slowStart {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return slowStart{window: b.cfg.SlowStart, now: time.Now(), rnd: rand.Float64}
+}
+
+// weight returns the share of its normal traffic s gets now.
+func (ss slowStart) weight(s *ServerInfo) float64 {
+	if ss.window <= 0 {
+		return 1
+	}
+	s.stateMu.Lock()
+	since := s.recoveredAt
+	s.stateMu.Unlock()
+	elapsed := ss.now.Sub(since)
+	if since.IsZero() || elapsed >= ss.window {
+		return 1
+	}
+	return max(float64(elapsed)/float64(ss.window), 0)
+}
+
+// admits reports whether s takes part in the selection.
+func (ss slowStart) admits(s *ServerInfo) bool {
+	w := ss.weight(s)
+	return w >= 1 || ss.rnd() < w
+}
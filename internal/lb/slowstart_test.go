@@ -0,0 +1,50 @@
+package lb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlowStart_Weight(t *testing.T) {
+	now := time.Unix(1000, 0)
+	s := testServerInfo("backend", true, 0)
+	ss := slowStart{window: 10 * time.Second, now: now}
+
+	if w := ss.weight(s); w != 1 {
+		t.Errorf("a backend that never recovered has weight %.2f, want 1", w)
+	}
+	s.recoveredAt = now.Add(-2500 * time.Millisecond)
+	if w := ss.weight(s); w != 0.25 {
+		t.Errorf("weight a quarter into the window = %.2f, want 0.25", w)
+	}
+	s.recoveredAt = now.Add(-time.Minute)
+	if w := ss.weight(s); w != 1 {
+		t.Errorf("weight after the window = %.2f, want 1", w)
+	}
+	s.recoveredAt = now
+	if w := (slowStart{now: now}).weight(s); w != 1 {
+		t.Errorf("weight with slow start disabled = %.2f, want 1", w)
+	}
+}
+
+func TestSelectServerLeastTraffic_SlowStart(t *testing.T) {
+	busy, recovered := testServerInfo("busy", true, 1<<20), testServerInfo("recovered", false, 0)
+	recovered.observeHealth(true, 0, 0)
+	b := testBalancer(busy, recovered)
+	b.cfg.SlowStart = time.Hour
+
+	picked := 0
+	for i := 0; i < 100; i++ {
+		if b.selectServerLeastTraffic(pool{}) == recovered {
+			picked++
+		}
+	}
+	if picked > 10 {
+		t.Errorf("a backend that just recovered got %d of 100 requests", picked)
+	}
+
+	busy.SetAlive(false)
+	if got := b.selectServerLeastTraffic(pool{}); got != recovered {
+		t.Error("a backend in slow start must still serve when it is the only one left")
+	}
+}
@@ -0,0 +1,112 @@
+package lb
+
+import (
+	"log"
+	"time"
+)
+
+// backendState is the lifecycle state of a backend. Healthy, degraded and
+// warming backends receive traffic; backends in maintenance, draining and
+// dead ones do not.
+type backendState int
+
+const (
+	stateHealthy backendState = iota
+	stateDegraded
+	stateWarming
+	stateMaintenance
+	stateDraining
+	stateDead
+)
+
+var stateNames = map[backendState]string{
+	stateHealthy:     "healthy",
+	stateDegraded:    "degraded",
+	stateWarming:     "warming",
+	stateMaintenance: "maintenance",
+	stateDraining:    "draining",
+	stateDead:        "dead",
+}
+
+func (s backendState) String() string {
+	return stateNames[s]
+}
+
+func (s backendState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s backendState) serving() bool {
+	return s == stateHealthy || s == stateDegraded || s == stateWarming
+}
+
+// stateTransitions lists the states every state may move to. A dead backend
+// or one leaving maintenance comes back through warming; a draining one only
+// leaves the pool.
+var stateTransitions = map[backendState][]backendState{
+	stateHealthy:     {stateDegraded, stateMaintenance, stateDraining, stateDead},
+	stateDegraded:    {stateHealthy, stateMaintenance, stateDraining, stateDead},
+	stateWarming:     {stateHealthy, stateDegraded, stateMaintenance, stateDraining, stateDead},
+	stateDead:        {stateWarming, stateMaintenance, stateDraining},
+	stateMaintenance: {stateWarming, stateDraining},
+	stateDraining:    {},
+}
+
+func canTransition(from, to backendState) bool {
+	for _, s := range stateTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// State returns the current state of the backend and when it was entered.
+func (s *ServerInfo) State() (backendState, time.Time) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	return s.state, s.stateSince
+}
+
+// transition moves the backend to the given state if that is allowed from
+// its current state, logging the change. It reports whether the state is
+// now to.
+func (s *ServerInfo) transition(to backendState) bool {
+	s.stateMu.Lock()
+	from := s.state
+	if from == to {
+		s.stateMu.Unlock()
+		return true
+	}
+	if !canTransition(from, to) {
+		s.stateMu.Unlock()
+		return false
+	}
+	s.state, s.stateSince = to, time.Now()
+	if to == stateWarming {
+		s.recoveredAt = s.stateSince
+	}
+	s.stateMu.Unlock()
+
+	log.Printf("Server %s state changed: %s -> %s", s.URL, from, to)
+	return true
+}
+
+// observeHealth applies the outcome of a health check: a failure kills the
+// backend, a dead backend that passes warms up first and becomes healthy on
+// the next pass, and a pass slower than degradedLatency (unless zero) marks it
+// degraded. Draining backends and those in maintenance ignore health checks.
+func (s *ServerInfo) observeHealth(ok bool, latency, degradedLatency time.Duration) {
+	state, _ := s.State()
+	switch {
+	case state == stateDraining, state == stateMaintenance:
+	case !ok:
+		s.transition(stateDead)
+	case state == stateDead:
+		s.transition(stateWarming)
+	case degradedLatency > 0 && latency > degradedLatency:
+		s.transition(stateDegraded)
+	default:
+		s.transition(stateHealthy)
+	}
+}
@@ -0,0 +1,57 @@
+package lb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestServerInfo_Transition(t *testing.T) {
+	s := testServerInfo("backend", false, 0)
+	if s.transition(stateHealthy) {
+		t.Error("a dead backend must warm up before becoming healthy")
+	}
+	_, deadSince := s.State()
+
+	steps := []backendState{stateWarming, stateHealthy, stateDegraded, stateDraining}
+	for _, step := range steps {
+		if !s.transition(step) {
+			t.Fatalf("transition to %s rejected", step)
+		}
+	}
+	state, since := s.State()
+	if state != stateDraining || since.Before(deadSince) {
+		t.Errorf("unexpected state %s since %v", state, since)
+	}
+	if s.transition(stateHealthy) || s.IsAlive() {
+		t.Error("a draining backend must not return to service")
+	}
+}
+
+func TestServerInfo_ObserveHealth(t *testing.T) {
+	const degradedLatency = 100 * time.Millisecond
+	s := testServerInfo("backend", true, 0)
+	checks := []struct {
+		ok      bool
+		latency time.Duration
+		want    backendState
+	}{
+		{true, time.Millisecond, stateHealthy},
+		{true, time.Second, stateDegraded},
+		{true, time.Millisecond, stateHealthy},
+		{false, 0, stateDead},
+		{true, time.Millisecond, stateWarming},
+		{true, time.Millisecond, stateHealthy},
+	}
+	for i, check := range checks {
+		s.observeHealth(check.ok, check.latency, degradedLatency)
+		if state, _ := s.State(); state != check.want {
+			t.Errorf("check %d: state %s, want %s", i, state, check.want)
+		}
+	}
+
+	s.transition(stateDraining)
+	s.observeHealth(true, time.Millisecond, degradedLatency)
+	if state, _ := s.State(); state != stateDraining {
+		t.Errorf("health checks must not end draining, got %s", state)
+	}
+}
@@ -0,0 +1,155 @@
+package lb
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// latencyWeight is the weight of the newest response time in a backend's
+// moving average; the older ones fade out over about 1/latencyWeight
+// requests.
+const latencyWeight = 0.2
+
+// latencyAverage is an exponentially weighted moving average of response
+// times, updated atomically.
+type latencyAverage struct {
+	bits atomic.Uint64 // math.Float64bits of the average in seconds
+}
+
+func (l *latencyAverage) observe(d time.Duration) {
+	for {
+		old := l.bits.Load()
+		avg := math.Float64frombits(old)
+		next := d.Seconds()
+		if old != 0 {
+			next = latencyWeight*next + (1-latencyWeight)*avg
+		}
+		if l.bits.CompareAndSwap(old, math.Float64bits(next)) {
+			return
+		}
+	}
+}
+
+// load returns the average, or 0 before the first response.
+func (l *latencyAverage) load() time.Duration {
+	return time.Duration(math.Float64frombits(l.bits.Load()) * float64(time.Second))
+}
+
+// selectServerRoundRobin hands requests to the alive backends of the pool in
+// turn.
+func (b *Balancer) selectServerRoundRobin(p pool) *ServerInfo {
+	b.serversMu.RLock()
+	defer b.serversMu.RUnlock()
+	alive := make([]*ServerInfo, 0, len(b.servers))
+	for _, server := range b.servers {
+		if b.inPool(p, server) && server.available() {
+			alive = append(alive, server)
+		}
+	}
+	if len(alive) == 0 {
+		return nil
+	}
+	return alive[(b.roundRobin.Add(1)-1)%uint64(len(alive))]
+}
+
+// selectServerLatencyAware picks the alive backend of the pool with the
+// lowest average response time, preferring the one with fewer requests in
+// flight on ties. Backends that have not answered yet average zero, so
+// they are tried first.
+func (b *Balancer) selectServerLatencyAware(p pool) *ServerInfo {
+	b.serversMu.RLock()
+	defer b.serversMu.RUnlock()
+
+	var selectedServer *ServerInfo
+	var bestLatency time.Duration
+	var bestInFlight int64
+	for _, server := range b.servers {
+		if !b.inPool(p, server) || !server.available() {
+			continue
+		}
+		latency, inFlight := server.latency.load(), server.InFlight()
+		if selectedServer == nil || latency < bestLatency ||
+			(latency == bestLatency && inFlight < bestInFlight) {
+			selectedServer, bestLatency, bestInFlight = server, latency, inFlight
+		}
+	}
+	return selectedServer
+}
+
+// selectServerLeastLatency picks the faster of two random alive backends of
+// the pool by average response time, preferring the one with fewer requests
+// in flight on ties. Unlike latency-aware, it does not send every request to
+// the one fastest backend until its average catches up, and it reads two
+// backends instead of all of them.
+func (b *Balancer) selectServerLeastLatency(p pool) *ServerInfo {
+	b.serversMu.RLock()
+	defer b.serversMu.RUnlock()
+	alive := make([]*ServerInfo, 0, len(b.servers))
+	for _, server := range b.servers {
+		if b.inPool(p, server) && server.available() {
+			alive = append(alive, server)
+		}
+	}
+	switch len(alive) {
+	case 0:
+		return nil
+	case 1:
+		return alive[0]
+	}
+	i := rand.IntN(len(alive))
+	j := rand.IntN(len(alive) - 1)
+	if j >= i {
+		j++
+	}
+	return fasterServer(alive[i], alive[j])
+}
+
+// fasterServer returns the backend with the lower average response time, or
+// the one with fewer requests in flight if they are equal.
+func fasterServer(a, b *ServerInfo) *ServerInfo {
+	la, lb := a.latency.load(), b.latency.load()
+	if lb < la || (lb == la && b.InFlight() < a.InFlight()) {
+		return b
+	}
+	return a
+}
+
+// handleStrategy reports the selection strategy on GET and changes it on
+// POST ?strategy=<name>[&hash_key=<key>]. Like the canary weight, the change
+// lasts until the next config reload.
+func (b *Balancer) handleStrategy(rw http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		q := r.URL.Query()
+		b.mu.Lock()
+		strategy, hashKey := q.Get("strategy"), b.cfg.HashKey
+		if q.Has("hash_key") {
+			hashKey = q.Get("hash_key")
+		}
+		if err := validateStrategy(strategy, hashKey); err != nil {
+			b.mu.Unlock()
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		previous := b.cfg.Strategy
+		b.cfg.Strategy, b.cfg.HashKey = strategy, hashKey
+		b.mu.Unlock()
+		log.Printf("Strategy changed from %s to %s via the admin API", previous, strategy)
+	default:
+		rw.Header().Set("Allow", "GET, POST")
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	b.mu.RLock()
+	state := map[string]string{"strategy": b.cfg.Strategy, "hash_key": b.cfg.HashKey}
+	b.mu.RUnlock()
+	rw.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(rw).Encode(state)
+}
@@ -0,0 +1,120 @@
+package lb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSelectServerRoundRobin(t *testing.T) {
+	a, dead, c := testServerInfo("a", true, 0), testServerInfo("dead", false, 0), testServerInfo("c", true, 0)
+	b := testBalancer(a, dead, c)
+
+	var got []string
+	for i := 0; i < 4; i++ {
+		got = append(got, b.selectServerRoundRobin(pool{}).GetURL())
+	}
+	if want := []string{"a", "c", "a", "c"}; !slices.Equal(got, want) {
+		t.Errorf("round-robin order %v, want %v", got, want)
+	}
+}
+
+func TestSelectServerLatencyAware(t *testing.T) {
+	fast, slow, fresh := testServerInfo("fast", true, 0), testServerInfo("slow", true, 0), testServerInfo("fresh", true, 0)
+	b := testBalancer(fast, slow, fresh)
+	fast.latency.observe(10 * time.Millisecond)
+	slow.latency.observe(100 * time.Millisecond)
+
+	if got := b.selectServerLatencyAware(pool{}); got != fresh {
+		t.Errorf("a backend without responses must be tried first, got %s", got.GetURL())
+	}
+	fresh.latency.observe(time.Second)
+	if got := b.selectServerLatencyAware(pool{}); got != fast {
+		t.Errorf("expected the fastest backend, got %s", got.GetURL())
+	}
+
+	// The average follows a backend that slows down.
+	for i := 0; i < 20; i++ {
+		fast.latency.observe(500 * time.Millisecond)
+	}
+	if got := b.selectServerLatencyAware(pool{}); got != slow {
+		t.Errorf("expected the backend that stayed fast, got %s", got.GetURL())
+	}
+}
+
+func TestSelectServerLeastLatency(t *testing.T) {
+	fast, slow, dead := testServerInfo("fast", true, 0), testServerInfo("slow", true, 0), testServerInfo("dead", false, 0)
+	b := testBalancer(fast, slow, dead)
+	fast.latency.observe(10 * time.Millisecond)
+	slow.latency.observe(100 * time.Millisecond)
+
+	// With two alive backends both are always the candidates.
+	for i := 0; i < 20; i++ {
+		if got := b.selectServerLeastLatency(pool{}); got != fast {
+			t.Fatalf("expected the faster of two backends, got %s", got.GetURL())
+		}
+	}
+
+	// The slowest of three backends is never picked, the others share the
+	// requests instead of the fastest taking them all.
+	mid := testServerInfo("mid", true, 0)
+	mid.latency.observe(50 * time.Millisecond)
+	b = testBalancer(fast, slow, mid)
+	picked := map[string]int{}
+	for i := 0; i < 300; i++ {
+		picked[b.selectServerLeastLatency(pool{}).GetURL()]++
+	}
+	if picked["slow"] != 0 || picked["mid"] == 0 || picked["fast"] <= picked["mid"] {
+		t.Errorf("unexpected picks %v", picked)
+	}
+
+	fast.SetAlive(false)
+	slow.SetAlive(false)
+	if got := b.selectServerLeastLatency(pool{}); got != mid {
+		t.Errorf("expected the only alive backend, got %v", got)
+	}
+	mid.SetAlive(false)
+	if got := b.selectServerLeastLatency(pool{}); got != nil {
+		t.Errorf("expected no backend, got %s", got.GetURL())
+	}
+}
+
+func TestForward_ObservesLatency(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer backend.Close()
+	server := testServerInfo(strings.TrimPrefix(backend.URL, "http://"), true, 0)
+	b := testBalancer(server)
+
+	if err := b.forward(server, httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if got := server.latency.load(); got < 20*time.Millisecond || got > time.Second {
+		t.Errorf("average latency %s after one 20ms response", got)
+	}
+}
+
+func TestHandleStrategy(t *testing.T) {
+	b := testBalancer()
+
+	rw := httptest.NewRecorder()
+	b.handleStrategy(rw, httptest.NewRequest(http.MethodPost, "/admin/strategy?strategy=round-robin", nil))
+	if rw.Code != http.StatusOK || b.Config().Strategy != "round-robin" {
+		t.Errorf("status %d, strategy %s", rw.Code, b.Config().Strategy)
+	}
+
+	for _, query := range []string{"strategy=random", "strategy=hash&hash_key=cookie"} {
+		rw = httptest.NewRecorder()
+		b.handleStrategy(rw, httptest.NewRequest(http.MethodPost, "/admin/strategy?"+query, nil))
+		if rw.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, rw.Code)
+		}
+	}
+	if b.Config().Strategy != "round-robin" {
+		t.Errorf("a rejected change must keep the strategy, got %s", b.Config().Strategy)
+	}
+}
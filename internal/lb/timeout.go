@@ -0,0 +1,69 @@
+package lb
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// timeoutHeader lets a client shorten or extend the timeout of its request,
+// up to Config.MaxTimeout. It takes a duration such as "1.5s" or a number of
+// milliseconds, and is not forwarded.
+const timeoutHeader = "X-LB-Timeout"
+
+func parseTimeout(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		ms, msErr := strconv.ParseInt(value, 10, 64)
+		if msErr != nil {
+			return 0, fmt.Errorf("invalid %s %q", timeoutHeader, value)
+		}
+		d = time.Duration(ms) * time.Millisecond
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be positive", timeoutHeader, value)
+	}
+	return d, nil
+}
+
+// requestTimeout returns the timeout of a forwarded request: the
+// X-LB-Timeout header capped by Config.MaxTimeout, else the timeout of the
+// matching route, else Config.Timeout. An invalid header is an error, with
+// the timeout the request would get without it.
+func (b *Balancer) requestTimeout(r *http.Request) (time.Duration, error) {
+	b.mu.RLock()
+	timeout, maxTimeout := b.cfg.Timeout, b.cfg.MaxTimeout
+	if route := matchRoute(b.cfg.Routes, r); route != nil && route.TimeoutMs > 0 {
+		timeout = time.Duration(route.TimeoutMs) * time.Millisecond
+	}
+	b.mu.RUnlock()
+
+	value := r.Header.Get(timeoutHeader)
+	if value == "" {
+		return timeout, nil
+	}
+	requested, err := parseTimeout(value)
+	if err != nil {
+		return timeout, err
+	}
+	if maxTimeout > 0 {
+		requested = min(requested, maxTimeout)
+	}
+	return requested, nil
+}
+
+// observeLatency logs and counts a request against the backend that served
+// it if it took longer than Config.SlowThreshold.
+func (b *Balancer) observeLatency(server *ServerInfo, r *http.Request, status int, elapsed time.Duration) {
+	b.mu.RLock()
+	threshold := b.cfg.SlowThreshold
+	b.mu.RUnlock()
+	if threshold <= 0 || elapsed < threshold {
+		return
+	}
+	b.metrics.slowRequests.Add(1)
+	server.slowRequests.Add(1)
+	log.Printf("Slow request %s %s: %s on %s, status %d", r.Method, r.URL.Path, elapsed.Round(time.Millisecond), server.GetURL(), status)
+}
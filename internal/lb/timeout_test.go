@@ -0,0 +1,90 @@
+package lb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	b := testBalancer()
+	b.cfg.Timeout = 3 * time.Second
+	b.cfg.MaxTimeout = 10 * time.Second
+	b.cfg.Routes = []Route{{PathPrefix: "/slow", TimeoutMs: 5000}}
+
+	for _, tt := range []struct {
+		path, header string
+		want         time.Duration
+		invalid      bool
+	}{
+		{"/api", "", 3 * time.Second, false},
+		{"/slow/report", "", 5 * time.Second, false},
+		{"/slow/report", "500ms", 500 * time.Millisecond, false},
+		{"/api", "1500", 1500 * time.Millisecond, false},
+		{"/api", "1m", 10 * time.Second, false},
+		{"/api", "soon", 3 * time.Second, true},
+		{"/api", "-1s", 3 * time.Second, true},
+	} {
+		r := httptest.NewRequest("GET", tt.path, nil)
+		if tt.header != "" {
+			r.Header.Set(timeoutHeader, tt.header)
+		}
+		got, err := b.requestTimeout(r)
+		if got != tt.want || (err != nil) != tt.invalid {
+			t.Errorf("%s with %q: got %s, %v; want %s (invalid %t)", tt.path, tt.header, got, err, tt.want, tt.invalid)
+		}
+	}
+}
+
+func TestBalancer_TimeoutHeaderAndSlowRequests(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(timeoutHeader) != "" {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(backend.Close)
+	s := testServerInfo(strings.TrimPrefix(backend.URL, "http://"), true, 0)
+	b := testBalancer(s)
+	b.cfg.SlowThreshold = 50 * time.Millisecond
+
+	rr := httptest.NewRecorder()
+	b.ServeHTTP(rr, httptest.NewRequest("GET", "/api", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if b.metrics.slowRequests.Value() != 1 || s.Snapshot().SlowRequests != 1 {
+		t.Errorf("expected 1 slow request on %s, got %d total and %d on the backend",
+			s.URL, b.metrics.slowRequests.Value(), s.Snapshot().SlowRequests)
+	}
+
+	r := httptest.NewRequest("GET", "/api", nil)
+	r.Header.Set(timeoutHeader, "1s")
+	rr = httptest.NewRecorder()
+	b.ServeHTTP(rr, r)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected %s to stay out of the forwarded request, got %d", timeoutHeader, rr.Code)
+	}
+
+	r = httptest.NewRequest("GET", "/api", nil)
+	r.Header.Set(timeoutHeader, "10ms")
+	rr = httptest.NewRecorder()
+	b.ServeHTTP(rr, r)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected the 10ms timeout to cut the request, got %d", rr.Code)
+	}
+
+	r = httptest.NewRequest("GET", "/api", nil)
+	r.Header.Set(timeoutHeader, "soon")
+	rr = httptest.NewRecorder()
+	b.ServeHTTP(rr, r)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), timeoutHeader) {
+		t.Errorf("expected 400 for an invalid %s, got %d %q", timeoutHeader, rr.Code, rr.Body)
+	}
+}
@@ -0,0 +1,59 @@
+package lb
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// trafficAfterHeader carries the backend's total traffic including the
+// response. It is sent as a trailer, after the body.
+const trafficAfterHeader = "lb-traffic-after"
+
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// parseTraceparent returns the trace ID of a W3C traceparent header value
+// ("00-<trace-id>-<parent-id>-<flags>") and the flags, or ok == false if the
+// value is malformed.
+func parseTraceparent(value string) (traceID, flags string, ok bool) {
+	parts := strings.Split(value, "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return "", "", false
+	}
+	for _, part := range parts[1:] {
+		if _, err := hex.DecodeString(part); err != nil {
+			return "", "", false
+		}
+	}
+	if parts[1] == strings.Repeat("0", 32) || parts[2] == strings.Repeat("0", 16) {
+		return "", "", false
+	}
+	return parts[1], parts[3], true
+}
+
+// setTraceHeaders makes sure the request carries an X-Request-ID and a
+// traceparent header, keeping the trace of the caller if there is a valid
+// one. The balancer becomes the parent span of the backend request. It
+// returns the request ID.
+func setTraceHeaders(h http.Header) string {
+	requestID := h.Get(requestIDHeader)
+	if requestID == "" {
+		requestID = randomHex(16)
+		h.Set(requestIDHeader, requestID)
+	}
+
+	traceID, flags, ok := parseTraceparent(h.Get("Traceparent"))
+	if !ok {
+		traceID, flags = randomHex(16), "01"
+		h.Del("Tracestate")
+	}
+	h.Set("Traceparent", "00-"+traceID+"-"+randomHex(8)+"-"+flags)
+	return requestID
+}
@@ -0,0 +1,101 @@
+package lb
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/roman-mazur/architecture-practice-4-template/internal/telemetry"
+)
+
+func TestSetTraceHeaders(t *testing.T) {
+	h := http.Header{}
+	id := setTraceHeaders(h)
+	if id == "" || h.Get(requestIDHeader) != id {
+		t.Errorf("expected a generated request ID, got %q", h.Get(requestIDHeader))
+	}
+	if _, _, ok := parseTraceparent(h.Get("Traceparent")); !ok {
+		t.Errorf("generated traceparent %q is invalid", h.Get("Traceparent"))
+	}
+
+	const incoming = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	h = http.Header{}
+	h.Set(requestIDHeader, "req-1")
+	h.Set("Traceparent", incoming)
+	if id := setTraceHeaders(h); id != "req-1" {
+		t.Errorf("request ID = %q, want the incoming one", id)
+	}
+	got := h.Get("Traceparent")
+	if !strings.HasPrefix(got, "00-4bf92f3577b34da6a3ce929d0e0e4736-") || got == incoming {
+		t.Errorf("traceparent %q must keep the trace ID with a new parent ID", got)
+	}
+
+	h = http.Header{}
+	h.Set("Traceparent", "garbage")
+	h.Set("Tracestate", "vendor=1")
+	setTraceHeaders(h)
+	if _, _, ok := parseTraceparent(h.Get("Traceparent")); !ok || h.Get("Tracestate") != "" {
+		t.Errorf("malformed traceparent must be replaced, got %q / %q", h.Get("Traceparent"), h.Get("Tracestate"))
+	}
+}
+
+func TestBalancer_OpenTelemetry(t *testing.T) {
+	var mu sync.Mutex
+	spans := map[string]string{} // name -> spanId/parentSpanId
+	collector := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []struct {
+						Name         string `json:"name"`
+						SpanID       string `json:"spanId"`
+						ParentSpanID string `json:"parentSpanId"`
+					} `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		defer mu.Unlock()
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, s := range ss.Spans {
+					spans[s.Name] = s.SpanID + "/" + s.ParentSpanID
+				}
+			}
+		}
+	}))
+	defer collector.Close()
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", collector.URL)
+	tracer, err := telemetry.FromEnv("lb")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var received string
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get("Traceparent")
+	}))
+	defer backend.Close()
+	b := testBalancer(testServerInfo(strings.TrimPrefix(backend.URL, "http://"), true, 0))
+	b.SetTracer(tracer)
+
+	tracer.Handler(b).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	server, _, _ := strings.Cut(spans["GET"], "/")
+	forwardID, forwardParent, _ := strings.Cut(spans["lb.forward"], "/")
+	_, selectParent, _ := strings.Cut(spans["lb.select"], "/")
+	if server == "" || selectParent != server || forwardParent != server {
+		t.Errorf("selection and forwarding must be children of the request span: %v", spans)
+	}
+	if _, _, ok := parseTraceparent(received); !ok || !strings.Contains(received, "-"+forwardID+"-") {
+		t.Errorf("the backend must get the forward span as parent, got %q, spans %v", received, spans)
+	}
+}
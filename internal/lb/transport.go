@@ -0,0 +1,149 @@
+package lb
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// TransportOptions configure the connections to backends.
+type TransportOptions struct {
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+	DialTimeout         time.Duration
+
+	// TLSSkipVerify skips the verification of every backend certificate;
+	// TLSSkipVerifyBackends, a comma-separated list of backends as they are
+	// configured, only of theirs, e.g. of lab servers with self-signed
+	// certificates.
+	TLSSkipVerify         bool
+	TLSSkipVerifyBackends string
+	// TLSCAFile is a PEM file of the CAs trusted to sign backend
+	// certificates instead of the system roots. TLSCertFile and TLSKeyFile
+	// are a client certificate presented to backends that ask for one.
+	TLSCAFile   string
+	TLSCertFile string
+	TLSKeyFile  string
+}
+
+func (o *TransportOptions) skipVerify(backend string) bool {
+	if o.TLSSkipVerify {
+		return true
+	}
+	for _, name := range strings.Split(o.TLSSkipVerifyBackends, ",") {
+		if name = strings.TrimSpace(name); name != "" && name == backend {
+			return true
+		}
+	}
+	return false
+}
+
+// tlsConfig returns the TLS settings of the connections to backend.
+func (o *TransportOptions) tlsConfig(backend string) (*tls.Config, error) {
+	cfg := &tls.Config{InsecureSkipVerify: o.skipVerify(backend)}
+	if o.TLSCAFile != "" {
+		pem, err := os.ReadFile(o.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("backend CA: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("backend CA: no certificates in %s", o.TLSCAFile)
+		}
+	}
+	if o.TLSCertFile != "" || o.TLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.TLSCertFile, o.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("backend client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// backendTLS is tlsConfig for a backend joining the pool. The files were
+// checked when the config was validated, so if they have become unreadable
+// since, the error is logged and only the verification settings are kept.
+func (o *TransportOptions) backendTLS(backend string) *tls.Config {
+	cfg, err := o.tlsConfig(backend)
+	if err != nil {
+		log.Printf("TLS settings of %s: %v", backend, err)
+		return &tls.Config{InsecureSkipVerify: o.skipVerify(backend)}
+	}
+	return cfg
+}
+
+// parseBackend splits a configured backend into its scheme and address.
+// A plain host:port has no scheme of its own and uses the one of
+// Config.HTTPS.
+func parseBackend(backend string) (scheme, addr string, err error) {
+	if !strings.Contains(backend, "://") {
+		return "", backend, nil
+	}
+	u, err := url.Parse(backend)
+	if err != nil {
+		return "", "", fmt.Errorf("backend %q: %w", backend, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", "", fmt.Errorf("backend %q: scheme must be http or https", backend)
+	}
+	if u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+		return "", "", fmt.Errorf("backend %q: expected <scheme>://<host:port>", backend)
+	}
+	return u.Scheme, u.Host, nil
+}
+
+// validateBackends checks the scheme of every backend of the pools.
+func validateBackends(backends []string, groups map[string][]string) error {
+	for _, backend := range backends {
+		if _, _, err := parseBackend(backend); err != nil {
+			return err
+		}
+	}
+	for _, members := range groups {
+		for _, backend := range members {
+			if _, _, err := parseBackend(backend); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// newBackendTransport builds the connection pool for a single backend, so
+// forwarded requests neither share idle connections with health checks nor
+// compete with other backends for the default per-host limit of 2.
+func newBackendTransport(opts TransportOptions, tlsConfig *tls.Config) *http.Transport {
+	return &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   opts.DialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConnsPerHost:   opts.MaxIdleConnsPerHost,
+		IdleConnTimeout:       opts.IdleConnTimeout,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       tlsConfig,
+		ForceAttemptHTTP2:     true,
+	}
+}
+
+// newHealthClient returns the client of a backend's health checks. It does
+// not keep connections alive: probes are rare and should observe a backend
+// the way a fresh client would.
+func newHealthClient(tlsConfig *tls.Config) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DisableKeepAlives:   true,
+			TLSHandshakeTimeout: 5 * time.Second,
+			TLSClientConfig:     tlsConfig,
+		},
+	}
+}
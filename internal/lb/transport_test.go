@@ -0,0 +1,85 @@
+package lb
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseBackend(t *testing.T) {
+	tests := []struct {
+		backend, scheme, addr string
+	}{
+		{"server1:8080", "", "server1:8080"},
+		{"http://server1:8080", "http", "server1:8080"},
+		{"https://server1:8443/", "https", "server1:8443"},
+	}
+	for _, tt := range tests {
+		scheme, addr, err := parseBackend(tt.backend)
+		if err != nil || scheme != tt.scheme || addr != tt.addr {
+			t.Errorf("parseBackend(%q) = %q, %q, %v", tt.backend, scheme, addr, err)
+		}
+	}
+	for _, backend := range []string{"ftp://server1", "https://", "http://server1:8080/api"} {
+		if _, _, err := parseBackend(backend); err == nil {
+			t.Errorf("%q must be rejected", backend)
+		}
+	}
+}
+
+func TestForward_BackendSchemes(t *testing.T) {
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		_, _ = rw.Write([]byte("ok"))
+	})
+	secure := httptest.NewTLSServer(handler)
+	defer secure.Close()
+	plain := httptest.NewServer(handler)
+	defer plain.Close()
+
+	forward := func(cfg Config, backend string) error {
+		b := newBalancer(cfg)
+		server := newServerInfo(backend, true, &cfg)
+		return b.forward(server, httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+
+	cfg := DefaultConfig()
+	cfg.HTTPS = true
+	if err := forward(cfg, plain.URL); err != nil {
+		t.Errorf("an http:// backend must not use -https: %v", err)
+	}
+	if err := forward(cfg, secure.URL); err == nil {
+		t.Error("expected a self-signed certificate to be rejected")
+	}
+
+	cfg.Transport.TLSSkipVerifyBackends = "other:8443, " + secure.URL
+	if err := forward(cfg, secure.URL); err != nil {
+		t.Errorf("verification must be skipped for a listed backend: %v", err)
+	}
+
+	cfg.Transport.TLSSkipVerifyBackends = ""
+	cfg.Transport.TLSCAFile = filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: secure.Certificate().Raw})
+	if err := os.WriteFile(cfg.Transport.TLSCAFile, ca, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := forward(cfg, secure.URL); err != nil {
+		t.Errorf("a certificate signed by the configured CA must be accepted: %v", err)
+	}
+}
+
+func TestNewBalancer_TLSFiles(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Transport.TLSCAFile = filepath.Join(t.TempDir(), "missing.pem")
+	if _, err := NewBalancer(cfg); err == nil || !strings.Contains(err.Error(), "backend CA") {
+		t.Errorf("expected a missing CA file to be rejected, got %v", err)
+	}
+	cfg = DefaultConfig()
+	cfg.Backends = []string{"ftp://server1:21"}
+	if _, err := NewBalancer(cfg); err == nil {
+		t.Error("expected a backend with an unknown scheme to be rejected")
+	}
+}
@@ -0,0 +1,399 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const scopeName = "github.com/roman-mazur/architecture-practice-4-template/internal/telemetry"
+
+// exporterOptions configure the batching OTLP/HTTP exporter.
+type exporterOptions struct {
+	endpoint  string
+	headers   map[string]string
+	timeout   time.Duration
+	delay     time.Duration
+	queueSize int
+	batchSize int
+}
+
+// FromEnv returns a tracer of service configured by the environment, or nil
+// if tracing is off. It is off unless OTEL_EXPORTER_OTLP_TRACES_ENDPOINT or
+// OTEL_EXPORTER_OTLP_ENDPOINT is set, and when OTEL_SDK_DISABLED is true or
+// OTEL_TRACES_EXPORTER is none. Only the http/json protocol is supported.
+// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES, OTEL_EXPORTER_OTLP_HEADERS
+// and OTEL_EXPORTER_OTLP_TIMEOUT and the OTEL_BSP_* batching settings are
+// honoured as the OpenTelemetry specification defines them.
+func FromEnv(service string) (*Tracer, error) {
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") {
+		return nil, nil
+	}
+	switch exporter := os.Getenv("OTEL_TRACES_EXPORTER"); exporter {
+	case "", "otlp":
+	case "none":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unsupported OTEL_TRACES_EXPORTER %q", exporter)
+	}
+	opts := exporterOptions{endpoint: os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")}
+	if opts.endpoint == "" {
+		base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if base == "" {
+			return nil, nil
+		}
+		opts.endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+	}
+	if _, err := url.ParseRequestURI(opts.endpoint); err != nil {
+		return nil, fmt.Errorf("invalid OTLP endpoint: %w", err)
+	}
+	protocol := envFirst("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL", "OTEL_EXPORTER_OTLP_PROTOCOL")
+	if protocol != "" && protocol != "http/json" {
+		return nil, fmt.Errorf("unsupported OTLP protocol %q, only http/json is", protocol)
+	}
+
+	var err error
+	opts.headers, err = parseKeyValues(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid OTEL_EXPORTER_OTLP_HEADERS: %w", err)
+	}
+	traceHeaders, err := parseKeyValues(os.Getenv("OTEL_EXPORTER_OTLP_TRACES_HEADERS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid OTEL_EXPORTER_OTLP_TRACES_HEADERS: %w", err)
+	}
+	for k, v := range traceHeaders {
+		opts.headers[k] = v
+	}
+	timeoutMs, err := envPositive("OTEL_EXPORTER_OTLP_TIMEOUT", 10000)
+	if err != nil {
+		return nil, err
+	}
+	delayMs, err := envPositive("OTEL_BSP_SCHEDULE_DELAY", 5000)
+	if err != nil {
+		return nil, err
+	}
+	if opts.queueSize, err = envPositive("OTEL_BSP_MAX_QUEUE_SIZE", 2048); err != nil {
+		return nil, err
+	}
+	if opts.batchSize, err = envPositive("OTEL_BSP_MAX_EXPORT_BATCH_SIZE", 512); err != nil {
+		return nil, err
+	}
+	opts.timeout = time.Duration(timeoutMs) * time.Millisecond
+	opts.delay = time.Duration(delayMs) * time.Millisecond
+
+	resource, err := parseKeyValues(os.Getenv("OTEL_RESOURCE_ATTRIBUTES"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid OTEL_RESOURCE_ATTRIBUTES: %w", err)
+	}
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		service = name
+	} else if name := resource["service.name"]; name != "" {
+		service = name
+	}
+	delete(resource, "service.name")
+	attrs := []attribute{{"service.name", service}}
+	for k, v := range resource {
+		attrs = append(attrs, attribute{k, v})
+	}
+	return newTracer(attrs, opts), nil
+}
+
+// envPositive reads a positive integer setting, def if it is unset.
+func envPositive(name string, def int) (int, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%s must be a positive integer, got %q", name, v)
+	}
+	return n, nil
+}
+
+func envFirst(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// parseKeyValues parses the "key1=value1,key2=value2" lists of the OTEL_*
+// variables, with URL-encoded values.
+func parseKeyValues(list string) (map[string]string, error) {
+	kv := make(map[string]string)
+	for _, pair := range strings.Split(list, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(pair, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" {
+			return nil, fmt.Errorf("%q is not key=value", pair)
+		}
+		value, err := url.PathUnescape(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("value of %s: %w", k, err)
+		}
+		kv[k] = value
+	}
+	return kv, nil
+}
+
+func newTracer(resource []attribute, opts exporterOptions) *Tracer {
+	e := &exporter{
+		exporterOptions: opts,
+		client:          &http.Client{Timeout: opts.timeout},
+		spans:           make(chan otlpSpan, opts.queueSize),
+		done:            make(chan struct{}),
+		stopped:         make(chan struct{}),
+	}
+	e.resource = make([]keyValue, len(resource))
+	for i, a := range resource {
+		e.resource[i] = a.export()
+	}
+	go e.run()
+	return &Tracer{exporter: e}
+}
+
+// Shutdown exports the spans that ended and stops the exporter. Spans that
+// end later are dropped.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.exporter.stop.Do(func() { close(t.exporter.done) })
+	select {
+	case <-t.exporter.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close is Shutdown bounded by the export timeout, for deferring in main.
+func (t *Tracer) Close() {
+	if t == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), t.exporter.timeout)
+	defer cancel()
+	if err := t.Shutdown(ctx); err != nil {
+		log.Printf("telemetry: spans lost on shutdown: %v", err)
+	}
+}
+
+// Dropped returns the number of spans lost to a full queue or a failed
+// export.
+func (t *Tracer) Dropped() int64 {
+	if t == nil {
+		return 0
+	}
+	return t.exporter.dropped.Load()
+}
+
+// exporter sends ended spans to the collector in batches of up to batchSize,
+// at least every delay. Spans that do not fit its queue are dropped rather
+// than slowing down requests.
+type exporter struct {
+	exporterOptions
+	client   *http.Client
+	resource []keyValue
+	spans    chan otlpSpan
+	dropped  atomic.Int64
+	// failing is set while exports fail, so only the first failure is
+	// logged.
+	failing bool
+
+	stop    sync.Once
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func (e *exporter) enqueue(s otlpSpan) {
+	select {
+	case e.spans <- s:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+func (e *exporter) run() {
+	defer close(e.stopped)
+	ticker := time.NewTicker(e.delay)
+	defer ticker.Stop()
+	var batch []otlpSpan
+	for {
+		select {
+		case s := <-e.spans:
+			batch = append(batch, s)
+			if len(batch) >= e.batchSize {
+				e.export(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			e.export(batch)
+			batch = nil
+		case <-e.done:
+			for {
+				select {
+				case s := <-e.spans:
+					batch = append(batch, s)
+					if len(batch) >= e.batchSize {
+						e.export(batch)
+						batch = nil
+					}
+				default:
+					e.export(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (e *exporter) export(batch []otlpSpan) {
+	if len(batch) == 0 {
+		return
+	}
+	err := e.post(batch)
+	if err != nil {
+		e.dropped.Add(int64(len(batch)))
+		if !e.failing {
+			log.Printf("telemetry: exporting %d spans to %s failed: %v", len(batch), e.endpoint, err)
+		}
+	} else if e.failing {
+		log.Printf("telemetry: exporting spans to %s again", e.endpoint)
+	}
+	e.failing = err != nil
+}
+
+func (e *exporter) post(batch []otlpSpan) error {
+	body, err := json.Marshal(otlpRequest{ResourceSpans: []resourceSpans{{
+		Resource:   otlpResource{Attributes: e.resource},
+		ScopeSpans: []scopeSpans{{Scope: scope{Name: scopeName}, Spans: batch}},
+	}}})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector answered %s", resp.Status)
+	}
+	return nil
+}
+
+// The OTLP/JSON trace export request; see
+// https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding. IDs are
+// hex strings and 64-bit integers decimal strings.
+type otlpRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   otlpResource `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope      `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              Kind       `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Status            otlpStatus `json:"status"`
+}
+
+// otlpStatus codes: 0 unset, 2 error.
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type keyValue struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+func (a attribute) export() keyValue {
+	var value map[string]any
+	switch v := a.value.(type) {
+	case string:
+		value = map[string]any{"stringValue": v}
+	case bool:
+		value = map[string]any{"boolValue": v}
+	case int:
+		value = map[string]any{"intValue": strconv.Itoa(v)}
+	case int64:
+		value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		value = map[string]any{"doubleValue": v}
+	default:
+		value = map[string]any{"stringValue": fmt.Sprint(v)}
+	}
+	return keyValue{Key: a.key, Value: value}
+}
+
+// export converts the span for the exporter; s.mu must be held.
+func (s *Span) export(end time.Time) otlpSpan {
+	out := otlpSpan{
+		TraceID:           hex.EncodeToString(s.sc.traceID[:]),
+		SpanID:            hex.EncodeToString(s.sc.spanID[:]),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+		Attributes:        make([]keyValue, len(s.attrs)),
+	}
+	if s.parent != [8]byte{} {
+		out.ParentSpanID = hex.EncodeToString(s.parent[:])
+	}
+	for i, a := range s.attrs {
+		out.Attributes[i] = a.export()
+	}
+	if s.failed {
+		out.Status = otlpStatus{Code: 2, Message: s.message}
+	}
+	return out
+}
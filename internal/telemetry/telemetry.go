@@ -0,0 +1,256 @@
+// Package telemetry traces requests across the balancer, the API server and
+// the db service. Spans are exported to an OpenTelemetry collector, such as
+// Jaeger or Tempo, over OTLP/HTTP with the JSON encoding, configured by the
+// standard OTEL_* environment variables (see FromEnv). The trace context
+// travels between the services in the W3C traceparent header.
+//
+// A nil *Tracer and a nil *Span are valid and do nothing, so services trace
+// unconditionally and only pay for it when an exporter is configured.
+package telemetry
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kind is the OTLP span kind.
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// spanContext is what the traceparent header carries.
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+	sampled bool
+}
+
+// parseTraceparent parses a W3C traceparent header value
+// ("00-<trace-id>-<parent-id>-<flags>").
+func parseTraceparent(value string) (spanContext, bool) {
+	var sc spanContext
+	parts := strings.Split(value, "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.traceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.spanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	if sc.traceID == [16]byte{} || sc.spanID == [8]byte{} {
+		return sc, false
+	}
+	sc.sampled = flags[0]&1 == 1
+	return sc, true
+}
+
+func (sc spanContext) traceparent() string {
+	flags := "00"
+	if sc.sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.traceID[:]) + "-" + hex.EncodeToString(sc.spanID[:]) + "-" + flags
+}
+
+// Span is an operation of a trace. Its methods are safe for concurrent use.
+type Span struct {
+	// tracer is nil for the remote parent of a server span, which only
+	// carries its context.
+	tracer *Tracer
+	sc     spanContext
+	parent [8]byte
+	name   string
+	kind   Kind
+	start  time.Time
+
+	mu      sync.Mutex
+	attrs   []attribute
+	failed  bool
+	message string
+	ended   bool
+}
+
+type attribute struct {
+	key   string
+	value any
+}
+
+type spanKey struct{}
+
+func spanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// recording reports whether the span is exported: the tracer is set and the
+// trace sampled. Spans of unsampled traces still propagate their context.
+func (s *Span) recording() bool {
+	return s != nil && s.tracer != nil && s.sc.sampled
+}
+
+// SetAttribute records key = value on the span. Values are exported as
+// strings, integers, floats or booleans; other types are formatted as
+// strings.
+func (s *Span) SetAttribute(key string, value any) {
+	if !s.recording() {
+		return
+	}
+	s.mu.Lock()
+	s.attrs = append(s.attrs, attribute{key, value})
+	s.mu.Unlock()
+}
+
+// SetError marks the span failed with err, if err is not nil.
+func (s *Span) SetError(err error) {
+	if err == nil || !s.recording() {
+		return
+	}
+	s.mu.Lock()
+	s.failed, s.message = true, err.Error()
+	s.mu.Unlock()
+}
+
+// SetHTTPStatus records the status of the response the span is about. A
+// server span fails on a 5xx, a client span on any 4xx or 5xx, as the
+// OpenTelemetry HTTP conventions have it.
+func (s *Span) SetHTTPStatus(code int) {
+	if !s.recording() {
+		return
+	}
+	s.SetAttribute("http.response.status_code", code)
+	if code >= 500 || (s.kind == KindClient && code >= 400) {
+		s.mu.Lock()
+		s.failed = true
+		if s.message == "" {
+			s.message = strconv.Itoa(code) + " " + http.StatusText(code)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// End finishes the span and queues it for export. Later calls do nothing.
+func (s *Span) End() {
+	if !s.recording() {
+		return
+	}
+	end := time.Now()
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	exported := s.export(end)
+	s.mu.Unlock()
+	s.tracer.exporter.enqueue(exported)
+}
+
+// Tracer starts spans of one service and exports them.
+type Tracer struct {
+	exporter *exporter
+}
+
+// Start starts a span named name as a child of the span in ctx, if any, and
+// returns a context holding the new span.
+func (t *Tracer) Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	s := &Span{tracer: t, name: name, kind: kind, start: time.Now()}
+	if parent := spanFromContext(ctx); parent != nil {
+		s.sc.traceID, s.sc.sampled, s.parent = parent.sc.traceID, parent.sc.sampled, parent.sc.spanID
+	} else {
+		_, _ = rand.Read(s.sc.traceID[:])
+		s.sc.sampled = true
+	}
+	_, _ = rand.Read(s.sc.spanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Inject sets the traceparent header of an outgoing request to the span in
+// ctx, making it the parent of the spans of the callee. Without a span it
+// leaves h alone, so headers copied from the incoming request still carry
+// the trace through a service that does not trace.
+func Inject(ctx context.Context, h http.Header) {
+	if s := spanFromContext(ctx); s != nil {
+		h.Set("Traceparent", s.sc.traceparent())
+	}
+}
+
+// Handler traces every request to next with a server span, continuing the
+// trace of the caller if the request has a valid traceparent header. The
+// span is in the request context, for next to start children of.
+func (t *Tracer) Handler(next http.Handler) http.Handler {
+	if t == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if sc, ok := parseTraceparent(r.Header.Get("Traceparent")); ok {
+			ctx = context.WithValue(ctx, spanKey{}, &Span{sc: sc})
+		}
+		ctx, span := t.Start(ctx, r.Method, KindServer)
+		defer span.End()
+		span.SetAttribute("http.request.method", r.Method)
+		span.SetAttribute("url.path", r.URL.Path)
+		if id := r.Header.Get("X-Request-ID"); id != "" {
+			span.SetAttribute("http.request.header.x-request-id", id)
+		}
+
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			span.SetHTTPStatus(rec.status)
+		}()
+		next.ServeHTTP(rec, r.WithContext(ctx))
+	})
+}
+
+// statusRecorder remembers the status of a response. It keeps the Flusher
+// of the writer it wraps, which streaming handlers assert.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,213 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collector is an OTLP/HTTP endpoint keeping the spans it receives.
+type collector struct {
+	*httptest.Server
+	mu       sync.Mutex
+	resource []keyValue
+	spans    []otlpSpan
+}
+
+func newCollector(t *testing.T) *collector {
+	c := &collector{}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(rw, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		var req otlpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for _, rs := range req.ResourceSpans {
+			c.resource = rs.Resource.Attributes
+			for _, ss := range rs.ScopeSpans {
+				c.spans = append(c.spans, ss.Spans...)
+			}
+		}
+	}))
+	t.Cleanup(c.Close)
+	return c
+}
+
+func (c *collector) span(t *testing.T, name string) otlpSpan {
+	t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, s := range c.spans {
+		if s.Name == name {
+			return s
+		}
+	}
+	t.Fatalf("no span %q among %v", name, c.spans)
+	return otlpSpan{}
+}
+
+func attr(s otlpSpan, key string) any {
+	for _, kv := range s.Attributes {
+		if kv.Key == key {
+			for _, v := range kv.Value {
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+func testTracer(t *testing.T, c *collector) *Tracer {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", c.URL)
+	t.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "http/json")
+	t.Setenv("OTEL_BSP_SCHEDULE_DELAY", "60000")
+	tracer, err := FromEnv("test")
+	if err != nil || tracer == nil {
+		t.Fatalf("FromEnv: %v, %v", tracer, err)
+	}
+	return tracer
+}
+
+func TestHandler_ContinuesTrace(t *testing.T) {
+	c := newCollector(t)
+	tracer := testTracer(t, c)
+
+	var outgoing http.Header
+	handler := tracer.Handler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		ctx, span := tracer.Start(r.Context(), "db.fetch", KindClient)
+		outgoing = http.Header{}
+		Inject(ctx, outgoing)
+		span.SetHTTPStatus(http.StatusNotFound)
+		span.End()
+		rw.WriteHeader(http.StatusBadGateway)
+	}))
+	req := httptest.NewRequest("GET", "/api/v1/some-data", nil)
+	req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	server, client := c.span(t, "GET"), c.span(t, "db.fetch")
+	if server.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || server.ParentSpanID != "00f067aa0ba902b7" || server.Kind != KindServer {
+		t.Errorf("the server span must continue the caller's trace: %+v", server)
+	}
+	if client.TraceID != server.TraceID || client.ParentSpanID != server.SpanID {
+		t.Errorf("the client span must be a child of the server span: %+v", client)
+	}
+	if want := "00-" + client.TraceID + "-" + client.SpanID + "-01"; outgoing.Get("Traceparent") != want {
+		t.Errorf("injected traceparent %q, want %q", outgoing.Get("Traceparent"), want)
+	}
+	if server.Status.Code != 2 || attr(server, "http.response.status_code") != "502" || attr(server, "url.path") != "/api/v1/some-data" {
+		t.Errorf("unexpected server span %+v", server)
+	}
+	if client.Status.Code != 2 {
+		t.Errorf("a 404 must fail a client span: %+v", client)
+	}
+	if len(c.resource) != 1 || c.resource[0].Key != "service.name" || c.resource[0].Value["stringValue"] != "test" {
+		t.Errorf("unexpected resource %v", c.resource)
+	}
+}
+
+func TestHandler_Unsampled(t *testing.T) {
+	c := newCollector(t)
+	tracer := testTracer(t, c)
+
+	var outgoing http.Header
+	handler := tracer.Handler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		outgoing = http.Header{}
+		Inject(r.Context(), outgoing)
+	}))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	_ = tracer.Shutdown(context.Background())
+
+	if len(c.spans) != 0 {
+		t.Errorf("spans of an unsampled trace must not be exported: %v", c.spans)
+	}
+	if tp := outgoing.Get("Traceparent"); !strings.HasPrefix(tp, "00-4bf92f3577b34da6a3ce929d0e0e4736-") || !strings.HasSuffix(tp, "-00") {
+		t.Errorf("the trace must still be propagated unsampled, got %q", tp)
+	}
+}
+
+func TestNilTracer(t *testing.T) {
+	var tracer *Tracer
+	ctx, span := tracer.Start(context.Background(), "op", KindInternal)
+	span.SetAttribute("k", "v")
+	span.SetError(errors.New("failed"))
+	span.End()
+	h := http.Header{}
+	Inject(ctx, h)
+	if span != nil || len(h) != 0 {
+		t.Errorf("a nil tracer must not trace: span %v, headers %v", span, h)
+	}
+	next := http.NotFoundHandler()
+	if got := tracer.Handler(next); got == nil {
+		t.Error("a nil tracer must pass requests through")
+	}
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	for _, name := range []string{"OTEL_EXPORTER_OTLP_ENDPOINT", "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "OTEL_SDK_DISABLED", "OTEL_TRACES_EXPORTER", "OTEL_EXPORTER_OTLP_PROTOCOL", "OTEL_EXPORTER_OTLP_TRACES_PROTOCOL"} {
+		t.Setenv(name, "")
+	}
+	if tracer, err := FromEnv("lb"); tracer != nil || err != nil {
+		t.Errorf("tracing must be off without an endpoint: %v, %v", tracer, err)
+	}
+
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4318/")
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "Authorization=Bearer%20secret, X-Scope-OrgID=lab")
+	t.Setenv("OTEL_SERVICE_NAME", "balancer")
+	t.Setenv("OTEL_RESOURCE_ATTRIBUTES", "service.name=ignored,deployment.environment=test")
+	tracer, err := FromEnv("lb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tracer.Shutdown(context.Background())
+	e := tracer.exporter
+	if e.endpoint != "http://collector:4318/v1/traces" {
+		t.Errorf("endpoint %q", e.endpoint)
+	}
+	if e.headers["Authorization"] != "Bearer secret" || e.headers["X-Scope-OrgID"] != "lab" {
+		t.Errorf("headers %v", e.headers)
+	}
+	if e.resource[0].Value["stringValue"] != "balancer" || len(e.resource) != 2 {
+		t.Errorf("resource %v", e.resource)
+	}
+	if e.delay != 5*time.Second || e.batchSize != 512 {
+		t.Errorf("batching defaults: delay %s, batch %d", e.delay, e.batchSize)
+	}
+
+	for name, value := range map[string]string{
+		"OTEL_EXPORTER_OTLP_PROTOCOL": "grpc",
+		"OTEL_TRACES_EXPORTER":        "zipkin",
+		"OTEL_BSP_SCHEDULE_DELAY":     "soon",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			if _, err := FromEnv("lb"); err == nil {
+				t.Errorf("%s=%s must be rejected", name, value)
+			}
+		})
+	}
+	t.Setenv("OTEL_TRACES_EXPORTER", "none")
+	if tracer, err := FromEnv("lb"); tracer != nil || err != nil {
+		t.Errorf("OTEL_TRACES_EXPORTER=none must turn tracing off: %v, %v", tracer, err)
+	}
+}
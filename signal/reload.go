@@ -0,0 +1,19 @@
+package signal
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// OnReload calls fn in a background goroutine every time the process
+// receives SIGHUP.
+func OnReload(fn func()) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	go func() {
+		for range ch {
+			fn()
+		}
+	}()
+}